@@ -0,0 +1,9 @@
+module github.com/eclipse/paho.mqtt.golang
+
+go 1.18
+
+require (
+	github.com/gorilla/websocket v1.5.0
+	golang.org/x/net v0.8.0
+	golang.org/x/sync v0.1.0
+)
@@ -0,0 +1,4 @@
+// Deprecated: Consider using the github.com/rabbitmq/amqp091-go package instead.
+module github.com/streadway/amqp
+
+go 1.10
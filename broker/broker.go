@@ -0,0 +1,528 @@
+package broker
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"github.com/go-stomp/stomp"
+	"github.com/go-stomp/stomp/frame"
+	"github.com/streadway/amqp"
+
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/credentials"
+)
+
+// Connector connects to the broker over its native protocols, rather than
+// through an example app, as the user of a binding or service key.
+type Connector interface {
+	AMQP(endpoint credentials.Protocol) (AMQP, error)
+	MQTT(endpoint credentials.Protocol, clientID string) (MQTT, error)
+	STOMP(endpoint credentials.Protocol) (STOMP, error)
+}
+
+// AMQP is a channel on a connection of its own; closing it closes both.
+// Once the broker closes the channel, every call returns the *amqp.Error it
+// closed it with.
+type AMQP interface {
+	DeclareQueue(name string, options QueueOptions) (amqp.Queue, error)
+	DeleteQueue(name string) (int, error)
+	BindQueue(queue, exchange, routingKey string, arguments amqp.Table) error
+	Publish(exchange, routingKey string, message amqp.Publishing) error
+	Get(queue string, autoAck bool) (amqp.Delivery, bool, error)
+	Ack(deliveryTag uint64, multiple bool) error
+	Nack(deliveryTag uint64, multiple, requeue bool) error
+	Reject(deliveryTag uint64, requeue bool) error
+	ConfirmSelect() error
+	NextConfirmation() (amqp.Confirmation, error)
+	Qos(prefetchCount int) error
+	Consume(queue string, autoAck bool, arguments amqp.Table) (string, error)
+	Next() (amqp.Delivery, error)
+	Cancel(consumerTag string) error
+	Close() error
+}
+
+// QueueOptions are the flags and arguments of a queue declaration.
+type QueueOptions struct {
+	Passive    bool
+	Durable    bool
+	Exclusive  bool
+	AutoDelete bool
+	Arguments  amqp.Table
+}
+
+type MQTT interface {
+	Publish(topic string, payload []byte, qos byte) error
+	Close() error
+}
+
+type STOMP interface {
+	Send(destination string, body []byte, headers map[string]string) error
+	Close() error
+}
+
+// STOMPError is an ERROR frame from the broker.
+type STOMPError struct {
+	Message string
+	Details string
+}
+
+func (e *STOMPError) Error() string {
+	return fmt.Sprintf("the broker sent an error: %s: %s", e.Message, e.Details)
+}
+
+// Default ports, for endpoints that only give a URI without one.
+var defaultPorts = map[string]int{
+	"amqp":      5672,
+	"amqps":     5671,
+	"mqtt":      1883,
+	"mqtt+ssl":  8883,
+	"stomp":     61613,
+	"stomp+ssl": 61614,
+}
+
+type connector struct {
+	timeout   time.Duration
+	tlsConfig *tls.Config
+}
+
+// NewConnector returns a Connector that dials the endpoints with the given
+// timeout, and with tlsConfig for those that use TLS; the endpoint's host
+// is checked against the broker's certificate. The timeout also bounds
+// each wait for the broker to answer.
+func NewConnector(timeout time.Duration, tlsConfig *tls.Config) Connector {
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{}
+	}
+	return connector{timeout: timeout, tlsConfig: tlsConfig}
+}
+
+// amqpSession makes the channel's asynchronous parts, confirmations and
+// deliveries, into calls that wait for the next one.
+type amqpSession struct {
+	connection *amqp.Connection
+	channel    *amqp.Channel
+	timeout    time.Duration
+
+	// closing gets what the broker closed the channel with, kept in err.
+	closing chan *amqp.Error
+	err     *amqp.Error
+
+	confirmations chan amqp.Confirmation
+
+	// deliveries are those of every consumer started; Next skips the
+	// ones for consumers since cancelled.
+	deliveries chan amqp.Delivery
+	consumers  int
+	cancelled  map[string]bool
+	done       chan struct{}
+}
+
+func (c connector) AMQP(endpoint credentials.Protocol) (AMQP, error) {
+	endpoint = Resolve(endpoint)
+	uri := url.URL{Scheme: "amqp", Host: Address(endpoint), Path: "/"}
+	if endpoint.SSL {
+		uri.Scheme = "amqps"
+	}
+	connection, err := amqp.DialConfig(uri.String(), amqp.Config{
+		SASL:            []amqp.Authentication{&amqp.PlainAuth{Username: endpoint.Username, Password: endpoint.Password}},
+		Vhost:           endpoint.VHost,
+		TLSClientConfig: c.tls(endpoint),
+		Dial:            amqp.DefaultDial(c.timeout),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	channel, err := connection.Channel()
+	if err != nil {
+		connection.Close()
+		return nil, err
+	}
+	return &amqpSession{
+		connection: connection,
+		channel:    channel,
+		timeout:    c.timeout,
+		closing:    channel.NotifyClose(make(chan *amqp.Error, 1)),
+		deliveries: make(chan amqp.Delivery),
+		cancelled:  map[string]bool{},
+		done:       make(chan struct{}),
+	}, nil
+}
+
+func (s *amqpSession) DeclareQueue(name string, options QueueOptions) (amqp.Queue, error) {
+	var queue amqp.Queue
+	var err error
+	if options.Passive {
+		queue, err = s.channel.QueueDeclarePassive(name, options.Durable, options.AutoDelete, options.Exclusive, false, options.Arguments)
+	} else {
+		queue, err = s.channel.QueueDeclare(name, options.Durable, options.AutoDelete, options.Exclusive, false, options.Arguments)
+	}
+	return queue, s.check(err)
+}
+
+func (s *amqpSession) DeleteQueue(name string) (int, error) {
+	messages, err := s.channel.QueueDelete(name, false, false, false)
+	return messages, s.check(err)
+}
+
+func (s *amqpSession) BindQueue(queue, exchange, routingKey string, arguments amqp.Table) error {
+	return s.check(s.channel.QueueBind(queue, routingKey, exchange, false, arguments))
+}
+
+// Publish does not wait for the broker: a publish it refuses closes the
+// channel, which the calls after it report.
+func (s *amqpSession) Publish(exchange, routingKey string, message amqp.Publishing) error {
+	return s.check(s.channel.Publish(exchange, routingKey, false, false, message))
+}
+
+func (s *amqpSession) Get(queue string, autoAck bool) (amqp.Delivery, bool, error) {
+	delivery, ok, err := s.channel.Get(queue, autoAck)
+	return delivery, ok, s.check(err)
+}
+
+func (s *amqpSession) Ack(deliveryTag uint64, multiple bool) error {
+	return s.check(s.channel.Ack(deliveryTag, multiple))
+}
+
+func (s *amqpSession) Nack(deliveryTag uint64, multiple, requeue bool) error {
+	return s.check(s.channel.Nack(deliveryTag, multiple, requeue))
+}
+
+func (s *amqpSession) Reject(deliveryTag uint64, requeue bool) error {
+	return s.check(s.channel.Reject(deliveryTag, requeue))
+}
+
+// ConfirmSelect puts the channel in confirm mode. The broker confirms a
+// range of publishes at once, which NextConfirmation returns one by one.
+func (s *amqpSession) ConfirmSelect() error {
+	if err := s.channel.Confirm(false); err != nil {
+		return s.check(err)
+	}
+	s.confirmations = s.channel.NotifyPublish(make(chan amqp.Confirmation, maxUnconfirmed))
+	return nil
+}
+
+// maxUnconfirmed is how many confirmations may wait for NextConfirmation
+// before they hold up the connection.
+const maxUnconfirmed = 1 << 16
+
+func (s *amqpSession) NextConfirmation() (amqp.Confirmation, error) {
+	timer := time.NewTimer(s.timeout)
+	defer timer.Stop()
+
+	select {
+	case confirmation, ok := <-s.confirmations:
+		if !ok {
+			return amqp.Confirmation{}, s.check(amqp.ErrClosed)
+		}
+		return confirmation, nil
+	case <-timer.C:
+		return amqp.Confirmation{}, fmt.Errorf("timed out after %s waiting for the broker", s.timeout)
+	}
+}
+
+func (s *amqpSession) Qos(prefetchCount int) error {
+	return s.check(s.channel.Qos(prefetchCount, 0, false))
+}
+
+func (s *amqpSession) Consume(queue string, autoAck bool, arguments amqp.Table) (string, error) {
+	s.consumers++
+	tag := fmt.Sprintf("smoke-test-%d", s.consumers)
+	deliveries, err := s.channel.Consume(queue, tag, autoAck, false, false, false, arguments)
+	if err != nil {
+		return "", s.check(err)
+	}
+
+	go func() {
+		for delivery := range deliveries {
+			select {
+			case s.deliveries <- delivery:
+			case <-s.done:
+				return
+			}
+		}
+	}()
+	return tag, nil
+}
+
+func (s *amqpSession) Next() (amqp.Delivery, error) {
+	timer := time.NewTimer(s.timeout)
+	defer timer.Stop()
+
+	for {
+		select {
+		case delivery := <-s.deliveries:
+			if s.cancelled[delivery.ConsumerTag] {
+				continue
+			}
+			return delivery, nil
+		case err, ok := <-s.closing:
+			if ok {
+				s.err = err
+			}
+			return amqp.Delivery{}, s.check(amqp.ErrClosed)
+		case <-timer.C:
+			return amqp.Delivery{}, fmt.Errorf("timed out after %s waiting for the broker", s.timeout)
+		}
+	}
+}
+
+func (s *amqpSession) Cancel(consumerTag string) error {
+	s.cancelled[consumerTag] = true
+	return s.check(s.channel.Cancel(consumerTag, false))
+}
+
+func (s *amqpSession) Close() error {
+	select {
+	case <-s.done:
+		return nil
+	default:
+		close(s.done)
+	}
+	if err := s.connection.Close(); err != amqp.ErrClosed {
+		return err
+	}
+	return nil
+}
+
+// check makes a call on a closed channel report what the broker closed it
+// with.
+func (s *amqpSession) check(err error) error {
+	if err != amqp.ErrClosed {
+		return err
+	}
+	if s.err == nil {
+		select {
+		case closeErr, ok := <-s.closing:
+			if ok {
+				s.err = closeErr
+			}
+		default:
+		}
+	}
+	if s.err != nil {
+		return s.err
+	}
+	return err
+}
+
+// MQTT connects with a clean session. RabbitMQ takes the vhost as part of
+// the username, which the broker's credentials may or may not have done
+// already.
+func (c connector) MQTT(endpoint credentials.Protocol, clientID string) (MQTT, error) {
+	endpoint = Resolve(endpoint)
+	username := endpoint.Username
+	if endpoint.VHost != "" && !strings.Contains(username, ":") {
+		username = endpoint.VHost + ":" + username
+	}
+
+	// the client reports a failure to dial only as text, so dial for it
+	var dialErr error
+	options := mqtt.NewClientOptions().
+		AddBroker("tcp://" + Address(endpoint)).
+		SetClientID(clientID).
+		SetUsername(username).
+		SetPassword(endpoint.Password).
+		SetCleanSession(true).
+		SetProtocolVersion(4).
+		SetAutoReconnect(false).
+		SetConnectTimeout(c.timeout).
+		SetWriteTimeout(c.timeout).
+		SetCustomOpenConnectionFn(func(*url.URL, mqtt.ClientOptions) (net.Conn, error) {
+			var conn net.Conn
+			conn, dialErr = c.dial(endpoint)
+			return conn, dialErr
+		})
+	client := mqtt.NewClient(options)
+
+	// the connect timeout bounds dialing and the handshake
+	token := client.Connect()
+	token.Wait()
+	if dialErr != nil {
+		return nil, dialErr
+	}
+	if err := token.Error(); err != nil {
+		return nil, err
+	}
+	return mqttSession{client: client, timeout: c.timeout}, nil
+}
+
+type mqttSession struct {
+	client  mqtt.Client
+	timeout time.Duration
+}
+
+// Publish waits for the broker to acknowledge the message, for QoS 1.
+func (s mqttSession) Publish(topic string, payload []byte, qos byte) error {
+	token := s.client.Publish(topic, qos, false, payload)
+	if !token.WaitTimeout(s.timeout) {
+		return fmt.Errorf("timed out after %s waiting for the broker", s.timeout)
+	}
+	return token.Error()
+}
+
+func (s mqttSession) Close() error {
+	s.client.Disconnect(250)
+	return nil
+}
+
+// STOMP connects to the vhost as the endpoint's user, without heart-beats.
+func (c connector) STOMP(endpoint credentials.Protocol) (STOMP, error) {
+	endpoint = Resolve(endpoint)
+	conn, err := c.dial(endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	host := endpoint.VHost
+	if host == "" {
+		host = "/"
+	}
+	conn.SetDeadline(time.Now().Add(c.timeout))
+	client, err := stomp.Connect(conn,
+		stomp.ConnOpt.Login(endpoint.Username, endpoint.Password),
+		stomp.ConnOpt.Host(host),
+		stomp.ConnOpt.HeartBeat(0, 0),
+	)
+	if err != nil {
+		conn.Close()
+		return nil, stompError(err)
+	}
+	conn.SetDeadline(time.Time{})
+	return &stompSession{conn: conn, client: client, timeout: c.timeout}, nil
+}
+
+type stompSession struct {
+	conn    net.Conn
+	client  *stomp.Conn
+	timeout time.Duration
+}
+
+// Send waits for the broker's receipt. The client would wait for it
+// forever once the connection is gone, so the wait is bounded.
+func (s *stompSession) Send(destination string, body []byte, headers map[string]string) error {
+	options := []func(*frame.Frame) error{stomp.SendOpt.Receipt}
+	for key, value := range headers {
+		options = append(options, stomp.SendOpt.Header(key, value))
+	}
+	return s.wait(func() error {
+		return stompError(s.client.Send(destination, "", body, options...))
+	})
+}
+
+// Close disconnects, waiting for the broker's receipt as long as Send
+// does, and then closes the connection either way.
+func (s *stompSession) Close() error {
+	s.wait(s.client.Disconnect)
+	return s.conn.Close()
+}
+
+// wait runs call for up to the timeout; after that, it closes the
+// connection and stops waiting.
+func (s *stompSession) wait(call func() error) error {
+	done := make(chan error, 1)
+	go func() { done <- call() }()
+
+	timer := time.NewTimer(s.timeout)
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+		s.conn.Close()
+		return fmt.Errorf("timed out after %s waiting for the broker", s.timeout)
+	}
+}
+
+// stompError makes an ERROR frame from the broker a *STOMPError.
+func stompError(err error) error {
+	stompErr, ok := err.(stomp.Error)
+	if !ok || stompErr.Frame == nil || stompErr.Frame.Command != frame.ERROR {
+		return err
+	}
+	return &STOMPError{Message: stompErr.Message, Details: string(stompErr.Frame.Body)}
+}
+
+// dial connects to the endpoint, over TLS if it uses it.
+func (c connector) dial(endpoint credentials.Protocol) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: c.timeout}
+	if config := c.tls(endpoint); config != nil {
+		return tls.DialWithDialer(dialer, "tcp", Address(endpoint), config)
+	}
+	return dialer.Dial("tcp", Address(endpoint))
+}
+
+func (c connector) tls(endpoint credentials.Protocol) *tls.Config {
+	if !endpoint.SSL {
+		return nil
+	}
+	config := c.tlsConfig.Clone()
+	config.ServerName = endpoint.Host
+	return config
+}
+
+// Resolve fills in what an endpoint leaves out from its URI: the host,
+// port, username, password, vhost and whether it uses TLS.
+func Resolve(endpoint credentials.Protocol) credentials.Protocol {
+	uri, err := url.Parse(endpoint.URI)
+	if err != nil || endpoint.URI == "" {
+		return endpoint
+	}
+
+	if endpoint.Host == "" {
+		endpoint.Host = uri.Hostname()
+	}
+	if endpoint.Port == 0 {
+		endpoint.Port, _ = strconv.Atoi(uri.Port())
+		if endpoint.Port == 0 {
+			endpoint.Port = defaultPorts[uri.Scheme]
+		}
+	}
+	if uri.User != nil {
+		if endpoint.Username == "" {
+			endpoint.Username = uri.User.Username()
+		}
+		if password, ok := uri.User.Password(); ok && endpoint.Password == "" {
+			endpoint.Password = password
+		}
+	}
+	if endpoint.VHost == "" && len(uri.Path) > 1 {
+		endpoint.VHost, _ = url.PathUnescape(uri.EscapedPath()[1:])
+	}
+	switch uri.Scheme {
+	case "amqps", "mqtts", "mqtt+ssl", "stomp+ssl":
+		endpoint.SSL = true
+	}
+	return endpoint
+}
+
+// Address is the host and port to dial for an endpoint.
+func Address(endpoint credentials.Protocol) string {
+	return net.JoinHostPort(endpoint.Host, strconv.Itoa(endpoint.Port))
+}
+
+// Refused tells whether a connector's error means the broker turned the
+// connection away: nothing listened on the port, the broker reset the
+// connection, or it rejected the user's login. Other errors, such as a
+// timeout or a host that does not resolve, say nothing about the broker.
+func Refused(err error) bool {
+	var amqpErr *amqp.Error
+	var stompErr *STOMPError
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED), errors.Is(err, syscall.ECONNRESET):
+		return true
+	case errors.As(err, &amqpErr):
+		return amqpErr == amqp.ErrCredentials || amqpErr == amqp.ErrSASL || amqpErr.Code == amqp.AccessRefused
+	case errors.As(err, &stompErr):
+		return true
+	}
+	return err == packets.ErrorRefusedBadUsernameOrPassword || err == packets.ErrorRefusedNotAuthorised
+}
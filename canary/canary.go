@@ -0,0 +1,213 @@
+package canary
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/lifecycle"
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/metrics"
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/platform"
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/report"
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/statsd"
+)
+
+// Kinds of check, as labelled in metrics.
+const (
+	// Warm checks send a message through an app that stays bound to a
+	// long-lived service instance.
+	Warm = "warm"
+	// Full checks provision, use and delete everything, like a run of the
+	// smoke tests.
+	Full = "full"
+)
+
+// Canary keeps checking each plan over each protocol: a cheap warm check
+// every Interval and a full lifecycle every FullInterval.
+type Canary struct {
+	Interval     time.Duration
+	FullInterval time.Duration
+
+	// Emitter, when set, also sends the steps of every check to StatsD.
+	Emitter *statsd.Emitter
+
+	configs              []lifecycle.Config
+	platform             platform.Platform
+	httpClient           *http.Client
+	managementHTTPClient *http.Client
+	out                  io.Writer
+
+	success      *metrics.Gauge
+	lastRun      *metrics.Gauge
+	stepDuration *metrics.Histogram
+	latency      *metrics.Histogram
+
+	warm     map[int]*lifecycle.Lifecycle
+	lastFull time.Time
+}
+
+// New registers the canary's metrics with registry. There is one
+// lifecycle config for each plan and protocol to check; the HTTP clients
+// are the lifecycles', for the app routes and the management API.
+func New(configs []lifecycle.Config, p platform.Platform, httpClient, managementHTTPClient *http.Client, out io.Writer, registry *metrics.Registry) *Canary {
+	return &Canary{
+		Interval:     time.Minute,
+		FullInterval: time.Hour,
+
+		configs:              configs,
+		platform:             p,
+		httpClient:           httpClient,
+		managementHTTPClient: managementHTTPClient,
+		out:                  out,
+
+		success: registry.NewGauge(
+			"rabbitmq_smoke_tests_check_success",
+			"Whether the last check of the plan over the protocol passed (1) or failed (0).",
+			"plan", "protocol", "check"),
+		lastRun: registry.NewGauge(
+			"rabbitmq_smoke_tests_check_last_run_timestamp_seconds",
+			"When the plan was last checked over the protocol, in seconds since the epoch.",
+			"plan", "protocol", "check"),
+		stepDuration: registry.NewHistogram(
+			"rabbitmq_smoke_tests_step_duration_seconds",
+			"How long each step of a check took.",
+			metrics.DefaultDurationBuckets,
+			"plan", "protocol", "step", "outcome"),
+		latency: registry.NewHistogram(
+			"rabbitmq_smoke_tests_message_latency_seconds",
+			"How long after it was published a message was read back.",
+			metrics.DefaultLatencyBuckets,
+			"plan", "protocol", "check"),
+
+		warm: map[int]*lifecycle.Lifecycle{},
+	}
+}
+
+// Run checks until stop is closed, then deletes the warm apps and service
+// instances.
+func (c *Canary) Run(stop <-chan struct{}) error {
+	ticker := time.NewTicker(c.Interval)
+	defer ticker.Stop()
+
+	for {
+		c.RunOnce(c.lastFull.IsZero() || time.Since(c.lastFull) >= c.FullInterval)
+
+		select {
+		case <-stop:
+			return c.Cleanup()
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce runs a warm check of every plan and protocol, and a full check
+// too when full is set.
+func (c *Canary) RunOnce(full bool) {
+	for i, config := range c.configs {
+		c.checkWarm(i, config)
+		if full {
+			c.checkFull(config)
+		}
+	}
+	if full {
+		c.lastFull = time.Now()
+	}
+}
+
+// Cleanup deletes the warm apps and service instances.
+func (c *Canary) Cleanup() error {
+	failures := []string{}
+	for i, lc := range c.warm {
+		if err := lc.Cleanup(); err != nil {
+			failures = append(failures, err.Error())
+		}
+		delete(c.warm, i)
+	}
+
+	if len(failures) > 0 {
+		return fmt.Errorf("failed to clean up the warm checks:\n\n%s", strings.Join(failures, "\n\n"))
+	}
+	return nil
+}
+
+// checkWarm provisions the warm app and instance when there are none, and
+// sends a message through them. A failure throws them away, so the next
+// check starts afresh.
+func (c *Canary) checkWarm(i int, config lifecycle.Config) {
+	recorder := report.NewRecorder()
+
+	var err error
+	lc, ok := c.warm[i]
+	if ok {
+		err = c.run(lc.WithRecorder(recorder).WriteAndRead)
+	} else {
+		lc = lifecycle.New(config, c.platform, c.httpClient, c.managementHTTPClient, c.out).WithRecorder(recorder)
+		err = c.run(lc.PushApp, lc.CreateService, lc.BindAndStart, lc.WriteAndRead)
+	}
+
+	if err == nil {
+		c.warm[i] = lc
+	} else {
+		fmt.Fprintf(c.out, "Warm check of %s over %s failed: %s\n", config.PlanName, config.Protocol, err.Error())
+		delete(c.warm, i)
+		if cleanupErr := lc.Cleanup(); cleanupErr != nil {
+			fmt.Fprintf(c.out, "Failed to clean up after the warm check: %s\n", cleanupErr.Error())
+		}
+	}
+
+	c.observe(config, Warm, err, recorder)
+}
+
+func (c *Canary) checkFull(config lifecycle.Config) {
+	recorder := report.NewRecorder()
+	lc := lifecycle.New(config, c.platform, c.httpClient, c.managementHTTPClient, c.out).WithRecorder(recorder)
+
+	err := c.run(lc.PushApp, lc.CreateService, lc.BindAndStart, lc.WriteAndRead)
+	if cleanupErr := lc.Cleanup(); err == nil {
+		err = cleanupErr
+	}
+	if err != nil {
+		fmt.Fprintf(c.out, "Full check of %s over %s failed: %s\n", config.PlanName, config.Protocol, err.Error())
+	}
+
+	c.observe(config, Full, err, recorder)
+}
+
+// run runs steps until one fails.
+func (c *Canary) run(steps ...func() error) error {
+	for _, step := range steps {
+		if err := step(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Canary) observe(config lifecycle.Config, check string, err error, recorder *report.Recorder) {
+	plan, protocol := config.PlanName, string(config.Protocol)
+
+	success := 1.0
+	if err != nil {
+		success = 0
+	}
+	c.success.Set(success, plan, protocol, check)
+	c.lastRun.Set(float64(time.Now().Unix()), plan, protocol, check)
+
+	for _, step := range recorder.Steps() {
+		if step.Outcome == report.Skipped {
+			continue
+		}
+		c.stepDuration.Observe(step.Duration().Seconds(), plan, protocol, step.Name, step.Outcome)
+		if step.Latency > 0 {
+			c.latency.Observe(step.Latency.Seconds(), plan, protocol, check)
+		}
+	}
+
+	if c.Emitter != nil {
+		if err := c.Emitter.Emit(recorder.Steps()); err != nil {
+			fmt.Fprintf(c.out, "Failed to send metrics to StatsD: %s\n", err.Error())
+		}
+	}
+}
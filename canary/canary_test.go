@@ -0,0 +1,160 @@
+package canary_test
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/canary"
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/fakeplatform"
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/lifecycle"
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/metrics"
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/statsd"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+)
+
+var _ = Describe("Canary", func() {
+	var (
+		fake     *fakeplatform.Platform
+		registry *metrics.Registry
+		out      *gbytes.Buffer
+		c        *canary.Canary
+	)
+
+	BeforeEach(func() {
+		fake = fakeplatform.New("fake-domain")
+		registry = metrics.NewRegistry()
+		out = gbytes.NewBuffer()
+
+		config := lifecycle.Config{
+			ServiceName:   "p-rabbitmq",
+			PlanName:      "standard",
+			Protocol:      lifecycle.AMQP,
+			AppPath:       "fake/app/path",
+			AppsDomain:    "fake-domain",
+			Timeout:       50 * time.Millisecond,
+			RetryInterval: time.Millisecond,
+		}
+		c = canary.New([]lifecycle.Config{config}, fake, fake.HTTPClient(), fake.HTTPClient(), out, registry)
+	})
+
+	exposition := func() string {
+		buffer := &bytes.Buffer{}
+		registry.Write(buffer)
+		return buffer.String()
+	}
+
+	pushes := func() int {
+		count := 0
+		for _, call := range fake.Calls() {
+			if call[0] == "push" {
+				count++
+			}
+		}
+		return count
+	}
+
+	publishes := func() int {
+		match := regexp.MustCompile(`_count{[^}]*step="publish",outcome="passed"} (\d+)`).FindStringSubmatch(exposition())
+		if match == nil {
+			return 0
+		}
+		count, _ := strconv.Atoi(match[1])
+		return count
+	}
+
+	It("keeps the warm app and instance between checks", func() {
+		c.RunOnce(false)
+		c.RunOnce(false)
+
+		Expect(pushes()).To(Equal(1))
+		Expect(fake.AppNames()).To(HaveLen(1))
+		Expect(fake.ServiceInstanceNames()).To(HaveLen(1))
+		Expect(exposition()).To(ContainSubstring(`rabbitmq_smoke_tests_check_success{plan="standard",protocol="amqp",check="warm"} 1`))
+		Expect(exposition()).To(ContainSubstring(`rabbitmq_smoke_tests_step_duration_seconds_count{plan="standard",protocol="amqp",step="publish",outcome="passed"} 2`))
+		Expect(exposition()).To(ContainSubstring(`rabbitmq_smoke_tests_step_duration_seconds_count{plan="standard",protocol="amqp",step="push",outcome="passed"} 1`))
+		Expect(exposition()).To(ContainSubstring(`rabbitmq_smoke_tests_message_latency_seconds_count{plan="standard",protocol="amqp",check="warm"} 2`))
+	})
+
+	It("provisions and deletes a separate app and instance for a full check", func() {
+		c.RunOnce(true)
+
+		Expect(pushes()).To(Equal(2))
+		Expect(fake.AppNames()).To(HaveLen(1))
+		Expect(exposition()).To(ContainSubstring(`rabbitmq_smoke_tests_check_success{plan="standard",protocol="amqp",check="full"} 1`))
+		Expect(exposition()).To(ContainSubstring(`rabbitmq_smoke_tests_step_duration_seconds_count{plan="standard",protocol="amqp",step="cleanup",outcome="passed"} 1`))
+	})
+
+	Context("when a warm check fails", func() {
+		BeforeEach(func() {
+			c.RunOnce(false)
+			fake.DropMessages(1)
+			c.RunOnce(false)
+		})
+
+		It("reports it and starts afresh on the next check", func() {
+			Expect(out).To(gbytes.Say("Warm check of standard over amqp failed"))
+			Expect(exposition()).To(ContainSubstring(`check="warm"} 0`))
+			Expect(exposition()).To(ContainSubstring(`step="consume",outcome="failed"} 1`))
+			Expect(fake.AppNames()).To(BeEmpty())
+
+			c.RunOnce(false)
+			Expect(pushes()).To(Equal(2))
+			Expect(exposition()).To(ContainSubstring(`check="warm"} 1`))
+		})
+	})
+
+	It("does not report skipped steps", func() {
+		fake.FailNext("create-service", errors.New("Server error, status code: 502"))
+		c.RunOnce(false)
+
+		Expect(exposition()).To(ContainSubstring(`step="create",outcome="failed"} 1`))
+		Expect(exposition()).NotTo(ContainSubstring(`step="bind"`))
+	})
+
+	It("sends the steps of every check to StatsD", func() {
+		listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		defer listener.Close()
+
+		c.Emitter, err = statsd.NewEmitter(statsd.Config{
+			Host:      "127.0.0.1",
+			Port:      listener.LocalAddr().(*net.UDPAddr).Port,
+			DogStatsD: true,
+		})
+		Expect(err).NotTo(HaveOccurred())
+		defer c.Emitter.Close()
+
+		c.RunOnce(false)
+
+		buffer := make([]byte, 1024)
+		listener.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err := listener.ReadFrom(buffer)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(buffer[:n])).To(Equal("step.passed:1|c|#plan:standard,protocol:amqp,step:push"))
+	})
+
+	It("checks until stopped, then deletes the warm app and instance", func() {
+		c.Interval = time.Millisecond
+		c.FullInterval = time.Hour
+
+		stop := make(chan struct{})
+		done := make(chan error)
+		go func() { done <- c.Run(stop) }()
+
+		Eventually(pushes).Should(Equal(2))
+		Eventually(publishes).Should(BeNumerically(">", 5))
+		close(stop)
+
+		Eventually(done).Should(Receive(BeNil()))
+		Expect(pushes()).To(Equal(2))
+		Expect(fake.AppNames()).To(BeEmpty())
+		Expect(fake.ServiceInstanceNames()).To(BeEmpty())
+	})
+})
@@ -0,0 +1,319 @@
+package ccv3
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Client talks to the Cloud Controller v3 API directly, authenticating
+// against UAA with the password grant the cf CLI uses.
+type Client struct {
+	api      string
+	username string
+	password string
+
+	httpClient *http.Client
+
+	PollInterval time.Duration
+
+	mutex        sync.Mutex
+	tokenURL     string
+	accessToken  string
+	refreshToken string
+	tokenExpiry  time.Time
+}
+
+func NewClient(api, username, password string, skipSSLValidation bool) *Client {
+	return &Client{
+		api:      strings.TrimRight(api, "/"),
+		username: username,
+		password: password,
+		httpClient: &http.Client{
+			Timeout: 2 * time.Minute,
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: skipSSLValidation},
+			},
+		},
+		PollInterval: 2 * time.Second,
+	}
+}
+
+// WrapHTTPClient sends the requests to the Cloud Controller and UAA
+// through what wrap makes of the client's HTTP client, e.g. to trace them.
+func (c *Client) WrapHTTPClient(wrap func(*http.Client) *http.Client) *Client {
+	c.httpClient = wrap(c.httpClient)
+	return c
+}
+
+// Error is a Cloud Controller or UAA error response.
+type Error struct {
+	StatusCode int           `json:"-"`
+	Method     string        `json:"-"`
+	Path       string        `json:"-"`
+	Errors     []ErrorDetail `json:"errors"`
+	Body       string        `json:"-"`
+}
+
+type ErrorDetail struct {
+	Code   int    `json:"code"`
+	Title  string `json:"title"`
+	Detail string `json:"detail"`
+}
+
+func (e *Error) Error() string {
+	if len(e.Errors) == 0 {
+		return fmt.Sprintf("%s %s: unexpected status %d: %s", e.Method, e.Path, e.StatusCode, e.Body)
+	}
+
+	details := []string{}
+	for _, detail := range e.Errors {
+		details = append(details, fmt.Sprintf("%s: %s", detail.Title, detail.Detail))
+	}
+	return fmt.Sprintf("%s %s: status %d: %s", e.Method, e.Path, e.StatusCode, strings.Join(details, "; "))
+}
+
+// Do sends a JSON request to the Cloud Controller and decodes the JSON
+// response into result, which may be nil. The returned Location header is
+// set for asynchronous operations that are tracked by a job.
+func (c *Client) Do(method, path string, body, result interface{}) (location string, err error) {
+	var payload []byte
+	if body != nil {
+		payload, err = json.Marshal(body)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return c.send(method, path, "application/json", payload, result)
+}
+
+// Upload sends a multipart/form-data request with a single file field, as
+// needed for package bits.
+func (c *Client) Upload(path, field, fileName string, contents io.Reader, result interface{}) error {
+	buffer := &bytes.Buffer{}
+	writer := multipart.NewWriter(buffer)
+	part, err := writer.CreateFormFile(field, fileName)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(part, contents); err != nil {
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+
+	_, err = c.send("POST", path, writer.FormDataContentType(), buffer.Bytes(), result)
+	return err
+}
+
+func (c *Client) send(method, path, contentType string, payload []byte, result interface{}) (string, error) {
+	response, err := c.sendAuthenticated(method, path, contentType, payload)
+	if err != nil {
+		return "", err
+	}
+
+	if response.StatusCode == http.StatusUnauthorized {
+		response.Body.Close()
+		c.expireToken()
+		response, err = c.sendAuthenticated(method, path, contentType, payload)
+		if err != nil {
+			return "", err
+		}
+	}
+	defer response.Body.Close()
+
+	responseBody, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return "", err
+	}
+
+	if response.StatusCode >= 300 {
+		ccErr := &Error{StatusCode: response.StatusCode, Method: method, Path: path, Body: string(responseBody)}
+		json.Unmarshal(responseBody, ccErr)
+		return "", ccErr
+	}
+
+	if result != nil && len(responseBody) > 0 {
+		if err := json.Unmarshal(responseBody, result); err != nil {
+			return "", fmt.Errorf("%s %s: decoding response: %s", method, path, err.Error())
+		}
+	}
+
+	return response.Header.Get("Location"), nil
+}
+
+func (c *Client) sendAuthenticated(method, path, contentType string, payload []byte) (*http.Response, error) {
+	token, err := c.token()
+	if err != nil {
+		return nil, err
+	}
+
+	request, err := http.NewRequest(method, c.resolve(path), bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Authorization", token)
+	request.Header.Set("Accept", "application/json")
+	if payload != nil {
+		request.Header.Set("Content-Type", contentType)
+	}
+
+	return c.httpClient.Do(request)
+}
+
+func (c *Client) resolve(path string) string {
+	if strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://") {
+		return path
+	}
+	return c.api + path
+}
+
+type rootResponse struct {
+	Links map[string]struct {
+		Href string `json:"href"`
+	} `json:"links"`
+}
+
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"`
+}
+
+// token returns a bearer token, fetching or refreshing it from UAA when it
+// is missing or about to expire.
+func (c *Client) token() (string, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.accessToken != "" && time.Now().Before(c.tokenExpiry) {
+		return c.accessToken, nil
+	}
+
+	if c.tokenURL == "" {
+		tokenURL, err := c.discoverTokenURL()
+		if err != nil {
+			return "", err
+		}
+		c.tokenURL = tokenURL
+	}
+
+	form := url.Values{}
+	if c.refreshToken != "" {
+		form.Set("grant_type", "refresh_token")
+		form.Set("refresh_token", c.refreshToken)
+	} else {
+		form.Set("grant_type", "password")
+		form.Set("username", c.username)
+		form.Set("password", c.password)
+	}
+
+	token, err := c.requestToken(form)
+	if err != nil && c.refreshToken != "" {
+		c.refreshToken = ""
+		form = url.Values{"grant_type": {"password"}, "username": {c.username}, "password": {c.password}}
+		token, err = c.requestToken(form)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	tokenType := token.TokenType
+	if tokenType == "" || strings.EqualFold(tokenType, "bearer") {
+		tokenType = "bearer"
+	}
+	c.accessToken = tokenType + " " + token.AccessToken
+	c.refreshToken = token.RefreshToken
+	// refresh a little early so a token never expires mid-request, but by no
+	// more than half its lifetime so a short-lived token is still reused
+	lifetime := time.Duration(token.ExpiresIn) * time.Second
+	margin := 30 * time.Second
+	if margin > lifetime/2 {
+		margin = lifetime / 2
+	}
+	c.tokenExpiry = time.Now().Add(lifetime - margin)
+
+	return c.accessToken, nil
+}
+
+func (c *Client) expireToken() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.tokenExpiry = time.Time{}
+}
+
+func (c *Client) discoverTokenURL() (string, error) {
+	response, err := c.httpClient.Get(c.api + "/")
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return "", err
+	}
+	if response.StatusCode != http.StatusOK {
+		return "", &Error{StatusCode: response.StatusCode, Method: "GET", Path: "/", Body: string(body)}
+	}
+
+	var root rootResponse
+	if err := json.Unmarshal(body, &root); err != nil {
+		return "", fmt.Errorf("GET /: decoding response: %s", err.Error())
+	}
+
+	login := root.Links["login"].Href
+	if login == "" {
+		login = root.Links["uaa"].Href
+	}
+	if login == "" {
+		return "", fmt.Errorf("GET /: no login or uaa link in the API root")
+	}
+
+	return strings.TrimRight(login, "/") + "/oauth/token", nil
+}
+
+func (c *Client) requestToken(form url.Values) (tokenResponse, error) {
+	var token tokenResponse
+
+	request, err := http.NewRequest("POST", c.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return token, err
+	}
+	request.SetBasicAuth("cf", "")
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Accept", "application/json")
+
+	response, err := c.httpClient.Do(request)
+	if err != nil {
+		return token, err
+	}
+	defer response.Body.Close()
+
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return token, err
+	}
+	if response.StatusCode != http.StatusOK {
+		// the body can echo the request, which carries the password
+		return token, &Error{StatusCode: response.StatusCode, Method: "POST", Path: "/oauth/token"}
+	}
+
+	if err := json.Unmarshal(body, &token); err != nil {
+		return token, fmt.Errorf("POST /oauth/token: decoding response: %s", err.Error())
+	}
+	return token, nil
+}
@@ -0,0 +1,213 @@
+package ccv3_test
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/ccv3"
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/tracing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+)
+
+var _ = Describe("Client", func() {
+	var (
+		server     *ghttp.Server
+		client     *ccv3.Client
+		tokenCalls int
+		expiresIn  int
+	)
+
+	BeforeEach(func() {
+		server = ghttp.NewServer()
+		tokenCalls = 0
+		expiresIn = 3600
+
+		server.RouteToHandler("GET", "/", ghttp.RespondWithJSONEncoded(http.StatusOK, map[string]interface{}{
+			"links": map[string]interface{}{
+				"login": map[string]string{"href": server.URL()},
+			},
+		}))
+		server.RouteToHandler("POST", "/oauth/token", func(w http.ResponseWriter, req *http.Request) {
+			tokenCalls++
+			Expect(req.ParseForm()).To(Succeed())
+			username, password, ok := req.BasicAuth()
+			Expect(ok).To(BeTrue())
+			Expect(username).To(Equal("cf"))
+			Expect(password).To(BeEmpty())
+
+			if tokenCalls == 1 {
+				Expect(req.Form.Get("grant_type")).To(Equal("password"))
+				Expect(req.Form.Get("username")).To(Equal("fake-user"))
+				Expect(req.Form.Get("password")).To(Equal("fake-password"))
+			} else {
+				Expect(req.Form.Get("grant_type")).To(Equal("refresh_token"))
+				Expect(req.Form.Get("refresh_token")).To(Equal("fake-refresh-token"))
+			}
+
+			ghttp.RespondWithJSONEncoded(http.StatusOK, map[string]interface{}{
+				"access_token":  "fake-token",
+				"token_type":    "bearer",
+				"refresh_token": "fake-refresh-token",
+				"expires_in":    expiresIn,
+			})(w, req)
+		})
+
+		client = ccv3.NewClient(server.URL(), "fake-user", "fake-password", false)
+		client.PollInterval = time.Millisecond
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	Describe("Do", func() {
+		It("authenticates with a token from the login server advertised by the API", func() {
+			server.AppendHandlers(ghttp.CombineHandlers(
+				ghttp.VerifyRequest("GET", "/v3/apps/fake-guid"),
+				ghttp.VerifyHeaderKV("Authorization", "bearer fake-token"),
+				ghttp.RespondWithJSONEncoded(http.StatusOK, map[string]string{"guid": "fake-guid", "name": "fake-app"}),
+			))
+
+			var app ccv3.App
+			_, err := client.Do("GET", "/v3/apps/fake-guid", nil, &app)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(app.Name).To(Equal("fake-app"))
+		})
+
+		It("reuses the token until it expires", func() {
+			server.AppendHandlers(
+				ghttp.RespondWith(http.StatusOK, "{}"),
+				ghttp.RespondWith(http.StatusOK, "{}"),
+			)
+
+			_, err := client.Do("GET", "/v3/apps", nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+			_, err = client.Do("GET", "/v3/apps", nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(tokenCalls).To(Equal(1))
+		})
+
+		It("reuses a short-lived token instead of treating it as already expired", func() {
+			expiresIn = 20
+			server.AppendHandlers(
+				ghttp.RespondWith(http.StatusOK, "{}"),
+				ghttp.RespondWith(http.StatusOK, "{}"),
+			)
+
+			_, err := client.Do("GET", "/v3/apps", nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+			_, err = client.Do("GET", "/v3/apps", nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(tokenCalls).To(Equal(1))
+		})
+
+		It("refreshes the token and retries once when the API rejects it", func() {
+			server.AppendHandlers(
+				ghttp.RespondWith(http.StatusUnauthorized, `{"errors":[{"code":1000,"title":"CF-InvalidAuthToken"}]}`),
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("POST", "/v3/apps"),
+					ghttp.VerifyJSON(`{"name":"fake-app"}`),
+					ghttp.RespondWith(http.StatusCreated, `{"guid":"fake-guid"}`),
+				),
+			)
+
+			var app ccv3.App
+			_, err := client.Do("POST", "/v3/apps", map[string]string{"name": "fake-app"}, &app)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(app.GUID).To(Equal("fake-guid"))
+			Expect(tokenCalls).To(Equal(2))
+		})
+
+		It("returns the job location of asynchronous operations", func() {
+			server.AppendHandlers(ghttp.RespondWith(http.StatusAccepted, "", http.Header{
+				"Location": {server.URL() + "/v3/jobs/fake-job"},
+			}))
+
+			location, err := client.Do("DELETE", "/v3/service_instances/fake-guid", nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(location).To(Equal(server.URL() + "/v3/jobs/fake-job"))
+		})
+
+		It("returns Cloud Controller errors", func() {
+			server.AppendHandlers(ghttp.RespondWith(http.StatusUnprocessableEntity,
+				`{"errors":[{"code":10008,"title":"CF-UnprocessableEntity","detail":"Name must be unique"}]}`))
+
+			_, err := client.Do("POST", "/v3/apps", map[string]string{"name": "fake-app"}, nil)
+			Expect(err).To(MatchError("POST /v3/apps: status 422: CF-UnprocessableEntity: Name must be unique"))
+
+			ccErr, ok := err.(*ccv3.Error)
+			Expect(ok).To(BeTrue())
+			Expect(ccErr.StatusCode).To(Equal(http.StatusUnprocessableEntity))
+		})
+
+		It("does not leak the password when UAA rejects it", func() {
+			server.RouteToHandler("POST", "/oauth/token", ghttp.RespondWith(http.StatusUnauthorized, `{"error":"unauthorized","password":"fake-password"}`))
+
+			_, err := client.Do("GET", "/v3/apps", nil, nil)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).NotTo(ContainSubstring("fake-password"))
+		})
+	})
+
+	Describe("WrapHTTPClient", func() {
+		It("sends the requests to the API and the login server through the wrapped client", func() {
+			server.AppendHandlers(ghttp.RespondWith(http.StatusOK, "{}"))
+			tracer := tracing.NewTracer("fake-service")
+			client.WrapHTTPClient(tracer.WrapHTTPClient)
+
+			_, err := client.Do("GET", "/v3/apps", nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			urls := []interface{}{}
+			for _, span := range tracer.Finished() {
+				urls = append(urls, span.Attributes["http.url"])
+			}
+			Expect(urls).To(Equal([]interface{}{server.URL() + "/", server.URL() + "/oauth/token", server.URL() + "/v3/apps"}))
+		})
+	})
+
+	Describe("WaitForJob", func() {
+		It("polls the job until it is complete", func() {
+			server.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", "/v3/jobs/fake-job"),
+					ghttp.RespondWith(http.StatusOK, `{"guid":"fake-job","state":"PROCESSING"}`),
+				),
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", "/v3/jobs/fake-job"),
+					ghttp.RespondWith(http.StatusOK, `{"guid":"fake-job","state":"COMPLETE"}`),
+				),
+			)
+
+			Expect(client.WaitForJob(server.URL()+"/v3/jobs/fake-job", time.Second)).To(Succeed())
+			Expect(server.ReceivedRequests()).To(HaveLen(4))
+		})
+
+		It("returns the errors of a failed job", func() {
+			server.AppendHandlers(ghttp.RespondWith(http.StatusOK,
+				`{"guid":"fake-job","operation":"service_instance.create","state":"FAILED","errors":[{"title":"CF-ServiceBrokerRequestRejected","detail":"plan is full"}]}`))
+
+			err := client.WaitForJob(server.URL()+"/v3/jobs/fake-job", time.Second)
+			Expect(err).To(MatchError("job fake-job (service_instance.create) failed: CF-ServiceBrokerRequestRejected: plan is full"))
+		})
+
+		It("times out", func() {
+			server.AllowUnhandledRequests = true
+			server.UnhandledRequestStatusCode = http.StatusOK
+			server.RouteToHandler("GET", "/v3/jobs/fake-job", ghttp.RespondWith(http.StatusOK, `{"state":"PROCESSING"}`))
+
+			err := client.WaitForJob(server.URL()+"/v3/jobs/fake-job", 10*time.Millisecond)
+			Expect(err).To(MatchError(ContainSubstring("timed out")))
+		})
+
+		It("does nothing for synchronous operations", func() {
+			Expect(client.WaitForJob("", time.Second)).To(Succeed())
+			Expect(server.ReceivedRequests()).To(BeEmpty())
+		})
+	})
+})
@@ -0,0 +1,53 @@
+package ccv3
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Poll calls check every PollInterval until it reports done, returns an
+// error, or the timeout elapses.
+func (c *Client) Poll(timeout time.Duration, description string, check func() (done bool, err error)) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		done, err := check()
+		if err != nil {
+			return err
+		}
+		if done {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out after %s waiting for %s", timeout, description)
+		}
+		time.Sleep(c.PollInterval)
+	}
+}
+
+// WaitForJob polls an asynchronous job until it completes. An empty jobURL
+// means the operation finished synchronously.
+func (c *Client) WaitForJob(jobURL string, timeout time.Duration) error {
+	if jobURL == "" {
+		return nil
+	}
+
+	return c.Poll(timeout, "job "+jobURL, func() (bool, error) {
+		var job Job
+		if _, err := c.Do("GET", jobURL, nil, &job); err != nil {
+			return false, err
+		}
+
+		switch job.State {
+		case "COMPLETE":
+			return true, nil
+		case "FAILED":
+			details := []string{}
+			for _, detail := range job.Errors {
+				details = append(details, fmt.Sprintf("%s: %s", detail.Title, detail.Detail))
+			}
+			return false, fmt.Errorf("job %s (%s) failed: %s", job.GUID, job.Operation, strings.Join(details, "; "))
+		}
+		return false, nil
+	})
+}
@@ -0,0 +1,382 @@
+package ccv3
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+)
+
+var ErrNotFound = errors.New("resource not found")
+
+type Relationship struct {
+	Data *RelationshipData `json:"data"`
+}
+
+type RelationshipData struct {
+	GUID string `json:"guid"`
+}
+
+func ToOne(guid string) Relationship {
+	return Relationship{Data: &RelationshipData{GUID: guid}}
+}
+
+type App struct {
+	GUID  string `json:"guid"`
+	Name  string `json:"name"`
+	State string `json:"state"`
+}
+
+type Package struct {
+	GUID  string `json:"guid"`
+	Type  string `json:"type"`
+	State string `json:"state"`
+}
+
+type Build struct {
+	GUID    string `json:"guid"`
+	State   string `json:"state"`
+	Error   string `json:"error"`
+	Droplet *struct {
+		GUID string `json:"guid"`
+	} `json:"droplet"`
+}
+
+type ProcessInstance struct {
+	Index int    `json:"index"`
+	State string `json:"state"`
+}
+
+type Domain struct {
+	GUID string `json:"guid"`
+	Name string `json:"name"`
+}
+
+type Route struct {
+	GUID string `json:"guid"`
+	Host string `json:"host"`
+	URL  string `json:"url"`
+}
+
+type ServicePlan struct {
+	GUID string `json:"guid"`
+	Name string `json:"name"`
+}
+
+type LastOperation struct {
+	Type        string `json:"type"`
+	State       string `json:"state"`
+	Description string `json:"description"`
+}
+
+type ServiceInstance struct {
+	GUID          string        `json:"guid"`
+	Name          string        `json:"name"`
+	LastOperation LastOperation `json:"last_operation"`
+}
+
+type ServiceCredentialBinding struct {
+	GUID string `json:"guid"`
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+type Job struct {
+	GUID      string        `json:"guid"`
+	Operation string        `json:"operation"`
+	State     string        `json:"state"`
+	Errors    []ErrorDetail `json:"errors"`
+}
+
+type listResponse struct {
+	Resources interface{} `json:"resources"`
+}
+
+func (c *Client) list(path string, query url.Values, resources interface{}) error {
+	_, err := c.Do("GET", path+"?"+query.Encode(), nil, &listResponse{Resources: resources})
+	return err
+}
+
+func (c *Client) FindSpaceGUID(orgName, spaceName string) (string, error) {
+	var orgs []struct {
+		GUID string `json:"guid"`
+	}
+	if err := c.list("/v3/organizations", url.Values{"names": {orgName}}, &orgs); err != nil {
+		return "", err
+	}
+	if len(orgs) == 0 {
+		return "", fmt.Errorf("organization %s: %s", orgName, ErrNotFound)
+	}
+
+	var spaces []struct {
+		GUID string `json:"guid"`
+	}
+	query := url.Values{"names": {spaceName}, "organization_guids": {orgs[0].GUID}}
+	if err := c.list("/v3/spaces", query, &spaces); err != nil {
+		return "", err
+	}
+	if len(spaces) == 0 {
+		return "", fmt.Errorf("space %s: %s", spaceName, ErrNotFound)
+	}
+
+	return spaces[0].GUID, nil
+}
+
+func (c *Client) FindApp(spaceGUID, name string) (App, error) {
+	var apps []App
+	if err := c.list("/v3/apps", url.Values{"names": {name}, "space_guids": {spaceGUID}}, &apps); err != nil {
+		return App{}, err
+	}
+	if len(apps) == 0 {
+		return App{}, fmt.Errorf("app %s: %s", name, ErrNotFound)
+	}
+	return apps[0], nil
+}
+
+func (c *Client) CreateApp(name, spaceGUID, stack string) (App, error) {
+	body := map[string]interface{}{
+		"name": name,
+		"relationships": map[string]interface{}{
+			"space": ToOne(spaceGUID),
+		},
+		"lifecycle": map[string]interface{}{
+			"type": "buildpack",
+			"data": map[string]interface{}{"stack": stack},
+		},
+	}
+
+	var app App
+	_, err := c.Do("POST", "/v3/apps", body, &app)
+	return app, err
+}
+
+func (c *Client) DeleteApp(guid string) (jobURL string, err error) {
+	return c.Do("DELETE", "/v3/apps/"+guid, nil, nil)
+}
+
+func (c *Client) StartApp(guid string) error {
+	_, err := c.Do("POST", "/v3/apps/"+guid+"/actions/start", nil, nil)
+	return err
+}
+
+func (c *Client) StopApp(guid string) error {
+	_, err := c.Do("POST", "/v3/apps/"+guid+"/actions/stop", nil, nil)
+	return err
+}
+
+func (c *Client) UpdateEnvironmentVariables(appGUID string, variables map[string]string) error {
+	body := map[string]interface{}{"var": variables}
+	_, err := c.Do("PATCH", "/v3/apps/"+appGUID+"/environment_variables", body, nil)
+	return err
+}
+
+func (c *Client) ScaleProcess(appGUID, processType string, instances, memoryInMB int) error {
+	body := map[string]interface{}{}
+	if instances > 0 {
+		body["instances"] = instances
+	}
+	if memoryInMB > 0 {
+		body["memory_in_mb"] = memoryInMB
+	}
+	_, err := c.Do("POST", "/v3/apps/"+appGUID+"/processes/"+processType+"/actions/scale", body, nil)
+	return err
+}
+
+func (c *Client) ProcessStats(appGUID, processType string) ([]ProcessInstance, error) {
+	var stats listResponse
+	var instances []ProcessInstance
+	stats.Resources = &instances
+	_, err := c.Do("GET", "/v3/apps/"+appGUID+"/processes/"+processType+"/stats", nil, &stats)
+	return instances, err
+}
+
+func (c *Client) CreatePackage(appGUID string) (Package, error) {
+	body := map[string]interface{}{
+		"type": "bits",
+		"relationships": map[string]interface{}{
+			"app": ToOne(appGUID),
+		},
+	}
+
+	var pkg Package
+	_, err := c.Do("POST", "/v3/packages", body, &pkg)
+	return pkg, err
+}
+
+func (c *Client) UploadPackage(guid string, zip io.Reader) error {
+	return c.Upload("/v3/packages/"+guid+"/upload", "bits", "package.zip", zip, nil)
+}
+
+func (c *Client) GetPackage(guid string) (Package, error) {
+	var pkg Package
+	_, err := c.Do("GET", "/v3/packages/"+guid, nil, &pkg)
+	return pkg, err
+}
+
+func (c *Client) LatestPackage(appGUID string) (Package, error) {
+	var packages []Package
+	query := url.Values{"order_by": {"-created_at"}, "per_page": {"1"}}
+	if err := c.list("/v3/apps/"+appGUID+"/packages", query, &packages); err != nil {
+		return Package{}, err
+	}
+	if len(packages) == 0 {
+		return Package{}, fmt.Errorf("package for app %s: %s", appGUID, ErrNotFound)
+	}
+	return packages[0], nil
+}
+
+func (c *Client) CreateBuild(packageGUID string) (Build, error) {
+	body := map[string]interface{}{
+		"package": RelationshipData{GUID: packageGUID},
+	}
+
+	var build Build
+	_, err := c.Do("POST", "/v3/builds", body, &build)
+	return build, err
+}
+
+func (c *Client) GetBuild(guid string) (Build, error) {
+	var build Build
+	_, err := c.Do("GET", "/v3/builds/"+guid, nil, &build)
+	return build, err
+}
+
+func (c *Client) SetCurrentDroplet(appGUID, dropletGUID string) error {
+	_, err := c.Do("PATCH", "/v3/apps/"+appGUID+"/relationships/current_droplet", ToOne(dropletGUID), nil)
+	return err
+}
+
+func (c *Client) FindDomain(name string) (Domain, error) {
+	var domains []Domain
+	if err := c.list("/v3/domains", url.Values{"names": {name}}, &domains); err != nil {
+		return Domain{}, err
+	}
+	if len(domains) == 0 {
+		return Domain{}, fmt.Errorf("domain %s: %s", name, ErrNotFound)
+	}
+	return domains[0], nil
+}
+
+func (c *Client) CreateRoute(spaceGUID, domainGUID, host string) (Route, error) {
+	body := map[string]interface{}{
+		"host": host,
+		"relationships": map[string]interface{}{
+			"space":  ToOne(spaceGUID),
+			"domain": ToOne(domainGUID),
+		},
+	}
+
+	var route Route
+	_, err := c.Do("POST", "/v3/routes", body, &route)
+	return route, err
+}
+
+func (c *Client) MapRoute(routeGUID, appGUID string) error {
+	body := map[string]interface{}{
+		"destinations": []interface{}{
+			map[string]interface{}{"app": RelationshipData{GUID: appGUID}},
+		},
+	}
+	_, err := c.Do("POST", "/v3/routes/"+routeGUID+"/destinations", body, nil)
+	return err
+}
+
+func (c *Client) FindServicePlan(offeringName, planName string) (ServicePlan, error) {
+	var plans []ServicePlan
+	query := url.Values{"names": {planName}, "service_offering_names": {offeringName}}
+	if err := c.list("/v3/service_plans", query, &plans); err != nil {
+		return ServicePlan{}, err
+	}
+	if len(plans) == 0 {
+		return ServicePlan{}, fmt.Errorf("plan %s of service %s: %s", planName, offeringName, ErrNotFound)
+	}
+	return plans[0], nil
+}
+
+func (c *Client) CreateServiceInstance(name, spaceGUID, planGUID string) (jobURL string, err error) {
+	body := map[string]interface{}{
+		"type": "managed",
+		"name": name,
+		"relationships": map[string]interface{}{
+			"space":        ToOne(spaceGUID),
+			"service_plan": ToOne(planGUID),
+		},
+	}
+	return c.Do("POST", "/v3/service_instances", body, nil)
+}
+
+func (c *Client) FindServiceInstance(spaceGUID, name string) (ServiceInstance, error) {
+	var instances []ServiceInstance
+	query := url.Values{"names": {name}, "space_guids": {spaceGUID}}
+	if err := c.list("/v3/service_instances", query, &instances); err != nil {
+		return ServiceInstance{}, err
+	}
+	if len(instances) == 0 {
+		return ServiceInstance{}, fmt.Errorf("service instance %s: %s", name, ErrNotFound)
+	}
+	return instances[0], nil
+}
+
+func (c *Client) DeleteServiceInstance(guid string) (jobURL string, err error) {
+	return c.Do("DELETE", "/v3/service_instances/"+guid, nil, nil)
+}
+
+// ShareServiceInstance makes the instance visible in another space, so
+// that apps there can bind to it.
+func (c *Client) ShareServiceInstance(instanceGUID, spaceGUID string) error {
+	body := map[string]interface{}{
+		"data": []RelationshipData{{GUID: spaceGUID}},
+	}
+	_, err := c.Do("POST", "/v3/service_instances/"+instanceGUID+"/relationships/shared_spaces", body, nil)
+	return err
+}
+
+// UnshareServiceInstance takes the instance back from a space; the Cloud
+// Controller deletes the bindings and keys it has there.
+func (c *Client) UnshareServiceInstance(instanceGUID, spaceGUID string) error {
+	_, err := c.Do("DELETE", "/v3/service_instances/"+instanceGUID+"/relationships/shared_spaces/"+spaceGUID, nil, nil)
+	return err
+}
+
+func (c *Client) CreateAppBinding(instanceGUID, appGUID string) (jobURL string, err error) {
+	body := map[string]interface{}{
+		"type": "app",
+		"relationships": map[string]interface{}{
+			"service_instance": ToOne(instanceGUID),
+			"app":              ToOne(appGUID),
+		},
+	}
+	return c.Do("POST", "/v3/service_credential_bindings", body, nil)
+}
+
+func (c *Client) CreateServiceKey(instanceGUID, name string) (jobURL string, err error) {
+	body := map[string]interface{}{
+		"type": "key",
+		"name": name,
+		"relationships": map[string]interface{}{
+			"service_instance": ToOne(instanceGUID),
+		},
+	}
+	return c.Do("POST", "/v3/service_credential_bindings", body, nil)
+}
+
+// FindServiceCredentialBindings filters bindings with the query parameters
+// documented for GET /v3/service_credential_bindings, e.g. app_guids,
+// service_instance_guids, names and type.
+func (c *Client) FindServiceCredentialBindings(query url.Values) ([]ServiceCredentialBinding, error) {
+	var bindings []ServiceCredentialBinding
+	err := c.list("/v3/service_credential_bindings", query, &bindings)
+	return bindings, err
+}
+
+func (c *Client) ServiceCredentialBindingCredentials(guid string) (map[string]interface{}, error) {
+	var details struct {
+		Credentials map[string]interface{} `json:"credentials"`
+	}
+	_, err := c.Do("GET", "/v3/service_credential_bindings/"+guid+"/details", nil, &details)
+	return details.Credentials, err
+}
+
+func (c *Client) DeleteServiceCredentialBinding(guid string) (jobURL string, err error) {
+	return c.Do("DELETE", "/v3/service_credential_bindings/"+guid, nil, nil)
+}
@@ -0,0 +1,108 @@
+package credentials
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+)
+
+// Protocol names, as keys of the protocols in the credentials. The broker
+// adds "+ssl" to them when the plan requires TLS.
+const (
+	AMQP       = "amqp"
+	MQTT       = "mqtt"
+	STOMP      = "stomp"
+	Management = "management"
+)
+
+// Credentials are what the RabbitMQ service broker hands out to a binding
+// or a service key.
+type Credentials struct {
+	Hostname string `json:"hostname"`
+	VHost    string `json:"vhost"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	URI      string `json:"uri"`
+	SSL      bool   `json:"ssl"`
+
+	// Hostnames are the hosts of all the broker's nodes, for a cluster.
+	Hostnames []string `json:"hostnames"`
+
+	// HTTPAPIURI is the management API, with the username and password
+	// in it.
+	HTTPAPIURI string `json:"http_api_uri"`
+
+	Protocols map[string]Protocol `json:"protocols"`
+
+	// DashboardURL is the management UI.
+	DashboardURL string `json:"dashboard_url"`
+}
+
+// Protocol is how to connect over one protocol.
+type Protocol struct {
+	URI      string `json:"uri"`
+	Host     string `json:"host"`
+	Port     int    `json:"port"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	VHost    string `json:"vhost"`
+	Path     string `json:"path"`
+	SSL      bool   `json:"ssl"`
+
+	// Hosts are the hosts of all the broker's nodes, for a cluster.
+	Hosts []string `json:"hosts"`
+}
+
+// Parse reads credentials as returned by platform.Platform's ServiceKey or
+// found in VCAP_SERVICES.
+func Parse(raw map[string]interface{}) (Credentials, error) {
+	var credentials Credentials
+
+	encoded, err := json.Marshal(raw)
+	if err != nil {
+		return credentials, err
+	}
+	if err := json.Unmarshal(encoded, &credentials); err != nil {
+		return credentials, fmt.Errorf("unexpected credentials: %s", err.Error())
+	}
+	return credentials, nil
+}
+
+// Protocol returns how to connect over protocol, preferring TLS when the
+// credentials offer both.
+func (c Credentials) Protocol(name string) (Protocol, bool) {
+	if protocol, ok := c.Protocols[name+"+ssl"]; ok {
+		return protocol, true
+	}
+	protocol, ok := c.Protocols[name]
+	return protocol, ok
+}
+
+// ManagementAPI returns the URL of the management API, without the
+// username and password, which are returned separately.
+func (c Credentials) ManagementAPI() (apiURL, username, password string, err error) {
+	uri := c.HTTPAPIURI
+	if uri == "" {
+		if protocol, ok := c.Protocol(Management); ok {
+			uri = protocol.URI
+		}
+	}
+	if uri == "" {
+		return "", "", "", fmt.Errorf("the credentials have no management API")
+	}
+
+	parsed, err := url.Parse(uri)
+	if err != nil {
+		return "", "", "", fmt.Errorf("invalid management API URI: %s", err.Error())
+	}
+
+	username, password = c.Username, c.Password
+	if parsed.User != nil {
+		username = parsed.User.Username()
+		if p, ok := parsed.User.Password(); ok {
+			password = p
+		}
+		parsed.User = nil
+	}
+	return parsed.String(), username, password, nil
+}
@@ -0,0 +1,140 @@
+package diagnostics
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/cloudfoundry-incubator/cf-test-helpers/cf"
+)
+
+// Masked replaces credentials in collected files.
+const Masked = "[PRIVATE DATA HIDDEN]"
+
+// Target is what a failed lifecycle had created.
+type Target struct {
+	AppName             string
+	AppURL              string
+	ServiceInstanceName string
+}
+
+// Collector gathers what is needed to debug a failed step, using the cf
+// CLI of the current user and the app's own routes, into files under a
+// directory.
+type Collector struct {
+	dir        string
+	timeout    time.Duration
+	httpClient *http.Client
+}
+
+func NewCollector(dir string, timeout time.Duration, httpClient *http.Client) *Collector {
+	return &Collector{
+		dir:        dir,
+		timeout:    timeout,
+		httpClient: httpClient,
+	}
+}
+
+// File is one collected diagnostic.
+type File struct {
+	Description string
+	Path        string
+}
+
+// Collect writes the diagnostics for target into a new directory named
+// after the failure. A diagnostic that cannot be collected still gets a
+// file, saying why.
+func (c *Collector) Collect(name string, target Target) ([]File, error) {
+	dir := filepath.Join(c.dir, time.Now().UTC().Format("20060102T150405")+"-"+name)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	type diagnostic struct {
+		description string
+		fileName    string
+		collect     func() string
+	}
+	diagnostics := []diagnostic{
+		{"app logs", "app-logs.txt", func() string { return c.cf("logs", target.AppName, "--recent") }},
+		{"app events", "app-events.txt", func() string { return c.cf("events", target.AppName) }},
+		{"app environment", "app-env.txt", func() string { return c.cf("env", target.AppName) }},
+		{"service instance", "service.txt", func() string { return c.cf("service", target.ServiceInstanceName) }},
+		{"app ping", "ping.txt", func() string { return c.ping(target.AppURL + "/ping") }},
+	}
+
+	files := []File{}
+	for _, d := range diagnostics {
+		path := filepath.Join(dir, d.fileName)
+		if err := ioutil.WriteFile(path, []byte(Mask(d.collect())), 0644); err != nil {
+			return files, err
+		}
+		files = append(files, File{Description: d.description, Path: path})
+	}
+	return files, nil
+}
+
+// Summary lists the collected files for a failure message.
+func Summary(files []File) string {
+	lines := []string{"Diagnostics:"}
+	for _, file := range files {
+		lines = append(lines, fmt.Sprintf("  %-17s %s", file.Description+":", file.Path))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// cf runs a cf command and returns what it printed, noting a failure or a
+// timeout rather than returning an error: the output is the diagnostic.
+func (c *Collector) cf(args ...string) string {
+	session := cf.Cf(args...)
+	header := "$ cf " + strings.Join(args, " ") + "\n\n"
+
+	timer := time.NewTimer(c.timeout)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		session.Kill()
+		return fmt.Sprintf("%s%s%s\nTimed out after %v", header, session.Out.Contents(), session.Err.Contents(), c.timeout)
+	case <-session.Exited:
+	}
+
+	output := header + string(session.Out.Contents()) + string(session.Err.Contents())
+	if session.ExitCode() != 0 {
+		output += fmt.Sprintf("\nExited with %d", session.ExitCode())
+	}
+	return output
+}
+
+func (c *Collector) ping(url string) string {
+	header := "GET " + url + "\n\n"
+
+	response, err := c.httpClient.Get(url)
+	if err != nil {
+		return header + err.Error()
+	}
+	defer response.Body.Close()
+
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return header + response.Status + "\n\n" + err.Error()
+	}
+	return header + response.Status + "\n\n" + string(body)
+}
+
+var (
+	secretValue = regexp.MustCompile(`("[A-Za-z_]*(?i:password|secret|token|uri|url)[A-Za-z_]*"\s*:\s*)"[^"]*"`)
+	urlUserInfo = regexp.MustCompile(`(://)[^/@\s"]+@`)
+)
+
+// Mask hides credentials: the values of JSON fields such as password, uri
+// and dashboard_url, and the user info of any URL.
+func Mask(text string) string {
+	text = secretValue.ReplaceAllString(text, `$1"`+Masked+`"`)
+	return urlUserInfo.ReplaceAllString(text, "${1}"+Masked+"@")
+}
@@ -0,0 +1,13 @@
+package dryrun_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestDryRun(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Dry Run Suite")
+}
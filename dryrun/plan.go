@@ -0,0 +1,77 @@
+package dryrun
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Plan is what a run would cover and the names services.NewContext picked
+// for the resources it creates.
+type Plan struct {
+	API         string
+	Backend     string
+	ServiceName string
+	Plans       []string
+	Protocols   []string
+
+	Org           string
+	ExistingOrg   bool
+	Space         string
+	User          string
+	Quota         string
+	SecurityGroup string
+}
+
+// ResolveContextNames fills in the quota and security group names from the
+// commands recorded while setting up the context; they are not exposed by
+// services.Context.
+func (r *Recorder) ResolveContextNames(plan *Plan) {
+	for _, command := range r.Commands() {
+		if len(command) < 3 {
+			continue
+		}
+
+		switch command[1] {
+		case "create-security-group":
+			plan.SecurityGroup = command[2]
+		case "curl":
+			if command[2] != "/v2/quota_definitions" || len(command) < 7 {
+				continue
+			}
+			var definition struct {
+				Name string `json:"name"`
+			}
+			if json.Unmarshal([]byte(command[6]), &definition) == nil {
+				plan.Quota = definition.Name
+			}
+		}
+	}
+}
+
+func (p Plan) Write(out io.Writer) {
+	orgNote := "created for the run"
+	if p.ExistingOrg {
+		orgNote = "existing"
+	}
+
+	none := func(value string) string {
+		if value == "" {
+			return "(none)"
+		}
+		return value
+	}
+
+	fmt.Fprintf(out, "Dry run: nothing will be created on %s\n", p.API)
+	fmt.Fprintf(out, "  backend:        %s\n", p.Backend)
+	fmt.Fprintf(out, "  org:            %s (%s)\n", p.Org, orgNote)
+	fmt.Fprintf(out, "  space:          %s\n", p.Space)
+	fmt.Fprintf(out, "  user:           %s\n", p.User)
+	fmt.Fprintf(out, "  quota:          %s\n", none(p.Quota))
+	fmt.Fprintf(out, "  security group: %s\n", none(p.SecurityGroup))
+	fmt.Fprintf(out, "  service:        %s\n", p.ServiceName)
+	for _, plan := range p.Plans {
+		fmt.Fprintf(out, "  plan:           %s (%s)\n", plan, strings.Join(p.Protocols, ", "))
+	}
+}
@@ -0,0 +1,175 @@
+package dryrun
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/onsi/gomega/gexec"
+
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/broker"
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/credentials"
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/fakeplatform"
+)
+
+// Masked replaces secrets in printed commands, like the cf CLI does in its
+// trace output.
+const Masked = "[PRIVATE DATA HIDDEN]"
+
+// Recorder stands in for cf.Cf, cf.ApiRequest and the app HTTP client. It
+// prints every operation the suite would perform and answers it from a
+// fake platform, so a whole run can be planned without touching the
+// foundation.
+type Recorder struct {
+	out  io.Writer
+	fake *fakeplatform.Platform
+
+	mutex    sync.Mutex
+	secrets  []string
+	commands [][]string
+}
+
+func NewRecorder(out io.Writer, fake *fakeplatform.Platform) *Recorder {
+	return &Recorder{
+		out:  out,
+		fake: fake,
+	}
+}
+
+// AddSecret masks value wherever it appears in printed operations.
+func (r *Recorder) AddSecret(value string) {
+	if value == "" {
+		return
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.secrets = append(r.secrets, value)
+}
+
+// Commands returns the recorded cf commands with secrets masked.
+func (r *Recorder) Commands() [][]string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([][]string{}, r.commands...)
+}
+
+// Cf can replace cf.Cf.
+func (r *Recorder) Cf(args ...string) *gexec.Session {
+	r.record(append([]string{"cf"}, args...))
+	return r.fake.Cf(args...)
+}
+
+// ApiRequest can replace cf.ApiRequest. Resources it creates get a fixed
+// placeholder guid.
+func (r *Recorder) ApiRequest(method, endpoint string, response interface{}, timeout time.Duration, data ...string) {
+	r.record([]string{"cf", "curl", endpoint, "-X", method, "-d", strings.Join(data, "")})
+
+	if response != nil {
+		json.Unmarshal([]byte(`{"metadata": {"guid": "dry-run-guid"}}`), response)
+	}
+}
+
+// HTTPClient wraps the fake platform's app client to print each request to
+// an app route.
+func (r *Recorder) HTTPClient() *http.Client {
+	return &http.Client{Transport: printingTransport{
+		recorder:  r,
+		transport: r.fake.HTTPClient().Transport,
+	}}
+}
+
+type printingTransport struct {
+	recorder  *Recorder
+	transport http.RoundTripper
+}
+
+func (t printingTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	fmt.Fprintf(t.recorder.out, "  %s %s\n", request.Method, t.recorder.mask(request.URL.String()))
+	return t.transport.RoundTrip(request)
+}
+
+// Connector wraps the fake platform's broker connector to print each
+// connection to the broker.
+func (r *Recorder) Connector() broker.Connector {
+	return printingConnector{recorder: r, connector: r.fake.Connector()}
+}
+
+type printingConnector struct {
+	recorder  *Recorder
+	connector broker.Connector
+}
+
+func (c printingConnector) AMQP(endpoint credentials.Protocol) (broker.AMQP, error) {
+	c.print(credentials.AMQP, endpoint)
+	return c.connector.AMQP(endpoint)
+}
+
+func (c printingConnector) MQTT(endpoint credentials.Protocol, clientID string) (broker.MQTT, error) {
+	c.print(credentials.MQTT, endpoint)
+	return c.connector.MQTT(endpoint, clientID)
+}
+
+func (c printingConnector) STOMP(endpoint credentials.Protocol) (broker.STOMP, error) {
+	c.print(credentials.STOMP, endpoint)
+	return c.connector.STOMP(endpoint)
+}
+
+func (c printingConnector) print(protocol string, endpoint credentials.Protocol) {
+	endpoint = broker.Resolve(endpoint)
+	fmt.Fprintf(c.recorder.out, "  connect over %s to %s as %s\n", protocol, broker.Address(endpoint), c.recorder.mask(endpoint.Username))
+}
+
+// Dial prints the TLS handshakes the suite would make, for
+// tlsprobe.Config, and answers them from the fake platform, whose
+// CACertificates are to be trusted.
+func (r *Recorder) Dial(network, address string) (net.Conn, error) {
+	fmt.Fprintf(r.out, "  handshake over TLS with %s\n", address)
+	return r.fake.Dial(network, address)
+}
+
+func (r *Recorder) CACertificates() (*x509.CertPool, error) {
+	return r.fake.CACertificates()
+}
+
+func (r *Recorder) record(command []string) {
+	masked := make([]string, len(command))
+	for i, arg := range command {
+		masked[i] = r.mask(arg)
+	}
+
+	r.mutex.Lock()
+	r.commands = append(r.commands, masked)
+	r.mutex.Unlock()
+
+	fmt.Fprintf(r.out, "  %s\n", strings.Join(quote(masked), " "))
+}
+
+func (r *Recorder) mask(value string) string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for _, secret := range r.secrets {
+		value = strings.Replace(value, secret, Masked, -1)
+	}
+	return value
+}
+
+// quote makes printed commands copy-pastable into a shell.
+func quote(args []string) []string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		if arg == "" || strings.ContainsAny(arg, " \t\"'{}[]$*") {
+			quoted[i] = "'" + strings.Replace(arg, "'", `'\''`, -1) + "'"
+		} else {
+			quoted[i] = arg
+		}
+	}
+	return quoted
+}
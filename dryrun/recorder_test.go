@@ -0,0 +1,123 @@
+package dryrun_test
+
+import (
+	"time"
+
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/credentials"
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/dryrun"
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/fakeplatform"
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/tlsprobe"
+	"github.com/cloudfoundry-incubator/cf-test-helpers/cf"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+	"github.com/onsi/gomega/gexec"
+)
+
+var _ = Describe("Recorder", func() {
+	var (
+		out      *gbytes.Buffer
+		recorder *dryrun.Recorder
+	)
+
+	BeforeEach(func() {
+		out = gbytes.NewBuffer()
+		recorder = dryrun.NewRecorder(out, fakeplatform.New("fake-domain"))
+		recorder.AddSecret("s3cr3t")
+	})
+
+	It("prints cf commands with secrets masked and answers them from the fake", func() {
+		session := recorder.Cf("auth", "admin", "s3cr3t").Wait(time.Second)
+		Expect(session).To(gexec.Exit(0))
+
+		Expect(out).To(gbytes.Say(`  cf auth admin '\[PRIVATE DATA HIDDEN\]'\n`))
+		Expect(recorder.Commands()).To(Equal([][]string{{"cf", "auth", "admin", "[PRIVATE DATA HIDDEN]"}}))
+	})
+
+	It("tracks the apps and instances of the planned run", func() {
+		Expect(recorder.Cf("push", "fake-app", "-p", "fake/path", "-no-start").Wait(time.Second)).To(gexec.Exit(0))
+		Expect(recorder.Cf("bind-service", "fake-app", "missing-instance").Wait(time.Second)).To(gexec.Exit(1))
+	})
+
+	It("answers API requests with a placeholder resource", func() {
+		var response cf.GenericResource
+		recorder.ApiRequest("POST", "/v2/quota_definitions", &response, time.Second, `{"name":"fake-quota"}`)
+
+		Expect(response.Metadata.Guid).To(Equal("dry-run-guid"))
+		Expect(out).To(gbytes.Say(`  cf curl /v2/quota_definitions -X POST -d '{"name":"fake-quota"}'`))
+	})
+
+	It("prints requests to app routes", func() {
+		response, err := recorder.HTTPClient().Get("https://fake-app.fake-domain/ping")
+		Expect(err).NotTo(HaveOccurred())
+		response.Body.Close()
+
+		Expect(out).To(gbytes.Say("  GET https://fake-app.fake-domain/ping"))
+	})
+
+	It("prints connections to the broker", func() {
+		fake := fakeplatform.New("fake-domain")
+		recorder = dryrun.NewRecorder(out, fake)
+		Expect(fake.CreateService("p-rabbitmq", "standard", "fake-instance")).To(Succeed())
+		Expect(fake.CreateServiceKey("fake-instance", "fake-key")).To(Succeed())
+		raw, err := fake.ServiceKey("fake-instance", "fake-key")
+		Expect(err).NotTo(HaveOccurred())
+		key, err := credentials.Parse(raw)
+		Expect(err).NotTo(HaveOccurred())
+
+		endpoint, _ := key.Protocol(credentials.STOMP)
+		client, err := recorder.Connector().STOMP(endpoint)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(client.Send("/queue/test-q", []byte("hello"), nil)).To(Succeed())
+
+		Expect(out).To(gbytes.Say("  connect over stomp to rabbitmq.fake:61613 as user-fake-key"))
+	})
+
+	It("prints TLS handshakes with the broker", func() {
+		recorder = dryrun.NewRecorder(out, fakeplatform.New("fake-domain"))
+		roots, err := recorder.CACertificates()
+		Expect(err).NotTo(HaveOccurred())
+
+		result, err := tlsprobe.Probe("rabbitmq.fake:5671", "rabbitmq.fake", tlsprobe.Config{RootCAs: roots, Dial: recorder.Dial})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.Problems).To(BeEmpty())
+		Expect(out).To(gbytes.Say("  handshake over TLS with rabbitmq.fake:5671"))
+	})
+
+	Describe("ResolveContextNames", func() {
+		It("finds the quota and security group names used by the context", func() {
+			recorder.ApiRequest("POST", "/v2/quota_definitions", nil, time.Second, `{"name":"fake-quota"}`)
+			recorder.Cf("create-security-group", "fake-security-group", "/tmp/rules.json").Wait(time.Second)
+
+			plan := dryrun.Plan{}
+			recorder.ResolveContextNames(&plan)
+
+			Expect(plan.Quota).To(Equal("fake-quota"))
+			Expect(plan.SecurityGroup).To(Equal("fake-security-group"))
+		})
+	})
+})
+
+var _ = Describe("Plan", func() {
+	It("lists the resources and plans of the run", func() {
+		out := gbytes.NewBuffer()
+		dryrun.Plan{
+			API:         "https://api.fake",
+			Backend:     "cli",
+			ServiceName: "p-rabbitmq",
+			Plans:       []string{"standard", "ha"},
+			Protocols:   []string{"amqp", "mqtt"},
+			Org:         "fake-org",
+			ExistingOrg: true,
+			Space:       "fake-space",
+			User:        "fake-user",
+		}.Write(out)
+
+		Expect(out).To(gbytes.Say("Dry run: nothing will be created on https://api.fake"))
+		Expect(out).To(gbytes.Say(`org:            fake-org \(existing\)`))
+		Expect(out).To(gbytes.Say(`quota:          \(none\)`))
+		Expect(out).To(gbytes.Say(`plan:           standard \(amqp, mqtt\)`))
+		Expect(out).To(gbytes.Say(`plan:           ha \(amqp, mqtt\)`))
+	})
+})
@@ -0,0 +1,178 @@
+package exampleapp
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Client talks to one of the cf-rabbitmq-example apps over its route.
+type Client struct {
+	url        string
+	httpClient *http.Client
+
+	// instance, when set, has the router send every request to one
+	// instance of the app, as appGUID:index.
+	instance string
+}
+
+func NewClient(appURL string, httpClient *http.Client) *Client {
+	return &Client{
+		url:        strings.TrimRight(appURL, "/"),
+		httpClient: httpClient,
+	}
+}
+
+// NewHTTPClient returns the client used to reach app routes, which
+// verifies their certificates with tlsConfig; nil verifies them against
+// the system's CAs.
+func NewHTTPClient(timeout time.Duration, tlsConfig *tls.Config) *http.Client {
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: tlsConfig,
+		},
+	}
+}
+
+// CertificateError is a request refused because the route's certificate
+// did not verify: it expired, it is for another host, or no trusted CA
+// signed it. Waiting does not fix it, so it is not worth retrying.
+type CertificateError struct {
+	Err error
+}
+
+func (e *CertificateError) Error() string {
+	return fmt.Sprintf("the app route's certificate was rejected: %s", e.Err.Error())
+}
+
+func isCertificateError(err error) bool {
+	var verification *tls.CertificateVerificationError
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostname x509.HostnameError
+	var invalid x509.CertificateInvalidError
+	return errors.As(err, &verification) ||
+		errors.As(err, &unknownAuthority) ||
+		errors.As(err, &hostname) ||
+		errors.As(err, &invalid)
+}
+
+// Instance returns a client whose requests all go to the app instance with
+// the given index, through the X-CF-APP-INSTANCE header.
+func (c *Client) Instance(appGUID string, index int) *Client {
+	return &Client{
+		url:        c.url,
+		httpClient: c.httpClient,
+		instance:   fmt.Sprintf("%s:%d", appGUID, index),
+	}
+}
+
+func (c *Client) URL() string {
+	return c.url
+}
+
+func (c *Client) Ping() error {
+	body, err := c.do("GET", "/ping", nil)
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(body, "OK") {
+		return fmt.Errorf("GET %s/ping: expected OK, got %q", c.url, body)
+	}
+	return nil
+}
+
+// CreateQueue declares a queue through the AMQP app.
+func (c *Client) CreateQueue(name string) error {
+	return c.expectSuccess("POST", "/queues", url.Values{"name": {name}})
+}
+
+// Queues lists the queues known to the AMQP app.
+func (c *Client) Queues() ([]string, error) {
+	body, err := c.do("GET", "/queues", nil)
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(body), nil
+}
+
+func (c *Client) Publish(queue, data string) error {
+	return c.expectSuccess("PUT", "/queue/"+queue, url.Values{"data": {data}})
+}
+
+// Consume reads the next message from the queue. An empty queue yields an
+// empty message.
+func (c *Client) Consume(queue string) (string, error) {
+	return c.do("GET", "/queue/"+queue, nil)
+}
+
+// ConsumeMessage reads the next message from the queue, telling an empty
+// queue (the app answers 204 No Content) from an empty message.
+func (c *Client) ConsumeMessage(queue string) (message string, ok bool, err error) {
+	status, body, err := c.doWithStatus("GET", "/queue/"+queue, nil)
+	if err != nil {
+		return "", false, err
+	}
+	return body, status != http.StatusNoContent, nil
+}
+
+func (c *Client) expectSuccess(method, path string, form url.Values) error {
+	body, err := c.do(method, path, form)
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(body, "SUCCESS") {
+		return fmt.Errorf("%s %s%s: expected SUCCESS, got %q", method, c.url, path, body)
+	}
+	return nil
+}
+
+func (c *Client) do(method, path string, form url.Values) (string, error) {
+	_, body, err := c.doWithStatus(method, path, form)
+	return body, err
+}
+
+func (c *Client) doWithStatus(method, path string, form url.Values) (int, string, error) {
+	var request *http.Request
+	var err error
+	if form != nil {
+		request, err = http.NewRequest(method, c.url+path, strings.NewReader(form.Encode()))
+		if err == nil {
+			request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+	} else {
+		request, err = http.NewRequest(method, c.url+path, nil)
+	}
+	if err != nil {
+		return 0, "", err
+	}
+	if c.instance != "" {
+		request.Header.Set("X-CF-APP-INSTANCE", c.instance)
+	}
+
+	response, err := c.httpClient.Do(request)
+	if err != nil {
+		if isCertificateError(err) {
+			return 0, "", &CertificateError{Err: err}
+		}
+		return 0, "", err
+	}
+	defer response.Body.Close()
+
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return 0, "", err
+	}
+
+	if response.StatusCode >= 300 {
+		return 0, "", fmt.Errorf("%s %s%s: status %d: %s", method, c.url, path, response.StatusCode, string(body))
+	}
+	return response.StatusCode, string(body), nil
+}
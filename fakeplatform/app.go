@@ -0,0 +1,143 @@
+package fakeplatform
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+)
+
+// HTTPClient returns a client whose requests to app routes are answered in
+// memory by the fake example apps.
+func (p *Platform) HTTPClient() *http.Client {
+	return &http.Client{Transport: roundTripper{p}}
+}
+
+type roundTripper struct {
+	platform *Platform
+}
+
+func (r roundTripper) RoundTrip(request *http.Request) (*http.Response, error) {
+	recorder := httptest.NewRecorder()
+	r.platform.ServeHTTP(recorder, request)
+
+	response := recorder.Result()
+	response.Request = request
+	return response, nil
+}
+
+// ServeHTTP answers like the example apps do on their routes, for apps that
+// are started and bound to a service instance, and like the management API
+// on the broker's host.
+func (p *Platform) ServeHTTP(w http.ResponseWriter, request *http.Request) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	host := strings.Split(request.Host, ":")[0]
+	if host == brokerHost {
+		p.serveManagement(w, request)
+		return
+	}
+
+	appName := strings.TrimSuffix(host, "."+p.AppsDomain)
+	app, ok := p.apps[appName]
+	if !ok || appName == host || !app.Started || app.Crashed {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "404 Not Found: Requested route ('%s') does not exist.\n", host)
+		return
+	}
+
+	// like the gorouter, route to a given instance on request, and to each
+	// in turn otherwise
+	var index int
+	if header := request.Header.Get("X-CF-APP-INSTANCE"); header == "" {
+		index = app.next % app.Instances
+		app.next++
+	} else {
+		parts := strings.SplitN(header, ":", 2)
+		guid, requested := parts[0], ""
+		if len(parts) == 2 {
+			requested = parts[1]
+		}
+		n, err := strconv.Atoi(requested)
+		if guid != app.GUID || err != nil || n < 0 || n >= app.Instances {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "400 Bad Request: Requested instance ('%s') with guid ('%s') does not exist for route ('%s')\n", requested, guid, host)
+			return
+		}
+		index = n
+	}
+
+	if request.URL.Path == "/ping" {
+		fmt.Fprint(w, "OK")
+		return
+	}
+
+	if len(app.Bindings) == 0 {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, "no rabbitmq service bound")
+		return
+	}
+	instance := p.instances[app.Bindings[0]]
+	binding := instance.Keys[bindingKey(appName)]
+	if !instance.allows(binding["username"].(string), binding["password"].(string)) {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, "Exception (403) Reason: \"ACCESS_REFUSED - Login was refused using authentication mechanism PLAIN\"")
+		return
+	}
+
+	switch {
+	case request.URL.Path == "/queues" && request.Method == "POST":
+		name := request.FormValue("name")
+		if _, ok := instance.Queues[name]; !ok {
+			instance.Queues[name] = []string{}
+		}
+		fmt.Fprint(w, "SUCCESS")
+
+	case request.URL.Path == "/queues" && request.Method == "GET":
+		for name := range instance.Queues {
+			fmt.Fprintln(w, name)
+		}
+
+	case strings.HasPrefix(request.URL.Path, "/queue/") && request.Method == "PUT":
+		name := strings.TrimPrefix(request.URL.Path, "/queue/")
+		p.deliver(instance, name, request.FormValue("data"))
+		fmt.Fprint(w, "SUCCESS")
+
+	case strings.HasPrefix(request.URL.Path, "/queue/") && request.Method == "GET":
+		name := strings.TrimPrefix(request.URL.Path, "/queue/")
+		messages := instance.Queues[name]
+		if len(messages) == 0 || p.singleActiveConsumer && index > 0 {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		instance.Queues[name] = messages[1:]
+		app.gets[index]++
+		if instance.redelivered[name] > 0 {
+			instance.redelivered[name]--
+		}
+		fmt.Fprint(w, messages[0])
+
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// deliver puts a message on a queue, unless it is to be dropped, mangling
+// or duplicating it as configured. The caller holds the lock.
+func (p *Platform) deliver(instance *ServiceInstance, queue, message string) {
+	if p.dropMessages > 0 {
+		p.dropMessages--
+		return
+	}
+	if p.truncateAt > 0 && len(message) > p.truncateAt {
+		message = message[:p.truncateAt]
+	}
+	instance.Queues[queue] = append(instance.Queues[queue], message)
+	if p.duplicates > 0 {
+		p.duplicates--
+		instance.Queues[queue] = append(instance.Queues[queue], message)
+	}
+	p.enforceQueueLimits(instance, queue, message)
+}
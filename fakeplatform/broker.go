@@ -0,0 +1,843 @@
+package fakeplatform
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"github.com/streadway/amqp"
+
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/broker"
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/credentials"
+)
+
+// queueBinding routes the messages published to an exchange with a
+// matching routing key to a queue.
+type queueBinding struct {
+	queue      string
+	exchange   string
+	routingKey string
+}
+
+// Connector returns a broker.Connector whose connections are answered in
+// memory, with the queues the example apps use. The default exchange and
+// amq.topic route like RabbitMQ's do; MQTT publishes to amq.topic, and
+// STOMP to /queue/ destinations declares the queue as it goes.
+func (p *Platform) Connector() broker.Connector {
+	return connector{p}
+}
+
+// ClusterHosts makes the credentials handed out from now on list hosts as
+// the broker's nodes, as it does for a cluster, next to the host of each
+// endpoint.
+func (p *Platform) ClusterHosts(hosts ...string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.clusterHosts = hosts
+}
+
+// DisableProtocol leaves protocol out of the credentials handed out from
+// now on, and refuses connections over it.
+func (p *Platform) DisableProtocol(protocol string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.disabledProtocols = append(p.disabledProtocols, protocol)
+}
+
+func (p *Platform) protocolEnabled(protocol string) bool {
+	for _, disabled := range p.disabledProtocols {
+		if disabled == protocol {
+			return false
+		}
+	}
+	return true
+}
+
+// Limits are what the instances of a plan allow. Zero values do not
+// limit, and no queue types allow every type.
+type Limits struct {
+	MaxMessageSize int
+	QueueTypes     []string
+
+	// MaxConnections counts a connection for each instance of the started
+	// apps bound to the instance, besides the connector's.
+	MaxConnections int
+
+	// TLSRequired leaves all but the TLS endpoints out of the credentials,
+	// and refuses plaintext connections unless PlaintextAccepted leaves
+	// those listeners open.
+	TLSRequired       bool
+	PlaintextAccepted bool
+
+	// OperatorPolicy is the definition of the operator policy that
+	// applies to every queue, e.g. {"max-length": 10000}. The broker
+	// enforces its max-length, message-ttl and dead-letter-exchange.
+	OperatorPolicy map[string]interface{}
+}
+
+// LimitPlan makes the instances of the plan enforce limits, including
+// those created before.
+func (p *Platform) LimitPlan(planName string, limits Limits) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.planLimits[planName] = limits
+}
+
+// limits are what the instance's plan allows. The caller holds the lock.
+func (p *Platform) limits(instance *ServiceInstance) Limits {
+	return p.planLimits[instance.Plan]
+}
+
+type connector struct {
+	platform *Platform
+}
+
+func (c connector) AMQP(endpoint credentials.Protocol) (broker.AMQP, error) {
+	instanceName, err := c.platform.login(credentials.AMQP, endpoint, endpoint.VHost, endpoint.Username)
+	if refusedErr, ok := err.(*net.OpError); ok {
+		return nil, refusedErr
+	}
+	if limitErr, ok := err.(connectionLimitError); ok {
+		return nil, &amqp.Error{Code: amqp.NotAllowed, Reason: limitErr.Error()}
+	}
+	if err != nil {
+		return nil, amqp.ErrCredentials
+	}
+	return &amqpSession{platform: c.platform, vhost: instanceName, username: endpoint.Username, unacked: map[uint64]unacked{}}, nil
+}
+
+func (c connector) MQTT(endpoint credentials.Protocol, clientID string) (broker.MQTT, error) {
+	parts := strings.SplitN(endpoint.Username, ":", 2)
+	vhost, username := endpoint.VHost, parts[0]
+	if len(parts) == 2 {
+		vhost, username = parts[0], parts[1]
+	}
+	instanceName, err := c.platform.login(credentials.MQTT, endpoint, vhost, username)
+	if refusedErr, ok := err.(*net.OpError); ok {
+		return nil, refusedErr
+	}
+	if _, ok := err.(connectionLimitError); ok {
+		return nil, packets.ErrorRefusedNotAuthorised
+	}
+	if err != nil {
+		return nil, packets.ErrorRefusedBadUsernameOrPassword
+	}
+	return &mqttSession{platform: c.platform, vhost: instanceName, username: username}, nil
+}
+
+func (c connector) STOMP(endpoint credentials.Protocol) (broker.STOMP, error) {
+	instanceName, err := c.platform.login(credentials.STOMP, endpoint, endpoint.VHost, endpoint.Username)
+	if refusedErr, ok := err.(*net.OpError); ok {
+		return nil, refusedErr
+	}
+	if limitErr, ok := err.(connectionLimitError); ok {
+		return nil, &broker.STOMPError{Message: "Bad CONNECT", Details: limitErr.Error()}
+	}
+	if err != nil {
+		return nil, &broker.STOMPError{Message: "Bad CONNECT", Details: "Access refused for user '" + endpoint.Username + "'"}
+	}
+	return &stompSession{platform: c.platform, vhost: instanceName, username: endpoint.Username}, nil
+}
+
+// connectionLimitError refuses a connection to a vhost that has as many
+// as its plan allows.
+type connectionLimitError struct {
+	vhost    string
+	username string
+	limit    int
+}
+
+func (e connectionLimitError) Error() string {
+	return fmt.Sprintf("NOT_ALLOWED - access to vhost '%s' refused for user '%s': connection limit (%d) is reached", e.vhost, e.username, e.limit)
+}
+
+// plaintextRefused refuses a connection without TLS to an instance of a
+// plan that requires it, as if nothing listened on the port.
+func plaintextRefused(address string) error {
+	return &net.OpError{
+		Op:   "dial",
+		Net:  "tcp",
+		Addr: tcpAddress(address),
+		Err:  &os.SyscallError{Syscall: "connect", Err: syscall.ECONNREFUSED},
+	}
+}
+
+// tcpAddress is a host and port that may be a name rather than an IP.
+type tcpAddress string
+
+func (a tcpAddress) Network() string { return "tcp" }
+func (a tcpAddress) String() string  { return string(a) }
+
+// login finds the instance whose vhost the user may access over the
+// endpoint, and counts the connection against the plan's limit.
+func (p *Platform) login(protocol string, endpoint credentials.Protocol, vhost, username string) (string, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if !p.protocolEnabled(protocol) {
+		return "", fmt.Errorf("%s is disabled", protocol)
+	}
+	instance, ok := p.instances[vhost]
+	if !ok {
+		return "", fmt.Errorf("no vhost %s", vhost)
+	}
+	endpoint = broker.Resolve(endpoint)
+	if limits := p.limits(instance); limits.TLSRequired && !limits.PlaintextAccepted && !endpoint.SSL {
+		return "", plaintextRefused(broker.Address(endpoint))
+	}
+	if !instance.allows(username, endpoint.Password) {
+		return "", fmt.Errorf("access refused")
+	}
+	if limit := p.limits(instance).MaxConnections; limit > 0 && len(p.connections(instance)) >= limit {
+		return "", connectionLimitError{vhost: vhost, username: username, limit: limit}
+	}
+	instance.connections[username]++
+	return instance.Name, nil
+}
+
+// logout closes the user's connection to the vhost, if it is still there.
+func (p *Platform) logout(vhost, username string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if instance, ok := p.instances[vhost]; ok && instance.connections[username] > 0 {
+		instance.connections[username]--
+	}
+}
+
+// session looks the vhost up again for every operation, as it may have
+// been deleted since the connection opened. The caller holds the lock.
+func (p *Platform) session(vhost string) (*ServiceInstance, error) {
+	instance, ok := p.instances[vhost]
+	if !ok {
+		return nil, fmt.Errorf("the broker closed the connection: vhost %s is deleted", vhost)
+	}
+	return instance, nil
+}
+
+// route delivers a message published to exchange to every queue bound with
+// a matching key. Unroutable messages are dropped. The caller holds the
+// lock.
+func (p *Platform) route(instance *ServiceInstance, exchange, routingKey, message string) error {
+	switch exchange {
+	case "":
+		if _, ok := instance.Queues[routingKey]; ok {
+			p.deliver(instance, routingKey, message)
+		}
+		return nil
+	case "amq.topic", "amq.direct", "amq.fanout":
+	default:
+		return &amqp.Error{Code: amqp.NotFound, Reason: fmt.Sprintf("NOT_FOUND - no exchange '%s' in vhost '%s'", exchange, instance.Name)}
+	}
+
+	routed := map[string]bool{}
+	for _, binding := range instance.bindings {
+		if binding.exchange != exchange || routed[binding.queue] {
+			continue
+		}
+		if exchange == "amq.fanout" ||
+			exchange == "amq.direct" && binding.routingKey == routingKey ||
+			exchange == "amq.topic" && topicMatches(strings.Split(binding.routingKey, "."), strings.Split(routingKey, ".")) {
+			routed[binding.queue] = true
+			p.deliver(instance, binding.queue, message)
+		}
+	}
+	return nil
+}
+
+// topicMatches matches the words of a routing key against a binding's,
+// where * stands for one word and # for any number of them.
+func topicMatches(pattern, words []string) bool {
+	if len(pattern) == 0 {
+		return len(words) == 0
+	}
+	if pattern[0] == "#" {
+		for i := 0; i <= len(words); i++ {
+			if topicMatches(pattern[1:], words[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(words) == 0 || (pattern[0] != "*" && pattern[0] != words[0]) {
+		return false
+	}
+	return topicMatches(pattern[1:], words[1:])
+}
+
+// amqpSession is closed by the broker, like a channel, when it refuses a
+// message; every later operation fails.
+type amqpSession struct {
+	platform    *Platform
+	vhost       string
+	username    string
+	deliveryTag uint64
+	err         error
+	closed      bool
+
+	prefetch int
+	consumer *consumer
+
+	// unacked are the deliveries to acknowledge, by delivery tag; the
+	// broker requeues them when the session closes.
+	unacked map[uint64]unacked
+
+	confirming    bool
+	published     uint64
+	confirmations []amqp.Confirmation
+}
+
+type unacked struct {
+	queue   string
+	message string
+}
+
+// consumer takes messages off its queue as Next asks for them; a stream
+// consumer reads from an offset instead, and leaves them there.
+type consumer struct {
+	tag     string
+	queue   string
+	autoAck bool
+	stream  bool
+	offset  int
+}
+
+func (s *amqpSession) DeclareQueue(name string, options broker.QueueOptions) (amqp.Queue, error) {
+	s.platform.mutex.Lock()
+	defer s.platform.mutex.Unlock()
+
+	instance, err := s.session()
+	if err != nil {
+		return amqp.Queue{}, err
+	}
+	messages, ok := instance.Queues[name]
+	if !ok {
+		if options.Passive {
+			return amqp.Queue{}, notFound(name, s.vhost)
+		}
+		queueType, _ := options.Arguments["x-queue-type"].(string)
+		if queueType != "" {
+			if types := s.platform.limits(instance).QueueTypes; len(types) > 0 && !contains(types, queueType) {
+				s.err = &amqp.Error{Code: amqp.PreconditionFailed, Reason: fmt.Sprintf("PRECONDITION_FAILED - invalid arg 'x-queue-type' for queue '%s' in vhost '%s': {unsupported_queue_type,<<\"%s\">>}", name, s.vhost, queueType)}
+				return amqp.Queue{}, s.err
+			}
+		}
+		if (queueType == "quorum" || queueType == "stream") && !options.Durable {
+			s.err = &amqp.Error{Code: amqp.PreconditionFailed, Reason: fmt.Sprintf("PRECONDITION_FAILED - invalid property 'non-durable' for queue '%s' in vhost '%s'", name, s.vhost)}
+			return amqp.Queue{}, s.err
+		}
+		if queueType != "" && queueType != "classic" {
+			instance.queueTypes[name] = queueType
+		}
+		if len(options.Arguments) > 0 {
+			instance.queueArguments[name] = options.Arguments
+		}
+		instance.Queues[name] = []string{}
+	}
+	return amqp.Queue{Name: name, Messages: len(messages)}, nil
+}
+
+func (s *amqpSession) DeleteQueue(name string) (int, error) {
+	s.platform.mutex.Lock()
+	defer s.platform.mutex.Unlock()
+
+	instance, err := s.session()
+	if err != nil {
+		return 0, err
+	}
+	messages := instance.Queues[name]
+	delete(instance.Queues, name)
+	delete(instance.queueTypes, name)
+	delete(instance.redelivered, name)
+	delete(instance.queueArguments, name)
+
+	bindings := []queueBinding{}
+	for _, binding := range instance.bindings {
+		if binding.queue != name {
+			bindings = append(bindings, binding)
+		}
+	}
+	instance.bindings = bindings
+	return len(messages), nil
+}
+
+func (s *amqpSession) BindQueue(queue, exchange, routingKey string, arguments amqp.Table) error {
+	s.platform.mutex.Lock()
+	defer s.platform.mutex.Unlock()
+
+	instance, err := s.session()
+	if err != nil {
+		return err
+	}
+	if _, ok := instance.Queues[queue]; !ok {
+		return notFound(queue, s.vhost)
+	}
+	instance.bindings = append(instance.bindings, queueBinding{queue: queue, exchange: exchange, routingKey: routingKey})
+	return nil
+}
+
+func (s *amqpSession) Publish(exchange, routingKey string, message amqp.Publishing) error {
+	s.platform.mutex.Lock()
+	defer s.platform.mutex.Unlock()
+
+	instance, err := s.session()
+	if err != nil {
+		return err
+	}
+	if limit := s.platform.limits(instance).MaxMessageSize; limit > 0 && len(message.Body) > limit {
+		s.err = &amqp.Error{Code: amqp.PreconditionFailed, Reason: fmt.Sprintf("PRECONDITION_FAILED - message size %d is larger than configured max size %d", len(message.Body), limit)}
+		return s.err
+	}
+	if !s.confirming {
+		return s.platform.route(instance, exchange, routingKey, string(message.Body))
+	}
+
+	s.published++
+	confirmation := amqp.Confirmation{DeliveryTag: s.published, Ack: true}
+	if s.platform.nackPublishes > 0 {
+		s.platform.nackPublishes--
+		confirmation.Ack = false
+	} else if err := s.platform.route(instance, exchange, routingKey, string(message.Body)); err != nil {
+		return err
+	}
+	s.confirmations = append(s.confirmations, confirmation)
+	return nil
+}
+
+func (s *amqpSession) Get(queue string, autoAck bool) (amqp.Delivery, bool, error) {
+	s.platform.mutex.Lock()
+	defer s.platform.mutex.Unlock()
+
+	instance, err := s.session()
+	if err != nil {
+		return amqp.Delivery{}, false, err
+	}
+	messages, ok := instance.Queues[queue]
+	if !ok {
+		return amqp.Delivery{}, false, notFound(queue, s.vhost)
+	}
+	if instance.queueType(queue) == "stream" {
+		s.err = &amqp.Error{Code: amqp.NotImplemented, Reason: fmt.Sprintf("NOT_IMPLEMENTED - basic.get not supported by stream queues queue '%s' in vhost '%s'", queue, s.vhost)}
+		return amqp.Delivery{}, false, s.err
+	}
+	if len(messages) == 0 {
+		return amqp.Delivery{}, false, nil
+	}
+
+	delivery := s.take(instance, queue, autoAck)
+	delivery.MessageCount = uint32(len(messages) - 1)
+	return delivery, true, nil
+}
+
+// take delivers the message at the head of the queue, keeping it until
+// it is acknowledged unless autoAck is set. The caller holds the lock.
+func (s *amqpSession) take(instance *ServiceInstance, queue string, autoAck bool) amqp.Delivery {
+	message := instance.Queues[queue][0]
+	instance.Queues[queue] = instance.Queues[queue][1:]
+
+	s.deliveryTag++
+	delivery := amqp.Delivery{
+		Body:        []byte(message),
+		DeliveryTag: s.deliveryTag,
+		RoutingKey:  queue,
+	}
+	if instance.redelivered[queue] > 0 {
+		instance.redelivered[queue]--
+		delivery.Redelivered = true
+	}
+	if !autoAck {
+		s.unacked[delivery.DeliveryTag] = unacked{queue: queue, message: message}
+	}
+	return delivery
+}
+
+// settle acknowledges the delivery, or every one up to it with multiple,
+// putting them back at the head of their queues with requeue. The caller
+// holds the lock.
+func (s *amqpSession) settle(deliveryTag uint64, multiple, requeue bool) error {
+	tags := []uint64{deliveryTag}
+	if multiple {
+		tags = nil
+		for tag := range s.unacked {
+			if tag <= deliveryTag {
+				tags = append(tags, tag)
+			}
+		}
+		sort.Sort(sort.Reverse(tagSlice(tags)))
+	} else if _, ok := s.unacked[deliveryTag]; !ok {
+		s.err = &amqp.Error{Code: amqp.PreconditionFailed, Reason: fmt.Sprintf("PRECONDITION_FAILED - unknown delivery tag %d", deliveryTag)}
+		return s.err
+	}
+
+	for _, tag := range tags {
+		delivery := s.unacked[tag]
+		delete(s.unacked, tag)
+		if instance, ok := s.platform.instances[s.vhost]; ok && requeue {
+			if _, ok := instance.Queues[delivery.queue]; ok {
+				instance.Queues[delivery.queue] = append([]string{delivery.message}, instance.Queues[delivery.queue]...)
+				instance.redelivered[delivery.queue]++
+			}
+		}
+	}
+	return nil
+}
+
+type tagSlice []uint64
+
+func (t tagSlice) Len() int           { return len(t) }
+func (t tagSlice) Less(i, j int) bool { return t[i] < t[j] }
+func (t tagSlice) Swap(i, j int)      { t[i], t[j] = t[j], t[i] }
+
+// Ack is ignored for streams, which keep their messages.
+func (s *amqpSession) Ack(deliveryTag uint64, multiple bool) error {
+	s.platform.mutex.Lock()
+	defer s.platform.mutex.Unlock()
+
+	if s.consumer != nil && s.consumer.stream {
+		return nil
+	}
+	return s.settle(deliveryTag, multiple, false)
+}
+
+func (s *amqpSession) Nack(deliveryTag uint64, multiple, requeue bool) error {
+	s.platform.mutex.Lock()
+	defer s.platform.mutex.Unlock()
+	return s.settle(deliveryTag, multiple, requeue)
+}
+
+func (s *amqpSession) Reject(deliveryTag uint64, requeue bool) error {
+	s.platform.mutex.Lock()
+	defer s.platform.mutex.Unlock()
+	return s.settle(deliveryTag, false, requeue)
+}
+
+func (s *amqpSession) ConfirmSelect() error {
+	s.platform.mutex.Lock()
+	defer s.platform.mutex.Unlock()
+
+	if _, err := s.session(); err != nil {
+		return err
+	}
+	s.confirming = true
+	return nil
+}
+
+// NextConfirmation returns the confirmations in order, one publish at a
+// time, or times out straight away when there is none.
+func (s *amqpSession) NextConfirmation() (amqp.Confirmation, error) {
+	s.platform.mutex.Lock()
+	defer s.platform.mutex.Unlock()
+
+	if _, err := s.session(); err != nil {
+		return amqp.Confirmation{}, err
+	}
+	if len(s.confirmations) == 0 {
+		return amqp.Confirmation{}, fmt.Errorf("timed out waiting for the broker")
+	}
+	confirmation := s.confirmations[0]
+	s.confirmations = s.confirmations[1:]
+	return confirmation, nil
+}
+
+func (s *amqpSession) Qos(prefetchCount int) error {
+	s.platform.mutex.Lock()
+	defer s.platform.mutex.Unlock()
+
+	if _, err := s.session(); err != nil {
+		return err
+	}
+	s.prefetch = prefetchCount
+	return nil
+}
+
+// Consume starts the session's one consumer. Stream consumers need a
+// prefetch, and start at the x-stream-offset argument: "first", "last",
+// "next" (the default) or a number.
+func (s *amqpSession) Consume(queue string, autoAck bool, arguments amqp.Table) (string, error) {
+	s.platform.mutex.Lock()
+	defer s.platform.mutex.Unlock()
+
+	instance, err := s.session()
+	if err != nil {
+		return "", err
+	}
+	messages, ok := instance.Queues[queue]
+	if !ok {
+		s.err = notFound(queue, s.vhost)
+		return "", s.err
+	}
+
+	c := &consumer{tag: fmt.Sprintf("amq.ctag-%d", s.deliveryTag+1), queue: queue, autoAck: autoAck}
+	if instance.queueType(queue) == "stream" {
+		if s.prefetch == 0 {
+			s.err = &amqp.Error{Code: amqp.PreconditionFailed, Reason: fmt.Sprintf("PRECONDITION_FAILED - consumer prefetch count is not set for stream queue '%s' in vhost '%s'", queue, s.vhost)}
+			return "", s.err
+		}
+		c.stream = true
+		c.offset = len(messages)
+		switch offset := arguments["x-stream-offset"].(type) {
+		case string:
+			switch offset {
+			case "first":
+				c.offset = 0
+			case "last":
+				if len(messages) > 0 {
+					c.offset = len(messages) - 1
+				}
+			}
+		case int:
+			c.offset = offset
+		case int64:
+			c.offset = int(offset)
+		}
+	}
+	s.consumer = c
+	return c.tag, nil
+}
+
+// Next delivers the consumer's next message, or times out straight away
+// when there is none.
+func (s *amqpSession) Next() (amqp.Delivery, error) {
+	s.platform.mutex.Lock()
+	defer s.platform.mutex.Unlock()
+
+	instance, err := s.session()
+	if err != nil {
+		return amqp.Delivery{}, err
+	}
+	c := s.consumer
+	if c == nil {
+		return amqp.Delivery{}, fmt.Errorf("timed out waiting for the broker")
+	}
+	messages := instance.Queues[c.queue]
+
+	delivery := amqp.Delivery{ConsumerTag: c.tag, RoutingKey: c.queue}
+	switch {
+	case c.stream && c.offset < len(messages):
+		delivery.Body = []byte(messages[c.offset])
+		delivery.Headers = amqp.Table{"x-stream-offset": int64(c.offset)}
+		c.offset++
+	case !c.stream && len(messages) > 0:
+		delivery = s.take(instance, c.queue, c.autoAck)
+		delivery.ConsumerTag = c.tag
+		return delivery, nil
+	default:
+		return amqp.Delivery{}, fmt.Errorf("timed out waiting for the broker")
+	}
+	s.deliveryTag++
+	delivery.DeliveryTag = s.deliveryTag
+	return delivery, nil
+}
+
+func (s *amqpSession) Cancel(consumerTag string) error {
+	s.platform.mutex.Lock()
+	defer s.platform.mutex.Unlock()
+
+	if s.consumer != nil && s.consumer.tag == consumerTag {
+		s.consumer = nil
+	}
+	return nil
+}
+
+// Close requeues the deliveries that were not acknowledged.
+func (s *amqpSession) Close() error {
+	if !s.closed {
+		s.platform.mutex.Lock()
+		s.settle(math.MaxUint64, true, true)
+		s.platform.mutex.Unlock()
+
+		s.closed = true
+		s.platform.logout(s.vhost, s.username)
+	}
+	return nil
+}
+
+// session is the session's instance, unless the broker closed the
+// session, when every call reports what it closed it with. The caller
+// holds the lock.
+func (s *amqpSession) session() (*ServiceInstance, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	instance, err := s.platform.session(s.vhost)
+	if err != nil {
+		s.err = &amqp.Error{Code: amqp.ConnectionForced, Reason: fmt.Sprintf("CONNECTION_FORCED - vhost %s is deleted", s.vhost)}
+		return nil, s.err
+	}
+	return instance, nil
+}
+
+func notFound(queue, vhost string) error {
+	return &amqp.Error{Code: amqp.NotFound, Reason: fmt.Sprintf("NOT_FOUND - no queue '%s' in vhost '%s'", queue, vhost)}
+}
+
+type mqttSession struct {
+	platform *Platform
+	vhost    string
+	username string
+	closed   bool
+}
+
+// Publish routes through amq.topic, with the topic's levels as the words
+// of the routing key.
+func (s *mqttSession) Publish(topic string, payload []byte, qos byte) error {
+	s.platform.mutex.Lock()
+	defer s.platform.mutex.Unlock()
+
+	instance, err := s.platform.session(s.vhost)
+	if err != nil {
+		return err
+	}
+	return s.platform.route(instance, "amq.topic", strings.Replace(topic, "/", ".", -1), string(payload))
+}
+
+func (s *mqttSession) Close() error {
+	if !s.closed {
+		s.closed = true
+		s.platform.logout(s.vhost, s.username)
+	}
+	return nil
+}
+
+type stompSession struct {
+	platform *Platform
+	vhost    string
+	username string
+	closed   bool
+}
+
+func (s *stompSession) Send(destination string, body []byte, headers map[string]string) error {
+	s.platform.mutex.Lock()
+	defer s.platform.mutex.Unlock()
+
+	instance, err := s.platform.session(s.vhost)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case strings.HasPrefix(destination, "/queue/"):
+		name := strings.TrimPrefix(destination, "/queue/")
+		if _, ok := instance.Queues[name]; !ok {
+			instance.Queues[name] = []string{}
+		}
+		s.platform.deliver(instance, name, string(body))
+		return nil
+	case strings.HasPrefix(destination, "/amq/queue/"):
+		name := strings.TrimPrefix(destination, "/amq/queue/")
+		if _, ok := instance.Queues[name]; !ok {
+			return &broker.STOMPError{Message: "not_found", Details: fmt.Sprintf("NOT_FOUND - no queue '%s' in vhost '%s'", name, s.vhost)}
+		}
+		s.platform.deliver(instance, name, string(body))
+		return nil
+	case strings.HasPrefix(destination, "/topic/"):
+		return s.platform.route(instance, "amq.topic", strings.TrimPrefix(destination, "/topic/"), string(body))
+	}
+	return &broker.STOMPError{Message: "Unknown destination", Details: fmt.Sprintf("'%s' is not a valid destination", destination)}
+}
+
+func (s *stompSession) Close() error {
+	if !s.closed {
+		s.closed = true
+		s.platform.logout(s.vhost, s.username)
+	}
+	return nil
+}
+
+// queueType is classic unless the queue was declared with another.
+func (instance *ServiceInstance) queueType(queue string) string {
+	if queueType, ok := instance.queueTypes[queue]; ok {
+		return queueType
+	}
+	return "classic"
+}
+
+// enforceQueueLimits dead-letters the messages at the head of the queue
+// that take it over its max length, and has the message just delivered
+// expire after the queue's message TTL, along with those still ahead of
+// it, which are older; queues expire from the head. The arguments the queue was
+// declared with and the plan's operator policy both limit it, the lower
+// value winning. The caller holds the lock.
+func (p *Platform) enforceQueueLimits(instance *ServiceInstance, queue, message string) {
+	if maxLength, ok := p.queueLimit(instance, queue, "max-length"); ok {
+		for len(instance.Queues[queue]) > maxLength {
+			p.deadLetter(instance, queue, 0)
+		}
+	}
+
+	if ttl, ok := p.queueLimit(instance, queue, "message-ttl"); ok {
+		time.AfterFunc(time.Duration(ttl)*time.Millisecond, func() {
+			p.mutex.Lock()
+			defer p.mutex.Unlock()
+
+			if p.instances[instance.Name] != instance {
+				return
+			}
+			for i, queued := range instance.Queues[queue] {
+				if queued == message {
+					for ; i >= 0; i-- {
+						p.deadLetter(instance, queue, 0)
+					}
+					return
+				}
+			}
+		})
+	}
+}
+
+// queueLimit is the lower of the queue's x-<name> argument and the
+// operator policy's <name>, if either is set. The caller holds the lock.
+func (p *Platform) queueLimit(instance *ServiceInstance, queue, name string) (int, bool) {
+	limit, ok := number(instance.queueArguments[queue]["x-"+name])
+	if policyLimit, policyOK := number(p.limits(instance).OperatorPolicy[name]); policyOK && (!ok || policyLimit < limit) {
+		return policyLimit, true
+	}
+	return limit, ok
+}
+
+// deadLetter takes the message at index off the queue and republishes it
+// to the queue's dead-letter exchange, with the queue's dead-letter
+// routing key or else its name; with no dead-letter exchange, it is
+// dropped. The caller holds the lock.
+func (p *Platform) deadLetter(instance *ServiceInstance, queue string, index int) {
+	message := instance.Queues[queue][index]
+	instance.Queues[queue] = append(instance.Queues[queue][:index:index], instance.Queues[queue][index+1:]...)
+	if index < instance.redelivered[queue] {
+		instance.redelivered[queue]--
+	}
+
+	arguments := instance.queueArguments[queue]
+	exchange, ok := arguments["x-dead-letter-exchange"].(string)
+	if !ok {
+		if exchange, ok = p.limits(instance).OperatorPolicy["dead-letter-exchange"].(string); !ok {
+			return
+		}
+	}
+	routingKey, ok := arguments["x-dead-letter-routing-key"].(string)
+	if !ok {
+		routingKey = queue
+	}
+	p.route(instance, exchange, routingKey, message)
+}
+
+// number reads a queue argument or policy value, which may come in any
+// integer type, or as a float from JSON.
+func number(value interface{}) (int, bool) {
+	switch v := value.(type) {
+	case int:
+		return v, true
+	case int32:
+		return int(v), true
+	case int64:
+		return int(v), true
+	case float64:
+		return int(v), true
+	}
+	return 0, false
+}
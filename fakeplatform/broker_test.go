@@ -0,0 +1,295 @@
+package fakeplatform_test
+
+import (
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"github.com/streadway/amqp"
+
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/broker"
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/credentials"
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/fakeplatform"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Connector", func() {
+	var (
+		fake      *fakeplatform.Platform
+		connector broker.Connector
+		key       credentials.Credentials
+		consumer  broker.AMQP
+	)
+
+	endpoint := func(protocol string) credentials.Protocol {
+		e, ok := key.Protocol(protocol)
+		Expect(ok).To(BeTrue())
+		return e
+	}
+
+	receive := func(queue string) []string {
+		messages := []string{}
+		for {
+			delivery, ok, err := consumer.Get(queue, true)
+			Ω(err).ShouldNot(HaveOccurred())
+			if !ok {
+				return messages
+			}
+			messages = append(messages, string(delivery.Body))
+		}
+	}
+
+	BeforeEach(func() {
+		fake = fakeplatform.New("fake-domain")
+		connector = fake.Connector()
+		Expect(fake.CreateService("p-rabbitmq", "standard", "fake-instance")).To(Succeed())
+		Expect(fake.CreateServiceKey("fake-instance", "fake-key")).To(Succeed())
+
+		raw, err := fake.ServiceKey("fake-instance", "fake-key")
+		Ω(err).ShouldNot(HaveOccurred())
+		key, err = credentials.Parse(raw)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		consumer, err = connector.AMQP(endpoint(credentials.AMQP))
+		Ω(err).ShouldNot(HaveOccurred())
+	})
+
+	It("routes AMQP through the default exchange and amq.topic", func() {
+		_, err := consumer.DeclareQueue("test-q", broker.QueueOptions{Durable: true})
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(consumer.BindQueue("test-q", "amq.topic", "smoke.*.test", nil)).Should(Succeed())
+
+		Ω(consumer.Publish("", "test-q", amqp.Publishing{Body: []byte("direct")})).Should(Succeed())
+		Ω(consumer.Publish("amq.topic", "smoke.one.test", amqp.Publishing{Body: []byte("matched")})).Should(Succeed())
+		Ω(consumer.Publish("amq.topic", "smoke.one.two.test", amqp.Publishing{Body: []byte("unmatched")})).Should(Succeed())
+		Expect(receive("test-q")).To(Equal([]string{"direct", "matched"}))
+
+		Expect(consumer.Publish("missing", "test-q", amqp.Publishing{})).To(Equal(&amqp.Error{Code: amqp.NotFound, Reason: "NOT_FOUND - no exchange 'missing' in vhost 'fake-instance'"}))
+		_, _, err = consumer.Get("missing", true)
+		Expect(err).To(Equal(&amqp.Error{Code: amqp.NotFound, Reason: "NOT_FOUND - no queue 'missing' in vhost 'fake-instance'"}))
+	})
+
+	It("routes MQTT topics through amq.topic", func() {
+		_, err := consumer.DeclareQueue("test-q", broker.QueueOptions{})
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(consumer.BindQueue("test-q", "amq.topic", "smoke.#", nil)).Should(Succeed())
+
+		publisher, err := connector.MQTT(endpoint(credentials.MQTT), "smoke-test")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(publisher.Publish("smoke/mqtt/test", []byte("hello"), 1)).Should(Succeed())
+		Expect(receive("test-q")).To(Equal([]string{"hello"}))
+	})
+
+	It("declares the queues STOMP sends to", func() {
+		publisher, err := connector.STOMP(endpoint(credentials.STOMP))
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(publisher.Send("/queue/test-q", []byte("hello"), nil)).Should(Succeed())
+		Expect(receive("test-q")).To(Equal([]string{"hello"}))
+
+		Expect(publisher.Send("/amq/queue/missing", []byte("hello"), nil)).To(MatchError(ContainSubstring("NOT_FOUND")))
+	})
+
+	It("enforces the limits of the instance's plan", func() {
+		fake.LimitPlan("standard", fakeplatform.Limits{MaxMessageSize: 4, MaxConnections: 2, QueueTypes: []string{"classic"}})
+
+		publisher, err := connector.STOMP(endpoint(credentials.STOMP))
+		Ω(err).ShouldNot(HaveOccurred())
+		_, err = connector.AMQP(endpoint(credentials.AMQP))
+		Expect(err).To(Equal(&amqp.Error{Code: amqp.NotAllowed, Reason: "NOT_ALLOWED - access to vhost 'fake-instance' refused for user 'user-fake-key': connection limit (2) is reached"}))
+		Ω(publisher.Close()).Should(Succeed())
+		Ω(publisher.Close()).Should(Succeed())
+		_, err = connector.AMQP(endpoint(credentials.AMQP))
+		Ω(err).ShouldNot(HaveOccurred())
+
+		_, err = consumer.DeclareQueue("test-q", broker.QueueOptions{Durable: true, Arguments: amqp.Table{"x-queue-type": "classic"}})
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(consumer.Publish("", "test-q", amqp.Publishing{Body: []byte("four")})).Should(Succeed())
+		Expect(receive("test-q")).To(Equal([]string{"four"}))
+
+		tooLarge := &amqp.Error{Code: amqp.PreconditionFailed, Reason: "PRECONDITION_FAILED - message size 5 is larger than configured max size 4"}
+		Expect(consumer.Publish("", "test-q", amqp.Publishing{Body: []byte("five!")})).To(Equal(tooLarge))
+		_, _, err = consumer.Get("test-q", true)
+		Expect(err).To(Equal(tooLarge), "the channel is closed")
+	})
+
+	It("refuses queue types the plan does not offer", func() {
+		fake.LimitPlan("standard", fakeplatform.Limits{QueueTypes: []string{"classic", "quorum"}})
+
+		_, err := consumer.DeclareQueue("test-q", broker.QueueOptions{Durable: true, Arguments: amqp.Table{"x-queue-type": "stream"}})
+		Expect(err).To(MatchError(ContainSubstring(`Exception (406) Reason: "PRECONDITION_FAILED - invalid arg 'x-queue-type'`)))
+	})
+
+	It("consumes streams from an offset, leaving the messages in place", func() {
+		_, err := consumer.DeclareQueue("test-stream", broker.QueueOptions{Durable: true, Arguments: amqp.Table{"x-queue-type": "stream"}})
+		Ω(err).ShouldNot(HaveOccurred())
+		for _, message := range []string{"one", "two", "three"} {
+			Ω(consumer.Publish("", "test-stream", amqp.Publishing{Body: []byte(message)})).Should(Succeed())
+		}
+
+		read := func(offset interface{}) []string {
+			tag, err := consumer.Consume("test-stream", false, amqp.Table{"x-stream-offset": offset})
+			Ω(err).ShouldNot(HaveOccurred())
+			defer consumer.Cancel(tag)
+
+			messages := []string{}
+			for {
+				delivery, err := consumer.Next()
+				if err != nil {
+					return messages
+				}
+				messages = append(messages, string(delivery.Body))
+			}
+		}
+
+		_, err = consumer.Consume("test-stream", false, nil)
+		Expect(err).To(MatchError(ContainSubstring("consumer prefetch count is not set")))
+
+		consumer, err = connector.AMQP(endpoint(credentials.AMQP))
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(consumer.Qos(10)).Should(Succeed())
+		Expect(read("first")).To(Equal([]string{"one", "two", "three"}))
+		Expect(read(int64(1))).To(Equal([]string{"two", "three"}))
+		Expect(read("next")).To(BeEmpty())
+
+		_, _, err = consumer.Get("test-stream", true)
+		Expect(err).To(MatchError(ContainSubstring(`Exception (540) Reason: "NOT_IMPLEMENTED`)))
+	})
+
+	It("confirms publishes, and requeues deliveries nacked or left unacknowledged", func() {
+		_, err := consumer.DeclareQueue("test-q", broker.QueueOptions{})
+		Ω(err).ShouldNot(HaveOccurred())
+
+		fake.NackPublishes(1)
+		Ω(consumer.ConfirmSelect()).Should(Succeed())
+		for _, message := range []string{"lost", "one", "two"} {
+			Ω(consumer.Publish("", "test-q", amqp.Publishing{Body: []byte(message)})).Should(Succeed())
+		}
+		for _, expected := range []amqp.Confirmation{{DeliveryTag: 1}, {DeliveryTag: 2, Ack: true}, {DeliveryTag: 3, Ack: true}} {
+			Expect(consumer.NextConfirmation()).To(Equal(expected))
+		}
+		_, err = consumer.NextConfirmation()
+		Expect(err).To(HaveOccurred())
+
+		delivery, _, err := consumer.Get("test-q", false)
+		Ω(err).ShouldNot(HaveOccurred())
+		Expect(delivery.Redelivered).To(BeFalse())
+		Ω(consumer.Nack(delivery.DeliveryTag, false, true)).Should(Succeed())
+
+		delivery, _, err = consumer.Get("test-q", false)
+		Ω(err).ShouldNot(HaveOccurred())
+		Expect(string(delivery.Body)).To(Equal("one"))
+		Expect(delivery.Redelivered).To(BeTrue())
+		Ω(consumer.Reject(delivery.DeliveryTag, false)).Should(Succeed())
+
+		_, _, err = consumer.Get("test-q", false)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(consumer.Close()).Should(Succeed())
+
+		consumer, err = connector.AMQP(endpoint(credentials.AMQP))
+		Ω(err).ShouldNot(HaveOccurred())
+		delivery, _, err = consumer.Get("test-q", true)
+		Ω(err).ShouldNot(HaveOccurred())
+		Expect(string(delivery.Body)).To(Equal("two"))
+		Expect(delivery.Redelivered).To(BeTrue())
+	})
+
+	It("dead-letters the messages that overflow or expire", func() {
+		_, err := consumer.DeclareQueue("test-dlq", broker.QueueOptions{})
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(consumer.BindQueue("test-dlq", "amq.direct", "dead", nil)).Should(Succeed())
+		_, err = consumer.DeclareQueue("test-q", broker.QueueOptions{Arguments: amqp.Table{
+			"x-max-length":              2,
+			"x-dead-letter-exchange":    "amq.direct",
+			"x-dead-letter-routing-key": "dead",
+		}})
+		Ω(err).ShouldNot(HaveOccurred())
+
+		for _, message := range []string{"one", "two", "three"} {
+			Ω(consumer.Publish("", "test-q", amqp.Publishing{Body: []byte(message)})).Should(Succeed())
+		}
+		Expect(receive("test-dlq")).To(Equal([]string{"one"}))
+		Expect(receive("test-q")).To(Equal([]string{"two", "three"}))
+
+		_, err = consumer.DeclareQueue("test-ttl-q", broker.QueueOptions{Arguments: amqp.Table{
+			"x-message-ttl":             int64(1),
+			"x-dead-letter-exchange":    "",
+			"x-dead-letter-routing-key": "test-dlq",
+		}})
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(consumer.Publish("", "test-ttl-q", amqp.Publishing{Body: []byte("expiring")})).Should(Succeed())
+		Eventually(func() []string { return receive("test-dlq") }).Should(Equal([]string{"expiring"}))
+		Expect(receive("test-ttl-q")).To(BeEmpty())
+	})
+
+	It("drops what overflows the operator policy's max length", func() {
+		fake.LimitPlan("standard", fakeplatform.Limits{OperatorPolicy: map[string]interface{}{"max-length": 1}})
+		_, err := consumer.DeclareQueue("test-q", broker.QueueOptions{Arguments: amqp.Table{"x-max-length": 5}})
+		Ω(err).ShouldNot(HaveOccurred())
+
+		Ω(consumer.Publish("", "test-q", amqp.Publishing{Body: []byte("one")})).Should(Succeed())
+		Ω(consumer.Publish("", "test-q", amqp.Publishing{Body: []byte("two")})).Should(Succeed())
+		Expect(receive("test-q")).To(Equal([]string{"two"}))
+	})
+
+	It("hands out TLS endpoints only for plans that require TLS", func() {
+		fake.LimitPlan("standard", fakeplatform.Limits{TLSRequired: true})
+		Expect(fake.CreateServiceKey("fake-instance", "tls-key")).To(Succeed())
+		raw, err := fake.ServiceKey("fake-instance", "tls-key")
+		Ω(err).ShouldNot(HaveOccurred())
+
+		tlsKey, err := credentials.Parse(raw)
+		Ω(err).ShouldNot(HaveOccurred())
+		Expect(tlsKey.SSL).To(BeTrue())
+		Expect(tlsKey.URI).To(HavePrefix("amqps://"))
+		Expect(tlsKey.Protocols).To(HaveLen(4))
+		Expect(tlsKey.Protocols).To(HaveKey("amqp+ssl"))
+		Expect(tlsKey.Protocols).To(HaveKey("mqtt+ssl"))
+		Expect(tlsKey.Protocols).To(HaveKey("stomp+ssl"))
+	})
+
+	It("refuses plaintext connections for plans that require TLS, unless told otherwise", func() {
+		fake.LimitPlan("standard", fakeplatform.Limits{TLSRequired: true})
+		plaintext := endpoint(credentials.AMQP)
+		plaintext.SSL, plaintext.URI = false, ""
+
+		_, err := connector.AMQP(plaintext)
+		Expect(err).To(MatchError("dial tcp rabbitmq.fake:5672: connect: connection refused"))
+		_, err = connector.STOMP(endpoint(credentials.STOMP))
+		Expect(err).To(MatchError("dial tcp rabbitmq.fake:61613: connect: connection refused"))
+		Expect(broker.Refused(err)).To(BeTrue())
+
+		fake.LimitPlan("standard", fakeplatform.Limits{TLSRequired: true, PlaintextAccepted: true})
+		session, err := connector.AMQP(plaintext)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(session.Close()).Should(Succeed())
+	})
+
+	It("lists the cluster's hosts in the credentials when told to", func() {
+		fake.ClusterHosts("rabbitmq.fake", "rabbitmq-1.fake")
+		Expect(fake.CreateServiceKey("fake-instance", "cluster-key")).To(Succeed())
+		raw, err := fake.ServiceKey("fake-instance", "cluster-key")
+		Ω(err).ShouldNot(HaveOccurred())
+		clusterKey, err := credentials.Parse(raw)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		Expect(clusterKey.Hostnames).To(Equal([]string{"rabbitmq.fake", "rabbitmq-1.fake"}))
+		Expect(clusterKey.Protocols["amqp"].Hosts).To(Equal([]string{"rabbitmq.fake", "rabbitmq-1.fake"}))
+		Expect(clusterKey.Protocols["management"].Hosts).To(BeEmpty())
+		Expect(key.Hostnames).To(BeEmpty())
+	})
+
+	It("refuses other users and disabled protocols", func() {
+		other := endpoint(credentials.AMQP)
+		other.Password = "wrong"
+		_, err := connector.AMQP(other)
+		Expect(err).To(Equal(amqp.ErrCredentials))
+
+		fake.DisableProtocol(credentials.MQTT)
+		_, err = connector.MQTT(endpoint(credentials.MQTT), "smoke-test")
+		Expect(err).To(Equal(packets.ErrorRefusedBadUsernameOrPassword))
+
+		Expect(fake.CreateServiceKey("fake-instance", "other-key")).To(Succeed())
+		raw, err := fake.ServiceKey("fake-instance", "other-key")
+		Ω(err).ShouldNot(HaveOccurred())
+		Expect(raw["protocols"]).NotTo(HaveKey(credentials.MQTT))
+	})
+})
@@ -0,0 +1,210 @@
+package fakeplatform
+
+import (
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strconv"
+
+	"github.com/onsi/gomega/gexec"
+)
+
+// Cf can replace cf.Cf. The commands the smoke tests use for apps, service
+// instances and keys act on the fake; any other command, such as the ones
+// services.Context runs to set up users, orgs and spaces, succeeds without
+// doing anything.
+func (p *Platform) Cf(args ...string) *gexec.Session {
+	output, err := p.runCf(args)
+	if err != nil {
+		return start(exec.Command("sh", "-c", `printf '%s\nFAILED\n' "$1"; exit 1`, "sh", err.Error()))
+	}
+	return start(exec.Command("printf", "%s", output))
+}
+
+func (p *Platform) runCf(args []string) (string, error) {
+	if len(args) == 0 {
+		return "", fmt.Errorf("no command given")
+	}
+
+	command := args[0]
+	positional := []string{}
+	flags := map[string]string{}
+	for i := 1; i < len(args); i++ {
+		switch args[i] {
+		case "-f", "-no-start", "--no-start":
+		case "--guid":
+			flags[args[i]] = "true"
+		case "-m", "-p", "-s", "-b", "-i", "-o", "-X", "-d":
+			if i+1 < len(args) {
+				flags[args[i]] = args[i+1]
+				i++
+			}
+		default:
+			positional = append(positional, args[i])
+		}
+	}
+
+	arg := func(index int) string {
+		if index < len(positional) {
+			return positional[index]
+		}
+		return ""
+	}
+
+	switch command {
+	case "push":
+		return "", p.PushApp(arg(0), flags["-p"])
+	case "start":
+		return "", p.StartApp(arg(0))
+	case "restart":
+		return "", p.RestartApp(arg(0))
+	case "scale":
+		instances, err := strconv.Atoi(flags["-i"])
+		if err != nil {
+			return "", fmt.Errorf("Incorrect Usage: invalid instance count %q", flags["-i"])
+		}
+		return "", p.ScaleApp(arg(0), instances)
+	case "app":
+		if flags["--guid"] == "" {
+			break
+		}
+		guid, err := p.AppGUID(arg(0))
+		return guid + "\n", err
+	case "delete":
+		return "", p.DeleteApp(arg(0))
+	case "set-env":
+		return "", p.SetEnv(arg(0), arg(1), arg(2))
+	case "create-service":
+		return "", p.CreateService(arg(0), arg(1), arg(2))
+	case "delete-service":
+		return "", p.DeleteService(arg(0))
+	case "bind-service":
+		return "", p.BindService(arg(0), arg(1))
+	case "unbind-service":
+		return "", p.UnbindService(arg(0), arg(1))
+	case "create-service-key":
+		return "", p.CreateServiceKey(arg(0), arg(1))
+	case "delete-service-key":
+		return "", p.DeleteServiceKey(arg(0), arg(1))
+	case "service-key":
+		key, err := p.ServiceKey(arg(0), arg(1))
+		if err != nil {
+			return "", err
+		}
+		encoded, err := json.MarshalIndent(key, "", " ")
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Getting key %s for service instance %s as admin...\n\n%s\n", arg(1), arg(0), encoded), nil
+	case "env":
+		return p.env(arg(0))
+	case "service":
+		return p.service(arg(0))
+	case "share-service":
+		return "", p.ShareService(arg(0), flags["-s"])
+	case "unshare-service":
+		return "", p.UnshareService(arg(0), flags["-s"])
+	case "target":
+		return p.target(flags["-o"], flags["-s"], args), nil
+	case "curl":
+		p.record(args)
+		return `{"metadata": {"guid": "fake-guid"}}`, nil
+	}
+
+	p.record(args)
+	return "OK\n", nil
+}
+
+// env prints the app's environment the way cf env does, with the bound
+// instances' credentials in VCAP_SERVICES.
+func (p *Platform) env(appName string) (string, error) {
+	var output string
+	err := p.call([]string{"env", appName}, func() error {
+		app, err := p.app(appName)
+		if err != nil {
+			return err
+		}
+
+		bindings := []map[string]interface{}{}
+		for _, instanceName := range app.Bindings {
+			instance, err := p.instance(instanceName)
+			if err != nil {
+				return err
+			}
+			bindings = append(bindings, map[string]interface{}{
+				"name":        instance.Name,
+				"label":       instance.Service,
+				"plan":        instance.Plan,
+				"tags":        []string{"rabbitmq"},
+				"credentials": instance.Keys[bindingKey(appName)],
+			})
+		}
+		services := map[string]interface{}{}
+		if len(bindings) > 0 {
+			services[bindings[0]["label"].(string)] = bindings
+		}
+		encoded, err := json.MarshalIndent(map[string]interface{}{"VCAP_SERVICES": services}, "", " ")
+		if err != nil {
+			return err
+		}
+
+		names := []string{}
+		for name := range app.Env {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		userProvided := ""
+		for _, name := range names {
+			userProvided += fmt.Sprintf("%s: %s\n", name, app.Env[name])
+		}
+
+		output = fmt.Sprintf("Getting env variables for app %s as admin...\nOK\n\nSystem-Provided:\n%s\n\nUser-Provided:\n%s", appName, encoded, userProvided)
+		return nil
+	})
+	return output, err
+}
+
+// target switches spaces like cf target: targeting only an org leaves no
+// space targeted. With neither, it prints the target.
+func (p *Platform) target(org, space string, command []string) string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.calls = append(p.calls, command)
+
+	if org != "" || space != "" {
+		p.space = space
+		return "OK\n"
+	}
+	return fmt.Sprintf("api endpoint:   https://api.%s\nuser:           admin\nspace:          %s\n", p.AppsDomain, p.space)
+}
+
+// service prints the instance the way cf service does; instances are
+// always created synchronously.
+func (p *Platform) service(instanceName string) (string, error) {
+	var output string
+	err := p.call([]string{"service", instanceName}, func() error {
+		instance, err := p.instance(instanceName)
+		if err != nil {
+			return err
+		}
+
+		output = fmt.Sprintf("Service instance: %s\nService: %s\nPlan: %s\n\nLast Operation\nStatus: create succeeded\n", instance.Name, instance.Service, instance.Plan)
+		return nil
+	})
+	return output, err
+}
+
+func (p *Platform) record(command []string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.calls = append(p.calls, command)
+}
+
+func start(cmd *exec.Cmd) *gexec.Session {
+	session, err := gexec.Start(cmd, nil, nil)
+	if err != nil {
+		panic(err)
+	}
+	return session
+}
@@ -0,0 +1,139 @@
+package fakeplatform_test
+
+import (
+	"errors"
+	"time"
+
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/exampleapp"
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/fakeplatform"
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/platform"
+	"github.com/cloudfoundry-incubator/cf-test-helpers/cf"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+	"github.com/onsi/gomega/gexec"
+)
+
+var _ = Describe("Cf", func() {
+	var (
+		fake       *fakeplatform.Platform
+		originalCf = cf.Cf
+	)
+
+	BeforeEach(func() {
+		fake = fakeplatform.New("fake-domain")
+		cf.Cf = fake.Cf
+	})
+
+	AfterEach(func() {
+		cf.Cf = originalCf
+	})
+
+	It("backs the CLI platform", func() {
+		cli := platform.NewCLI(time.Second, time.Second)
+
+		Expect(cli.PushApp("fake-app", "fake/path")).To(Succeed())
+		Expect(cli.CreateService("p-rabbitmq", "standard", "fake-instance")).To(Succeed())
+		Expect(cli.BindService("fake-app", "fake-instance")).To(Succeed())
+		Expect(cli.StartApp("fake-app")).To(Succeed())
+		Expect(cli.CreateServiceKey("fake-instance", "fake-key")).To(Succeed())
+
+		credentials, err := cli.ServiceKey("fake-instance", "fake-key")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(credentials).To(HaveKeyWithValue("vhost", "fake-instance"))
+
+		app, ok := fake.App("fake-app")
+		Expect(ok).To(BeTrue())
+		Expect(app.Started).To(BeTrue())
+		Expect(app.Bindings).To(ConsistOf("fake-instance"))
+	})
+
+	It("fails commands the way cf does", func() {
+		fake.FailNext("create-service", errors.New("Service broker error: plan is full"))
+
+		session := fake.Cf("create-service", "p-rabbitmq", "standard", "fake-instance").Wait(time.Second)
+		Expect(session).To(gexec.Exit(1))
+		Expect(session.Out).To(gbytes.Say("Service broker error: plan is full\nFAILED"))
+	})
+
+	It("succeeds for commands that only set up the context", func() {
+		session := fake.Cf("create-org", "fake-org").Wait(time.Second)
+		Expect(session).To(gexec.Exit(0))
+		Expect(fake.Calls()).To(Equal([][]string{{"create-org", "fake-org"}}))
+	})
+
+	It("shows the bound credentials and the instance's last operation", func() {
+		Expect(fake.PushApp("fake-app", "fake/path")).To(Succeed())
+		Expect(fake.CreateService("p-rabbitmq", "standard", "fake-instance")).To(Succeed())
+		Expect(fake.BindService("fake-app", "fake-instance")).To(Succeed())
+
+		session := fake.Cf("env", "fake-app").Wait(time.Second)
+		Expect(session).To(gexec.Exit(0))
+		Expect(session.Out).To(gbytes.Say("VCAP_SERVICES"))
+		Expect(session.Out).To(gbytes.Say(`"password": "password-fake-app"`))
+
+		session = fake.Cf("service", "fake-instance").Wait(time.Second)
+		Expect(session).To(gexec.Exit(0))
+		Expect(session.Out).To(gbytes.Say("Status: create succeeded"))
+	})
+
+	It("scales apps and routes to the instance asked for", func() {
+		cli := platform.NewCLI(time.Second, time.Second)
+		Expect(cli.PushApp("fake-app", "fake/path")).To(Succeed())
+		Expect(cli.StartApp("fake-app")).To(Succeed())
+		Expect(cli.ScaleApp("fake-app", 2)).To(Succeed())
+
+		guid, err := cli.AppGUID("fake-app")
+		Expect(err).NotTo(HaveOccurred())
+
+		app := exampleapp.NewClient("https://fake-app.fake-domain", fake.HTTPClient())
+		Expect(app.Instance(guid, 1).Ping()).To(Succeed())
+		Expect(app.Instance(guid, 2).Ping()).To(MatchError(ContainSubstring("status 400: 400 Bad Request: Requested instance ('2') with guid ('" + guid + "') does not exist")))
+	})
+	It("locks the other apps out when an app sharing their credentials is unbound", func() {
+		fake.SharedBindingCredentials()
+		cli := platform.NewCLI(time.Second, time.Second)
+		Expect(cli.CreateService("p-rabbitmq", "standard", "fake-instance")).To(Succeed())
+		for _, appName := range []string{"producer", "consumer"} {
+			Expect(cli.PushApp(appName, "fake/path")).To(Succeed())
+			Expect(cli.BindService(appName, "fake-instance")).To(Succeed())
+			Expect(cli.StartApp(appName)).To(Succeed())
+		}
+
+		producer, err := cli.BindingCredentials("producer", "fake-instance")
+		Expect(err).NotTo(HaveOccurred())
+		consumer, err := cli.BindingCredentials("consumer", "fake-instance")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(producer["username"]).To(Equal(consumer["username"]))
+
+		app := exampleapp.NewClient("https://producer.fake-domain", fake.HTTPClient())
+		Expect(app.CreateQueue("fake-queue")).To(Succeed())
+
+		Expect(cli.UnbindService("consumer", "fake-instance")).To(Succeed())
+		Expect(app.CreateQueue("fake-queue")).To(MatchError(ContainSubstring("ACCESS_REFUSED")))
+	})
+	It("shares instances into other spaces and unbinds their apps on unsharing", func() {
+		cli := platform.NewCLI(time.Second, time.Second)
+		fake.Cf("target", "-o", "fake-org", "-s", "fake-space").Wait(time.Second)
+		other := cli.InSpace("other-space")
+
+		Expect(cli.CreateService("p-rabbitmq", "standard", "fake-instance")).To(Succeed())
+		Expect(other.PushApp("other-app", "fake/path")).To(Succeed())
+		Expect(other.BindService("other-app", "fake-instance")).To(MatchError(ContainSubstring("Service instance fake-instance not found")))
+
+		Expect(cli.ShareService("fake-instance", "other-space")).To(Succeed())
+		Expect(other.BindService("other-app", "fake-instance")).To(Succeed())
+		Expect(cli.DeleteService("fake-instance")).To(MatchError(ContainSubstring("must be unshared")))
+		_, err := cli.AppGUID("other-app")
+		Expect(err).To(MatchError(ContainSubstring("App other-app not found")))
+
+		Expect(cli.UnshareService("fake-instance", "other-space")).To(Succeed())
+		app, _ := fake.App("other-app")
+		Expect(app.Space).To(Equal("other-space"))
+		Expect(app.Bindings).To(BeEmpty())
+		_, err = other.BindingCredentials("other-app", "fake-instance")
+		Expect(err).To(HaveOccurred())
+		Expect(cli.DeleteService("fake-instance")).To(Succeed())
+	})
+})
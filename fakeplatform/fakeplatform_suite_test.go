@@ -0,0 +1,13 @@
+package fakeplatform_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestFakePlatform(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Fake Platform Suite")
+}
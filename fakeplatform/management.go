@@ -0,0 +1,286 @@
+package fakeplatform
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// brokerHost is where the credentials point for every protocol and the
+// management API.
+const brokerHost = "rabbitmq.fake"
+
+// the versions the fake broker reports
+const (
+	fakeRabbitMQVersion = "3.8.9"
+	fakeErlangVersion   = "23.1.4"
+)
+
+// defaultUserTags are what the users of bindings and service keys get,
+// unless UserTags says otherwise.
+var defaultUserTags = []string{"management", "policymaker"}
+
+// UserTags changes the tags of every user, e.g. to make them
+// administrators, who may list permissions.
+func (p *Platform) UserTags(tags ...string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.userTags = tags
+}
+
+// serveManagement answers like the management API does, for the users of
+// an instance's bindings and service keys, whose vhost is named after it.
+// The caller holds the lock.
+func (p *Platform) serveManagement(w http.ResponseWriter, request *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(request.URL.EscapedPath(), "/api/"), "/")
+	for i, part := range parts {
+		parts[i], _ = url.PathUnescape(part)
+	}
+
+	if request.Method != "GET" {
+		managementError(w, http.StatusMethodNotAllowed, "Method Not Allowed")
+		return
+	}
+
+	switch {
+	case len(parts) == 1 && parts[0] == "whoami":
+		username, _, _ := request.BasicAuth()
+		if p.managementUser(request) == nil {
+			managementError(w, http.StatusUnauthorized, "Not management user")
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"name": username, "tags": strings.Join(p.tags(), ",")})
+		return
+
+	case len(parts) == 1 && parts[0] == "overview":
+		if p.managementUser(request) == nil {
+			managementError(w, http.StatusUnauthorized, "Not management user")
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"product_name":       "RabbitMQ",
+			"rabbitmq_version":   fakeRabbitMQVersion,
+			"management_version": fakeRabbitMQVersion,
+			"erlang_version":     fakeErlangVersion,
+			"cluster_name":       "rabbit@" + brokerHost,
+		})
+		return
+
+	case len(parts) == 2 && parts[0] == "vhosts":
+		instance, ok := p.instances[parts[1]]
+		if !ok || !p.authorized(instance, request) {
+			managementError(w, http.StatusUnauthorized, "Not management user")
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"name": instance.Name, "description": "", "tracing": false})
+		return
+
+	case len(parts) == 3 && parts[0] == "vhosts" && parts[2] == "channels":
+		instance, ok := p.instances[parts[1]]
+		if !ok || !p.authorized(instance, request) {
+			managementError(w, http.StatusUnauthorized, "Not management user")
+			return
+		}
+		json.NewEncoder(w).Encode(p.channels(instance, request))
+		return
+
+	case len(parts) == 3 && parts[0] == "vhosts" && parts[2] == "connections":
+		instance, ok := p.instances[parts[1]]
+		if !ok || !p.authorized(instance, request) {
+			managementError(w, http.StatusUnauthorized, "Not management user")
+			return
+		}
+		connections := []map[string]interface{}{}
+		for _, connection := range p.visibleConnections(instance, request) {
+			connections = append(connections, map[string]interface{}{"name": connection.name, "user": connection.user, "vhost": instance.Name})
+		}
+		json.NewEncoder(w).Encode(connections)
+		return
+
+	case len(parts) == 1 && parts[0] == "permissions":
+		if p.managementUser(request) == nil || !contains(p.tags(), "administrator") {
+			managementError(w, http.StatusUnauthorized, "Not administrator user")
+			return
+		}
+		permissions := []map[string]interface{}{}
+		for _, instance := range p.instances {
+			for _, key := range instance.Keys {
+				permissions = append(permissions, map[string]interface{}{
+					"user":      key["username"],
+					"vhost":     instance.Name,
+					"configure": ".*",
+					"write":     ".*",
+					"read":      ".*",
+				})
+			}
+		}
+		json.NewEncoder(w).Encode(permissions)
+		return
+
+	case len(parts) != 3 || parts[0] != "queues":
+		managementError(w, http.StatusNotFound, "Object Not Found")
+		return
+	}
+	vhost, name := parts[1], parts[2]
+
+	instance, ok := p.instances[vhost]
+	if !ok || !p.authorized(instance, request) {
+		managementError(w, http.StatusUnauthorized, "Not management user")
+		return
+	}
+
+	messages, ok := instance.Queues[name]
+	if !ok {
+		managementError(w, http.StatusNotFound, "Object Not Found")
+		return
+	}
+
+	arguments := map[string]interface{}{}
+	for key, value := range instance.queueArguments[name] {
+		arguments[key] = value
+	}
+	// like the API, list no policy as null and an empty definition as []
+	var operatorPolicy, definition interface{} = nil, []interface{}{}
+	if policy := p.limits(instance).OperatorPolicy; len(policy) > 0 {
+		operatorPolicy, definition = instance.Plan+"-limits", policy
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"name":                        name,
+		"vhost":                       vhost,
+		"durable":                     !p.transientQueues,
+		"auto_delete":                 p.transientQueues,
+		"exclusive":                   false,
+		"type":                        instance.queueType(name),
+		"arguments":                   arguments,
+		"messages":                    len(messages),
+		"operator_policy":             operatorPolicy,
+		"effective_policy_definition": definition,
+	})
+}
+
+// connection is a connection to an instance's vhost, of an app's instance
+// or through the connector.
+type connection struct {
+	name  string
+	user  string
+	app   *App
+	index int
+}
+
+// connections are those open to instance's vhost: one for each instance of
+// the started apps bound to it, and those its users hold open through the
+// connector. The caller holds the lock.
+func (p *Platform) connections(instance *ServiceInstance) []connection {
+	names := []string{}
+	for name := range p.apps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	connections := []connection{}
+	for _, name := range names {
+		app := p.apps[name]
+		if !app.Started || app.Crashed || !contains(app.Bindings, instance.Name) {
+			continue
+		}
+		user, _ := instance.Keys[bindingKey(name)]["username"].(string)
+		for i := 0; i < app.Instances; i++ {
+			connections = append(connections, connection{
+				name:  fmt.Sprintf("%s.%d:40000 -> %s:5672", name, i, brokerHost),
+				user:  user,
+				app:   app,
+				index: i,
+			})
+		}
+	}
+
+	users := []string{}
+	for user := range instance.connections {
+		users = append(users, user)
+	}
+	sort.Strings(users)
+	for _, user := range users {
+		for i := 0; i < instance.connections[user]; i++ {
+			connections = append(connections, connection{name: fmt.Sprintf("%s.%d:50000 -> %s:5672", user, i, brokerHost), user: user})
+		}
+	}
+	return connections
+}
+
+// visibleConnections are the connections to instance's vhost that the
+// request's user may see: like the API, only the user's own, unless the
+// user may monitor. The caller holds the lock.
+func (p *Platform) visibleConnections(instance *ServiceInstance, request *http.Request) []connection {
+	username, _, _ := request.BasicAuth()
+	monitoring := contains(p.tags(), "monitoring") || contains(p.tags(), "administrator")
+
+	visible := []connection{}
+	for _, connection := range p.connections(instance) {
+		if monitoring || connection.user == username {
+			visible = append(visible, connection)
+		}
+	}
+	return visible
+}
+
+// channels describes a channel for each of the apps' connections the
+// request's user may see, with the messages the app's instance took. The
+// caller holds the lock.
+func (p *Platform) channels(instance *ServiceInstance, request *http.Request) []map[string]interface{} {
+	channels := []map[string]interface{}{}
+	for _, connection := range p.visibleConnections(instance, request) {
+		if connection.app == nil {
+			continue
+		}
+		channels = append(channels, map[string]interface{}{
+			"name":               connection.name + " (1)",
+			"user":               connection.user,
+			"connection_details": map[string]interface{}{"name": connection.name},
+			"message_stats":      map[string]interface{}{"deliver_get": connection.app.gets[connection.index]},
+		})
+	}
+	return channels
+}
+
+// managementUser finds the instance whose user the request authenticates
+// as, if any.
+func (p *Platform) managementUser(request *http.Request) *ServiceInstance {
+	for _, instance := range p.instances {
+		if p.authorized(instance, request) {
+			return instance
+		}
+	}
+	return nil
+}
+
+func (p *Platform) tags() []string {
+	if p.userTags != nil {
+		return p.userTags
+	}
+	return defaultUserTags
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Platform) authorized(instance *ServiceInstance, request *http.Request) bool {
+	username, password, ok := request.BasicAuth()
+	if !ok {
+		return false
+	}
+	return instance.allows(username, password)
+}
+
+func managementError(w http.ResponseWriter, status int, reason string) {
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": http.StatusText(status), "reason": reason})
+}
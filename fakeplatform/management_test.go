@@ -0,0 +1,159 @@
+package fakeplatform_test
+
+import (
+	"fmt"
+
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/credentials"
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/exampleapp"
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/fakeplatform"
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/management"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Management API", func() {
+	var (
+		fake   *fakeplatform.Platform
+		client *management.Client
+	)
+
+	BeforeEach(func() {
+		fake = fakeplatform.New("fake-domain")
+		Expect(fake.CreateService("p-rabbitmq", "standard", "fake-instance")).To(Succeed())
+		Expect(fake.CreateServiceKey("fake-instance", "fake-key")).To(Succeed())
+		Expect(fake.Enqueue("fake-instance", "test-q", "message")).To(Succeed())
+
+		raw, err := fake.ServiceKey("fake-instance", "fake-key")
+		Ω(err).ShouldNot(HaveOccurred())
+		c, err := credentials.Parse(raw)
+		Ω(err).ShouldNot(HaveOccurred())
+		apiURL, username, password, err := c.ManagementAPI()
+		Ω(err).ShouldNot(HaveOccurred())
+		client = management.NewClient(apiURL, username, password, fake.HTTPClient())
+	})
+
+	It("describes the queues of the key's vhost", func() {
+		queue, err := client.Queue("fake-instance", "test-q")
+		Ω(err).ShouldNot(HaveOccurred())
+		Expect(queue.Durable).To(BeTrue())
+		Expect(queue.Messages).To(Equal(1))
+
+		_, err = client.Queue("fake-instance", "missing")
+		Expect(err).To(MatchError(ContainSubstring("status 404")))
+	})
+
+	It("refuses other users", func() {
+		other := management.NewClient("https://rabbitmq.fake/api/", "user-other", "password-other", fake.HTTPClient())
+		_, err := other.Queue("fake-instance", "test-q")
+		Expect(err).To(MatchError(ContainSubstring("status 401")))
+	})
+
+	It("reports transient queues", func() {
+		fake.TransientQueues()
+
+		queue, err := client.Queue("fake-instance", "test-q")
+		Ω(err).ShouldNot(HaveOccurred())
+		Expect(queue.Durable).To(BeFalse())
+		Expect(queue.AutoDelete).To(BeTrue())
+	})
+	It("reports the queue's arguments and the plan's operator policy", func() {
+		queue, err := client.Queue("fake-instance", "test-q")
+		Ω(err).ShouldNot(HaveOccurred())
+		Expect(queue.OperatorPolicy).To(BeEmpty())
+		Expect(queue.EffectivePolicyDefinition).To(BeEmpty())
+
+		fake.LimitPlan("standard", fakeplatform.Limits{OperatorPolicy: map[string]interface{}{"max-length": 1000, "overflow": "reject-publish"}})
+		queue, err = client.Queue("fake-instance", "test-q")
+		Ω(err).ShouldNot(HaveOccurred())
+		Expect(queue.OperatorPolicy).To(Equal("standard-limits"))
+		Expect(queue.EffectivePolicyDefinition).To(Equal(management.Definition{"max-length": 1000.0, "overflow": "reject-publish"}))
+	})
+
+	It("describes the user, the broker and the vhost", func() {
+		user, err := client.WhoAmI()
+		Ω(err).ShouldNot(HaveOccurred())
+		Expect(user.Name).To(Equal("user-fake-key"))
+		Expect(user.Tags).To(Equal(management.Tags{"management", "policymaker"}))
+
+		overview, err := client.Overview()
+		Ω(err).ShouldNot(HaveOccurred())
+		Expect(overview.RabbitMQVersion).NotTo(BeEmpty())
+		Expect(overview.ErlangVersion).NotTo(BeEmpty())
+
+		vhost, err := client.VHost("fake-instance")
+		Ω(err).ShouldNot(HaveOccurred())
+		Expect(vhost.Name).To(Equal("fake-instance"))
+	})
+
+	It("lists the channels of the app's instances with the messages each took", func() {
+		Expect(fake.PushApp("fake-app", "fake/path")).To(Succeed())
+		Expect(fake.BindService("fake-app", "fake-instance")).To(Succeed())
+		Expect(fake.StartApp("fake-app")).To(Succeed())
+		Expect(fake.ScaleApp("fake-app", 2)).To(Succeed())
+		Expect(fake.Enqueue("fake-instance", "test-q", "another")).To(Succeed())
+
+		app := exampleapp.NewClient("https://fake-app.fake-domain", fake.HTTPClient())
+		for i := 0; i < 2; i++ {
+			_, ok, err := app.ConsumeMessage("test-q")
+			Ω(err).ShouldNot(HaveOccurred())
+			Expect(ok).To(BeTrue())
+		}
+
+		channels, err := client.Channels("fake-instance")
+		Ω(err).ShouldNot(HaveOccurred())
+		Expect(channels).To(BeEmpty(), "the key's user sees only its own channels")
+
+		fake.UserTags("monitoring")
+		channels, err = client.Channels("fake-instance")
+		Ω(err).ShouldNot(HaveOccurred())
+		Expect(channels).To(HaveLen(2))
+		for i, channel := range channels {
+			Expect(channel.User).To(Equal("user-fake-app"))
+			Expect(channel.ConnectionDetails.Name).To(Equal(fmt.Sprintf("fake-app.%d:40000 -> rabbitmq.fake:5672", i)))
+			Expect(channel.MessageStats.DeliverGet).To(Equal(1))
+		}
+	})
+
+	It("lists the connections of the app's instances and of the connector", func() {
+		Expect(fake.PushApp("fake-app", "fake/path")).To(Succeed())
+		Expect(fake.BindService("fake-app", "fake-instance")).To(Succeed())
+		Expect(fake.StartApp("fake-app")).To(Succeed())
+
+		raw, err := fake.ServiceKey("fake-instance", "fake-key")
+		Ω(err).ShouldNot(HaveOccurred())
+		c, err := credentials.Parse(raw)
+		Ω(err).ShouldNot(HaveOccurred())
+		endpoint, _ := c.Protocol(credentials.AMQP)
+		session, err := fake.Connector().AMQP(endpoint)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		connections, err := client.Connections("fake-instance")
+		Ω(err).ShouldNot(HaveOccurred())
+		Expect(connections).To(Equal([]management.Connection{
+			{Name: "user-fake-key.0:50000 -> rabbitmq.fake:5672", User: "user-fake-key", VHost: "fake-instance"},
+		}), "the key's user sees only its own connections")
+
+		fake.UserTags("monitoring")
+		connections, err = client.Connections("fake-instance")
+		Ω(err).ShouldNot(HaveOccurred())
+		Expect(connections).To(HaveLen(2))
+		Expect(connections[0].Name).To(Equal("fake-app.0:40000 -> rabbitmq.fake:5672"))
+		Expect(connections[0].User).To(Equal("user-fake-app"))
+
+		Ω(session.Close()).Should(Succeed())
+		connections, err = client.Connections("fake-instance")
+		Ω(err).ShouldNot(HaveOccurred())
+		Expect(connections).To(HaveLen(1))
+	})
+
+	It("lists permissions for administrators only", func() {
+		_, err := client.Permissions()
+		Expect(err).To(MatchError(ContainSubstring("Not administrator user")))
+
+		fake.UserTags("administrator")
+		permissions, err := client.Permissions()
+		Ω(err).ShouldNot(HaveOccurred())
+		Expect(permissions).To(ContainElement(management.Permission{User: "user-fake-key", VHost: "fake-instance", Configure: ".*", Write: ".*", Read: ".*"}))
+	})
+})
@@ -0,0 +1,755 @@
+package fakeplatform
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/streadway/amqp"
+
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/platform"
+)
+
+// Platform is an in-memory stand-in for a Cloud Foundry foundation with the
+// RabbitMQ broker and the example apps. It implements platform.Platform,
+// can replace cf.Cf as a fake cf CLI, and serves the example apps' routes
+// through HTTPClient, so the smoke tests can run without any network.
+//
+// Apps and service instances belong to a space: the one the fake cf CLI
+// last targeted, or the one given to InSpace.
+type Platform struct {
+	*state
+	space string
+}
+
+// state is shared by the Platforms of every space.
+type state struct {
+	AppsDomain string
+
+	mutex     sync.Mutex
+	apps      map[string]*App
+	instances map[string]*ServiceInstance
+	calls     [][]string
+	failures  map[string][]error
+
+	crashNextStart bool
+	dropMessages   int
+	duplicates     int
+	nackPublishes  int
+	truncateAt     int
+
+	transientQueues          bool
+	disabledProtocols        []string
+	clusterHosts             []string
+	singleActiveConsumer     bool
+	sharedBindingCredentials bool
+	userTags                 []string
+	planLimits               map[string]Limits
+
+	tls TLS
+	ca  *certificateAuthority
+}
+
+type App struct {
+	Name      string
+	Space     string
+	GUID      string
+	Path      string
+	Instances int
+	Env       map[string]string
+	Started   bool
+	Crashed   bool
+	Bindings  []string
+
+	// next is the instance the next unpinned request is routed to, and
+	// gets counts the messages each instance has taken from the broker
+	// since it last started.
+	next int
+	gets map[int]int
+}
+
+type ServiceInstance struct {
+	Name    string
+	Space   string
+	Service string
+	Plan    string
+	Keys    map[string]map[string]interface{}
+	Queues  map[string][]string
+
+	// SharedSpaces are the spaces the instance is shared into.
+	SharedSpaces []string
+
+	bindings []queueBinding
+	users    map[string]bool
+
+	// connections counts the connections each user holds open through
+	// the connector.
+	connections map[string]int
+
+	// queueTypes are the types of the queues declared with one other
+	// than classic.
+	queueTypes map[string]string
+
+	// redelivered counts the requeued messages at the head of each
+	// queue, which are flagged as redelivered.
+	redelivered map[string]int
+
+	// queueArguments are the arguments the queues were declared with,
+	// if any.
+	queueArguments map[string]amqp.Table
+}
+
+func New(appsDomain string) *Platform {
+	return &Platform{state: &state{
+		AppsDomain: appsDomain,
+		apps:       map[string]*App{},
+		instances:  map[string]*ServiceInstance{},
+		failures:   map[string][]error{},
+		planLimits: map[string]Limits{},
+	}}
+}
+
+// InSpace returns the fake acting on another space; it shares everything
+// else, including injected failures, with p.
+func (p *Platform) InSpace(spaceName string) platform.Platform {
+	return &Platform{state: p.state, space: spaceName}
+}
+
+// FailNext makes the next call of the cf command named operation (e.g.
+// "create-service" or "start") fail with err.
+func (p *Platform) FailNext(operation string, err error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.failures[operation] = append(p.failures[operation], err)
+}
+
+// CrashNextStart makes the next app start leave the app crashed.
+func (p *Platform) CrashNextStart() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.crashNextStart = true
+}
+
+// DropMessages makes the broker accept but lose the next count messages.
+func (p *Platform) DropMessages(count int) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.dropMessages += count
+}
+
+// NackPublishes makes the broker nack, and lose, the next count messages
+// published in confirm mode.
+func (p *Platform) NackPublishes(count int) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.nackPublishes += count
+}
+
+// DuplicateMessages makes the broker deliver the next count messages
+// twice.
+func (p *Platform) DuplicateMessages(count int) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.duplicates += count
+}
+
+// TruncateMessages makes the broker keep only the first size bytes of
+// every message; zero keeps them whole.
+func (p *Platform) TruncateMessages(size int) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.truncateAt = size
+}
+
+// TransientQueues makes the queues the apps declare auto-delete, so they
+// go, with their messages, when the app's connection closes on a restart.
+func (p *Platform) TransientQueues() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.transientQueues = true
+}
+
+// SingleActiveConsumer makes only the first instance of an app get
+// messages, as if its queues had a single active consumer; the others find
+// them empty.
+func (p *Platform) SingleActiveConsumer() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.singleActiveConsumer = true
+}
+
+// SharedBindingCredentials makes every app binding get the same broker
+// user, as brokers that hand out per-instance credentials do. Unbinding one
+// app deletes the user, and so locks the other apps out.
+func (p *Platform) SharedBindingCredentials() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.sharedBindingCredentials = true
+}
+
+// Enqueue puts a message straight onto a queue of an instance, as if some
+// other client had published it.
+func (p *Platform) Enqueue(instanceName, queue, message string) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	instance, err := p.instance(instanceName)
+	if err != nil {
+		return err
+	}
+	instance.Queues[queue] = append(instance.Queues[queue], message)
+	return nil
+}
+
+// Calls returns every operation as the equivalent cf command line.
+func (p *Platform) Calls() [][]string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return append([][]string{}, p.calls...)
+}
+
+func (p *Platform) App(appName string) (App, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	app, ok := p.apps[appName]
+	if !ok {
+		return App{}, false
+	}
+	return *app, true
+}
+
+func (p *Platform) AppNames() []string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	names := []string{}
+	for name := range p.apps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (p *Platform) ServiceInstanceNames() []string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	names := []string{}
+	for name := range p.instances {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (p *Platform) PushApp(appName, appPath string) error {
+	return p.call([]string{"push", appName, "-p", appPath}, func() error {
+		if _, ok := p.apps[appName]; ok {
+			return fmt.Errorf("app %s already exists", appName)
+		}
+		p.apps[appName] = &App{Name: appName, Space: p.space, GUID: "guid-" + appName, Path: appPath, Instances: 1, Env: map[string]string{}, gets: map[int]int{}}
+		return nil
+	})
+}
+
+func (p *Platform) StartApp(appName string) error {
+	return p.call([]string{"start", appName}, func() error {
+		app, err := p.app(appName)
+		if err != nil {
+			return err
+		}
+		if p.crashNextStart {
+			p.crashNextStart = false
+			app.Crashed = true
+			return fmt.Errorf("Start unsuccessful\n\nTIP: use 'cf logs %s --recent' for more information", appName)
+		}
+		app.Started = true
+		app.Crashed = false
+		return nil
+	})
+}
+
+func (p *Platform) RestartApp(appName string) error {
+	return p.call([]string{"restart", appName}, func() error {
+		app, err := p.app(appName)
+		if err != nil {
+			return err
+		}
+		if p.transientQueues {
+			for _, instanceName := range app.Bindings {
+				if instance, ok := p.instances[instanceName]; ok {
+					instance.Queues = map[string][]string{}
+				}
+			}
+		}
+		app.Started = true
+		app.Crashed = false
+		app.gets = map[int]int{}
+		return nil
+	})
+}
+
+func (p *Platform) ScaleApp(appName string, instances int) error {
+	return p.call([]string{"scale", appName, "-i", strconv.Itoa(instances)}, func() error {
+		app, err := p.app(appName)
+		if err != nil {
+			return err
+		}
+		if instances < 1 {
+			return fmt.Errorf("Instance count must be a positive integer")
+		}
+		app.Instances = instances
+		return nil
+	})
+}
+
+func (p *Platform) AppGUID(appName string) (string, error) {
+	var guid string
+	err := p.call([]string{"app", appName, "--guid"}, func() error {
+		app, err := p.app(appName)
+		if err != nil {
+			return err
+		}
+		guid = app.GUID
+		return nil
+	})
+	return guid, err
+}
+
+func (p *Platform) DeleteApp(appName string) error {
+	return p.call([]string{"delete", appName, "-f"}, func() error {
+		app, err := p.app(appName)
+		if err != nil {
+			return err
+		}
+		for _, instanceName := range app.Bindings {
+			if instance, ok := p.instances[instanceName]; ok {
+				instance.deleteKey(bindingKey(appName))
+			}
+		}
+		delete(p.apps, appName)
+		return nil
+	})
+}
+
+func (p *Platform) SetEnv(appName, name, value string) error {
+	return p.call([]string{"set-env", appName, name, value}, func() error {
+		app, err := p.app(appName)
+		if err != nil {
+			return err
+		}
+		app.Env[name] = value
+		return nil
+	})
+}
+
+func (p *Platform) CreateService(serviceName, planName, instanceName string) error {
+	return p.call([]string{"create-service", serviceName, planName, instanceName}, func() error {
+		if _, ok := p.instances[instanceName]; ok {
+			return fmt.Errorf("service instance %s already exists", instanceName)
+		}
+		p.instances[instanceName] = &ServiceInstance{
+			Name:           instanceName,
+			Space:          p.space,
+			Service:        serviceName,
+			Plan:           planName,
+			Keys:           map[string]map[string]interface{}{},
+			Queues:         map[string][]string{},
+			users:          map[string]bool{},
+			connections:    map[string]int{},
+			queueTypes:     map[string]string{},
+			redelivered:    map[string]int{},
+			queueArguments: map[string]amqp.Table{},
+		}
+		return nil
+	})
+}
+
+func (p *Platform) DeleteService(instanceName string) error {
+	return p.call([]string{"delete-service", "-f", instanceName}, func() error {
+		instance, err := p.ownInstance(instanceName)
+		if err != nil {
+			return err
+		}
+		if len(instance.SharedSpaces) > 0 {
+			return fmt.Errorf("Service instances must be unshared before they can be deleted. Unsharing %s will automatically delete any bindings that have been made to applications in other spaces.", instanceName)
+		}
+		for _, app := range p.apps {
+			for _, bound := range app.Bindings {
+				if bound == instanceName {
+					return fmt.Errorf("service instance %s is still bound to %s", instanceName, app.Name)
+				}
+			}
+		}
+		delete(p.instances, instanceName)
+		return nil
+	})
+}
+
+func (p *Platform) BindService(appName, instanceName string) error {
+	return p.call([]string{"bind-service", appName, instanceName}, func() error {
+		app, err := p.app(appName)
+		if err != nil {
+			return err
+		}
+		instance, err := p.instance(instanceName)
+		if err != nil {
+			return err
+		}
+		user := appName
+		if p.sharedBindingCredentials {
+			user = "shared-binding"
+		}
+		app.Bindings = append(app.Bindings, instanceName)
+		instance.addKey(bindingKey(appName), p.credentials(instanceName, user))
+		return nil
+	})
+}
+
+func (p *Platform) UnbindService(appName, instanceName string) error {
+	return p.call([]string{"unbind-service", appName, instanceName}, func() error {
+		app, err := p.app(appName)
+		if err != nil {
+			return err
+		}
+		instance, err := p.instance(instanceName)
+		if err != nil {
+			return err
+		}
+		for i, bound := range app.Bindings {
+			if bound == instanceName {
+				app.Bindings = append(app.Bindings[:i], app.Bindings[i+1:]...)
+				instance.deleteKey(bindingKey(appName))
+				return nil
+			}
+		}
+		return fmt.Errorf("app %s is not bound to %s", appName, instanceName)
+	})
+}
+
+func (p *Platform) CreateServiceKey(instanceName, keyName string) error {
+	return p.call([]string{"create-service-key", instanceName, keyName}, func() error {
+		instance, err := p.instance(instanceName)
+		if err != nil {
+			return err
+		}
+		instance.addKey(keyName, p.credentials(instanceName, keyName))
+		return nil
+	})
+}
+
+func (p *Platform) ServiceKey(instanceName, keyName string) (map[string]interface{}, error) {
+	var key map[string]interface{}
+	err := p.call([]string{"service-key", instanceName, keyName}, func() error {
+		instance, err := p.instance(instanceName)
+		if err != nil {
+			return err
+		}
+		found, ok := instance.Keys[keyName]
+		if !ok {
+			return fmt.Errorf("service key %s not found", keyName)
+		}
+		key = found
+		return nil
+	})
+	return key, err
+}
+
+func (p *Platform) DeleteServiceKey(instanceName, keyName string) error {
+	return p.call([]string{"delete-service-key", "-f", instanceName, keyName}, func() error {
+		instance, err := p.instance(instanceName)
+		if err != nil {
+			return err
+		}
+		instance.deleteKey(keyName)
+		return nil
+	})
+}
+
+func (p *Platform) BindingCredentials(appName, instanceName string) (map[string]interface{}, error) {
+	var credentials map[string]interface{}
+	err := p.call([]string{"env", appName}, func() error {
+		if _, err := p.app(appName); err != nil {
+			return err
+		}
+		// an instance the space cannot see is not bound to its apps
+		instance, err := p.instance(instanceName)
+		if err != nil {
+			return &platform.NotBoundError{AppName: appName, InstanceName: instanceName}
+		}
+		found, ok := instance.Keys[bindingKey(appName)]
+		if !ok {
+			return &platform.NotBoundError{AppName: appName, InstanceName: instanceName}
+		}
+		credentials = found
+		return nil
+	})
+	return credentials, err
+}
+
+func (p *Platform) ShareService(instanceName, spaceName string) error {
+	return p.call([]string{"share-service", instanceName, "-s", spaceName}, func() error {
+		instance, err := p.ownInstance(instanceName)
+		if err != nil {
+			return err
+		}
+		if spaceName == instance.Space {
+			return fmt.Errorf("Service instance %s is already in space %s", instanceName, spaceName)
+		}
+		for _, shared := range instance.SharedSpaces {
+			if shared == spaceName {
+				return nil
+			}
+		}
+		instance.SharedSpaces = append(instance.SharedSpaces, spaceName)
+		return nil
+	})
+}
+
+// UnshareService also unbinds the instance from the apps of the space, as
+// the Cloud Controller does.
+func (p *Platform) UnshareService(instanceName, spaceName string) error {
+	return p.call([]string{"unshare-service", instanceName, "-s", spaceName, "-f"}, func() error {
+		instance, err := p.ownInstance(instanceName)
+		if err != nil {
+			return err
+		}
+		for i, shared := range instance.SharedSpaces {
+			if shared != spaceName {
+				continue
+			}
+			instance.SharedSpaces = append(instance.SharedSpaces[:i], instance.SharedSpaces[i+1:]...)
+
+			for _, app := range p.apps {
+				if app.Space != spaceName {
+					continue
+				}
+				for j, bound := range app.Bindings {
+					if bound == instanceName {
+						app.Bindings = append(app.Bindings[:j], app.Bindings[j+1:]...)
+						instance.deleteKey(bindingKey(app.Name))
+						break
+					}
+				}
+			}
+			return nil
+		}
+		return fmt.Errorf("Service instance %s is not shared with space %s", instanceName, spaceName)
+	})
+}
+
+// call records the operation and runs it under the lock, unless a failure
+// was injected for it.
+func (p *Platform) call(command []string, operation func() error) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.calls = append(p.calls, command)
+
+	if failures := p.failures[command[0]]; len(failures) > 0 {
+		p.failures[command[0]] = failures[1:]
+		return failures[0]
+	}
+
+	return operation()
+}
+
+func (p *Platform) app(appName string) (*App, error) {
+	app, ok := p.apps[appName]
+	if !ok || app.Space != p.space {
+		return nil, fmt.Errorf("App %s not found", appName)
+	}
+	return app, nil
+}
+
+// instance finds an instance of the space, or one shared into it.
+func (p *Platform) instance(instanceName string) (*ServiceInstance, error) {
+	instance, ok := p.instances[instanceName]
+	if ok && instance.Space == p.space {
+		return instance, nil
+	}
+	if ok {
+		for _, shared := range instance.SharedSpaces {
+			if shared == p.space {
+				return instance, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("Service instance %s not found", instanceName)
+}
+
+// ownInstance finds an instance of the space, not one shared into it.
+func (p *Platform) ownInstance(instanceName string) (*ServiceInstance, error) {
+	instance, ok := p.instances[instanceName]
+	if !ok || instance.Space != p.space {
+		return nil, fmt.Errorf("Service instance %s not found", instanceName)
+	}
+	return instance, nil
+}
+
+// addKey stores credentials and creates their broker user.
+func (instance *ServiceInstance) addKey(name string, credentials map[string]interface{}) {
+	instance.Keys[name] = credentials
+	instance.users[credentials["username"].(string)] = true
+}
+
+// deleteKey removes credentials and deletes their broker user, even if
+// other credentials share it.
+func (instance *ServiceInstance) deleteKey(name string) {
+	if credentials, ok := instance.Keys[name]; ok {
+		delete(instance.users, credentials["username"].(string))
+		delete(instance.Keys, name)
+	}
+}
+
+// allows tells whether the broker lets the user in with the password.
+func (instance *ServiceInstance) allows(username, password string) bool {
+	if !instance.users[username] {
+		return false
+	}
+	for _, key := range instance.Keys {
+		if key["username"] == username && key["password"] == password {
+			return true
+		}
+	}
+	return false
+}
+
+// bindingKey stores an app binding's credentials next to the service keys.
+func bindingKey(appName string) string {
+	return "binding:" + appName
+}
+
+// credentials are what the broker hands out to a binding or service key,
+// for every protocol that is not disabled; plans that require TLS only
+// get TLS endpoints. The caller holds the lock.
+func (p *Platform) credentials(instanceName, user string) map[string]interface{} {
+	vhost := instanceName
+	username := "user-" + user
+	password := "password-" + user
+	if p.planLimits[p.instances[instanceName].Plan].TLSRequired {
+		return p.withClusterHosts(p.tlsCredentials(vhost, username, password))
+	}
+
+	protocols := map[string]interface{}{
+		"amqp": map[string]interface{}{
+			"host":     brokerHost,
+			"port":     5672,
+			"username": username,
+			"password": password,
+			"vhost":    vhost,
+			"uri":      fmt.Sprintf("amqp://%s:%s@%s:5672/%s", username, password, brokerHost, vhost),
+		},
+		"mqtt": map[string]interface{}{
+			"host":     brokerHost,
+			"port":     1883,
+			"username": vhost + ":" + username,
+			"password": password,
+			"uri":      fmt.Sprintf("mqtt://%s%%3A%s:%s@%s:1883", vhost, username, password, brokerHost),
+		},
+		"stomp": map[string]interface{}{
+			"host":     brokerHost,
+			"port":     61613,
+			"username": username,
+			"password": password,
+			"vhost":    vhost,
+			"uri":      fmt.Sprintf("stomp://%s:%s@%s:61613", username, password, brokerHost),
+		},
+		"management": map[string]interface{}{
+			"host":     brokerHost,
+			"port":     443,
+			"path":     "/api/",
+			"ssl":      true,
+			"username": username,
+			"password": password,
+			"uri":      fmt.Sprintf("https://%s:%s@%s/api/", username, password, brokerHost),
+		},
+	}
+	for _, protocol := range p.disabledProtocols {
+		delete(protocols, protocol)
+	}
+
+	return p.withClusterHosts(map[string]interface{}{
+		"hostname":      brokerHost,
+		"vhost":         vhost,
+		"username":      username,
+		"password":      password,
+		"uri":           fmt.Sprintf("amqp://%s:%s@%s/%s", username, password, brokerHost, vhost),
+		"http_api_uri":  fmt.Sprintf("https://%s:%s@%s/api/", username, password, brokerHost),
+		"dashboard_url": fmt.Sprintf("https://%s/#/login/%s/%s", brokerHost, username, password),
+		"protocols":     protocols,
+	})
+}
+
+// withClusterHosts adds the cluster's hosts to the credentials and to each
+// of their endpoints but the management API's, when there are any. The
+// caller holds the lock.
+func (p *Platform) withClusterHosts(credentials map[string]interface{}) map[string]interface{} {
+	if len(p.clusterHosts) == 0 {
+		return credentials
+	}
+	credentials["hostnames"] = p.clusterHosts
+	for name, protocol := range credentials["protocols"].(map[string]interface{}) {
+		if name != "management" {
+			protocol.(map[string]interface{})["hosts"] = p.clusterHosts
+		}
+	}
+	return credentials
+}
+
+// tlsCredentials are credentials with only TLS endpoints, keyed by
+// protocol with "+ssl" added, as the broker hands out for plans that
+// require TLS. The caller holds the lock.
+func (p *Platform) tlsCredentials(vhost, username, password string) map[string]interface{} {
+	protocols := map[string]interface{}{
+		"amqp+ssl": map[string]interface{}{
+			"host":     brokerHost,
+			"port":     5671,
+			"ssl":      true,
+			"username": username,
+			"password": password,
+			"vhost":    vhost,
+			"uri":      fmt.Sprintf("amqps://%s:%s@%s:5671/%s", username, password, brokerHost, vhost),
+		},
+		"mqtt+ssl": map[string]interface{}{
+			"host":     brokerHost,
+			"port":     8883,
+			"ssl":      true,
+			"username": vhost + ":" + username,
+			"password": password,
+			"uri":      fmt.Sprintf("mqtt+ssl://%s%%3A%s:%s@%s:8883", vhost, username, password, brokerHost),
+		},
+		"stomp+ssl": map[string]interface{}{
+			"host":     brokerHost,
+			"port":     61614,
+			"ssl":      true,
+			"username": username,
+			"password": password,
+			"vhost":    vhost,
+			"uri":      fmt.Sprintf("stomp+ssl://%s:%s@%s:61614", username, password, brokerHost),
+		},
+		"management": map[string]interface{}{
+			"host":     brokerHost,
+			"port":     443,
+			"path":     "/api/",
+			"ssl":      true,
+			"username": username,
+			"password": password,
+			"uri":      fmt.Sprintf("https://%s:%s@%s/api/", username, password, brokerHost),
+		},
+	}
+	for _, protocol := range p.disabledProtocols {
+		delete(protocols, protocol+"+ssl")
+	}
+
+	return map[string]interface{}{
+		"hostname":      brokerHost,
+		"vhost":         vhost,
+		"username":      username,
+		"password":      password,
+		"ssl":           true,
+		"uri":           fmt.Sprintf("amqps://%s:%s@%s/%s", username, password, brokerHost, vhost),
+		"http_api_uri":  fmt.Sprintf("https://%s:%s@%s/api/", username, password, brokerHost),
+		"dashboard_url": fmt.Sprintf("https://%s/#/login/%s/%s", brokerHost, username, password),
+		"protocols":     protocols,
+	}
+}
@@ -0,0 +1,176 @@
+package fakeplatform
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"net"
+	"strconv"
+	"time"
+)
+
+// tlsPorts are where the broker's TLS endpoints listen: AMQP, MQTT, STOMP
+// and the management API.
+var tlsPorts = []int{5671, 8883, 61614, 443}
+
+// TLS is how the broker's TLS endpoints answer. The zero value presents a
+// certificate for the broker's host, signed by the fake's CA and valid
+// for a year, over any version from TLS 1.0 up.
+type TLS struct {
+	// Hosts are the names the certificate covers instead of the broker's
+	// host.
+	Hosts []string
+
+	// Expired presents a certificate that expired the day before.
+	Expired bool
+
+	// MaxVersion caps the version the endpoints speak, e.g.
+	// tls.VersionTLS11.
+	MaxVersion uint16
+}
+
+// ServeTLS changes how the TLS endpoints answer from now on.
+func (p *Platform) ServeTLS(config TLS) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.tls = config
+}
+
+// certificateAuthority signs the broker's certificates.
+type certificateAuthority struct {
+	certificate *x509.Certificate
+	key         *ecdsa.PrivateKey
+}
+
+// CACertificates are the certificates to trust for the broker's TLS
+// endpoints.
+func (p *Platform) CACertificates() (*x509.CertPool, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	ca, err := p.certificateAuthority()
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.certificate)
+	return pool, nil
+}
+
+// Dial reaches the broker's TLS endpoints, which answer the handshake in
+// memory; every other address is refused.
+func (p *Platform) Dial(network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	listening := false
+	for _, tlsPort := range tlsPorts {
+		listening = listening || host == brokerHost && port == strconv.Itoa(tlsPort)
+	}
+	if !listening {
+		return nil, fmt.Errorf("dial %s %s: connection refused", network, address)
+	}
+
+	config, err := p.ServerTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	client, server := net.Pipe()
+	go func() {
+		conn := tls.Server(server, config)
+		conn.Handshake()
+		conn.Close()
+	}()
+	return client, nil
+}
+
+// ServerTLSConfig is how the broker's TLS endpoints answer the handshake,
+// e.g. to serve the fake's management API over TLS of its own.
+func (p *Platform) ServerTLSConfig() (*tls.Config, error) {
+	p.mutex.Lock()
+	config := p.tls
+	ca, err := p.certificateAuthority()
+	p.mutex.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	certificate, err := ca.issue(config)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		MinVersion:   tls.VersionTLS10,
+		MaxVersion:   config.MaxVersion,
+	}, nil
+}
+
+// certificateAuthority is the fake's CA, made the first time it is
+// needed. The caller holds the lock.
+func (p *Platform) certificateAuthority() (*certificateAuthority, error) {
+	if p.ca != nil {
+		return p.ca, nil
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Fake RabbitMQ CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(10, 0, 0),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	p.ca = &certificateAuthority{certificate: certificate, key: key}
+	return p.ca, nil
+}
+
+// issue makes the certificate the endpoints present, with the CA's after
+// it in the chain.
+func (ca *certificateAuthority) issue(config TLS) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	hosts := config.Hosts
+	if len(hosts) == 0 {
+		hosts = []string{brokerHost}
+	}
+	notBefore, notAfter := time.Now().Add(-time.Hour), time.Now().AddDate(1, 0, 0)
+	if config.Expired {
+		notBefore, notAfter = time.Now().AddDate(-1, 0, 0), time.Now().AddDate(0, 0, -1)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: hosts[0]},
+		DNSNames:     hosts,
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.certificate, &key.PublicKey, ca.key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der, ca.certificate.Raw}, PrivateKey: key}, nil
+}
@@ -0,0 +1,96 @@
+package lifecycle
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/broker"
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/credentials"
+)
+
+// brokerEndpoint returns the service key's endpoint for protocol, for the
+// checks that connect to the broker themselves.
+func (l *Lifecycle) brokerEndpoint(protocol string) (credentials.Protocol, error) {
+	var none credentials.Protocol
+	if !l.serviceCreated {
+		return none, prerequisiteError("the service instance was not created")
+	}
+	if l.connector == nil {
+		return none, prerequisiteError("there is no connector to reach the broker with")
+	}
+
+	c, err := l.serviceKeyCredentials()
+	if err != nil {
+		return none, err
+	}
+	endpoint, ok := c.Protocol(protocol)
+	if !ok {
+		return none, prerequisiteError(fmt.Sprintf("the credentials offer no %s endpoint", strings.ToUpper(protocol)))
+	}
+	return broker.Resolve(endpoint), nil
+}
+
+// connectAMQP connects over AMQP as the service key's user, for the checks
+// that talk to the broker themselves, and returns the endpoint with the
+// session.
+func (l *Lifecycle) connectAMQP() (broker.AMQP, credentials.Protocol, error) {
+	endpoint, err := l.brokerEndpoint(credentials.AMQP)
+	if err != nil {
+		return nil, endpoint, err
+	}
+
+	l.println("Connecting over AMQP:", broker.Address(endpoint))
+	session, err := l.connector.AMQP(endpoint)
+	return session, endpoint, err
+}
+
+// bindingCredentials returns the credentials of the app's binding to the
+// lifecycle's instance, keeping their passwords out of traces.
+func (l *Lifecycle) bindingCredentials(appName string) (credentials.Credentials, error) {
+	raw, err := l.platform.BindingCredentials(appName, l.ServiceInstanceName)
+	if err != nil {
+		return credentials.Credentials{}, err
+	}
+	c, err := credentials.Parse(raw)
+	if err != nil {
+		return credentials.Credentials{}, err
+	}
+
+	if l.tracer != nil {
+		l.tracer.AddSecret(c.Password)
+		for _, protocol := range c.Protocols {
+			l.tracer.AddSecret(protocol.Password)
+		}
+	}
+	return c, nil
+}
+
+// serviceKeyCredentials creates a service key, the first time it is
+// called, and returns its credentials. Their passwords are kept out of
+// traces.
+func (l *Lifecycle) serviceKeyCredentials() (credentials.Credentials, error) {
+	if l.serviceKey == "" {
+		keyName := "smoke-test-key-" + randomName()
+		if err := l.platform.CreateServiceKey(l.ServiceInstanceName, keyName); err != nil {
+			return credentials.Credentials{}, err
+		}
+		l.serviceKey = keyName
+
+		raw, err := l.platform.ServiceKey(l.ServiceInstanceName, keyName)
+		if err != nil {
+			return credentials.Credentials{}, err
+		}
+		l.credentials, err = credentials.Parse(raw)
+		if err != nil {
+			return credentials.Credentials{}, err
+		}
+
+		if l.tracer != nil {
+			l.tracer.AddSecret(l.credentials.Password)
+			for _, protocol := range l.credentials.Protocols {
+				l.tracer.AddSecret(protocol.Password)
+			}
+		}
+	}
+	return l.credentials, nil
+}
@@ -0,0 +1,604 @@
+package lifecycle
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pborman/uuid"
+
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/broker"
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/credentials"
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/diagnostics"
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/exampleapp"
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/platform"
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/report"
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/tracing"
+)
+
+type Protocol string
+
+const (
+	AMQP  Protocol = "amqp"
+	STOMP Protocol = "stomp"
+	MQTT  Protocol = "mqtt"
+)
+
+// Steps, as named in reports.
+const (
+	StepPush       = "push"
+	StepCreate     = "create"
+	StepBind       = "bind"
+	StepStart      = "start"
+	StepPublish    = "publish"
+	StepConsume    = "consume"
+	StepPayloads   = "payloads"
+	StepOrdering   = "ordering"
+	StepDurability = "durability"
+	StepScaling    = "scaling"
+	StepCleanup    = "cleanup"
+
+	StepSharedInstance = "shared-instance"
+	StepSharedSpace    = "shared-space"
+	StepManagement     = "management"
+
+	StepMaxMessageSize = "max-message-size"
+	StepMaxConnections = "max-connections"
+	StepQueueTypes     = "queue-types"
+	StepTLSRequired    = "tls-required"
+
+	StepQuorumQueue = "quorum-queue"
+	StepStreamQueue = "stream-queue"
+
+	StepPublisherConfirms = "publisher-confirms"
+	StepNackRequeue       = "nack-requeue"
+	StepRejectDrop        = "reject-drop"
+
+	StepDeadLetterMaxLength = "dead-letter-max-length"
+	StepDeadLetterTTL       = "dead-letter-ttl"
+	StepOperatorPolicy      = "operator-policy"
+
+	StepTLSPosture       = "tls-posture"
+	StepPlaintextRefused = "plaintext-refused"
+
+	StepMQTTToAMQP  = "mqtt-to-amqp"
+	StepSTOMPToAMQP = "stomp-to-amqp"
+)
+
+type Config struct {
+	ServiceName     string
+	PlanName        string
+	Protocol        Protocol
+	AppPath         string
+	AppsDomain      string
+	RabbitMQSkipSSL bool
+
+	// AppRoutesHTTP reaches the apps' routes over http, not https.
+	AppRoutesHTTP bool
+
+	// Timeout bounds each check against the app, which is retried every
+	// RetryInterval until it passes.
+	Timeout       time.Duration
+	RetryInterval time.Duration
+}
+
+// Lifecycle pushes an example app, binds it to a fresh service instance of
+// one plan and checks that messages can go through it. The steps are run
+// in order; each one refuses to run when an earlier one did not succeed.
+type Lifecycle struct {
+	config               Config
+	platform             platform.Platform
+	httpClient           *http.Client
+	managementHTTPClient *http.Client
+	out                  io.Writer
+	recorder             *report.Recorder
+	collector            *diagnostics.Collector
+	tracer               *tracing.Tracer
+	connector            broker.Connector
+
+	AppName             string
+	ServiceInstanceName string
+
+	// QueueName is unique to the lifecycle, so that messages left over by
+	// another run cannot be mistaken for its own.
+	QueueName string
+
+	appPushed      bool
+	serviceCreated bool
+	serviceBound   bool
+	appIsRunning   bool
+	queueDeclared  bool
+	published      bool
+
+	// serviceKey is created the first time a step needs credentials of
+	// its own, e.g. for the management API.
+	serviceKey  string
+	credentials credentials.Credentials
+
+	// consumerApp is the second app bound to the instance, while it
+	// exists.
+	consumerApp string
+
+	// sharedSpace is the space the instance is shared into, while it is
+	// or while sharedSpaceApp is left there.
+	sharedSpace    string
+	sharedSpaceApp string
+	serviceShared  bool
+
+	// message is what WriteAndRead published, more than once if
+	// publishRetried: a publish that timed out may still have reached the
+	// broker.
+	message        string
+	publishRetried bool
+	publishedAt    time.Time
+	latency        time.Duration
+
+	stepOutput *bytes.Buffer
+	attempts   int
+	findings   []string
+
+	span    *tracing.Span
+	spanErr error
+}
+
+// New makes a lifecycle that reaches the app's routes with httpClient and
+// the broker's management API with managementHTTPClient, which trust
+// different CAs.
+func New(config Config, p platform.Platform, httpClient, managementHTTPClient *http.Client, out io.Writer) *Lifecycle {
+	return &Lifecycle{
+		config:               config,
+		platform:             p,
+		httpClient:           httpClient,
+		managementHTTPClient: managementHTTPClient,
+		out:                  out,
+		AppName:              randomName(),
+		ServiceInstanceName:  randomName(),
+		QueueName:            "test-q-" + randomName(),
+	}
+}
+
+// WithRecorder records the timing and outcome of every step.
+func (l *Lifecycle) WithRecorder(recorder *report.Recorder) *Lifecycle {
+	l.recorder = recorder
+	return l
+}
+
+// WithDiagnostics collects diagnostics whenever a step fails, and points
+// to them in the step's error.
+func (l *Lifecycle) WithDiagnostics(collector *diagnostics.Collector) *Lifecycle {
+	l.collector = collector
+	return l
+}
+
+// WithTracer traces the lifecycle in a span, with a child span for every
+// step; cf invocations and app requests made by a step go under it.
+func (l *Lifecycle) WithTracer(tracer *tracing.Tracer) *Lifecycle {
+	l.tracer = tracer
+	return l
+}
+
+// WithConnector lets the lifecycle connect to the broker itself, over the
+// native protocols, for the checks that do not go through the app.
+func (l *Lifecycle) WithConnector(connector broker.Connector) *Lifecycle {
+	l.connector = connector
+	return l
+}
+
+func randomName() string {
+	return uuid.NewRandom().String()
+}
+
+func (l *Lifecycle) AppURL() string {
+	return l.appURL(l.AppName)
+}
+
+func (l *Lifecycle) appURL(appName string) string {
+	scheme := "https"
+	if l.config.AppRoutesHTTP {
+		scheme = "http"
+	}
+	return scheme + "://" + appName + "." + l.config.AppsDomain
+}
+
+func (l *Lifecycle) PushApp() error {
+	return l.step(StepPush, func() error {
+		if err := l.platform.PushApp(l.AppName, l.config.AppPath); err != nil {
+			return err
+		}
+		l.appPushed = true
+		return nil
+	})
+}
+
+func (l *Lifecycle) CreateService() error {
+	return l.step(StepCreate, func() error {
+		if !l.appPushed {
+			return prerequisiteError("the app was not pushed")
+		}
+
+		if err := l.platform.CreateService(l.config.ServiceName, l.config.PlanName, l.ServiceInstanceName); err != nil {
+			return err
+		}
+		l.serviceCreated = true
+		return nil
+	})
+}
+
+func (l *Lifecycle) BindAndStart() error {
+	bindErr := l.step(StepBind, func() error {
+		if !l.appPushed || !l.serviceCreated {
+			return prerequisiteError("the app was not pushed or the service instance was not created")
+		}
+
+		if err := l.platform.BindService(l.AppName, l.ServiceInstanceName); err != nil {
+			return err
+		}
+		l.serviceBound = true
+		return nil
+	})
+
+	startErr := l.step(StepStart, func() error {
+		if !l.serviceBound {
+			return prerequisiteError("the service instance is not bound to the app")
+		}
+
+		if err := l.platform.SetEnv(l.AppName, "RABBITMQ_SKIP_SSL", l.skipSSL()); err != nil {
+			return err
+		}
+		if err := l.platform.StartApp(l.AppName); err != nil {
+			return err
+		}
+
+		app := l.app()
+		l.println("Checking that the app is responding at url: ", app.URL()+"/ping")
+		if err := l.eventually(app.Ping); err != nil {
+			return err
+		}
+		l.appIsRunning = true
+		return nil
+	})
+
+	if bindErr != nil {
+		return bindErr
+	}
+	return startErr
+}
+
+// WriteAndRead publishes a message through the app and reads it back. The
+// AMQP app needs the queue to be declared first; the STOMP and MQTT apps
+// create it on first use. Each message carries a unique ID and the time it
+// was published, and only that exact message is accepted back.
+func (l *Lifecycle) WriteAndRead() error {
+	publishErr := l.step(StepPublish, func() error {
+		if err := l.requireRunningApp(); err != nil {
+			return err
+		}
+
+		app := l.app()
+		queueName := l.QueueName
+
+		if err := l.declareQueue(app); err != nil {
+			return err
+		}
+
+		l.published = false
+		l.message = fmt.Sprintf("test-message-%s-%s-%d", l.config.Protocol, randomName(), time.Now().UnixNano())
+
+		l.println("Publishing to the queue: ", app.URL()+"/queue/"+queueName)
+		l.publishRetried = false
+		publishes := 0
+		err := l.eventually(func() error {
+			publishes++
+			l.publishRetried = publishes > 1
+			l.publishedAt = time.Now()
+			return app.Publish(queueName, l.message)
+		})
+		if err != nil {
+			return err
+		}
+		l.published = true
+		return nil
+	})
+
+	consumeErr := l.step(StepConsume, func() error {
+		if !l.published {
+			return prerequisiteError("no message was published")
+		}
+
+		app := l.app()
+		queueName := l.QueueName
+		uri := app.URL() + "/queue/" + queueName
+
+		l.println("Reading from the (non-empty) queue: ", uri)
+		err := l.eventually(func() error {
+			received, err := app.Consume(queueName)
+			if err != nil {
+				return err
+			}
+			if strings.TrimSpace(received) != l.message {
+				return fmt.Errorf("expected to read %q from %s, got %q", l.message, queueName, received)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		l.latency = time.Since(l.publishedAt)
+		l.println("Read the message back", l.latency, "after publishing it")
+
+		l.println("Reading from the (empty) queue: ", uri)
+		copies := 0
+		err = l.eventually(func() error {
+			for {
+				received, err := app.Consume(queueName)
+				if err != nil {
+					return err
+				}
+				if strings.TrimSpace(received) == l.message && l.publishRetried {
+					copies++
+					continue
+				}
+				if strings.Contains(received, "test-message-") {
+					return fmt.Errorf("expected %s to be empty, got %q", queueName, received)
+				}
+				return nil
+			}
+		})
+		if copies > 0 {
+			l.println("Drained", copies, "more copies of the message, left by retrying the publish")
+		}
+		return err
+	})
+
+	if publishErr != nil {
+		return publishErr
+	}
+	return consumeErr
+}
+
+// Cleanup removes whatever the earlier steps created. It carries on past
+// failures so one stuck resource does not leak the others.
+func (l *Lifecycle) Cleanup() error {
+	defer l.finishSpan()
+
+	return l.step(StepCleanup, func() error {
+		failures := []string{}
+
+		if l.serviceKey != "" {
+			if err := l.platform.DeleteServiceKey(l.ServiceInstanceName, l.serviceKey); err != nil {
+				failures = append(failures, err.Error())
+			} else {
+				l.serviceKey = ""
+			}
+		}
+		if err := l.deleteConsumerApp(); err != nil {
+			failures = append(failures, err.Error())
+		}
+		if err := l.cleanUpSharedSpace(); err != nil {
+			failures = append(failures, err.Error())
+		}
+		if l.serviceBound {
+			if err := l.platform.UnbindService(l.AppName, l.ServiceInstanceName); err != nil {
+				failures = append(failures, err.Error())
+			} else {
+				l.serviceBound = false
+			}
+		}
+		if l.serviceCreated && !l.serviceBound {
+			if err := l.platform.DeleteService(l.ServiceInstanceName); err != nil {
+				failures = append(failures, err.Error())
+			} else {
+				l.serviceCreated = false
+				l.queueDeclared = false
+			}
+		}
+		if l.appPushed {
+			if err := l.platform.DeleteApp(l.AppName); err != nil {
+				failures = append(failures, err.Error())
+			} else {
+				l.appPushed = false
+				l.appIsRunning = false
+			}
+		}
+
+		if len(failures) > 0 {
+			return fmt.Errorf("%s", strings.Join(failures, "\n\n"))
+		}
+		return nil
+	})
+}
+
+// finishSpan ends the lifecycle's span, failed if any step failed.
+func (l *Lifecycle) finishSpan() {
+	if l.span == nil {
+		return
+	}
+	l.span.Finish(l.spanErr)
+	l.span = nil
+	l.spanErr = nil
+}
+
+// prerequisiteError means a step did not run because an earlier one
+// failed; it is reported as skipped.
+type prerequisiteError string
+
+func (e prerequisiteError) Error() string {
+	return string(e)
+}
+
+// requireBinding is a prerequisiteError unless the service instance is
+// bound to the app.
+func (l *Lifecycle) requireBinding() error {
+	if !l.appPushed || !l.serviceCreated || !l.serviceBound {
+		return prerequisiteError("the service instance is not bound to the app")
+	}
+	return nil
+}
+
+// requireRunningApp is a prerequisiteError unless the app is running with
+// the service instance bound.
+func (l *Lifecycle) requireRunningApp() error {
+	if !l.appPushed || !l.serviceCreated || !l.serviceBound || !l.appIsRunning {
+		return prerequisiteError("the app is not running with the service instance bound")
+	}
+	return nil
+}
+
+// step runs one reported step, capturing what it prints and how many
+// attempts its checks took.
+func (l *Lifecycle) step(name string, run func() error) error {
+	l.stepOutput = &bytes.Buffer{}
+	l.attempts = 0
+	l.latency = 0
+	l.findings = nil
+	defer func() { l.stepOutput = nil }()
+
+	var span *tracing.Span
+	if l.tracer != nil {
+		previous := l.tracer.Active()
+		defer l.tracer.SetActive(previous)
+
+		span = l.startStepSpan(name)
+		l.tracer.SetActive(span)
+	}
+
+	start := time.Now()
+	err := run()
+	end := time.Now()
+
+	_, skipped := err.(prerequisiteError)
+
+	artifacts := []string{}
+	if err != nil && !skipped && l.collector != nil {
+		files, collectErr := l.collector.Collect(fmt.Sprintf("%s-%s-%s", l.config.PlanName, l.config.Protocol, name), diagnostics.Target{
+			AppName:             l.AppName,
+			AppURL:              l.AppURL(),
+			ServiceInstanceName: l.ServiceInstanceName,
+		})
+		for _, file := range files {
+			artifacts = append(artifacts, file.Path)
+		}
+		if collectErr != nil {
+			err = fmt.Errorf("%s\n\nFailed to collect diagnostics: %s", err.Error(), collectErr.Error())
+		} else {
+			err = fmt.Errorf("%s\n\n%s", err.Error(), diagnostics.Summary(files))
+		}
+	}
+
+	attempts := l.attempts
+	if attempts == 0 {
+		attempts = 1
+	}
+	outcome := report.Passed
+	if err != nil {
+		outcome = report.Failed
+		if skipped {
+			outcome = report.Skipped
+			attempts = 0
+		}
+	}
+
+	if span != nil {
+		span.SetAttribute("smoke.attempts", attempts)
+		span.SetAttribute("smoke.outcome", outcome)
+
+		var failure error
+		if outcome == report.Failed {
+			failure = err
+			if l.spanErr == nil {
+				l.spanErr = err
+			}
+		}
+		span.Finish(failure)
+	}
+
+	if l.recorder != nil {
+		step := report.Step{
+			Plan:      l.config.PlanName,
+			Protocol:  string(l.config.Protocol),
+			Name:      name,
+			Start:     start,
+			End:       end,
+			Attempts:  attempts,
+			Outcome:   outcome,
+			Output:    l.stepOutput.String(),
+			Artifacts: artifacts,
+			Latency:   l.latency,
+			Findings:  l.findings,
+		}
+		if err != nil {
+			step.Error = err.Error()
+		}
+		l.recorder.Record(step)
+	}
+
+	return err
+}
+
+// startStepSpan starts the span of the whole lifecycle with its first step,
+// under whatever span is active then, and the step's span under it.
+func (l *Lifecycle) startStepSpan(name string) *tracing.Span {
+	if l.span == nil {
+		l.span = l.tracer.StartSpan(fmt.Sprintf("plan %s over %s", l.config.PlanName, l.config.Protocol), tracing.KindInternal, l.tracer.Active())
+		l.span.SetAttribute("rabbitmq.service", l.config.ServiceName)
+		l.span.SetAttribute("rabbitmq.plan", l.config.PlanName)
+		l.span.SetAttribute("rabbitmq.protocol", string(l.config.Protocol))
+		l.span.SetAttribute("cf.app", l.AppName)
+		l.span.SetAttribute("cf.service_instance", l.ServiceInstanceName)
+	}
+
+	span := l.tracer.StartSpan(name, tracing.KindInternal, l.span)
+	span.SetAttribute("smoke.step", name)
+	span.SetAttribute("rabbitmq.plan", l.config.PlanName)
+	span.SetAttribute("rabbitmq.protocol", string(l.config.Protocol))
+	return span
+}
+
+func (l *Lifecycle) println(args ...interface{}) {
+	fmt.Fprintln(l.out, args...)
+	if l.stepOutput != nil {
+		fmt.Fprintln(l.stepOutput, args...)
+	}
+}
+
+// finding prints a security problem and records it with the step.
+func (l *Lifecycle) finding(problem string) {
+	l.println("SECURITY FINDING:", problem)
+	l.findings = append(l.findings, problem)
+}
+
+// skipSSL is the app's RABBITMQ_SKIP_SSL setting.
+func (l *Lifecycle) skipSSL() string {
+	if l.config.RabbitMQSkipSSL {
+		return "1"
+	}
+	return "0"
+}
+
+func (l *Lifecycle) app() *exampleapp.Client {
+	return exampleapp.NewClient(l.AppURL(), l.httpClient)
+}
+
+// eventually retries check until it passes or the timeout elapses, and
+// returns the last failure. A route certificate that does not verify is
+// returned at once: waiting will not make it valid.
+func (l *Lifecycle) eventually(check func() error) error {
+	deadline := time.Now().Add(l.config.Timeout)
+	for {
+		l.attempts++
+		err := check()
+		if err == nil {
+			return nil
+		}
+		if _, ok := err.(*exampleapp.CertificateError); ok {
+			return err
+		}
+		if time.Now().Add(l.config.RetryInterval).After(deadline) {
+			return fmt.Errorf("still failing after %s: %s", l.config.Timeout, err.Error())
+		}
+		time.Sleep(l.config.RetryInterval)
+	}
+}
@@ -0,0 +1,1338 @@
+package lifecycle_test
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/broker"
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/credentials"
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/diagnostics"
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/exampleapp"
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/fakeplatform"
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/lifecycle"
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/payload"
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/report"
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/tlsprobe"
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/tracing"
+	"github.com/cloudfoundry-incubator/cf-test-helpers/cf"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+)
+
+var _ = Describe("Lifecycle", func() {
+	var (
+		fake   *fakeplatform.Platform
+		out    *gbytes.Buffer
+		config lifecycle.Config
+		lc     *lifecycle.Lifecycle
+		route  *flakyRoute
+	)
+
+	BeforeEach(func() {
+		fake = fakeplatform.New("fake-domain")
+		route = &flakyRoute{transport: fake.HTTPClient().Transport}
+		out = gbytes.NewBuffer()
+		config = lifecycle.Config{
+			ServiceName:   "p-rabbitmq",
+			PlanName:      "standard",
+			Protocol:      lifecycle.AMQP,
+			AppPath:       "fake/app/path",
+			AppsDomain:    "fake-domain",
+			Timeout:       50 * time.Millisecond,
+			RetryInterval: time.Millisecond,
+		}
+	})
+
+	JustBeforeEach(func() {
+		lc = lifecycle.New(config, fake, &http.Client{Transport: route}, fake.HTTPClient(), out)
+	})
+
+	runAll := func() error {
+		for _, step := range []func() error{lc.PushApp, lc.CreateService, lc.BindAndStart, lc.WriteAndRead} {
+			if err := step(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	It("pushes the app, binds a new instance of the plan and sends a message through it", func() {
+		Expect(runAll()).To(Succeed())
+
+		app, ok := fake.App(lc.AppName)
+		Expect(ok).To(BeTrue())
+		Expect(app.Path).To(Equal("fake/app/path"))
+		Expect(app.Bindings).To(Equal([]string{lc.ServiceInstanceName}))
+		Expect(app.Env).To(HaveKeyWithValue("RABBITMQ_SKIP_SSL", "0"))
+
+		Expect(out).To(gbytes.Say("Checking that the app is responding at url:  https://%s.fake-domain/ping", lc.AppName))
+		Expect(out).To(gbytes.Say("Creating a new queue"))
+		Expect(out).To(gbytes.Say("Listing the queues"))
+		Expect(out).To(gbytes.Say("Publishing to the queue"))
+		Expect(out).To(gbytes.Say("Reading from the \\(non-empty\\) queue"))
+		Expect(out).To(gbytes.Say("Reading from the \\(empty\\) queue"))
+	})
+
+	Context("with http app routes", func() {
+		BeforeEach(func() {
+			config.AppRoutesHTTP = true
+		})
+
+		It("reaches the app over http", func() {
+			Expect(runAll()).To(Succeed())
+			Expect(lc.AppURL()).To(Equal("http://" + lc.AppName + ".fake-domain"))
+		})
+	})
+
+	Context("when the app route serves TLS", func() {
+		var (
+			server     *httptest.Server
+			management *httptest.Server
+			routeTLS   *tls.Config
+		)
+
+		// dialing sends every request to the server, whatever its host
+		dialing := func(server *httptest.Server, client *http.Client) *http.Client {
+			client.Transport.(*http.Transport).DialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, network, server.Listener.Addr().String())
+			}
+			return client
+		}
+
+		BeforeEach(func() {
+			// httptest's certificate covers *.example.com
+			fake = fakeplatform.New("example.com")
+			config.AppsDomain = "example.com"
+			config.Timeout = 5 * time.Second
+			routeTLS = nil
+
+			server = httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, request *http.Request) {
+				fake.ServeHTTP(w, request)
+			}))
+			server.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+			server.StartTLS()
+		})
+
+		AfterEach(func() {
+			server.Close()
+			management.Close()
+		})
+
+		JustBeforeEach(func() {
+			// the management API presents a certificate from the broker's
+			// CA, not the route's
+			management = httptest.NewUnstartedServer(fake)
+			management.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+			var err error
+			management.TLS, err = fake.ServerTLSConfig()
+			Ω(err).ShouldNot(HaveOccurred())
+			management.StartTLS()
+			brokerCAs, err := fake.CACertificates()
+			Ω(err).ShouldNot(HaveOccurred())
+
+			lc = lifecycle.New(config, fake,
+				dialing(server, exampleapp.NewHTTPClient(time.Second, routeTLS)),
+				dialing(management, &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: brokerCAs}}}),
+				out)
+		})
+
+		It("fails at once, and says so, when no trusted CA signed the certificate", func() {
+			err := runAll()
+			Expect(err).To(MatchError(ContainSubstring("the app route's certificate was rejected")))
+			Expect(err).To(MatchError(ContainSubstring("unknown authority")))
+			Expect(err).NotTo(MatchError(ContainSubstring("still failing after")))
+		})
+
+		Context("with the route's CA in the bundle", func() {
+			BeforeEach(func() {
+				roots := x509.NewCertPool()
+				roots.AddCert(server.Certificate())
+				routeTLS = &tls.Config{RootCAs: roots}
+			})
+
+			It("verifies the certificate", func() {
+				Expect(runAll()).To(Succeed())
+			})
+
+			It("verifies the management API's certificate against the broker's CA instead", func() {
+				Expect(runAll()).To(Succeed())
+				Expect(lc.CheckManagementAPI(lifecycle.ManagementExpectations{Tags: []string{"management"}})).To(Succeed())
+				Expect(out).To(gbytes.Say("The broker runs RabbitMQ 3.8.9"))
+			})
+
+			Context("when the certificate is for another apps domain", func() {
+				BeforeEach(func() {
+					fake = fakeplatform.New("fake-domain")
+					config.AppsDomain = "fake-domain"
+				})
+
+				It("rejects the certificate for the hostname", func() {
+					err := runAll()
+					Expect(err).To(MatchError(ContainSubstring("the app route's certificate was rejected")))
+					Expect(err).To(MatchError(ContainSubstring("not " + lc.AppName + ".fake-domain")))
+				})
+			})
+		})
+
+		Context("when verification is skipped", func() {
+			BeforeEach(func() {
+				config.AppsDomain = "fake-domain"
+				fake = fakeplatform.New("fake-domain")
+				routeTLS = &tls.Config{InsecureSkipVerify: true}
+			})
+
+			It("accepts any certificate", func() {
+				Expect(runAll()).To(Succeed())
+			})
+		})
+	})
+
+	Context("when the reply to a publish is lost", func() {
+		BeforeEach(func() {
+			route.lostPublishes = 1
+		})
+
+		It("drains the copy that retrying the publish left in the queue", func() {
+			Expect(runAll()).To(Succeed())
+			Expect(out).To(gbytes.Say("Drained 1 more copies of the message, left by retrying the publish"))
+		})
+	})
+
+	Context("with the STOMP app", func() {
+		BeforeEach(func() {
+			config.Protocol = lifecycle.STOMP
+			config.RabbitMQSkipSSL = true
+		})
+
+		It("does not declare the queue up front", func() {
+			Expect(runAll()).To(Succeed())
+			Expect(out).NotTo(gbytes.Say("Creating a new queue"))
+
+			app, _ := fake.App(lc.AppName)
+			Expect(app.Env).To(HaveKeyWithValue("RABBITMQ_SKIP_SSL", "1"))
+		})
+	})
+
+	It("cleans up everything it created", func() {
+		Expect(runAll()).To(Succeed())
+		Expect(lc.Cleanup()).To(Succeed())
+
+		Expect(fake.AppNames()).To(BeEmpty())
+		Expect(fake.ServiceInstanceNames()).To(BeEmpty())
+		Expect(fake.Calls()[len(fake.Calls())-3:]).To(Equal([][]string{
+			{"unbind-service", lc.AppName, lc.ServiceInstanceName},
+			{"delete-service", "-f", lc.ServiceInstanceName},
+			{"delete", lc.AppName, "-f"},
+		}))
+	})
+
+	Context("when creating the service instance fails", func() {
+		BeforeEach(func() {
+			fake.FailNext("create-service", errors.New("Server error, status code: 502"))
+		})
+
+		It("reports the failure and refuses to bind", func() {
+			Expect(lc.PushApp()).To(Succeed())
+			Expect(lc.CreateService()).To(MatchError("Server error, status code: 502"))
+			Expect(lc.BindAndStart()).To(MatchError(ContainSubstring("the service instance was not created")))
+		})
+
+		It("only deletes the app", func() {
+			Expect(runAll()).NotTo(Succeed())
+			Expect(lc.Cleanup()).To(Succeed())
+
+			Expect(fake.AppNames()).To(BeEmpty())
+			for _, call := range fake.Calls() {
+				Expect(call[0]).NotTo(Equal("delete-service"))
+				Expect(call[0]).NotTo(Equal("unbind-service"))
+			}
+		})
+	})
+
+	Context("when the app crashes on start", func() {
+		BeforeEach(func() {
+			fake.CrashNextStart()
+		})
+
+		It("fails to start and still unbinds and deletes everything", func() {
+			Expect(lc.PushApp()).To(Succeed())
+			Expect(lc.CreateService()).To(Succeed())
+			Expect(lc.BindAndStart()).To(MatchError(ContainSubstring("Start unsuccessful")))
+			Expect(lc.WriteAndRead()).To(MatchError(ContainSubstring("the app is not running")))
+
+			Expect(lc.Cleanup()).To(Succeed())
+			Expect(fake.AppNames()).To(BeEmpty())
+			Expect(fake.ServiceInstanceNames()).To(BeEmpty())
+		})
+	})
+
+	Context("when the message goes missing", func() {
+		BeforeEach(func() {
+			fake.DropMessages(1)
+		})
+
+		It("fails to read it back", func() {
+			err := runAll()
+			Expect(err).To(MatchError(ContainSubstring("still failing after 50ms")))
+			Expect(err).To(MatchError(ContainSubstring(`expected to read "test-message-amqp-`)))
+		})
+	})
+
+	It("publishes a unique message to a queue of its own and records how long it took to read back", func() {
+		recorder := report.NewRecorder()
+		lc.WithRecorder(recorder)
+
+		Expect(runAll()).To(Succeed())
+		Expect(lc.WriteAndRead()).To(Succeed())
+		Expect(lc.QueueName).To(HavePrefix("test-q-"))
+		Expect(lifecycle.New(config, fake, fake.HTTPClient(), fake.HTTPClient(), out).QueueName).NotTo(Equal(lc.QueueName))
+		Expect(out).To(gbytes.Say("Publishing to the queue:  https://%s.fake-domain/queue/%s", lc.AppName, lc.QueueName))
+		Expect(out).To(gbytes.Say("Read the message back .* after publishing it"))
+
+		steps := recorder.Steps()
+		Expect(steps[5].Name).To(Equal(lifecycle.StepConsume))
+		Expect(steps[5].Latency).To(BeNumerically(">", 0))
+		Expect(steps[5].Latency).To(BeNumerically("<=", steps[5].End.Sub(steps[4].Start)))
+		Expect(steps[4].Latency).To(BeZero())
+	})
+
+	Context("when the queue holds a message from another run", func() {
+		JustBeforeEach(func() {
+			Expect(lc.PushApp()).To(Succeed())
+			Expect(lc.CreateService()).To(Succeed())
+			Expect(fake.Enqueue(lc.ServiceInstanceName, lc.QueueName, "test-message-amqp")).To(Succeed())
+			Expect(lc.BindAndStart()).To(Succeed())
+		})
+
+		It("skips it and reads its own", func() {
+			Expect(lc.WriteAndRead()).To(Succeed())
+		})
+
+		Context("and its own message goes missing", func() {
+			BeforeEach(func() {
+				fake.DropMessages(1)
+			})
+
+			It("does not mistake the stale message for its own", func() {
+				Expect(lc.WriteAndRead()).To(MatchError(ContainSubstring(`expected to read "test-message-amqp-`)))
+			})
+		})
+	})
+
+	Describe("checking payloads", func() {
+		var payloads []payload.Payload
+
+		BeforeEach(func() {
+			var err error
+			payloads, err = payload.Matrix(payload.Kinds, 16*1024)
+			Ω(err).ShouldNot(HaveOccurred())
+		})
+
+		It("sends every payload through the app intact", func() {
+			recorder := report.NewRecorder()
+			lc.WithRecorder(recorder)
+
+			Expect(lc.PushApp()).To(Succeed())
+			Expect(lc.CreateService()).To(Succeed())
+			Expect(lc.BindAndStart()).To(Succeed())
+			Expect(lc.CheckPayloads(payloads)).To(Succeed())
+
+			Expect(out).To(gbytes.Say("Creating a new queue"))
+			Expect(out).To(gbytes.Say("Payload empty came back intact: 0 bytes"))
+			Expect(out).To(gbytes.Say("Payload binary came back intact: 1024 bytes"))
+			Expect(out).To(gbytes.Say("Payload size-16KiB came back intact: 16384 bytes"))
+
+			steps := recorder.StepsOf("standard", "amqp")
+			Expect(steps[len(steps)-1].Name).To(Equal(lifecycle.StepPayloads))
+			Expect(steps[len(steps)-1].Outcome).To(Equal(report.Passed))
+		})
+
+		It("is skipped when the app is not running", func() {
+			Expect(lc.PushApp()).To(Succeed())
+			Expect(lc.CheckPayloads(payloads)).To(MatchError("the app is not running with the service instance bound"))
+		})
+
+		Context("when the broker truncates messages", func() {
+			BeforeEach(func() {
+				fake.TruncateMessages(4096)
+			})
+
+			It("reports every payload it truncated", func() {
+				Expect(runAll()).To(Succeed())
+
+				err := lc.CheckPayloads(payloads)
+				Expect(err).To(MatchError(ContainSubstring("1 of 6 payloads did not come back intact over amqp")))
+				Expect(err).To(MatchError(ContainSubstring("payload size-16KiB is truncated: sent 16384 bytes")))
+				Expect(err).To(MatchError(ContainSubstring("received 4096 bytes")))
+				Expect(err.Error()).NotTo(ContainSubstring("size-4KiB"))
+			})
+		})
+
+		Context("when a payload goes missing", func() {
+			It("reports it and checks the rest", func() {
+				Expect(runAll()).To(Succeed())
+				fake.DropMessages(1)
+
+				err := lc.CheckPayloads(payloads)
+				Expect(err).To(MatchError(ContainSubstring("1 of 6 payloads did not come back intact")))
+				Expect(err).To(MatchError(ContainSubstring("payload empty was not received")))
+				Expect(out).To(gbytes.Say("Payload size-16KiB came back intact"))
+			})
+		})
+	})
+
+	Describe("checking ordering", func() {
+		JustBeforeEach(func() {
+			Expect(runAll()).To(Succeed())
+		})
+
+		It("reads back every message of the batch once and in order", func() {
+			Expect(lc.CheckOrdering(20)).To(Succeed())
+			Expect(out).To(gbytes.Say("Publishing 20 numbered messages to the queue"))
+			Expect(out).To(gbytes.Say("Read all 20 messages back once each and in order"))
+		})
+
+		Context("when messages are lost and duplicated", func() {
+			JustBeforeEach(func() {
+				fake.DropMessages(2)
+				fake.DuplicateMessages(1)
+			})
+
+			It("reports which ones", func() {
+				err := lc.CheckOrdering(5)
+				Expect(err).To(MatchError(ContainSubstring("the batch did not come back intact over amqp: sent 5 messages, received 4; 2 missing: 1-2; 1 duplicated: 3")))
+			})
+		})
+
+		Context("when the queue cannot be read any more", func() {
+			It("reports the failure, with how much of the batch was read", func() {
+				route.failReadsAfter(2)
+				err := lc.CheckOrdering(5)
+				Expect(err).To(MatchError(ContainSubstring("could not read the batch back over amqp, having read 2 of 5 messages: still failing after")))
+				Expect(err).To(MatchError(ContainSubstring("connection reset by peer")))
+			})
+		})
+
+		Context("when a message from another batch is in the queue", func() {
+			JustBeforeEach(func() {
+				Expect(fake.Enqueue(lc.ServiceInstanceName, lc.QueueName, "test-sequence-amqp-other-000001")).To(Succeed())
+			})
+
+			It("reports it as not part of the batch", func() {
+				err := lc.CheckOrdering(3)
+				Expect(err).To(MatchError(ContainSubstring(`1 not part of the batch: ["test-sequence-amqp-other-000001"]`)))
+			})
+		})
+	})
+
+	Describe("checking durability", func() {
+		JustBeforeEach(func() {
+			Expect(runAll()).To(Succeed())
+		})
+
+		It("reads every message back after restarting the app", func() {
+			Expect(lc.CheckDurability(3, true)).To(Succeed())
+			Expect(out).To(gbytes.Say("Checking that the queue is durable: %s", lc.QueueName))
+			Expect(out).To(gbytes.Say("Restarting the app: %s", lc.AppName))
+			Expect(out).To(gbytes.Say("Read all 3 messages back after the restart"))
+			Expect(fake.Calls()).To(ContainElement([]string{"restart", lc.AppName}))
+		})
+
+		It("deletes the service key it used for the management API", func() {
+			Expect(lc.CheckDurability(1, true)).To(Succeed())
+			Expect(lc.Cleanup()).To(Succeed())
+
+			var keyCommands [][]string
+			for _, call := range fake.Calls() {
+				if call[0] == "create-service-key" || call[0] == "delete-service-key" {
+					keyCommands = append(keyCommands, call)
+				}
+			}
+			Expect(keyCommands).To(HaveLen(2))
+			Expect(keyCommands[1]).To(Equal([]string{"delete-service-key", "-f", lc.ServiceInstanceName, keyCommands[0][2]}))
+		})
+
+		Context("when the queue is transient", func() {
+			JustBeforeEach(func() {
+				fake.TransientQueues()
+			})
+
+			It("reports that the queue is not durable", func() {
+				Expect(lc.CheckDurability(3, true)).To(MatchError(ContainSubstring(fmt.Sprintf("queue %s is not durable (durable: false, auto_delete: true)", lc.QueueName))))
+			})
+
+			It("reports the messages lost across the restart without the management API", func() {
+				err := lc.CheckDurability(3, false)
+				Expect(err).To(MatchError(ContainSubstring("messages did not survive the app restarting over amqp: sent 3 messages, received 0; 3 missing: 1-3")))
+				Expect(fake.Calls()).NotTo(ContainElement(ContainElement("create-service-key")))
+			})
+		})
+	})
+
+	Describe("checking scaling", func() {
+		JustBeforeEach(func() {
+			Expect(runAll()).To(Succeed())
+		})
+
+		It("consumes every message exactly once across the instances", func() {
+			Expect(lc.CheckScaling(3, 6)).To(Succeed())
+			Expect(out).To(gbytes.Say("Scaling the app to 3 instances: %s", lc.AppName))
+			Expect(out).To(gbytes.Say("Checking that instance 2 is responding"))
+			Expect(out).To(gbytes.Say("Publishing 6 messages through instance 0"))
+			Expect(out).To(gbytes.Say(`The connection %s\.0:40000 -> rabbitmq\.fake:5672 took 2 messages`, lc.AppName))
+			Expect(out).To(gbytes.Say(`The connection %s\.2:40000 -> rabbitmq\.fake:5672 took 2 messages`, lc.AppName))
+			Expect(out).To(gbytes.Say("Consumed all 6 messages exactly once across 3 connections"))
+			Expect(out).To(gbytes.Say("Scaling the app back to 1 instance"))
+
+			app, _ := fake.App(lc.AppName)
+			Expect(app.Instances).To(Equal(1))
+			Expect(fake.Calls()).To(ContainElement([]string{"scale", lc.AppName, "-i", "3"}))
+		})
+
+		It("reports messages consumed twice", func() {
+			fake.DuplicateMessages(1)
+			Expect(lc.CheckScaling(2, 4)).To(MatchError(ContainSubstring("messages were not consumed exactly once across 2 instances: sent 4 messages, received 5; 1 duplicated: 1")))
+		})
+
+		Context("when only one instance gets messages", func() {
+			BeforeEach(func() {
+				fake.SingleActiveConsumer()
+			})
+
+			It("reports that the instances did not compete", func() {
+				err := lc.CheckScaling(3, 6)
+				Expect(err).To(MatchError(ContainSubstring("only 1 of the app's 3 connections took any messages (map[%s.0:40000 -> rabbitmq.fake:5672:6])", lc.AppName)))
+				Expect(err).To(MatchError(ContainSubstring("did not compete for the queue")))
+			})
+		})
+
+		Context("when the queue cannot be read any more", func() {
+			It("reports the failure instead of missing messages", func() {
+				route.failReadsAfter(3)
+				err := lc.CheckScaling(2, 4)
+				Expect(err).To(MatchError(ContainSubstring("could not read the batch back across 2 instances, having read 3 of 4 messages: still failing after")))
+				Expect(err).To(MatchError(ContainSubstring("connection reset by peer")))
+			})
+		})
+	})
+
+	Describe("checking a second app bound to the instance", func() {
+		JustBeforeEach(func() {
+			Expect(runAll()).To(Succeed())
+		})
+
+		It("consumes through the second app and keeps the first working once it is unbound", func() {
+			Expect(lc.CheckSharedInstance(true)).To(Succeed())
+			Expect(out).To(gbytes.Say("Pushing a consumer app:"))
+			Expect(out).To(gbytes.Say("The bindings have distinct broker users: user-%s and user-", lc.AppName))
+			Expect(out).To(gbytes.Say("Reading through the consumer app:"))
+			Expect(out).To(gbytes.Say("The producer app still works with the consumer app unbound"))
+			Expect(fake.AppNames()).To(ConsistOf(lc.AppName))
+		})
+
+		Context("when the bindings share a broker user", func() {
+			BeforeEach(func() {
+				fake.SharedBindingCredentials()
+			})
+
+			It("reports that unbinding the second app broke the first", func() {
+				err := lc.CheckSharedInstance(false)
+				Expect(err).To(MatchError(ContainSubstring("the %s app stopped working after the", lc.AppName)))
+				Expect(err).To(MatchError(ContainSubstring("ACCESS_REFUSED")))
+				Expect(out).To(gbytes.Say("The bindings share the broker user user-shared-binding"))
+				Expect(fake.AppNames()).To(ConsistOf(lc.AppName))
+			})
+
+			It("fails straight away when distinct credentials are required", func() {
+				Expect(lc.CheckSharedInstance(true)).To(MatchError(ContainSubstring("share the broker user user-shared-binding, expected distinct credentials")))
+				Expect(fake.AppNames()).To(ConsistOf(lc.AppName))
+			})
+		})
+	})
+
+	Describe("checking an instance shared into another space", func() {
+		JustBeforeEach(func() {
+			Expect(runAll()).To(Succeed())
+		})
+
+		It("relays messages both ways and removes the other space's binding on unsharing", func() {
+			Expect(lc.CheckSharedSpace("other-space")).To(Succeed())
+			Expect(out).To(gbytes.Say("Sharing the service instance into the space: other-space"))
+			Expect(out).To(gbytes.Say("Pushing an app to the space other-space: "))
+			Expect(out).To(gbytes.Say("Unsharing removed the binding in the space other-space"))
+
+			Expect(fake.Calls()).To(ContainElement([]string{"share-service", lc.ServiceInstanceName, "-s", "other-space"}))
+			Expect(fake.Calls()).To(ContainElement([]string{"unshare-service", lc.ServiceInstanceName, "-s", "other-space", "-f"}))
+			Expect(fake.AppNames()).To(ConsistOf(lc.AppName))
+		})
+
+		Context("when the binding cannot be looked up after unsharing", func() {
+			It("fails rather than taking the binding for removed", func() {
+				fake.FailNext("env", errors.New("cf env: Server error, status code: 503"))
+				Expect(lc.CheckSharedSpace("other-space")).To(MatchError(ContainSubstring("could not tell whether unsharing the service instance removed its binding in the space other-space: cf env: Server error, status code: 503")))
+				Expect(fake.AppNames()).To(ConsistOf(lc.AppName))
+			})
+		})
+
+		Context("when the app in the other space cannot be bound", func() {
+			It("unshares the instance so that it can be deleted", func() {
+				fake.FailNext("bind-service", errors.New("Service broker error: sharing is disabled"))
+				Expect(lc.CheckSharedSpace("other-space")).To(MatchError(ContainSubstring("sharing is disabled")))
+				Expect(fake.AppNames()).To(ConsistOf(lc.AppName))
+				Expect(lc.Cleanup()).To(Succeed())
+				Expect(fake.ServiceInstanceNames()).To(BeEmpty())
+			})
+		})
+	})
+
+	Describe("checking the management API", func() {
+		var recorder *report.Recorder
+
+		JustBeforeEach(func() {
+			recorder = report.NewRecorder()
+			lc.WithRecorder(recorder)
+			Expect(runAll()).To(Succeed())
+		})
+
+		It("checks the binding's user and records the broker's versions", func() {
+			Expect(lc.CheckManagementAPI(lifecycle.ManagementExpectations{Tags: []string{"management"}, Configure: ".*"})).To(Succeed())
+			Expect(out).To(gbytes.Say("The binding offers a dashboard at https://rabbitmq.fake\n"))
+			Expect(out).To(gbytes.Say("The user has the tags \\[management policymaker\\]"))
+			Expect(out).To(gbytes.Say("The broker runs RabbitMQ 3.8.9 on Erlang 23.1.4"))
+			Expect(out).To(gbytes.Say("The user may not list permissions"))
+
+			plans := recorder.Plans()
+			Expect(plans).To(HaveLen(1))
+			Expect(plans[0].Broker).To(Equal(&report.Broker{RabbitMQVersion: "3.8.9", ErlangVersion: "23.1.4", ClusterName: "rabbit@rabbitmq.fake"}))
+		})
+
+		It("reports the tags the user lacks", func() {
+			err := lc.CheckManagementAPI(lifecycle.ManagementExpectations{Tags: []string{"management", "monitoring"}})
+			Expect(err).To(MatchError(ContainSubstring("does not get what the standard plan promises:\nthe user lacks the tag monitoring, having [management policymaker]")))
+		})
+
+		Context("when the user may list permissions", func() {
+			BeforeEach(func() {
+				fake.UserTags("administrator")
+			})
+
+			It("compares them with the plan's", func() {
+				Expect(lc.CheckManagementAPI(lifecycle.ManagementExpectations{Configure: ".*", Write: ".*", Read: ".*"})).To(Succeed())
+
+				err := lc.CheckManagementAPI(lifecycle.ManagementExpectations{Configure: "^amq\\.gen.*", Read: ".*"})
+				Expect(err).To(MatchError(ContainSubstring(`the user's configure permission is ".*", expected "^amq\\.gen.*"`)))
+				Expect(err).NotTo(MatchError(ContainSubstring("read permission")))
+			})
+		})
+	})
+
+	Describe("checking cross-protocol delivery", func() {
+		var recorder *report.Recorder
+
+		JustBeforeEach(func() {
+			recorder = report.NewRecorder()
+			lc.WithRecorder(recorder).WithConnector(fake.Connector())
+			Expect(runAll()).To(Succeed())
+		})
+
+		outcomes := func() map[string]string {
+			result := map[string]string{}
+			for _, step := range recorder.Steps() {
+				result[step.Name] = step.Outcome
+			}
+			return result
+		}
+
+		It("consumes over AMQP what was published over MQTT and STOMP", func() {
+			Expect(lc.CheckCrossProtocol()).To(Succeed())
+			Expect(out).To(gbytes.Say("Connecting over AMQP: rabbitmq.fake:5672"))
+			Expect(out).To(gbytes.Say("bound to amq.topic with the routing key smoke-test\\."))
+			Expect(out).To(gbytes.Say("Publishing over MQTT to the topic: smoke-test/"))
+			Expect(out).To(gbytes.Say("Sending over STOMP to the destination: /queue/test-q-"))
+			Expect(outcomes()).To(HaveKeyWithValue(lifecycle.StepMQTTToAMQP, report.Passed))
+			Expect(outcomes()).To(HaveKeyWithValue(lifecycle.StepSTOMPToAMQP, report.Passed))
+		})
+
+		It("uses a service key that the cleanup deletes", func() {
+			Expect(lc.CheckCrossProtocol()).To(Succeed())
+			Expect(lc.Cleanup()).To(Succeed())
+
+			keys := 0
+			for _, call := range fake.Calls() {
+				if call[0] == "create-service-key" {
+					keys++
+					Expect(fake.Calls()).To(ContainElement([]string{"delete-service-key", "-f", lc.ServiceInstanceName, call[2]}))
+				}
+			}
+			Expect(keys).To(Equal(1))
+		})
+
+		Context("when the MQTT message goes missing", func() {
+			It("fails that pair only", func() {
+				fake.DropMessages(1)
+				Expect(lc.CheckCrossProtocol()).To(MatchError(MatchRegexp("test-q-.* is empty")))
+				Expect(outcomes()).To(HaveKeyWithValue(lifecycle.StepMQTTToAMQP, report.Failed))
+				Expect(outcomes()).To(HaveKeyWithValue(lifecycle.StepSTOMPToAMQP, report.Passed))
+			})
+		})
+
+		Context("when the credentials offer no STOMP endpoint", func() {
+			BeforeEach(func() {
+				fake.DisableProtocol("stomp")
+			})
+
+			It("skips that pair", func() {
+				Expect(lc.CheckCrossProtocol()).To(MatchError("the credentials offer no STOMP endpoint"))
+				Expect(outcomes()).To(HaveKeyWithValue(lifecycle.StepMQTTToAMQP, report.Passed))
+				Expect(outcomes()).To(HaveKeyWithValue(lifecycle.StepSTOMPToAMQP, report.Skipped))
+			})
+		})
+	})
+
+	Describe("checking the plan's capabilities", func() {
+		var limits fakeplatform.Limits
+
+		BeforeEach(func() {
+			limits = fakeplatform.Limits{
+				MaxMessageSize: 1024,
+				MaxConnections: 3,
+				QueueTypes:     []string{"classic", "quorum"},
+			}
+		})
+
+		JustBeforeEach(func() {
+			fake.LimitPlan("standard", limits)
+			lc.WithConnector(fake.Connector())
+			Expect(runAll()).To(Succeed())
+		})
+
+		It("passes when the broker enforces the plan's limits", func() {
+			Expect(lc.CheckMaxMessageSize(1024)).To(Succeed())
+			Expect(out).To(gbytes.Say(`The broker refused it: Exception \(406\) Reason: "PRECONDITION_FAILED - message size 1025 is larger than configured max size 1024"`))
+
+			Expect(lc.CheckMaxConnections(3)).To(Succeed())
+			Expect(out).To(gbytes.Say("The vhost already has 1 connections open, leaving 2 of the plan's 3"))
+			Expect(out).To(gbytes.Say("The broker accepted 2 connections, with 1 already open, and refused the next"))
+			Expect(lc.CheckMaxConnections(3)).To(Succeed(), "the connections were not closed")
+
+			Expect(lc.CheckQueueTypes([]string{"classic", "quorum"})).To(Succeed())
+		})
+
+		It("reports limits the broker does not enforce", func() {
+			Expect(lc.CheckMaxMessageSize(512)).To(MatchError("a message of 513 bytes, more than the plan's max of 512, was accepted (read back: true)"))
+			Expect(lc.CheckMaxMessageSize(2048)).To(MatchError(ContainSubstring("a message of the plan's max size, 2048 bytes, was refused")))
+			Expect(lc.CheckMaxConnections(2)).To(MatchError("the broker accepted 2 connections, with 1 already open, more than the plan's limit of 2"))
+			Expect(lc.CheckMaxConnections(5)).To(MatchError(HavePrefix("the broker accepted only 2 connections, with 1 already open, below the plan's limit of 5: Exception (530)")))
+		})
+
+		It("reports the queue types the broker refuses", func() {
+			err := lc.CheckQueueTypes([]string{"classic", "stream"})
+			Expect(err).To(MatchError(ContainSubstring("the broker refused 1 of the 2 queue types the standard plan offers:\nstream: Exception (406) Reason: \"PRECONDITION_FAILED")))
+		})
+
+		It("reports a binding that offers endpoints without TLS", func() {
+			Expect(lc.CheckTLSRequired()).To(MatchError("the standard plan requires TLS, but the binding offers endpoints without it: uri, amqp, mqtt, stomp"))
+		})
+
+		Context("when the plan requires TLS", func() {
+			BeforeEach(func() {
+				limits.TLSRequired = true
+			})
+
+			It("connects with TLS", func() {
+				Expect(lc.CheckTLSRequired()).To(Succeed())
+				Expect(out).To(gbytes.Say("The binding only offers TLS endpoints: amqp\\+ssl, management, mqtt\\+ssl, stomp\\+ssl"))
+				Expect(out).To(gbytes.Say("Connecting over AMQP with TLS: rabbitmq.fake:5671"))
+			})
+		})
+	})
+
+	Describe("checking quorum queues and streams", func() {
+		JustBeforeEach(func() {
+			lc.WithConnector(fake.Connector())
+			Expect(runAll()).To(Succeed())
+		})
+
+		It("sends messages through a quorum queue", func() {
+			Expect(lc.CheckQuorumQueue()).To(Succeed())
+			Expect(out).To(gbytes.Say("Declaring a quorum queue: test-q-quorum-"))
+			Expect(out).To(gbytes.Say("Checking the queue's type in the management API"))
+			Expect(out).To(gbytes.Say("Read all 5 messages back from the quorum queue"))
+			Expect(out).NotTo(gbytes.Say("Failed to delete"))
+		})
+
+		It("reads a stream from the first offset, twice", func() {
+			Expect(lc.CheckStreamQueue()).To(Succeed())
+			Expect(out).To(gbytes.Say("Reading the stream from the first offset\n"))
+			Expect(out).To(gbytes.Say("Reading the stream from the first offset again"))
+			Expect(out).To(gbytes.Say("Read all 5 messages back from the stream queue"))
+		})
+
+		Context("when messages go missing", func() {
+			It("reports which ones", func() {
+				fake.DropMessages(1)
+				Expect(lc.CheckQuorumQueue()).To(MatchError(ContainSubstring("the batch did not come back intact")))
+			})
+		})
+
+		Context("when the plan does not offer streams", func() {
+			BeforeEach(func() {
+				fake.LimitPlan("standard", fakeplatform.Limits{QueueTypes: []string{"classic", "quorum"}})
+			})
+
+			It("reports the broker refusing the stream", func() {
+				Expect(lc.CheckQuorumQueue()).To(Succeed())
+				Expect(lc.CheckStreamQueue()).To(MatchError(ContainSubstring(`{unsupported_queue_type,<<\"stream\">>}`)))
+			})
+		})
+	})
+
+	Describe("checking delivery guarantees", func() {
+		var recorder *report.Recorder
+
+		JustBeforeEach(func() {
+			recorder = report.NewRecorder()
+			lc.WithRecorder(recorder).WithConnector(fake.Connector())
+			Expect(runAll()).To(Succeed())
+		})
+
+		outcomes := func() map[string]string {
+			result := map[string]string{}
+			for _, step := range recorder.Steps() {
+				result[step.Name] = step.Outcome
+			}
+			return result
+		}
+
+		It("confirms publishes, redelivers what was nacked and drops what was rejected", func() {
+			Expect(lc.CheckDeliveryGuarantees()).To(Succeed())
+			Expect(out).To(gbytes.Say("Publishing 10 messages in confirm mode to the queue: test-q-"))
+			Expect(out).To(gbytes.Say("The broker acked all 10 publishes"))
+			Expect(out).To(gbytes.Say("Nacking the message with requeue"))
+			Expect(out).To(gbytes.Say("The message came back flagged as redelivered"))
+			Expect(out).To(gbytes.Say("Rejecting the message without requeue"))
+			Expect(out).To(gbytes.Say("The queue dropped the rejected message"))
+			Expect(outcomes()).To(HaveKeyWithValue(lifecycle.StepPublisherConfirms, report.Passed))
+			Expect(outcomes()).To(HaveKeyWithValue(lifecycle.StepNackRequeue, report.Passed))
+			Expect(outcomes()).To(HaveKeyWithValue(lifecycle.StepRejectDrop, report.Passed))
+		})
+
+		Context("when the broker nacks a publish", func() {
+			It("fails the confirms step only, naming the publish", func() {
+				fake.NackPublishes(1)
+				Expect(lc.CheckDeliveryGuarantees()).To(MatchError("the broker nacked 1 of 10 publishes: 1"))
+				Expect(outcomes()).To(HaveKeyWithValue(lifecycle.StepPublisherConfirms, report.Failed))
+				Expect(outcomes()).To(HaveKeyWithValue(lifecycle.StepNackRequeue, report.Passed))
+				Expect(outcomes()).To(HaveKeyWithValue(lifecycle.StepRejectDrop, report.Passed))
+			})
+		})
+
+		Context("when the broker loses an acked publish", func() {
+			It("reports the queue coming up short", func() {
+				fake.DropMessages(1)
+				Expect(lc.CheckDeliveryGuarantees()).To(MatchError("the broker acked 10 publishes, but the queue holds 9 messages"))
+			})
+		})
+	})
+
+	Describe("checking dead-lettering", func() {
+		var recorder *report.Recorder
+
+		JustBeforeEach(func() {
+			recorder = report.NewRecorder()
+			lc.WithRecorder(recorder).WithConnector(fake.Connector())
+			Expect(runAll()).To(Succeed())
+		})
+
+		outcomes := func() map[string]string {
+			result := map[string]string{}
+			for _, step := range recorder.Steps() {
+				result[step.Name] = step.Outcome
+			}
+			return result
+		}
+
+		It("dead-letters what overflows the max length or expires", func() {
+			Expect(lc.CheckDeadLettering()).To(Succeed())
+			Expect(out).To(gbytes.Say("Declaring the queue test-q-.* with x-max-length 2, dead-lettering to: test-q-dlq-"))
+			Expect(out).To(gbytes.Say("All 3 messages were dead-lettered to test-q-dlq-"))
+			Expect(out).To(gbytes.Say("The queue kept the newest 2 messages"))
+			Expect(out).To(gbytes.Say("Declaring the queue test-q-.* with x-message-ttl 1, dead-lettering to: test-q-dlq-"))
+			Expect(out).To(gbytes.Say("Waiting 1ms for the messages to expire"))
+			Expect(out).To(gbytes.Say("All 5 messages were dead-lettered to test-q-dlq-"))
+			Expect(outcomes()).To(HaveKeyWithValue(lifecycle.StepDeadLetterMaxLength, report.Passed))
+			Expect(outcomes()).To(HaveKeyWithValue(lifecycle.StepDeadLetterTTL, report.Passed))
+		})
+
+		Context("when the operator policy caps the queues below the check's max length", func() {
+			BeforeEach(func() {
+				fake.LimitPlan("standard", fakeplatform.Limits{OperatorPolicy: map[string]interface{}{"max-length": 1}})
+			})
+
+			It("reports the messages that went missing", func() {
+				Expect(lc.CheckDeadLettering()).To(MatchError(ContainSubstring("the dead-lettered messages did not come back intact: sent 3 messages, received 1; 3 missing: 1-3")))
+				Expect(outcomes()).To(HaveKeyWithValue(lifecycle.StepDeadLetterMaxLength, report.Failed))
+			})
+		})
+
+		It("checks the operator policy against the plan's", func() {
+			fake.LimitPlan("standard", fakeplatform.Limits{OperatorPolicy: map[string]interface{}{"max-length": 10000, "overflow": "reject-publish"}})
+			Expect(lc.CheckOperatorPolicy(map[string]interface{}{"max-length": 10000.0})).To(Succeed())
+			Expect(out).To(gbytes.Say("The operator policy standard-limits applies to the queue"))
+
+			Expect(lc.CheckOperatorPolicy(map[string]interface{}{"max-length": 5000, "message-ttl": 60000, "overflow": "reject-publish"})).To(MatchError(
+				"the queue's effective policy does not match the plan's: max-length is 10000, expected 5000; message-ttl is not set, expected 60000"))
+		})
+
+		Context("when no operator policy applies", func() {
+			It("fails", func() {
+				Expect(lc.CheckOperatorPolicy(map[string]interface{}{"max-length": 10000})).To(MatchError(MatchRegexp("no operator policy applies to the queue test-q-")))
+				Expect(outcomes()).To(HaveKeyWithValue(lifecycle.StepOperatorPolicy, report.Failed))
+			})
+		})
+	})
+
+	Describe("checking the TLS posture", func() {
+		var probe tlsprobe.Config
+
+		BeforeEach(func() {
+			roots, err := fake.CACertificates()
+			Ω(err).ShouldNot(HaveOccurred())
+			probe = tlsprobe.Config{MinVersion: tls.VersionTLS12, RootCAs: roots, Dial: fake.Dial}
+		})
+
+		JustBeforeEach(func() {
+			Expect(runAll()).To(Succeed())
+		})
+
+		It("probes the management API of a plan without TLS", func() {
+			Expect(lc.CheckTLSPosture(probe)).To(Succeed())
+			Expect(out).To(gbytes.Say("management rabbitmq.fake:443: TLS 1.3, TLS_"))
+			Expect(out).NotTo(gbytes.Say("amqp"))
+		})
+
+		Context("when the plan requires TLS", func() {
+			BeforeEach(func() {
+				fake.LimitPlan("standard", fakeplatform.Limits{TLSRequired: true})
+			})
+
+			It("describes every TLS endpoint of the binding", func() {
+				Expect(lc.CheckTLSPosture(probe)).To(Succeed())
+				Expect(out).To(gbytes.Say("amqp\\+ssl rabbitmq.fake:5671: TLS 1.3, TLS_"))
+				Expect(out).To(gbytes.Say("  certificate: CN=rabbitmq.fake issued by CN=Fake RabbitMQ CA expires \\d{4}-"))
+				Expect(out).To(gbytes.Say("  certificate: CN=Fake RabbitMQ CA issued by CN=Fake RabbitMQ CA"))
+				Expect(out).To(gbytes.Say("  covers: rabbitmq.fake\n"))
+				Expect(out).To(gbytes.Say("  chain trusted\n"))
+				Expect(out).To(gbytes.Say("management rabbitmq.fake:443: "))
+				Expect(out).To(gbytes.Say("mqtt\\+ssl rabbitmq.fake:8883: "))
+				Expect(out).To(gbytes.Say("stomp\\+ssl rabbitmq.fake:61614: "))
+			})
+
+			It("fails on an expired certificate", func() {
+				fake.ServeTLS(fakeplatform.TLS{Expired: true})
+				Expect(lc.CheckTLSPosture(probe)).To(MatchError(MatchRegexp(
+					`^the broker's TLS endpoints fall short: amqp\+ssl \(rabbitmq.fake:5671\): the certificate of CN=rabbitmq.fake expired on \d{4}-.*; management \(rabbitmq.fake:443\): the certificate`)))
+			})
+
+			It("fails on an old version or a certificate for another host", func() {
+				fake.ServeTLS(fakeplatform.TLS{MaxVersion: tls.VersionTLS11, Hosts: []string{"other.fake"}})
+				err := lc.CheckTLSPosture(probe)
+				Expect(err).To(MatchError(ContainSubstring("mqtt+ssl (rabbitmq.fake:8883): TLS 1.1 is below the minimum of TLS 1.2; mqtt+ssl (rabbitmq.fake:8883): the certificate does not cover rabbitmq.fake, only: other.fake")))
+				Expect(out).To(gbytes.Say("  covers: other.fake"))
+			})
+
+			It("reports an untrusted chain without failing when verification is skipped", func() {
+				probe.RootCAs, probe.SkipVerify = x509.NewCertPool(), true
+				Expect(lc.CheckTLSPosture(probe)).To(Succeed())
+				Expect(out).To(gbytes.Say("  chain not trusted: x509: "))
+			})
+		})
+	})
+
+	Describe("checking that plaintext is refused", func() {
+		var (
+			recorder *report.Recorder
+			hostless bool
+		)
+
+		BeforeEach(func() {
+			fake.LimitPlan("standard", fakeplatform.Limits{TLSRequired: true})
+			hostless = false
+		})
+
+		JustBeforeEach(func() {
+			if hostless {
+				lc = lifecycle.New(config, hostlessPlatform{fake}, &http.Client{Transport: route}, fake.HTTPClient(), out)
+			}
+			recorder = report.NewRecorder()
+			lc.WithRecorder(recorder).WithConnector(fake.Connector())
+			Expect(runAll()).To(Succeed())
+		})
+
+		findings := func() []string {
+			steps := recorder.Steps()
+			return steps[len(steps)-1].Findings
+		}
+
+		It("passes when every plaintext connection is refused", func() {
+			Expect(lc.CheckPlaintextRefused()).To(Succeed())
+			Expect(out).To(gbytes.Say("Connecting over AMQP without TLS: rabbitmq.fake:5672\nThe broker refused it: dial tcp rabbitmq.fake:5672: connect: connection refused"))
+			Expect(out).To(gbytes.Say("Connecting over MQTT without TLS: rabbitmq.fake:1883\nThe broker refused it: "))
+			Expect(out).To(gbytes.Say("Connecting over STOMP without TLS: rabbitmq.fake:61613\nThe broker refused it: "))
+			Expect(findings()).To(BeEmpty())
+		})
+
+		Context("when the broker is a cluster", func() {
+			BeforeEach(func() {
+				fake.ClusterHosts("rabbitmq.fake", "rabbitmq-1.fake")
+			})
+
+			It("tries each of its hosts", func() {
+				Expect(lc.CheckPlaintextRefused()).To(Succeed())
+				Expect(out).To(gbytes.Say("Connecting over AMQP without TLS: rabbitmq.fake:5672\nThe broker refused it: "))
+				Expect(out).To(gbytes.Say("Connecting over AMQP without TLS: rabbitmq-1.fake:5672\nThe broker refused it: "))
+				Expect(out).To(gbytes.Say("Connecting over MQTT without TLS: rabbitmq.fake:1883\nThe broker refused it: "))
+				Expect(out).To(gbytes.Say("Connecting over MQTT without TLS: rabbitmq-1.fake:1883\nThe broker refused it: "))
+				Expect(out).To(gbytes.Say("Connecting over STOMP without TLS: rabbitmq-1.fake:61613\nThe broker refused it: "))
+			})
+		})
+
+		It("fails as inconclusive when a connection fails for another reason", func() {
+			lc.WithConnector(unresolvableMQTT{fake.Connector()})
+			Expect(lc.CheckPlaintextRefused()).To(MatchError("inconclusive: could not tell whether the broker refuses plaintext connections: mqtt (rabbitmq.fake:1883): dial tcp: lookup rabbitmq.fake: no such host"))
+			Expect(out).To(gbytes.Say("Connecting over MQTT without TLS: rabbitmq.fake:1883\nCould not tell whether the broker refuses it: dial tcp: lookup rabbitmq.fake: no such host"))
+			Expect(out).To(gbytes.Say("Connecting over STOMP without TLS: rabbitmq.fake:61613\nThe broker refused it: "))
+			Expect(findings()).To(BeEmpty())
+		})
+
+		Context("when the binding names no host", func() {
+			BeforeEach(func() {
+				hostless = true
+			})
+
+			It("fails rather than trying a default one", func() {
+				Expect(lc.CheckPlaintextRefused()).To(MatchError("the binding names no host to try plaintext AMQP on"))
+				Expect(out).NotTo(gbytes.Say("Connecting over AMQP"))
+			})
+		})
+
+		Context("when the broker accepts plaintext", func() {
+			BeforeEach(func() {
+				fake.LimitPlan("standard", fakeplatform.Limits{TLSRequired: true, PlaintextAccepted: true})
+				fake.DisableProtocol("stomp")
+			})
+
+			It("reports each connection it accepted as a security finding", func() {
+				Expect(lc.CheckPlaintextRefused()).To(MatchError("security finding: the standard plan requires TLS, but the broker accepted plaintext connections: amqp (rabbitmq.fake:5672), mqtt (rabbitmq.fake:1883)"))
+				Expect(out).To(gbytes.Say("SECURITY FINDING: the broker accepted a plaintext AMQP connection on rabbitmq.fake:5672"))
+				Expect(findings()).To(Equal([]string{
+					"the broker accepted a plaintext AMQP connection on rabbitmq.fake:5672",
+					"the broker accepted a plaintext MQTT connection on rabbitmq.fake:1883",
+				}))
+			})
+		})
+	})
+
+	Context("when a cleanup operation fails", func() {
+		BeforeEach(func() {
+			fake.FailNext("unbind-service", errors.New("unbind failed"))
+		})
+
+		It("keeps the instance that is still bound but deletes the app", func() {
+			Expect(runAll()).To(Succeed())
+			Expect(lc.Cleanup()).To(MatchError("unbind failed"))
+
+			Expect(fake.AppNames()).To(BeEmpty())
+			Expect(fake.ServiceInstanceNames()).To(Equal([]string{lc.ServiceInstanceName}))
+		})
+	})
+
+	Context("with a recorder", func() {
+		var recorder *report.Recorder
+
+		JustBeforeEach(func() {
+			recorder = report.NewRecorder()
+			lc.WithRecorder(recorder)
+		})
+
+		outcomes := func() map[string]string {
+			result := map[string]string{}
+			for _, step := range recorder.Steps() {
+				result[step.Name] = step.Outcome
+			}
+			return result
+		}
+
+		It("records every step of the plan and protocol in order", func() {
+			Expect(runAll()).To(Succeed())
+			Expect(lc.Cleanup()).To(Succeed())
+
+			steps := recorder.Steps()
+			names := []string{}
+			for _, step := range steps {
+				Expect(step.Plan).To(Equal("standard"))
+				Expect(step.Protocol).To(Equal("amqp"))
+				Expect(step.Outcome).To(Equal(report.Passed))
+				Expect(step.Attempts).To(BeNumerically(">=", 1))
+				Expect(step.End).NotTo(BeTemporally("<", step.Start))
+				names = append(names, step.Name)
+			}
+			Expect(names).To(Equal([]string{
+				lifecycle.StepPush,
+				lifecycle.StepCreate,
+				lifecycle.StepBind,
+				lifecycle.StepStart,
+				lifecycle.StepPublish,
+				lifecycle.StepConsume,
+				lifecycle.StepCleanup,
+			}))
+
+			Expect(steps[3].Output).To(ContainSubstring("Checking that the app is responding"))
+			Expect(steps[5].Output).To(ContainSubstring("Reading from the (empty) queue"))
+			Expect(steps[5].Output).NotTo(ContainSubstring("Publishing"))
+		})
+
+		Context("when the message goes missing", func() {
+			BeforeEach(func() {
+				fake.DropMessages(1)
+			})
+
+			It("records the failure with every attempt it took", func() {
+				Expect(runAll()).NotTo(Succeed())
+
+				consume := recorder.Steps()[5]
+				Expect(consume.Name).To(Equal(lifecycle.StepConsume))
+				Expect(consume.Outcome).To(Equal(report.Failed))
+				Expect(consume.Error).To(ContainSubstring("still failing after 50ms"))
+				Expect(consume.Attempts).To(BeNumerically(">", 1))
+			})
+		})
+
+		Context("when creating the service instance fails", func() {
+			BeforeEach(func() {
+				fake.FailNext("create-service", errors.New("Server error, status code: 502"))
+			})
+
+			It("records the later steps as skipped", func() {
+				Expect(lc.PushApp()).To(Succeed())
+				Expect(lc.CreateService()).NotTo(Succeed())
+				Expect(lc.BindAndStart()).NotTo(Succeed())
+				Expect(lc.WriteAndRead()).NotTo(Succeed())
+
+				Expect(outcomes()).To(Equal(map[string]string{
+					lifecycle.StepPush:    report.Passed,
+					lifecycle.StepCreate:  report.Failed,
+					lifecycle.StepBind:    report.Skipped,
+					lifecycle.StepStart:   report.Skipped,
+					lifecycle.StepPublish: report.Skipped,
+					lifecycle.StepConsume: report.Skipped,
+				}))
+			})
+		})
+	})
+
+	Context("with diagnostics", func() {
+		var (
+			dir        string
+			recorder   *report.Recorder
+			originalCf = cf.Cf
+		)
+
+		BeforeEach(func() {
+			cf.Cf = fake.Cf
+
+			var err error
+			dir, err = ioutil.TempDir("", "lifecycle")
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		JustBeforeEach(func() {
+			recorder = report.NewRecorder()
+			lc.WithRecorder(recorder).WithDiagnostics(diagnostics.NewCollector(dir, time.Second, fake.HTTPClient()))
+		})
+
+		AfterEach(func() {
+			cf.Cf = originalCf
+			os.RemoveAll(dir)
+		})
+
+		It("collects nothing when every step passes", func() {
+			Expect(runAll()).To(Succeed())
+
+			entries, err := ioutil.ReadDir(dir)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(entries).To(BeEmpty())
+		})
+
+		Context("when the message goes missing", func() {
+			BeforeEach(func() {
+				fake.DropMessages(1)
+			})
+
+			It("points to the diagnostics in the failure", func() {
+				err := runAll()
+				Expect(err).To(MatchError(ContainSubstring(`expected to read "test-message-amqp-`)))
+				Expect(err).To(MatchError(ContainSubstring("Diagnostics:\n  app logs:")))
+				Expect(err).To(MatchError(ContainSubstring("-standard-amqp-consume/app-logs.txt")))
+
+				consume := recorder.Steps()[5]
+				Expect(consume.Artifacts).To(HaveLen(5))
+				Expect(consume.Artifacts[0]).To(BeAnExistingFile())
+			})
+		})
+
+		Context("when an earlier step failed", func() {
+			BeforeEach(func() {
+				fake.FailNext("create-service", errors.New("Server error, status code: 502"))
+			})
+
+			It("collects diagnostics for the failure only", func() {
+				Expect(lc.PushApp()).To(Succeed())
+				Expect(lc.CreateService()).To(MatchError(ContainSubstring("Diagnostics:")))
+				Expect(lc.BindAndStart()).NotTo(MatchError(ContainSubstring("Diagnostics:")))
+
+				entries, err := ioutil.ReadDir(dir)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(entries).To(HaveLen(1))
+			})
+		})
+	})
+
+	Context("with a tracer", func() {
+		var (
+			tracer *tracing.Tracer
+			root   *tracing.Span
+		)
+
+		BeforeEach(func() {
+			tracer = tracing.NewTracer("smoke-tests")
+			root = tracer.StartSpan("run", tracing.KindInternal, nil)
+			tracer.SetActive(root)
+		})
+
+		JustBeforeEach(func() {
+			lc = lifecycle.New(config, fake, tracer.WrapHTTPClient(fake.HTTPClient()), tracer.WrapHTTPClient(fake.HTTPClient()), out).WithTracer(tracer)
+		})
+
+		spanNamed := func(name string) *tracing.Span {
+			for _, span := range tracer.Finished() {
+				if span.Name == name {
+					return span
+				}
+			}
+			Fail("no span named " + name)
+			return nil
+		}
+
+		It("traces the lifecycle under the active span, with a span per step", func() {
+			Expect(runAll()).To(Succeed())
+			Expect(lc.Cleanup()).To(Succeed())
+
+			plan := spanNamed("plan standard over amqp")
+			Expect(plan.ParentSpanID).To(Equal(root.SpanID))
+			Expect(plan.Attributes).To(HaveKeyWithValue("rabbitmq.plan", "standard"))
+			Expect(plan.Attributes).To(HaveKeyWithValue("rabbitmq.protocol", "amqp"))
+			Expect(plan.Error).To(BeEmpty())
+
+			for _, name := range []string{"push", "create", "bind", "start", "publish", "consume", "cleanup"} {
+				Expect(spanNamed(name).ParentSpanID).To(Equal(plan.SpanID))
+			}
+			Expect(spanNamed("start").Attributes).To(HaveKeyWithValue("smoke.attempts", 1))
+
+			ping := spanNamed("HTTP GET")
+			Expect(ping.ParentSpanID).To(Equal(spanNamed("start").SpanID))
+			Expect(tracer.Active()).To(Equal(root))
+		})
+
+		Context("when a step fails", func() {
+			BeforeEach(func() {
+				fake.DropMessages(1)
+			})
+
+			It("marks the step and the lifecycle failed", func() {
+				Expect(runAll()).NotTo(Succeed())
+				Expect(lc.Cleanup()).To(Succeed())
+
+				Expect(spanNamed("consume").Error).To(ContainSubstring("still failing"))
+				Expect(spanNamed("consume").Attributes).To(HaveKeyWithValue("smoke.outcome", "failed"))
+				Expect(spanNamed("plan standard over amqp").Error).To(ContainSubstring("still failing"))
+			})
+		})
+	})
+})
+
+// hostlessPlatform hands out binding credentials without any hosts in
+// them, leaving only the URIs' paths.
+type hostlessPlatform struct {
+	*fakeplatform.Platform
+}
+
+func (p hostlessPlatform) BindingCredentials(appName, instanceName string) (map[string]interface{}, error) {
+	raw, err := p.Platform.BindingCredentials(appName, instanceName)
+	if err != nil {
+		return nil, err
+	}
+	delete(raw, "hostname")
+	delete(raw, "uri")
+	for _, protocol := range raw["protocols"].(map[string]interface{}) {
+		delete(protocol.(map[string]interface{}), "host")
+		delete(protocol.(map[string]interface{}), "uri")
+	}
+	return raw, nil
+}
+
+// unresolvableMQTT connects over AMQP and STOMP as connector does, but
+// over MQTT fails to resolve the host.
+type unresolvableMQTT struct {
+	broker.Connector
+}
+
+func (c unresolvableMQTT) MQTT(endpoint credentials.Protocol, clientID string) (broker.MQTT, error) {
+	return nil, &net.OpError{Op: "dial", Net: "tcp", Err: &net.DNSError{Err: "no such host", Name: endpoint.Host, IsNotFound: true}}
+}
+
+// flakyRoute passes requests to the app on to transport, but fails some
+// the way a flaky route does: once failReadsAfter is called it lets only
+// so many more reads of a queue through, and it loses the reply to the
+// next lostPublishes publishes, which the app still carries out.
+type flakyRoute struct {
+	transport     http.RoundTripper
+	failReads     bool
+	readsAllowed  int
+	lostPublishes int
+}
+
+func (f *flakyRoute) failReadsAfter(reads int) {
+	f.failReads, f.readsAllowed = true, reads
+}
+
+func (f *flakyRoute) RoundTrip(request *http.Request) (*http.Response, error) {
+	if !strings.HasPrefix(request.URL.Path, "/queue/") {
+		return f.transport.RoundTrip(request)
+	}
+	if request.Method == "GET" && f.failReads {
+		if f.readsAllowed == 0 {
+			return nil, errors.New("read tcp: connection reset by peer")
+		}
+		f.readsAllowed--
+	}
+	response, err := f.transport.RoundTrip(request)
+	if request.Method == "PUT" && f.lostPublishes > 0 && err == nil {
+		f.lostPublishes--
+		return nil, errors.New("net/http: timeout awaiting response headers")
+	}
+	return response, err
+}
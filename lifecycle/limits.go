@@ -0,0 +1,230 @@
+package lifecycle
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/streadway/amqp"
+
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/broker"
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/credentials"
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/management"
+)
+
+// CheckMaxMessageSize publishes a message of the plan's max size over
+// AMQP, which must come back, then one a byte larger, which the broker
+// must refuse by closing the channel. The broker only says so with the
+// channel's next synchronous method, so the refusal is looked for in the
+// publish and in the get that follows it.
+func (l *Lifecycle) CheckMaxMessageSize(maxSize int) error {
+	return l.step(StepMaxMessageSize, func() error {
+		session, _, err := l.connectAMQP()
+		if err != nil {
+			return err
+		}
+		defer session.Close()
+
+		// an exclusive queue goes with the connection, even once the
+		// channel is closed
+		queueName := "test-q-" + randomName()
+		if _, err := session.DeclareQueue(queueName, broker.QueueOptions{Exclusive: true}); err != nil {
+			return err
+		}
+
+		l.println("Publishing a message of", maxSize, "bytes, the plan's max, to the queue:", queueName)
+		if err := session.Publish("", queueName, amqp.Publishing{Body: bytes.Repeat([]byte("x"), maxSize)}); err != nil {
+			return fmt.Errorf("a message of the plan's max size, %d bytes, was refused: %s", maxSize, err.Error())
+		}
+		err = l.eventually(func() error {
+			delivery, ok, err := session.Get(queueName, true)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return fmt.Errorf("%s is empty", queueName)
+			}
+			if len(delivery.Body) != maxSize {
+				return fmt.Errorf("expected %d bytes back, got %d", maxSize, len(delivery.Body))
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("a message of the plan's max size, %d bytes, did not come back: %s", maxSize, err.Error())
+		}
+
+		l.println("Publishing a message of", maxSize+1, "bytes, one more than the plan's max")
+		err = session.Publish("", queueName, amqp.Publishing{Body: bytes.Repeat([]byte("x"), maxSize+1)})
+		if err == nil {
+			var ok bool
+			_, ok, err = session.Get(queueName, true)
+			if err == nil {
+				return fmt.Errorf("a message of %d bytes, more than the plan's max of %d, was accepted (read back: %t)", maxSize+1, maxSize, ok)
+			}
+		}
+		if brokerErr, ok := err.(*amqp.Error); !ok || brokerErr.Code != amqp.PreconditionFailed {
+			return fmt.Errorf("a message of %d bytes, more than the plan's max, failed without the broker refusing it: %s", maxSize+1, err.Error())
+		}
+		l.println("The broker refused it:", err)
+		return nil
+	})
+}
+
+// CheckMaxConnections counts the connections already open to the vhost,
+// the app's among them, and then opens AMQP connections as the service
+// key's user until the broker refuses one for the vhost's connection
+// limit. It must accept exactly as many as the plan's limit leaves, and
+// refuse the next. Every connection is closed after.
+func (l *Lifecycle) CheckMaxConnections(limit int) error {
+	return l.step(StepMaxConnections, func() error {
+		if err := l.requireBinding(); err != nil {
+			return err
+		}
+		endpoint, err := l.brokerEndpoint(credentials.AMQP)
+		if err != nil {
+			return err
+		}
+
+		existing, err := l.openConnections()
+		if err != nil {
+			return fmt.Errorf("could not count the connections already open: %s", err.Error())
+		}
+		allowed := limit - existing
+		if allowed < 0 {
+			allowed = 0
+		}
+		l.println("The vhost already has", existing, "connections open, leaving", allowed, "of the plan's", limit)
+
+		sessions := []broker.AMQP{}
+		defer func() {
+			for _, session := range sessions {
+				session.Close()
+			}
+		}()
+
+		l.println("Opening up to", allowed+1, "connections over AMQP:", broker.Address(endpoint))
+		for len(sessions) <= allowed {
+			session, err := l.connector.AMQP(endpoint)
+			if err != nil {
+				if !connectionLimitReached(err) {
+					return fmt.Errorf("connection %d failed without reaching the limit: %s", len(sessions)+1, err.Error())
+				}
+				if len(sessions) < allowed {
+					return fmt.Errorf("the broker accepted only %d connections, with %d already open, below the plan's limit of %d: %s", len(sessions), existing, limit, err.Error())
+				}
+				l.println("The broker accepted", len(sessions), "connections, with", existing, "already open, and refused the next:", err)
+				return nil
+			}
+			sessions = append(sessions, session)
+		}
+		return fmt.Errorf("the broker accepted %d connections, with %d already open, more than the plan's limit of %d", len(sessions), existing, limit)
+	})
+}
+
+// connectionLimitReached tells whether the broker refused a connection
+// for the vhost or the user having as many as they may.
+func connectionLimitReached(err error) bool {
+	var brokerErr *amqp.Error
+	return errors.As(err, &brokerErr) && brokerErr.Code == amqp.NotAllowed
+}
+
+// CheckQueueTypes declares a durable queue of each type the plan offers,
+// e.g. "classic", "quorum" or "stream", over AMQP, and deletes it again.
+// A refused declaration closes the channel, so each type gets a
+// connection of its own.
+func (l *Lifecycle) CheckQueueTypes(queueTypes []string) error {
+	return l.step(StepQueueTypes, func() error {
+		endpoint, err := l.brokerEndpoint(credentials.AMQP)
+		if err != nil {
+			return err
+		}
+
+		failures := []string{}
+		for _, queueType := range queueTypes {
+			if err := l.declareQueueOfType(endpoint, queueType); err != nil {
+				failures = append(failures, fmt.Sprintf("%s: %s", queueType, err.Error()))
+				continue
+			}
+			l.println("Declared and deleted a", queueType, "queue")
+		}
+
+		if len(failures) > 0 {
+			return fmt.Errorf("the broker refused %d of the %d queue types the %s plan offers:\n%s",
+				len(failures), len(queueTypes), l.config.PlanName, strings.Join(failures, "\n"))
+		}
+		return nil
+	})
+}
+
+func (l *Lifecycle) declareQueueOfType(endpoint credentials.Protocol, queueType string) error {
+	session, err := l.connector.AMQP(endpoint)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	queueName := "test-q-" + queueType + "-" + randomName()
+	l.println("Declaring a", queueType, "queue:", queueName)
+	_, err = session.DeclareQueue(queueName, broker.QueueOptions{
+		Durable:   true,
+		Arguments: amqp.Table{"x-queue-type": queueType},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = session.DeleteQueue(queueName)
+	return err
+}
+
+// CheckOperatorPolicy checks that the management API reports an operator
+// policy on a fresh queue, whose effective definition has the values
+// expected of the plan, e.g. {"max-length": 10000}.
+func (l *Lifecycle) CheckOperatorPolicy(expected map[string]interface{}) error {
+	return l.step(StepOperatorPolicy, func() error {
+		return l.withExclusiveQueue(func(session broker.AMQP, queueName string) error {
+			client, vhost, err := l.managementClient()
+			if err != nil {
+				return err
+			}
+
+			l.println("Checking the queue's operator policy in the management API")
+			var queue management.Queue
+			err = l.eventually(func() error {
+				queue, err = client.Queue(vhost, queueName)
+				return err
+			})
+			if err != nil {
+				return err
+			}
+			if queue.OperatorPolicy == "" {
+				return fmt.Errorf("no operator policy applies to the queue %s", queueName)
+			}
+			l.println("The operator policy", queue.OperatorPolicy, "applies to the queue")
+
+			names := []string{}
+			for name := range expected {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			mismatches := []string{}
+			for _, name := range names {
+				want, _ := json.Marshal(expected[name])
+				value, ok := queue.EffectivePolicyDefinition[name]
+				if !ok {
+					mismatches = append(mismatches, fmt.Sprintf("%s is not set, expected %s", name, want))
+					continue
+				}
+				if got, _ := json.Marshal(value); string(got) != string(want) {
+					mismatches = append(mismatches, fmt.Sprintf("%s is %s, expected %s", name, got, want))
+				}
+			}
+			if len(mismatches) > 0 {
+				return fmt.Errorf("the queue's effective policy does not match the plan's: %s", strings.Join(mismatches, "; "))
+			}
+			return nil
+		})
+	})
+}
@@ -0,0 +1,188 @@
+package lifecycle
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/management"
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/report"
+)
+
+// ManagementExpectations are what a plan promises the users of its
+// bindings in the management API. Empty fields are not checked.
+type ManagementExpectations struct {
+	// Tags the user must have, e.g. "management" or "policymaker".
+	Tags []string
+
+	// Configure, Write and Read are the permissions the user must have
+	// on the instance's vhost, e.g. ".*".
+	Configure string
+	Write     string
+	Read      string
+}
+
+// CheckManagementAPI signs in to the management API as the user of the
+// app's binding, and checks that the user is who the binding says, with
+// the tags the plan promises and access to the instance's vhost. The
+// user's permissions on the vhost are compared with the plan's when the
+// user may list permissions, which takes the administrator tag. The
+// broker's RabbitMQ and Erlang versions go into the report.
+func (l *Lifecycle) CheckManagementAPI(expected ManagementExpectations) error {
+	return l.step(StepManagement, func() error {
+		if err := l.requireBinding(); err != nil {
+			return err
+		}
+
+		c, err := l.bindingCredentials(l.AppName)
+		if err != nil {
+			return err
+		}
+		apiURL, username, password, err := c.ManagementAPI()
+		if err != nil {
+			return err
+		}
+		if l.tracer != nil {
+			l.tracer.AddSecret(password)
+		}
+		if dashboard, err := url.Parse(c.DashboardURL); err == nil && dashboard.Host != "" {
+			l.println("The binding offers a dashboard at", dashboard.Scheme+"://"+dashboard.Host)
+		}
+
+		client := management.NewClient(apiURL, username, password, l.managementHTTPClient)
+		l.println("Signing in to the management API as the binding's user:", apiURL)
+
+		var user management.User
+		err = l.eventually(func() error {
+			user, err = client.WhoAmI()
+			return err
+		})
+		if err != nil {
+			return err
+		}
+
+		mismatches := []string{}
+		if user.Name != username {
+			mismatches = append(mismatches, fmt.Sprintf("the API knows the user as %s, not %s", user.Name, username))
+		}
+		l.println("The user has the tags", user.Tags)
+		for _, tag := range expected.Tags {
+			if !user.Tags.Has(tag) {
+				mismatches = append(mismatches, fmt.Sprintf("the user lacks the tag %s, having %v", tag, user.Tags))
+			}
+		}
+
+		overview, err := client.Overview()
+		if err != nil {
+			return err
+		}
+		l.println("The broker runs RabbitMQ", overview.RabbitMQVersion, "on Erlang", overview.ErlangVersion)
+		if l.recorder != nil {
+			l.recorder.RecordBroker(l.config.PlanName, report.Broker{
+				RabbitMQVersion: overview.RabbitMQVersion,
+				ErlangVersion:   overview.ErlangVersion,
+				ClusterName:     overview.ClusterName,
+			})
+		}
+
+		if _, err := client.VHost(c.VHost); err != nil {
+			mismatches = append(mismatches, fmt.Sprintf("the user cannot see the vhost %s: %s", c.VHost, err.Error()))
+		}
+
+		permissions, err := client.Permissions()
+		if statusErr, ok := err.(*management.StatusError); ok && statusErr.Refused() && !user.Tags.Has("administrator") {
+			l.println("The user may not list permissions, so they are not compared with the plan's")
+		} else if err != nil {
+			return err
+		} else {
+			mismatches = append(mismatches, comparePermissions(permissions, username, c.VHost, expected)...)
+		}
+
+		if len(mismatches) > 0 {
+			return fmt.Errorf("the binding's user does not get what the %s plan promises:\n%s", l.config.PlanName, strings.Join(mismatches, "\n"))
+		}
+		l.println("The binding's user gets what the plan promises")
+		return nil
+	})
+}
+
+// comparePermissions finds the user's permissions on the vhost and
+// describes how they differ from the expected ones.
+func comparePermissions(permissions []management.Permission, username, vhost string, expected ManagementExpectations) []string {
+	for _, permission := range permissions {
+		if permission.User != username || permission.VHost != vhost {
+			continue
+		}
+
+		mismatches := []string{}
+		for _, p := range []struct{ name, actual, expected string }{
+			{"configure", permission.Configure, expected.Configure},
+			{"write", permission.Write, expected.Write},
+			{"read", permission.Read, expected.Read},
+		} {
+			if p.expected != "" && p.actual != p.expected {
+				mismatches = append(mismatches, fmt.Sprintf("the user's %s permission is %q, expected %q", p.name, p.actual, p.expected))
+			}
+		}
+		return mismatches
+	}
+	return []string{fmt.Sprintf("the user has no permissions on the vhost %s", vhost)}
+}
+
+// managementClient returns a client of the management API as the user of
+// the lifecycle's service key, with its vhost.
+func (l *Lifecycle) managementClient() (*management.Client, string, error) {
+	c, err := l.serviceKeyCredentials()
+	if err != nil {
+		return nil, "", err
+	}
+
+	apiURL, username, password, err := c.ManagementAPI()
+	if err != nil {
+		return nil, "", err
+	}
+	if l.tracer != nil {
+		l.tracer.AddSecret(password)
+	}
+	return management.NewClient(apiURL, username, password, l.managementHTTPClient), c.VHost, nil
+}
+
+// appManagementClient returns a client of the management API as the user
+// of the app's binding, who sees the app's own connections, which the
+// user of a service key might not.
+func (l *Lifecycle) appManagementClient() (*management.Client, error) {
+	c, err := l.bindingCredentials(l.AppName)
+	if err != nil {
+		return nil, err
+	}
+	apiURL, username, password, err := c.ManagementAPI()
+	if err != nil {
+		return nil, err
+	}
+	return management.NewClient(apiURL, username, password, l.managementHTTPClient), nil
+}
+
+// openConnections counts the connections to the vhost that the users of
+// the app's binding and of the service key see between them.
+func (l *Lifecycle) openConnections() (int, error) {
+	keyClient, vhost, err := l.managementClient()
+	if err != nil {
+		return 0, err
+	}
+	appClient, err := l.appManagementClient()
+	if err != nil {
+		return 0, err
+	}
+
+	names := map[string]bool{}
+	for _, client := range []*management.Client{appClient, keyClient} {
+		connections, err := client.Connections(vhost)
+		if err != nil {
+			return 0, err
+		}
+		for _, connection := range connections {
+			names[connection.Name] = true
+		}
+	}
+	return len(names), nil
+}
@@ -0,0 +1,383 @@
+package lifecycle
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/exampleapp"
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/management"
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/payload"
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/sequence"
+)
+
+// CheckPayloads sends each payload through the app and reads it back,
+// comparing checksums. It carries on past a mangled or lost payload, so the
+// step reports every payload the plan cannot carry over the protocol.
+func (l *Lifecycle) CheckPayloads(payloads []payload.Payload) error {
+	return l.step(StepPayloads, func() error {
+		app, err := l.queueApp()
+		if err != nil {
+			return err
+		}
+
+		queueName := l.QueueName
+
+		failures := []string{}
+		for _, p := range payloads {
+			if err := l.checkPayload(app, queueName, p); err != nil {
+				failures = append(failures, err.Error())
+				continue
+			}
+			l.println("Payload", p.Name, "came back intact:", len(p.Data), "bytes with sha256", p.Checksum())
+		}
+
+		if len(failures) > 0 {
+			return fmt.Errorf("%d of %d payloads did not come back intact over %s:\n%s",
+				len(failures), len(payloads), l.config.Protocol, strings.Join(failures, "\n"))
+		}
+		return nil
+	})
+}
+
+// CheckOrdering publishes a batch of count numbered messages, reads them
+// all back and checks that each came back once and in order. MQTT only
+// promises ordering from QoS 1 up, which the check expects the MQTT app to
+// publish and subscribe with.
+func (l *Lifecycle) CheckOrdering(count int) error {
+	return l.step(StepOrdering, func() error {
+		app, err := l.queueApp()
+		if err != nil {
+			return err
+		}
+
+		queueName := l.QueueName
+
+		batch := fmt.Sprintf("test-sequence-%s-%s", l.config.Protocol, randomName())
+
+		l.println("Publishing", count, "numbered messages to the queue: ", app.URL()+"/queue/"+queueName)
+		if err := l.publishBatch(app, batch, count); err != nil {
+			return err
+		}
+
+		l.println("Reading the batch back from the queue: ", app.URL()+"/queue/"+queueName)
+		result, err := l.consumeBatch(app, batch, count)
+		if err != nil {
+			return fmt.Errorf("could not read the batch back over %s, having read %d of %d messages: %s", l.config.Protocol, result.Received, count, err.Error())
+		}
+		if err := result.Err(); err != nil {
+			return fmt.Errorf("the batch did not come back intact over %s: %s", l.config.Protocol, err.Error())
+		}
+		l.println("Read all", count, "messages back once each and in order")
+		return nil
+	})
+}
+
+// CheckDurability publishes a batch of count messages, restarts the app,
+// which closes its connections, and checks that every message can still
+// be read back. It relies on the app publishing persistent messages to a
+// durable queue. checkDurableFlag also asks the management API whether the
+// queue is durable, over AMQP only: the other apps name the broker's queue
+// after the lifecycle's their own way.
+func (l *Lifecycle) CheckDurability(count int, checkDurableFlag bool) error {
+	return l.step(StepDurability, func() error {
+		app, err := l.queueApp()
+		if err != nil {
+			return err
+		}
+
+		queueName := l.QueueName
+
+		batch := fmt.Sprintf("test-durable-%s-%s", l.config.Protocol, randomName())
+
+		l.println("Publishing", count, "messages to the queue: ", app.URL()+"/queue/"+queueName)
+		if err := l.publishBatch(app, batch, count); err != nil {
+			return err
+		}
+
+		if checkDurableFlag && l.config.Protocol == AMQP {
+			client, vhost, err := l.managementClient()
+			if err != nil {
+				return err
+			}
+
+			l.println("Checking that the queue is durable:", queueName)
+			queue, err := client.Queue(vhost, queueName)
+			if err != nil {
+				return err
+			}
+			if !queue.Durable || queue.AutoDelete {
+				return fmt.Errorf("queue %s is not durable (durable: %t, auto_delete: %t)", queueName, queue.Durable, queue.AutoDelete)
+			}
+		}
+
+		l.println("Restarting the app:", l.AppName)
+		if err := l.platform.RestartApp(l.AppName); err != nil {
+			l.appIsRunning = false
+			return err
+		}
+		if err := l.eventually(app.Ping); err != nil {
+			l.appIsRunning = false
+			return err
+		}
+
+		l.println("Reading the messages back after the restart: ", app.URL()+"/queue/"+queueName)
+		result, err := l.consumeBatch(app, batch, count)
+		if err != nil {
+			return fmt.Errorf("could not read the messages back after the app restarted over %s, having read %d of %d: %s", l.config.Protocol, result.Received, count, err.Error())
+		}
+		if !result.Complete() {
+			return fmt.Errorf("messages did not survive the app restarting over %s: %s", l.config.Protocol, result.Err().Error())
+		}
+		l.println("Read all", count, "messages back after the restart")
+		return nil
+	})
+}
+
+// CheckScaling scales the app out to instances, publishes a batch of count
+// messages through the first instance, pinned to it with the
+// X-CF-APP-INSTANCE header, and consumes them through the app's route,
+// which spreads the requests over the instances. Every message must be
+// consumed exactly once, in whatever order. The bound queue's consumers
+// compete, so the management API must show the messages taken on more than
+// one of the app's connections. The app is scaled back to one instance
+// after.
+func (l *Lifecycle) CheckScaling(instances, count int) error {
+	return l.step(StepScaling, func() error {
+		app, err := l.queueApp()
+		if err != nil {
+			return err
+		}
+
+		// the app's own user sees its connections, which the user of a
+		// service key might not
+		c, err := l.bindingCredentials(l.AppName)
+		if err != nil {
+			return err
+		}
+		apiURL, username, password, err := c.ManagementAPI()
+		if err != nil {
+			return err
+		}
+		client := management.NewClient(apiURL, username, password, l.managementHTTPClient)
+
+		guid, err := l.platform.AppGUID(l.AppName)
+		if err != nil {
+			return err
+		}
+
+		l.println("Scaling the app to", instances, "instances:", l.AppName)
+		if err := l.platform.ScaleApp(l.AppName, instances); err != nil {
+			return err
+		}
+		defer func() {
+			l.println("Scaling the app back to 1 instance:", l.AppName)
+			if err := l.platform.ScaleApp(l.AppName, 1); err != nil {
+				l.println("Failed to scale the app back:", err)
+			}
+		}()
+
+		for i := 0; i < instances; i++ {
+			l.println("Checking that instance", i, "is responding")
+			if err := l.eventually(app.Instance(guid, i).Ping); err != nil {
+				return fmt.Errorf("instance %d of the app: %s", i, err.Error())
+			}
+		}
+
+		before, err := deliveriesByConnection(client, c.VHost, username)
+		if err != nil {
+			return fmt.Errorf("could not list the app's channels: %s", err.Error())
+		}
+
+		batch := fmt.Sprintf("test-scaling-%s-%s", l.config.Protocol, randomName())
+
+		l.println("Publishing", count, "messages through instance 0 to the queue: ", app.URL()+"/queue/"+l.QueueName)
+		if err := l.publishBatch(app.Instance(guid, 0), batch, count); err != nil {
+			return err
+		}
+
+		l.println("Reading the messages back through the app's route, spread over its instances")
+		result, err := l.consumeBatch(app, batch, count)
+		if err != nil {
+			return fmt.Errorf("could not read the batch back across %d instances, having read %d of %d messages: %s", instances, count-len(result.Missing), count, err.Error())
+		}
+
+		// competing consumers take messages in no particular order
+		result.OutOfOrder = nil
+		if err := result.Err(); err != nil {
+			return fmt.Errorf("messages were not consumed exactly once across %d instances: %s", instances, err.Error())
+		}
+
+		// the API's message rates lag behind
+		var taken map[string]int
+		err = l.eventually(func() error {
+			after, err := deliveriesByConnection(client, c.VHost, username)
+			if err != nil {
+				return err
+			}
+			taken = map[string]int{}
+			for connection, n := range after {
+				if n > before[connection] {
+					taken[connection] = n - before[connection]
+				}
+			}
+			if len(taken) < 2 {
+				return fmt.Errorf("only %d of the app's %d connections took any messages (%v), so its instances did not compete for the queue", len(taken), len(after), taken)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		connections := []string{}
+		for connection := range taken {
+			connections = append(connections, connection)
+		}
+		sort.Strings(connections)
+		for _, connection := range connections {
+			l.println("The connection", connection, "took", taken[connection], "messages")
+		}
+		l.println("Consumed all", count, "messages exactly once across", len(taken), "connections")
+		return nil
+	})
+}
+
+// deliveriesByConnection sums the messages the user's channels on vhost
+// have taken from queues, by connection.
+func deliveriesByConnection(client *management.Client, vhost, username string) (map[string]int, error) {
+	channels, err := client.Channels(vhost)
+	if err != nil {
+		return nil, err
+	}
+	deliveries := map[string]int{}
+	for _, channel := range channels {
+		if channel.User == username {
+			deliveries[channel.ConnectionDetails.Name] += channel.MessageStats.DeliverGet
+		}
+	}
+	return deliveries, nil
+}
+
+// publishBatch publishes count numbered messages of the batch to the
+// lifecycle's queue, in order.
+func (l *Lifecycle) publishBatch(app *exampleapp.Client, batch string, count int) error {
+	for n := 1; n <= count; n++ {
+		message := sequence.Message(batch, n)
+		if err := l.eventually(func() error { return app.Publish(l.QueueName, message) }); err != nil {
+			return fmt.Errorf("message %d of %d could not be published: %s", n, count, err.Error())
+		}
+	}
+	return nil
+}
+
+// consumeBatch reads the lifecycle's queue until every message of the
+// batch came back or the timeout elapses. The queue is drained on every
+// attempt, so that duplicates that arrive with the last of the batch are
+// counted too. It fails only when the queue could not be read, with what
+// was read until then.
+func (l *Lifecycle) consumeBatch(app *exampleapp.Client, batch string, count int) (sequence.Result, error) {
+	received := []string{}
+	var consumeErr error
+	err := l.eventually(func() error {
+		consumeErr = nil
+		for {
+			message, ok, err := app.ConsumeMessage(l.QueueName)
+			if err != nil {
+				consumeErr = err
+				return err
+			}
+			if !ok {
+				break
+			}
+			received = append(received, message)
+		}
+
+		result := sequence.Check(batch, count, received)
+		if !result.Complete() {
+			return fmt.Errorf("%d of %d messages are missing", len(result.Missing), count)
+		}
+		return nil
+	})
+	result := sequence.Check(batch, count, received)
+	if consumeErr != nil {
+		return result, err
+	}
+	return result, nil
+}
+
+func (l *Lifecycle) checkPayload(app *exampleapp.Client, queueName string, p payload.Payload) error {
+	l.println("Publishing payload", p.Name, "to the queue: ", app.URL()+"/queue/"+queueName)
+	err := l.eventually(func() error { return app.Publish(queueName, p.Encoded()) })
+	if err != nil {
+		return fmt.Errorf("payload %s could not be published: %s", p.Name, err.Error())
+	}
+
+	var received string
+	err = l.eventually(func() error {
+		message, ok, err := app.ConsumeMessage(queueName)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("%s is empty", queueName)
+		}
+		received = message
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("payload %s was not received: %s", p.Name, err.Error())
+	}
+	return p.Verify(received)
+}
+
+// queueApp returns the app for the checks that go through it to the
+// lifecycle's queue, once it is running with the service instance bound,
+// and declares the queue if no step has yet.
+func (l *Lifecycle) queueApp() (*exampleapp.Client, error) {
+	if err := l.requireRunningApp(); err != nil {
+		return nil, err
+	}
+
+	app := l.app()
+	if !l.queueDeclared {
+		if err := l.declareQueue(app); err != nil {
+			return nil, err
+		}
+	}
+	return app, nil
+}
+
+// declareQueue creates the lifecycle's queue for the AMQP app and waits
+// for it to be listed; the STOMP and MQTT apps create it on first use.
+func (l *Lifecycle) declareQueue(app *exampleapp.Client) error {
+	if l.config.Protocol != AMQP {
+		l.queueDeclared = true
+		return nil
+	}
+
+	queueName := l.QueueName
+
+	l.println("Creating a new queue: ", app.URL()+"/queues")
+	if err := l.eventually(func() error { return app.CreateQueue(queueName) }); err != nil {
+		return err
+	}
+
+	l.println("Listing the queues: ", app.URL()+"/queues")
+	err := l.eventually(func() error {
+		queues, err := app.Queues()
+		if err != nil {
+			return err
+		}
+		for _, queue := range queues {
+			if queue == queueName {
+				return nil
+			}
+		}
+		return fmt.Errorf("queue %s is not listed in %v", queueName, queues)
+	})
+	if err != nil {
+		return err
+	}
+	l.queueDeclared = true
+	return nil
+}
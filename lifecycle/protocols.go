@@ -0,0 +1,131 @@
+package lifecycle
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/streadway/amqp"
+
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/broker"
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/credentials"
+)
+
+// CheckCrossProtocol publishes over MQTT and over STOMP with the
+// credentials of a service key, and consumes each message over AMQP. MQTT
+// publishes to amq.topic, with the topic's levels as the words of the
+// routing key; STOMP sends to /queue/ destinations through the default
+// exchange. Each pair is a step of its own, skipped when the credentials
+// do not offer both protocols.
+func (l *Lifecycle) CheckCrossProtocol() error {
+	mqttErr := l.step(StepMQTTToAMQP, func() error {
+		consumer, publisher, err := l.crossProtocolEndpoints(credentials.MQTT)
+		if err != nil {
+			return err
+		}
+		topic := "smoke-test/" + randomName()
+		routingKey := strings.Replace(topic, "/", ".", -1)
+
+		return l.crossProtocol(consumer, "amq.topic", routingKey, func(queueName, message string) error {
+			l.println("Connecting over MQTT:", broker.Address(publisher))
+			client, err := l.connector.MQTT(publisher, "smoke-test-"+randomName())
+			if err != nil {
+				return err
+			}
+			defer client.Close()
+
+			l.println("Publishing over MQTT to the topic:", topic)
+			return client.Publish(topic, []byte(message), 1)
+		})
+	})
+
+	stompErr := l.step(StepSTOMPToAMQP, func() error {
+		consumer, publisher, err := l.crossProtocolEndpoints(credentials.STOMP)
+		if err != nil {
+			return err
+		}
+
+		return l.crossProtocol(consumer, "", "", func(queueName, message string) error {
+			l.println("Connecting over STOMP:", broker.Address(publisher))
+			client, err := l.connector.STOMP(publisher)
+			if err != nil {
+				return err
+			}
+			defer client.Close()
+
+			// the queue is the STOMP adapter's to declare
+			destination := "/queue/" + queueName
+			l.println("Sending over STOMP to the destination:", destination)
+			return client.Send(destination, []byte(message), map[string]string{"persistent": "true"})
+		})
+	})
+
+	if mqttErr != nil {
+		return mqttErr
+	}
+	return stompErr
+}
+
+// crossProtocolEndpoints returns the AMQP endpoint to consume from and the
+// endpoint of the protocol to publish over.
+func (l *Lifecycle) crossProtocolEndpoints(protocol string) (credentials.Protocol, credentials.Protocol, error) {
+	consumer, err := l.brokerEndpoint(credentials.AMQP)
+	if err != nil {
+		return consumer, consumer, err
+	}
+	publisher, err := l.brokerEndpoint(protocol)
+	return consumer, publisher, err
+}
+
+// crossProtocol publishes a unique message to a queue of its own with
+// publish, and reads it back over AMQP. With an exchange, the queue is
+// declared and bound to it first; without one, publish declares it. The
+// queue is deleted afterwards either way.
+func (l *Lifecycle) crossProtocol(endpoint credentials.Protocol, exchange, routingKey string, publish func(queueName, message string) error) error {
+	queueName := "test-q-" + randomName()
+
+	l.println("Connecting over AMQP:", broker.Address(endpoint))
+	consumer, err := l.connector.AMQP(endpoint)
+	if err != nil {
+		return err
+	}
+	defer consumer.Close()
+
+	if exchange != "" {
+		l.println("Declaring the queue", queueName, "bound to", exchange, "with the routing key", routingKey)
+		if _, err := consumer.DeclareQueue(queueName, broker.QueueOptions{}); err != nil {
+			return err
+		}
+		if err := consumer.BindQueue(queueName, exchange, routingKey, nil); err != nil {
+			return err
+		}
+	}
+	defer func() {
+		if _, err := consumer.DeleteQueue(queueName); err != nil {
+			l.println("Failed to delete the queue", queueName+":", err)
+		}
+	}()
+
+	message := fmt.Sprintf("test-message-cross-protocol-%s-%d", randomName(), time.Now().UnixNano())
+	if err := publish(queueName, message); err != nil {
+		return err
+	}
+
+	l.println("Reading over AMQP from the queue:", queueName)
+	return l.eventually(func() error {
+		delivery, ok, err := consumer.Get(queueName, true)
+		if err != nil {
+			if brokerErr, isBrokerErr := err.(*amqp.Error); isBrokerErr && brokerErr.Code == amqp.NotFound && exchange == "" {
+				return fmt.Errorf("queue %s was not declared by the publisher", queueName)
+			}
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("%s is empty", queueName)
+		}
+		if string(delivery.Body) != message {
+			return fmt.Errorf("expected to read %q from %s, got %q", message, queueName, delivery.Body)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,485 @@
+package lifecycle
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/streadway/amqp"
+
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/broker"
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/credentials"
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/sequence"
+)
+
+// typedQueueMessages is how many messages go through the quorum queue and
+// the stream.
+const typedQueueMessages = 5
+
+// CheckQuorumQueue declares a quorum queue over AMQP, checks through the
+// management API that the broker made it one, and publishes a batch of
+// messages to it, which a consumer must read back once each and in order.
+// The queue is deleted after.
+func (l *Lifecycle) CheckQuorumQueue() error {
+	return l.step(StepQuorumQueue, func() error {
+		return l.checkTypedQueue("quorum", func(session broker.AMQP, queueName, batch string) error {
+			received, err := l.consumeWith(session, queueName, typedQueueMessages, nil)
+			return l.checkBatch(batch, received, err)
+		})
+	})
+}
+
+// CheckStreamQueue declares a stream over AMQP, checks through the
+// management API that the broker made it one, and publishes a batch of
+// messages to it. Streams are read over AMQP 0-9-1 by a consumer with a
+// prefetch and an x-stream-offset argument; one from the first offset
+// must read the whole batch, and, since reading leaves the messages in
+// the stream, so must a second one. The stream is deleted after.
+func (l *Lifecycle) CheckStreamQueue() error {
+	return l.step(StepStreamQueue, func() error {
+		return l.checkTypedQueue("stream", func(session broker.AMQP, queueName, batch string) error {
+			if err := session.Qos(typedQueueMessages); err != nil {
+				return err
+			}
+			first := amqp.Table{"x-stream-offset": "first"}
+
+			l.println("Reading the stream from the first offset")
+			received, err := l.consumeWith(session, queueName, typedQueueMessages, first)
+			if err := l.checkBatch(batch, received, err); err != nil {
+				return err
+			}
+
+			l.println("Reading the stream from the first offset again")
+			received, err = l.consumeWith(session, queueName, typedQueueMessages, first)
+			if err := l.checkBatch(batch, received, err); err != nil {
+				return fmt.Errorf("reading the stream again: %s", err.Error())
+			}
+			return nil
+		})
+	})
+}
+
+// checkTypedQueue declares a durable queue of the type, checks its type
+// through the management API, publishes a batch of persistent messages
+// to it and has consume read them back. The queue is deleted after, over
+// a connection of its own, as the broker may have closed the check's
+// channel.
+func (l *Lifecycle) checkTypedQueue(queueType string, consume func(session broker.AMQP, queueName, batch string) error) error {
+	session, endpoint, err := l.connectAMQP()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	queueName := "test-q-" + queueType + "-" + randomName()
+	l.println("Declaring a", queueType, "queue:", queueName)
+	_, err = session.DeclareQueue(queueName, broker.QueueOptions{
+		Durable:   true,
+		Arguments: amqp.Table{"x-queue-type": queueType},
+	})
+	if err != nil {
+		return err
+	}
+	defer l.deleteQueue(endpoint, queueName)
+
+	client, vhost, err := l.managementClient()
+	if err != nil {
+		return err
+	}
+	l.println("Checking the queue's type in the management API")
+	err = l.eventually(func() error {
+		queue, err := client.Queue(vhost, queueName)
+		if err != nil {
+			return err
+		}
+		if queue.Type != queueType {
+			return fmt.Errorf("queue %s is a %s queue, expected a %s queue", queueName, queue.Type, queueType)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	batch := fmt.Sprintf("test-%s-%s", queueType, randomName())
+	l.println("Publishing", typedQueueMessages, "messages to the queue:", queueName)
+	for n := 1; n <= typedQueueMessages; n++ {
+		message := amqp.Publishing{
+			DeliveryMode: amqp.Persistent,
+			Body:         []byte(sequence.Message(batch, n)),
+		}
+		if err := session.Publish("", queueName, message); err != nil {
+			return fmt.Errorf("message %d of %d could not be published: %s", n, typedQueueMessages, err.Error())
+		}
+	}
+
+	if err := consume(session, queueName, batch); err != nil {
+		return err
+	}
+	l.println("Read all", typedQueueMessages, "messages back from the", queueType, "queue")
+	return nil
+}
+
+// consumeWith starts a consumer on the queue with the arguments, reads
+// count messages, acknowledging each, and cancels the consumer.
+func (l *Lifecycle) consumeWith(session broker.AMQP, queueName string, count int, arguments amqp.Table) ([]string, error) {
+	consumerTag, err := session.Consume(queueName, false, arguments)
+	if err != nil {
+		return nil, err
+	}
+
+	received := []string{}
+	for len(received) < count {
+		delivery, err := session.Next()
+		if err != nil {
+			return received, err
+		}
+		received = append(received, string(delivery.Body))
+		if err := session.Ack(delivery.DeliveryTag, false); err != nil {
+			return received, err
+		}
+	}
+	return received, session.Cancel(consumerTag)
+}
+
+// checkBatch describes what went wrong with the messages of a batch read
+// back, if anything, and whatever stopped the reading.
+func (l *Lifecycle) checkBatch(batch string, received []string, readErr error) error {
+	if err := sequence.Check(batch, typedQueueMessages, received).Err(); err != nil {
+		if readErr != nil {
+			return fmt.Errorf("the batch did not come back intact: %s, after %s", err.Error(), readErr.Error())
+		}
+		return fmt.Errorf("the batch did not come back intact: %s", err.Error())
+	}
+	return readErr
+}
+
+// deleteQueue deletes a queue over a connection of its own.
+func (l *Lifecycle) deleteQueue(endpoint credentials.Protocol, queueName string) {
+	session, err := l.connector.AMQP(endpoint)
+	if err == nil {
+		_, err = session.DeleteQueue(queueName)
+		session.Close()
+	}
+	if err != nil {
+		l.println("Failed to delete the queue", queueName+":", err)
+	}
+}
+
+// confirmedMessages is how many messages are published in confirm mode.
+const confirmedMessages = 10
+
+// CheckDeliveryGuarantees checks what the broker promises about delivery
+// over AMQP, each promise in a step of its own with a queue of its own:
+// that it acks every message published in confirm mode, that a message
+// nacked with requeue comes back flagged as redelivered, and that one
+// rejected without requeue does not come back.
+func (l *Lifecycle) CheckDeliveryGuarantees() error {
+	confirmsErr := l.step(StepPublisherConfirms, func() error {
+		return l.withExclusiveQueue(func(session broker.AMQP, queueName string) error {
+			if err := session.ConfirmSelect(); err != nil {
+				return err
+			}
+
+			batch := fmt.Sprintf("test-confirms-%s", randomName())
+			l.println("Publishing", confirmedMessages, "messages in confirm mode to the queue:", queueName)
+			for n := 1; n <= confirmedMessages; n++ {
+				if err := session.Publish("", queueName, amqp.Publishing{Body: []byte(sequence.Message(batch, n))}); err != nil {
+					return err
+				}
+			}
+
+			settled := map[uint64]bool{}
+			nacked := []string{}
+			for len(settled) < confirmedMessages {
+				confirmation, err := session.NextConfirmation()
+				if err != nil {
+					return fmt.Errorf("the broker confirmed %d of %d publishes: %s", len(settled), confirmedMessages, err.Error())
+				}
+				settled[confirmation.DeliveryTag] = true
+				if !confirmation.Ack {
+					nacked = append(nacked, strconv.FormatUint(confirmation.DeliveryTag, 10))
+				}
+			}
+			if len(nacked) > 0 {
+				return fmt.Errorf("the broker nacked %d of %d publishes: %s", len(nacked), confirmedMessages, strings.Join(nacked, ", "))
+			}
+			l.println("The broker acked all", confirmedMessages, "publishes")
+
+			queue, err := session.DeclareQueue(queueName, broker.QueueOptions{Passive: true})
+			if err != nil {
+				return err
+			}
+			if queue.Messages != confirmedMessages {
+				return fmt.Errorf("the broker acked %d publishes, but the queue holds %d messages", confirmedMessages, queue.Messages)
+			}
+			return nil
+		})
+	})
+
+	requeueErr := l.step(StepNackRequeue, func() error {
+		return l.withExclusiveQueue(func(session broker.AMQP, queueName string) error {
+			message := fmt.Sprintf("test-requeue-%s", randomName())
+			delivery, err := l.publishAndGet(session, queueName, message)
+			if err != nil {
+				return err
+			}
+			if delivery.Redelivered {
+				return fmt.Errorf("the message was flagged as redelivered on its first delivery")
+			}
+
+			l.println("Nacking the message with requeue")
+			if err := session.Nack(delivery.DeliveryTag, false, true); err != nil {
+				return err
+			}
+			delivery, err = l.getMessage(session, queueName, message)
+			if err != nil {
+				return fmt.Errorf("the message nacked with requeue did not come back: %s", err.Error())
+			}
+			if !delivery.Redelivered {
+				return fmt.Errorf("the message nacked with requeue came back without the redelivered flag")
+			}
+			l.println("The message came back flagged as redelivered")
+			return session.Ack(delivery.DeliveryTag, false)
+		})
+	})
+
+	rejectErr := l.step(StepRejectDrop, func() error {
+		return l.withExclusiveQueue(func(session broker.AMQP, queueName string) error {
+			message := fmt.Sprintf("test-reject-%s", randomName())
+			delivery, err := l.publishAndGet(session, queueName, message)
+			if err != nil {
+				return err
+			}
+
+			l.println("Rejecting the message without requeue")
+			if err := session.Reject(delivery.DeliveryTag, false); err != nil {
+				return err
+			}
+			delivery, ok, err := session.Get(queueName, true)
+			if err != nil {
+				return err
+			}
+			if ok {
+				return fmt.Errorf("the message rejected without requeue came back (redelivered: %t)", delivery.Redelivered)
+			}
+			l.println("The queue dropped the rejected message")
+			return nil
+		})
+	})
+
+	for _, err := range []error{confirmsErr, requeueErr, rejectErr} {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// withExclusiveQueue connects over AMQP and runs check on a fresh queue
+// that goes with the connection.
+func (l *Lifecycle) withExclusiveQueue(check func(session broker.AMQP, queueName string) error) error {
+	session, _, err := l.connectAMQP()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	queueName := "test-q-" + randomName()
+	if _, err := session.DeclareQueue(queueName, broker.QueueOptions{Exclusive: true}); err != nil {
+		return err
+	}
+	return check(session, queueName)
+}
+
+// publishAndGet publishes a message to the queue and gets it back, to be
+// acknowledged.
+func (l *Lifecycle) publishAndGet(session broker.AMQP, queueName, message string) (amqp.Delivery, error) {
+	l.println("Publishing a message to the queue:", queueName)
+	if err := session.Publish("", queueName, amqp.Publishing{Body: []byte(message)}); err != nil {
+		return amqp.Delivery{}, err
+	}
+	return l.getMessage(session, queueName, message)
+}
+
+// getMessage gets the message from the queue, to be acknowledged.
+func (l *Lifecycle) getMessage(session broker.AMQP, queueName, message string) (amqp.Delivery, error) {
+	var delivery amqp.Delivery
+	err := l.eventually(func() error {
+		var ok bool
+		var err error
+		delivery, ok, err = session.Get(queueName, false)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("%s is empty", queueName)
+		}
+		if string(delivery.Body) != message {
+			return fmt.Errorf("expected to get %q from %s, got %q", message, queueName, delivery.Body)
+		}
+		return nil
+	})
+	return delivery, err
+}
+
+// deadLetteredMessages are published to a queue that keeps
+// deadLetterMaxLength of them.
+const (
+	deadLetteredMessages = 5
+	deadLetterMaxLength  = 2
+)
+
+// CheckDeadLettering checks over AMQP, in a step each, that a queue
+// declared with x-max-length dead-letters the oldest messages that
+// overflow it, and that one declared with x-message-ttl dead-letters
+// those that expire. Messages expire after half a retry interval, so that
+// they are gone by the next attempt.
+func (l *Lifecycle) CheckDeadLettering() error {
+	maxLengthErr := l.step(StepDeadLetterMaxLength, func() error {
+		arguments := amqp.Table{"x-max-length": deadLetterMaxLength}
+		return l.withDeadLetterQueue(arguments, func(session broker.AMQP, queueName, deadLetterQueue string) error {
+			batch := fmt.Sprintf("test-max-length-%s", randomName())
+			if err := l.publishSequence(session, queueName, batch, deadLetteredMessages); err != nil {
+				return err
+			}
+
+			overflowed := deadLetteredMessages - deadLetterMaxLength
+			if err := l.checkDeadLettered(session, deadLetterQueue, batch, overflowed); err != nil {
+				return err
+			}
+
+			kept, err := drain(session, queueName)
+			if err != nil {
+				return err
+			}
+			expected := []string{}
+			for n := overflowed + 1; n <= deadLetteredMessages; n++ {
+				expected = append(expected, sequence.Message(batch, n))
+			}
+			if strings.Join(kept, ",") != strings.Join(expected, ",") {
+				return fmt.Errorf("the queue kept %q, expected the newest %d messages", kept, deadLetterMaxLength)
+			}
+			l.println("The queue kept the newest", deadLetterMaxLength, "messages")
+			return nil
+		})
+	})
+
+	ttlErr := l.step(StepDeadLetterTTL, func() error {
+		ttl := l.config.RetryInterval / 2
+		if ttl < time.Millisecond {
+			ttl = time.Millisecond
+		}
+		arguments := amqp.Table{"x-message-ttl": int64(ttl / time.Millisecond)}
+		return l.withDeadLetterQueue(arguments, func(session broker.AMQP, queueName, deadLetterQueue string) error {
+			batch := fmt.Sprintf("test-ttl-%s", randomName())
+			if err := l.publishSequence(session, queueName, batch, deadLetteredMessages); err != nil {
+				return err
+			}
+
+			l.println("Waiting", ttl, "for the messages to expire")
+			if err := l.checkDeadLettered(session, deadLetterQueue, batch, deadLetteredMessages); err != nil {
+				return err
+			}
+			left, err := drain(session, queueName)
+			if err != nil {
+				return err
+			}
+			if len(left) > 0 {
+				return fmt.Errorf("%d messages were dead-lettered, yet %d are still in the queue", deadLetteredMessages, len(left))
+			}
+			return nil
+		})
+	})
+
+	if maxLengthErr != nil {
+		return maxLengthErr
+	}
+	return ttlErr
+}
+
+// withDeadLetterQueue connects over AMQP and runs check on a fresh queue
+// declared with the arguments, which dead-letters through amq.direct to a
+// fresh dead-letter queue. Both go with the connection.
+func (l *Lifecycle) withDeadLetterQueue(arguments amqp.Table, check func(session broker.AMQP, queueName, deadLetterQueue string) error) error {
+	session, _, err := l.connectAMQP()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	deadLetterQueue := "test-q-dlq-" + randomName()
+	if _, err := session.DeclareQueue(deadLetterQueue, broker.QueueOptions{Exclusive: true}); err != nil {
+		return err
+	}
+	if err := session.BindQueue(deadLetterQueue, "amq.direct", deadLetterQueue, nil); err != nil {
+		return err
+	}
+
+	queueName := "test-q-" + randomName()
+	options := broker.QueueOptions{Exclusive: true, Arguments: amqp.Table{
+		"x-dead-letter-exchange":    "amq.direct",
+		"x-dead-letter-routing-key": deadLetterQueue,
+	}}
+	names := []string{}
+	for name, value := range arguments {
+		options.Arguments[name] = value
+		names = append(names, fmt.Sprintf("%s %v", name, value))
+	}
+	sort.Strings(names)
+	l.println("Declaring the queue", queueName, "with", strings.Join(names, ", ")+", dead-lettering to:", deadLetterQueue)
+	if _, err := session.DeclareQueue(queueName, options); err != nil {
+		return err
+	}
+	return check(session, queueName, deadLetterQueue)
+}
+
+// publishSequence publishes a batch of count numbered messages over AMQP.
+func (l *Lifecycle) publishSequence(session broker.AMQP, queueName, batch string, count int) error {
+	l.println("Publishing", count, "messages to the queue:", queueName)
+	for n := 1; n <= count; n++ {
+		if err := session.Publish("", queueName, amqp.Publishing{Body: []byte(sequence.Message(batch, n))}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkDeadLettered waits for the first count messages of the batch to
+// land in the dead-letter queue, in order.
+func (l *Lifecycle) checkDeadLettered(session broker.AMQP, deadLetterQueue, batch string, count int) error {
+	received := []string{}
+	err := l.eventually(func() error {
+		messages, err := drain(session, deadLetterQueue)
+		received = append(received, messages...)
+		if err != nil {
+			return err
+		}
+		if len(received) < count {
+			return fmt.Errorf("%s holds %d of %d messages", deadLetterQueue, len(received), count)
+		}
+		return nil
+	})
+	if err := sequence.Check(batch, count, received).Err(); err != nil {
+		return fmt.Errorf("the dead-lettered messages did not come back intact: %s", err.Error())
+	}
+	if err != nil {
+		return err
+	}
+	l.println("All", count, "messages were dead-lettered to", deadLetterQueue)
+	return nil
+}
+
+// drain gets every message left in the queue.
+func drain(session broker.AMQP, queueName string) ([]string, error) {
+	messages := []string{}
+	for {
+		delivery, ok, err := session.Get(queueName, true)
+		if err != nil || !ok {
+			return messages, err
+		}
+		messages = append(messages, string(delivery.Body))
+	}
+}
@@ -0,0 +1,271 @@
+package lifecycle
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/exampleapp"
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/platform"
+)
+
+// CheckSharedInstance pushes a second app, the consumer, and binds it to
+// the lifecycle's instance, as tenants bind several apps to one instance.
+// A message published through the lifecycle's app must be consumed
+// through the consumer, and once the consumer is unbound the lifecycle's
+// app must still get messages through. Whether the two bindings got
+// distinct broker users is reported; sharing one fails the step only when
+// requireDistinctCredentials is set. The consumer app is deleted after.
+func (l *Lifecycle) CheckSharedInstance(requireDistinctCredentials bool) error {
+	return l.step(StepSharedInstance, func() error {
+		producer, err := l.queueApp()
+		if err != nil {
+			return err
+		}
+
+		queueName := l.QueueName
+
+		consumerName := randomName()
+		l.println("Pushing a consumer app:", consumerName)
+		if err := l.platform.PushApp(consumerName, l.config.AppPath); err != nil {
+			return err
+		}
+		l.consumerApp = consumerName
+		defer func() {
+			l.println("Deleting the consumer app:", consumerName)
+			if err := l.deleteConsumerApp(); err != nil {
+				l.println("Failed to delete the consumer app:", err)
+			}
+		}()
+
+		if err := l.platform.BindService(consumerName, l.ServiceInstanceName); err != nil {
+			return err
+		}
+		if err := l.platform.SetEnv(consumerName, "RABBITMQ_SKIP_SSL", l.skipSSL()); err != nil {
+			return err
+		}
+		if err := l.platform.StartApp(consumerName); err != nil {
+			return err
+		}
+		consumer := exampleapp.NewClient(l.appURL(consumerName), l.httpClient)
+		l.println("Checking that the consumer app is responding at url: ", consumer.URL()+"/ping")
+		if err := l.eventually(consumer.Ping); err != nil {
+			return fmt.Errorf("the consumer app: %s", err.Error())
+		}
+
+		producerCredentials, err := l.bindingCredentials(l.AppName)
+		if err != nil {
+			return err
+		}
+		consumerCredentials, err := l.bindingCredentials(consumerName)
+		if err != nil {
+			return err
+		}
+		producerUser, consumerUser := producerCredentials.Username, consumerCredentials.Username
+		if producerUser == consumerUser {
+			if requireDistinctCredentials {
+				return fmt.Errorf("the bindings of the %s and %s apps share the broker user %s, expected distinct credentials", l.AppName, consumerName, producerUser)
+			}
+			l.println("The bindings share the broker user", producerUser)
+		} else {
+			l.println("The bindings have distinct broker users:", producerUser, "and", consumerUser)
+		}
+
+		message := fmt.Sprintf("test-shared-%s-%s", l.config.Protocol, randomName())
+		l.println("Publishing through the producer app: ", producer.URL()+"/queue/"+queueName)
+		if err := l.eventually(func() error { return producer.Publish(queueName, message) }); err != nil {
+			return err
+		}
+
+		l.println("Reading through the consumer app: ", consumer.URL()+"/queue/"+queueName)
+		err = l.eventually(func() error {
+			received, ok, err := consumer.ConsumeMessage(queueName)
+			if err != nil {
+				return err
+			}
+			if !ok || received != message {
+				return fmt.Errorf("expected to read %q from %s, got %q", message, queueName, received)
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("the consumer app did not get the message published through the producer app: %s", err.Error())
+		}
+
+		l.println("Unbinding the consumer app:", consumerName)
+		if err := l.platform.UnbindService(consumerName, l.ServiceInstanceName); err != nil {
+			return err
+		}
+
+		message = fmt.Sprintf("test-shared-%s-%s", l.config.Protocol, randomName())
+		l.println("Checking that the producer app still publishes and consumes: ", producer.URL()+"/queue/"+queueName)
+		err = l.eventually(func() error {
+			if err := producer.Publish(queueName, message); err != nil {
+				return err
+			}
+			received, ok, err := producer.ConsumeMessage(queueName)
+			if err != nil {
+				return err
+			}
+			if !ok || received != message {
+				return fmt.Errorf("expected to read %q from %s, got %q", message, queueName, received)
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("the %s app stopped working after the %s app was unbound: %s", l.AppName, consumerName, err.Error())
+		}
+		l.println("The producer app still works with the consumer app unbound")
+		return nil
+	})
+}
+
+// CheckSharedSpace shares the lifecycle's instance into another space of
+// the org and binds an app there to it. A message must get from each
+// space's app to the other's, and unsharing the instance must remove the
+// binding in the other space. The app there is deleted after, and the
+// instance unshared if it still is.
+func (l *Lifecycle) CheckSharedSpace(spaceName string) error {
+	return l.step(StepSharedSpace, func() error {
+		app, err := l.queueApp()
+		if err != nil {
+			return err
+		}
+
+		queueName := l.QueueName
+
+		l.println("Sharing the service instance into the space:", spaceName)
+		if err := l.platform.ShareService(l.ServiceInstanceName, spaceName); err != nil {
+			return err
+		}
+		l.sharedSpace = spaceName
+		l.serviceShared = true
+		defer func() {
+			if err := l.cleanUpSharedSpace(); err != nil {
+				l.println("Failed to clean up the space", spaceName+":", err)
+			}
+		}()
+
+		other := l.platform.InSpace(spaceName)
+		otherName := randomName()
+		l.println("Pushing an app to the space", spaceName+":", otherName)
+		if err := other.PushApp(otherName, l.config.AppPath); err != nil {
+			return err
+		}
+		l.sharedSpaceApp = otherName
+
+		if err := other.BindService(otherName, l.ServiceInstanceName); err != nil {
+			return err
+		}
+		if err := other.SetEnv(otherName, "RABBITMQ_SKIP_SSL", l.skipSSL()); err != nil {
+			return err
+		}
+		if err := other.StartApp(otherName); err != nil {
+			return err
+		}
+		otherApp := exampleapp.NewClient(l.appURL(otherName), l.httpClient)
+		l.println("Checking that the app in the space", spaceName, "is responding at url: ", otherApp.URL()+"/ping")
+		if err := l.eventually(otherApp.Ping); err != nil {
+			return fmt.Errorf("the app in the space %s: %s", spaceName, err.Error())
+		}
+
+		if err := l.relay(app, otherApp, queueName); err != nil {
+			return fmt.Errorf("a message did not get from this space to the space %s: %s", spaceName, err.Error())
+		}
+		if err := l.relay(otherApp, app, queueName); err != nil {
+			return fmt.Errorf("a message did not get from the space %s to this space: %s", spaceName, err.Error())
+		}
+
+		l.println("Unsharing the service instance from the space:", spaceName)
+		if err := l.platform.UnshareService(l.ServiceInstanceName, spaceName); err != nil {
+			return err
+		}
+		l.serviceShared = false
+
+		// only the binding being gone counts: a failed lookup says nothing
+		// about it
+		var lookupErr error
+		err = l.eventually(func() error {
+			_, err := other.BindingCredentials(otherName, l.ServiceInstanceName)
+			if err == nil {
+				return fmt.Errorf("the %s app is still bound", otherName)
+			}
+			if _, notBound := err.(*platform.NotBoundError); !notBound {
+				lookupErr = err
+			}
+			return nil
+		})
+		if lookupErr != nil {
+			return fmt.Errorf("could not tell whether unsharing the service instance removed its binding in the space %s: %s", spaceName, lookupErr.Error())
+		}
+		if err != nil {
+			return fmt.Errorf("unsharing the service instance did not remove its binding in the space %s: %s", spaceName, err.Error())
+		}
+		l.println("Unsharing removed the binding in the space", spaceName)
+		return nil
+	})
+}
+
+// relay publishes a fresh message through one app and reads it back
+// through another.
+func (l *Lifecycle) relay(from, to *exampleapp.Client, queueName string) error {
+	message := fmt.Sprintf("test-relay-%s-%s", l.config.Protocol, randomName())
+
+	l.println("Publishing to the queue: ", from.URL()+"/queue/"+queueName)
+	if err := l.eventually(func() error { return from.Publish(queueName, message) }); err != nil {
+		return err
+	}
+
+	l.println("Reading from the queue: ", to.URL()+"/queue/"+queueName)
+	return l.eventually(func() error {
+		received, ok, err := to.ConsumeMessage(queueName)
+		if err != nil {
+			return err
+		}
+		if !ok || received != message {
+			return fmt.Errorf("expected to read %q from %s, got %q", message, queueName, received)
+		}
+		return nil
+	})
+}
+
+// cleanUpSharedSpace deletes the app in the space the instance was shared
+// into, then takes the instance back from the space.
+func (l *Lifecycle) cleanUpSharedSpace() error {
+	if l.sharedSpace == "" {
+		return nil
+	}
+
+	failures := []string{}
+	if l.sharedSpaceApp != "" {
+		if err := l.platform.InSpace(l.sharedSpace).DeleteApp(l.sharedSpaceApp); err != nil {
+			failures = append(failures, err.Error())
+		} else {
+			l.sharedSpaceApp = ""
+		}
+	}
+	if l.serviceShared {
+		if err := l.platform.UnshareService(l.ServiceInstanceName, l.sharedSpace); err != nil {
+			failures = append(failures, err.Error())
+		} else {
+			l.serviceShared = false
+		}
+	}
+
+	if len(failures) > 0 {
+		return fmt.Errorf("%s", strings.Join(failures, "\n\n"))
+	}
+	l.sharedSpace = ""
+	return nil
+}
+
+// deleteConsumerApp deletes the consumer app, and with it its binding.
+func (l *Lifecycle) deleteConsumerApp() error {
+	if l.consumerApp == "" {
+		return nil
+	}
+	if err := l.platform.DeleteApp(l.consumerApp); err != nil {
+		return err
+	}
+	l.consumerApp = ""
+	return nil
+}
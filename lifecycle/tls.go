@@ -0,0 +1,260 @@
+package lifecycle
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/broker"
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/credentials"
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/tlsprobe"
+)
+
+// CheckTLSRequired checks that the app's binding only offers TLS
+// endpoints for AMQP, MQTT and STOMP, and that a connection over AMQP
+// with TLS works.
+func (l *Lifecycle) CheckTLSRequired() error {
+	return l.step(StepTLSRequired, func() error {
+		if err := l.requireBinding(); err != nil {
+			return err
+		}
+
+		c, err := l.bindingCredentials(l.AppName)
+		if err != nil {
+			return err
+		}
+
+		plaintext := []string{}
+		if uri, err := url.Parse(c.URI); err == nil && uri.Scheme == "amqp" {
+			plaintext = append(plaintext, "uri")
+		}
+		names := []string{}
+		for name := range c.Protocols {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if name == credentials.Management {
+				continue
+			}
+			if !broker.Resolve(c.Protocols[name]).SSL {
+				plaintext = append(plaintext, name)
+			}
+		}
+		if len(plaintext) > 0 {
+			return fmt.Errorf("the %s plan requires TLS, but the binding offers endpoints without it: %s", l.config.PlanName, strings.Join(plaintext, ", "))
+		}
+		l.println("The binding only offers TLS endpoints:", strings.Join(names, ", "))
+
+		endpoint, ok := c.Protocol(credentials.AMQP)
+		if !ok {
+			return fmt.Errorf("the binding offers no AMQP endpoint")
+		}
+		if l.connector == nil {
+			return nil
+		}
+		endpoint = broker.Resolve(endpoint)
+		l.println("Connecting over AMQP with TLS:", broker.Address(endpoint))
+		session, err := l.connector.AMQP(endpoint)
+		if err != nil {
+			return err
+		}
+		return session.Close()
+	})
+}
+
+// CheckTLSPosture handshakes with every TLS endpoint of the binding, the
+// management API's included, and reports the version, cipher suite and
+// certificate chain of each. It fails on expired certificates, versions
+// below the minimum, certificates that do not cover the endpoint's host
+// and, unless config skips verification, chains that are not trusted.
+func (l *Lifecycle) CheckTLSPosture(config tlsprobe.Config) error {
+	return l.step(StepTLSPosture, func() error {
+		if err := l.requireBinding(); err != nil {
+			return err
+		}
+
+		c, err := l.bindingCredentials(l.AppName)
+		if err != nil {
+			return err
+		}
+		endpoints := tlsEndpoints(c)
+		if len(endpoints) == 0 {
+			return prerequisiteError("the binding offers no TLS endpoints")
+		}
+
+		names := []string{}
+		for name := range endpoints {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		problems := []string{}
+		for _, name := range names {
+			endpoint := endpoints[name]
+			address := broker.Address(endpoint)
+			result, err := tlsprobe.Probe(address, endpoint.Host, config)
+			if err != nil {
+				l.println(name, address+":", err)
+				problems = append(problems, fmt.Sprintf("%s (%s): %s", name, address, err.Error()))
+				continue
+			}
+
+			l.println(name, address+":", tlsprobe.VersionName(result.Version)+",", tls.CipherSuiteName(result.CipherSuite))
+			for _, certificate := range result.Chain {
+				l.println("  certificate:", certificate.Subject, "issued by", certificate.Issuer, "expires", certificate.NotAfter.UTC().Format(time.RFC3339))
+			}
+			if len(result.Chain) > 0 {
+				l.println("  covers:", strings.Join(tlsprobe.Names(result.Chain[0]), ", "))
+			}
+			if result.TrustError != nil {
+				l.println("  chain not trusted:", result.TrustError)
+			} else {
+				l.println("  chain trusted")
+			}
+			for _, problem := range result.Problems {
+				problems = append(problems, fmt.Sprintf("%s (%s): %s", name, address, problem))
+			}
+		}
+		if len(problems) > 0 {
+			return fmt.Errorf("the broker's TLS endpoints fall short: %s", strings.Join(problems, "; "))
+		}
+		return nil
+	})
+}
+
+// tlsEndpoints are the endpoints of the credentials that use TLS, by
+// protocol, with the management API's when it is served over HTTPS.
+func tlsEndpoints(c credentials.Credentials) map[string]credentials.Protocol {
+	endpoints := map[string]credentials.Protocol{}
+	for name, protocol := range c.Protocols {
+		if name == credentials.Management {
+			continue
+		}
+		if endpoint := broker.Resolve(protocol); endpoint.SSL {
+			endpoints[name] = endpoint
+		}
+	}
+
+	if apiURL, _, _, err := c.ManagementAPI(); err == nil {
+		if uri, err := url.Parse(apiURL); err == nil && uri.Scheme == "https" {
+			endpoint := credentials.Protocol{Host: uri.Hostname(), Port: 443, SSL: true}
+			if port, err := strconv.Atoi(uri.Port()); err == nil {
+				endpoint.Port = port
+			}
+			endpoints[credentials.Management] = endpoint
+		}
+	}
+	return endpoints
+}
+
+// plaintextPorts are where brokers listen without TLS.
+var plaintextPorts = map[string]int{
+	credentials.AMQP:  5672,
+	credentials.MQTT:  1883,
+	credentials.STOMP: 61613,
+}
+
+// CheckPlaintextRefused tries to connect without TLS over AMQP, MQTT and
+// STOMP, on the plaintext ports of every host in the binding, as the
+// binding's user. Each attempt must be refused or fail to authenticate;
+// any that succeeds is a security finding, for plans that require TLS.
+// An attempt that fails otherwise, say by timing out or on a host that
+// does not resolve, shows neither, so the step fails as inconclusive.
+func (l *Lifecycle) CheckPlaintextRefused() error {
+	return l.step(StepPlaintextRefused, func() error {
+		if err := l.requireBinding(); err != nil {
+			return err
+		}
+		if l.connector == nil {
+			return prerequisiteError("there is no connector to reach the broker with")
+		}
+
+		c, err := l.bindingCredentials(l.AppName)
+		if err != nil {
+			return err
+		}
+
+		accepted, inconclusive := []string{}, []string{}
+		for _, protocol := range []string{credentials.AMQP, credentials.MQTT, credentials.STOMP} {
+			endpoints := plaintextEndpoints(c, protocol)
+			if len(endpoints) == 0 {
+				return fmt.Errorf("the binding names no host to try plaintext %s on", strings.ToUpper(protocol))
+			}
+
+			for _, endpoint := range endpoints {
+				address := broker.Address(endpoint)
+				l.println("Connecting over", strings.ToUpper(protocol), "without TLS:", address)
+
+				var session io.Closer
+				switch protocol {
+				case credentials.AMQP:
+					session, err = l.connector.AMQP(endpoint)
+				case credentials.MQTT:
+					session, err = l.connector.MQTT(endpoint, "smoke-test-"+randomName())
+				case credentials.STOMP:
+					session, err = l.connector.STOMP(endpoint)
+				}
+				if err != nil && broker.Refused(err) {
+					l.println("The broker refused it:", err)
+					continue
+				}
+				if err != nil {
+					l.println("Could not tell whether the broker refuses it:", err)
+					inconclusive = append(inconclusive, fmt.Sprintf("%s (%s): %s", protocol, address, err))
+					continue
+				}
+				session.Close()
+
+				l.finding(fmt.Sprintf("the broker accepted a plaintext %s connection on %s", strings.ToUpper(protocol), address))
+				accepted = append(accepted, fmt.Sprintf("%s (%s)", protocol, address))
+			}
+		}
+		if len(accepted) > 0 {
+			return fmt.Errorf("security finding: the %s plan requires TLS, but the broker accepted plaintext connections: %s", l.config.PlanName, strings.Join(accepted, ", "))
+		}
+		if len(inconclusive) > 0 {
+			return fmt.Errorf("inconclusive: could not tell whether the broker refuses plaintext connections: %s", strings.Join(inconclusive, "; "))
+		}
+		return nil
+	})
+}
+
+// plaintextEndpoints are the binding's endpoint for protocol, preferring
+// TLS, moved to the protocol's plaintext port without TLS, once for every
+// host it names. Without an endpoint, or without hosts in it, they are
+// made from the binding's hostnames and user.
+func plaintextEndpoints(c credentials.Credentials, protocol string) []credentials.Protocol {
+	endpoint, ok := c.Protocol(protocol)
+	if ok {
+		endpoint = broker.Resolve(endpoint)
+	} else {
+		endpoint = credentials.Protocol{Username: c.Username, Password: c.Password, VHost: c.VHost}
+	}
+	hosts := append([]string{endpoint.Host}, endpoint.Hosts...)
+	if endpoint.Host == "" && len(endpoint.Hosts) == 0 {
+		hosts = append([]string{c.Hostname}, c.Hostnames...)
+	}
+
+	endpoints := []credentials.Protocol{}
+	seen := map[string]bool{}
+	for _, host := range hosts {
+		if host == "" || seen[host] {
+			continue
+		}
+		seen[host] = true
+
+		plaintext := endpoint
+		plaintext.Host, plaintext.Hosts = host, nil
+		plaintext.URI = ""
+		plaintext.Port = plaintextPorts[protocol]
+		plaintext.SSL = false
+		endpoints = append(endpoints, plaintext)
+	}
+	return endpoints
+}
@@ -0,0 +1,246 @@
+package management
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Client talks to the RabbitMQ management API as the user of a binding or
+// service key.
+type Client struct {
+	url        string
+	username   string
+	password   string
+	httpClient *http.Client
+}
+
+// NewClient returns a client of the API at apiURL, e.g.
+// https://rabbitmq.example.com/api/.
+func NewClient(apiURL, username, password string, httpClient *http.Client) *Client {
+	return &Client{
+		url:        strings.TrimSuffix(apiURL, "/"),
+		username:   username,
+		password:   password,
+		httpClient: httpClient,
+	}
+}
+
+// Queue is a queue as the management API describes it.
+type Queue struct {
+	Name       string                 `json:"name"`
+	VHost      string                 `json:"vhost"`
+	Durable    bool                   `json:"durable"`
+	AutoDelete bool                   `json:"auto_delete"`
+	Exclusive  bool                   `json:"exclusive"`
+	Type       string                 `json:"type"`
+	Arguments  map[string]interface{} `json:"arguments"`
+	Messages   int                    `json:"messages"`
+
+	// OperatorPolicy names the operator policy that applies to the queue,
+	// if any; EffectivePolicyDefinition is what it and the user's policy
+	// set together.
+	OperatorPolicy            string     `json:"operator_policy"`
+	EffectivePolicyDefinition Definition `json:"effective_policy_definition"`
+}
+
+// Definition is what a policy sets, e.g. "max-length". The API lists an
+// empty one as an empty array.
+type Definition map[string]interface{}
+
+func (d *Definition) UnmarshalJSON(data []byte) error {
+	var list []interface{}
+	if err := json.Unmarshal(data, &list); err == nil && len(list) == 0 {
+		*d = Definition{}
+		return nil
+	}
+
+	var definition map[string]interface{}
+	if err := json.Unmarshal(data, &definition); err != nil {
+		return fmt.Errorf("policy definition is not an object: %s", string(data))
+	}
+	*d = definition
+	return nil
+}
+
+func (c *Client) Queue(vhost, name string) (Queue, error) {
+	var queue Queue
+	err := c.get("/queues/"+url.PathEscape(vhost)+"/"+url.PathEscape(name), &queue)
+	return queue, err
+}
+
+// User is the client's user, as /api/whoami describes it.
+type User struct {
+	Name string `json:"name"`
+	Tags Tags   `json:"tags"`
+}
+
+// Tags are a user's tags. Brokers before 3.9 list them in one
+// comma-separated string, later ones in an array.
+type Tags []string
+
+func (t *Tags) UnmarshalJSON(data []byte) error {
+	var list []string
+	if err := json.Unmarshal(data, &list); err == nil {
+		*t = list
+		return nil
+	}
+
+	var joined string
+	if err := json.Unmarshal(data, &joined); err != nil {
+		return fmt.Errorf("tags are neither a list nor a string: %s", string(data))
+	}
+	*t = Tags{}
+	for _, tag := range strings.Split(joined, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			*t = append(*t, tag)
+		}
+	}
+	return nil
+}
+
+// Has tells whether tag is one of the tags.
+func (t Tags) Has(tag string) bool {
+	for _, own := range t {
+		if own == tag {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Client) WhoAmI() (User, error) {
+	var user User
+	err := c.get("/whoami", &user)
+	return user, err
+}
+
+// Overview is what /api/overview says about the broker.
+type Overview struct {
+	RabbitMQVersion   string `json:"rabbitmq_version"`
+	ErlangVersion     string `json:"erlang_version"`
+	ManagementVersion string `json:"management_version"`
+	ClusterName       string `json:"cluster_name"`
+	ProductName       string `json:"product_name"`
+}
+
+func (c *Client) Overview() (Overview, error) {
+	var overview Overview
+	err := c.get("/overview", &overview)
+	return overview, err
+}
+
+// VHost is a vhost as the management API describes it.
+type VHost struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Tracing     bool   `json:"tracing"`
+}
+
+func (c *Client) VHost(name string) (VHost, error) {
+	var vhost VHost
+	err := c.get("/vhosts/"+url.PathEscape(name), &vhost)
+	return vhost, err
+}
+
+// Permission is what a user may do on a vhost: each field is a regular
+// expression of the resources the user may configure, write to or read
+// from.
+type Permission struct {
+	User      string `json:"user"`
+	VHost     string `json:"vhost"`
+	Configure string `json:"configure"`
+	Write     string `json:"write"`
+	Read      string `json:"read"`
+}
+
+// Permissions lists every user's permissions; only administrators may.
+func (c *Client) Permissions() ([]Permission, error) {
+	var permissions []Permission
+	err := c.get("/permissions", &permissions)
+	return permissions, err
+}
+
+// Channel is a channel as the management API describes it, with the
+// connection it is on and how many messages it has taken from queues.
+type Channel struct {
+	Name              string `json:"name"`
+	User              string `json:"user"`
+	ConnectionDetails struct {
+		Name string `json:"name"`
+	} `json:"connection_details"`
+	MessageStats struct {
+		DeliverGet int `json:"deliver_get"`
+	} `json:"message_stats"`
+}
+
+// Channels lists the channels on the vhost that the user may see: without
+// the monitoring tag, only the user's own.
+func (c *Client) Channels(vhost string) ([]Channel, error) {
+	var channels []Channel
+	err := c.get("/vhosts/"+url.PathEscape(vhost)+"/channels", &channels)
+	return channels, err
+}
+
+// Connection is a client's connection to the broker.
+type Connection struct {
+	Name  string `json:"name"`
+	User  string `json:"user"`
+	VHost string `json:"vhost"`
+}
+
+// Connections lists the connections to the vhost that the user may see:
+// without the monitoring tag, only the user's own.
+func (c *Client) Connections(vhost string) ([]Connection, error) {
+	var connections []Connection
+	err := c.get("/vhosts/"+url.PathEscape(vhost)+"/connections", &connections)
+	return connections, err
+}
+
+// StatusError is a response with an error status.
+type StatusError struct {
+	URL    string
+	Status int
+	Body   string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("GET %s: status %d: %s", e.URL, e.Status, e.Body)
+}
+
+// Refused tells whether the API refused the user, e.g. for lacking the
+// administrator tag.
+func (e *StatusError) Refused() bool {
+	return e.Status == http.StatusUnauthorized || e.Status == http.StatusForbidden
+}
+
+func (c *Client) get(path string, result interface{}) error {
+	request, err := http.NewRequest("GET", c.url+path, nil)
+	if err != nil {
+		return err
+	}
+	request.SetBasicAuth(c.username, c.password)
+	request.Header.Set("Accept", "application/json")
+
+	response, err := c.httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+
+	if response.StatusCode >= 300 {
+		return &StatusError{URL: c.url + path, Status: response.StatusCode, Body: strings.TrimSpace(string(body))}
+	}
+	if err := json.Unmarshal(body, result); err != nil {
+		return fmt.Errorf("GET %s%s: decoding response: %s", c.url, path, err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package management_test
+
+import (
+	"net/http"
+
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/management"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+)
+
+var _ = Describe("Client", func() {
+	var (
+		server *ghttp.Server
+		client *management.Client
+	)
+
+	BeforeEach(func() {
+		server = ghttp.NewServer()
+		client = management.NewClient(server.URL()+"/api/", "user", "secret", http.DefaultClient)
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("gets a queue as the user", func() {
+		server.AppendHandlers(ghttp.CombineHandlers(
+			ghttp.VerifyRequest("GET", "/api/queues/instance-vhost/test-q"),
+			ghttp.VerifyBasicAuth("user", "secret"),
+			ghttp.RespondWith(http.StatusOK, `{"name":"test-q","vhost":"instance-vhost","durable":true,"auto_delete":false,"type":"classic","arguments":{"x-max-length":10},"messages":3}`),
+		))
+
+		queue, err := client.Queue("instance-vhost", "test-q")
+		Ω(err).ShouldNot(HaveOccurred())
+		Expect(queue.Durable).To(BeTrue())
+		Expect(queue.Type).To(Equal("classic"))
+		Expect(queue.Arguments).To(HaveKeyWithValue("x-max-length", 10.0))
+		Expect(queue.Messages).To(Equal(3))
+	})
+
+	It("describes the policies that apply to a queue, empty or not", func() {
+		server.AppendHandlers(
+			ghttp.RespondWith(http.StatusOK, `{"name":"test-q","operator_policy":"plan-limits","effective_policy_definition":{"max-length":10000,"overflow":"reject-publish"}}`),
+			ghttp.RespondWith(http.StatusOK, `{"name":"test-q","operator_policy":null,"effective_policy_definition":[]}`),
+		)
+
+		queue, err := client.Queue("instance-vhost", "test-q")
+		Ω(err).ShouldNot(HaveOccurred())
+		Expect(queue.OperatorPolicy).To(Equal("plan-limits"))
+		Expect(queue.EffectivePolicyDefinition).To(Equal(management.Definition{"max-length": 10000.0, "overflow": "reject-publish"}))
+
+		queue, err = client.Queue("instance-vhost", "test-q")
+		Ω(err).ShouldNot(HaveOccurred())
+		Expect(queue.OperatorPolicy).To(BeEmpty())
+		Expect(queue.EffectivePolicyDefinition).To(BeEmpty())
+	})
+
+	It("escapes the vhost", func() {
+		server.AppendHandlers(func(w http.ResponseWriter, request *http.Request) {
+			Expect(request.URL.EscapedPath()).To(Equal("/api/queues/%2F/test-q"))
+			w.Write([]byte(`{"name":"test-q","vhost":"/"}`))
+		})
+
+		queue, err := client.Queue("/", "test-q")
+		Ω(err).ShouldNot(HaveOccurred())
+		Expect(queue.VHost).To(Equal("/"))
+	})
+
+	It("reports errors with the response", func() {
+		server.AppendHandlers(ghttp.RespondWith(http.StatusNotFound, `{"error":"Object Not Found","reason":"Not Found"}`))
+
+		_, err := client.Queue("instance-vhost", "missing")
+		Expect(err).To(MatchError(ContainSubstring("GET " + server.URL() + "/api/queues/instance-vhost/missing: status 404: {\"error\":\"Object Not Found\"")))
+	})
+	It("describes the user, the broker and the vhost", func() {
+		server.AppendHandlers(
+			ghttp.CombineHandlers(
+				ghttp.VerifyRequest("GET", "/api/whoami"),
+				ghttp.VerifyBasicAuth("user", "secret"),
+				ghttp.RespondWith(http.StatusOK, `{"name":"user","tags":"management, policymaker"}`),
+			),
+			ghttp.CombineHandlers(
+				ghttp.VerifyRequest("GET", "/api/whoami"),
+				ghttp.RespondWith(http.StatusOK, `{"name":"user","tags":["management","policymaker"]}`),
+			),
+			ghttp.CombineHandlers(
+				ghttp.VerifyRequest("GET", "/api/overview"),
+				ghttp.RespondWith(http.StatusOK, `{"rabbitmq_version":"3.8.9","erlang_version":"23.1.4","cluster_name":"rabbit@node-0"}`),
+			),
+			ghttp.CombineHandlers(
+				ghttp.VerifyRequest("GET", "/api/vhosts/instance-vhost"),
+				ghttp.RespondWith(http.StatusOK, `{"name":"instance-vhost","tracing":false}`),
+			),
+		)
+
+		for i := 0; i < 2; i++ {
+			user, err := client.WhoAmI()
+			Ω(err).ShouldNot(HaveOccurred())
+			Expect(user.Name).To(Equal("user"))
+			Expect(user.Tags).To(Equal(management.Tags{"management", "policymaker"}))
+			Expect(user.Tags.Has("administrator")).To(BeFalse())
+		}
+
+		overview, err := client.Overview()
+		Ω(err).ShouldNot(HaveOccurred())
+		Expect(overview.RabbitMQVersion).To(Equal("3.8.9"))
+		Expect(overview.ErlangVersion).To(Equal("23.1.4"))
+
+		vhost, err := client.VHost("instance-vhost")
+		Ω(err).ShouldNot(HaveOccurred())
+		Expect(vhost.Name).To(Equal("instance-vhost"))
+	})
+
+	It("lists the channels on a vhost with what they took from queues", func() {
+		server.AppendHandlers(ghttp.CombineHandlers(
+			ghttp.VerifyRequest("GET", "/api/vhosts/instance-vhost/channels"),
+			ghttp.VerifyBasicAuth("user", "secret"),
+			ghttp.RespondWith(http.StatusOK, `[{"name":"10.0.0.1:40000 -> 10.0.1.1:5672 (1)","user":"user","connection_details":{"name":"10.0.0.1:40000 -> 10.0.1.1:5672"},"message_stats":{"deliver_get":3}},{"name":"10.0.0.2:40000 -> 10.0.1.1:5672 (1)","user":"user","connection_details":{"name":"10.0.0.2:40000 -> 10.0.1.1:5672"}}]`),
+		))
+
+		channels, err := client.Channels("instance-vhost")
+		Ω(err).ShouldNot(HaveOccurred())
+		Expect(channels).To(HaveLen(2))
+		Expect(channels[0].User).To(Equal("user"))
+		Expect(channels[0].ConnectionDetails.Name).To(Equal("10.0.0.1:40000 -> 10.0.1.1:5672"))
+		Expect(channels[0].MessageStats.DeliverGet).To(Equal(3))
+		Expect(channels[1].MessageStats.DeliverGet).To(BeZero())
+	})
+
+	It("lists the connections to a vhost", func() {
+		server.AppendHandlers(ghttp.CombineHandlers(
+			ghttp.VerifyRequest("GET", "/api/vhosts/instance-vhost/connections"),
+			ghttp.VerifyBasicAuth("user", "secret"),
+			ghttp.RespondWith(http.StatusOK, `[{"name":"10.0.0.1:40000 -> 10.0.1.1:5672","user":"user","vhost":"instance-vhost","channels":1}]`),
+		))
+
+		connections, err := client.Connections("instance-vhost")
+		Ω(err).ShouldNot(HaveOccurred())
+		Expect(connections).To(Equal([]management.Connection{{Name: "10.0.0.1:40000 -> 10.0.1.1:5672", User: "user", VHost: "instance-vhost"}}))
+	})
+
+	It("tells when listing permissions is refused", func() {
+		server.AppendHandlers(
+			ghttp.CombineHandlers(
+				ghttp.VerifyRequest("GET", "/api/permissions"),
+				ghttp.RespondWith(http.StatusOK, `[{"user":"user","vhost":"instance-vhost","configure":".*","write":".*","read":".*"}]`),
+			),
+			ghttp.RespondWith(http.StatusUnauthorized, `{"error":"not_authorised","reason":"Not administrator user"}`),
+		)
+
+		permissions, err := client.Permissions()
+		Ω(err).ShouldNot(HaveOccurred())
+		Expect(permissions).To(Equal([]management.Permission{{User: "user", VHost: "instance-vhost", Configure: ".*", Write: ".*", Read: ".*"}}))
+
+		_, err = client.Permissions()
+		Expect(err).To(BeAssignableToTypeOf(&management.StatusError{}))
+		Expect(err.(*management.StatusError).Refused()).To(BeTrue())
+	})
+})
@@ -0,0 +1,229 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Registry holds metrics and writes them in the Prometheus text exposition
+// format.
+type Registry struct {
+	mutex   sync.Mutex
+	metrics []metric
+}
+
+type metric interface {
+	write(out io.Writer)
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(m metric) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.metrics = append(r.metrics, m)
+}
+
+// Write writes every metric, in the order they were registered.
+func (r *Registry) Write(out io.Writer) {
+	r.mutex.Lock()
+	metrics := append([]metric{}, r.metrics...)
+	r.mutex.Unlock()
+
+	for _, m := range metrics {
+		m.write(out)
+	}
+}
+
+// ServeHTTP serves the metrics for Prometheus to scrape.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, request *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.Write(w)
+}
+
+// Gauge is a value per combination of label values.
+type Gauge struct {
+	family
+	values map[string]float64
+}
+
+func (r *Registry) NewGauge(name, help string, labelNames ...string) *Gauge {
+	g := &Gauge{
+		family: family{name: name, help: help, labelNames: labelNames},
+		values: map[string]float64{},
+	}
+	r.register(g)
+	return g
+}
+
+// Set sets the value for the given label values, in the order of the
+// gauge's label names.
+func (g *Gauge) Set(value float64, labelValues ...string) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.values[g.labels(labelValues)] = value
+}
+
+func (g *Gauge) write(out io.Writer) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	g.header(out, "gauge")
+	for _, labels := range sortedKeys(g.values) {
+		fmt.Fprintf(out, "%s%s %s\n", g.name, braces(labels), formatFloat(g.values[labels]))
+	}
+}
+
+// Histogram counts observations into cumulative buckets per combination of
+// label values.
+type Histogram struct {
+	family
+	buckets []float64
+	series  map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// DefaultDurationBuckets suit steps that take from a second to several
+// minutes, like staging an app or provisioning a service instance.
+var DefaultDurationBuckets = []float64{0.1, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600}
+
+// DefaultLatencyBuckets suit message latencies, from milliseconds to a few
+// seconds.
+var DefaultLatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labelNames ...string) *Histogram {
+	h := &Histogram{
+		family:  family{name: name, help: help, labelNames: labelNames},
+		buckets: append([]float64{}, buckets...),
+		series:  map[string]*histogramSeries{},
+	}
+	sort.Float64s(h.buckets)
+	r.register(h)
+	return h
+}
+
+// Observe adds value for the given label values.
+func (h *Histogram) Observe(value float64, labelValues ...string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	labels := h.labels(labelValues)
+	series, ok := h.series[labels]
+	if !ok {
+		series = &histogramSeries{counts: make([]uint64, len(h.buckets))}
+		h.series[labels] = series
+	}
+
+	for i, bound := range h.buckets {
+		if value <= bound {
+			series.counts[i]++
+		}
+	}
+	series.count++
+	series.sum += value
+}
+
+func (h *Histogram) write(out io.Writer) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.header(out, "histogram")
+
+	keys := []string{}
+	for labels := range h.series {
+		keys = append(keys, labels)
+	}
+	sort.Strings(keys)
+
+	for _, labels := range keys {
+		series := h.series[labels]
+		for i, bound := range h.buckets {
+			fmt.Fprintf(out, "%s_bucket%s %d\n", h.name, braces(join(labels, `le="`+formatFloat(bound)+`"`)), series.counts[i])
+		}
+		fmt.Fprintf(out, "%s_bucket%s %d\n", h.name, braces(join(labels, `le="+Inf"`)), series.count)
+		fmt.Fprintf(out, "%s_sum%s %s\n", h.name, braces(labels), formatFloat(series.sum))
+		fmt.Fprintf(out, "%s_count%s %d\n", h.name, braces(labels), series.count)
+	}
+}
+
+// family is what all metrics share: a name, help text and label names.
+type family struct {
+	mutex      sync.Mutex
+	name       string
+	help       string
+	labelNames []string
+}
+
+func (f *family) header(out io.Writer, metricType string) {
+	help := strings.Replace(strings.Replace(f.help, `\`, `\\`, -1), "\n", `\n`, -1)
+	fmt.Fprintf(out, "# HELP %s %s\n", f.name, help)
+	fmt.Fprintf(out, "# TYPE %s %s\n", f.name, metricType)
+}
+
+// labels renders label values as name="value" pairs, which also serve as
+// the key of the series.
+func (f *family) labels(values []string) string {
+	if len(values) != len(f.labelNames) {
+		panic(fmt.Sprintf("%s has labels %v, got values %v", f.name, f.labelNames, values))
+	}
+
+	pairs := make([]string, len(values))
+	for i, value := range values {
+		pairs[i] = f.labelNames[i] + `="` + escape(value) + `"`
+	}
+	return strings.Join(pairs, ",")
+}
+
+func escape(value string) string {
+	value = strings.Replace(value, `\`, `\\`, -1)
+	value = strings.Replace(value, `"`, `\"`, -1)
+	return strings.Replace(value, "\n", `\n`, -1)
+}
+
+func join(labels, label string) string {
+	if labels == "" {
+		return label
+	}
+	return labels + "," + label
+}
+
+func braces(labels string) string {
+	if labels == "" {
+		return ""
+	}
+	return "{" + labels + "}"
+}
+
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func sortedKeys(values map[string]float64) []string {
+	keys := []string{}
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,75 @@
+package metrics_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/metrics"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Registry", func() {
+	var registry *metrics.Registry
+
+	BeforeEach(func() {
+		registry = metrics.NewRegistry()
+	})
+
+	write := func() string {
+		buffer := &bytes.Buffer{}
+		registry.Write(buffer)
+		return buffer.String()
+	}
+
+	It("writes gauges with their help, type and labels", func() {
+		gauge := registry.NewGauge("check_success", "Whether the check passed.", "plan", "protocol")
+		gauge.Set(1, "standard", "amqp")
+		gauge.Set(0, "standard", "mqtt")
+		gauge.Set(0, "standard", "amqp")
+
+		Expect(write()).To(Equal(`# HELP check_success Whether the check passed.
+# TYPE check_success gauge
+check_success{plan="standard",protocol="amqp"} 0
+check_success{plan="standard",protocol="mqtt"} 0
+`))
+	})
+
+	It("writes cumulative histogram buckets, the sum and the count", func() {
+		histogram := registry.NewHistogram("step_duration_seconds", "How long steps took.", []float64{10, 1}, "step")
+		histogram.Observe(0.5, "push")
+		histogram.Observe(2, "push")
+		histogram.Observe(20, "push")
+
+		Expect(write()).To(Equal(`# HELP step_duration_seconds How long steps took.
+# TYPE step_duration_seconds histogram
+step_duration_seconds_bucket{step="push",le="1"} 1
+step_duration_seconds_bucket{step="push",le="10"} 2
+step_duration_seconds_bucket{step="push",le="+Inf"} 3
+step_duration_seconds_sum{step="push"} 22.5
+step_duration_seconds_count{step="push"} 3
+`))
+	})
+
+	It("escapes label values", func() {
+		registry.NewGauge("plan_up", "Up.", "plan").Set(1, "a \"quoted\"\\plan\n")
+		Expect(write()).To(ContainSubstring(`plan_up{plan="a \"quoted\"\\plan\n"} 1`))
+	})
+
+	It("refuses label values that do not match the label names", func() {
+		gauge := registry.NewGauge("plan_up", "Up.", "plan")
+		Expect(func() { gauge.Set(1, "standard", "amqp") }).To(Panic())
+	})
+
+	It("serves the metrics over HTTP", func() {
+		registry.NewGauge("up", "Up.").Set(1)
+
+		recorder := httptest.NewRecorder()
+		registry.ServeHTTP(recorder, &http.Request{Method: "GET"})
+
+		Expect(recorder.Header().Get("Content-Type")).To(HavePrefix("text/plain; version=0.0.4"))
+		Expect(recorder.Body.String()).To(HaveSuffix("\nup 1\n"))
+	})
+})
@@ -0,0 +1,82 @@
+package notify
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/onsi/ginkgo/config"
+	"github.com/onsi/ginkgo/types"
+
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/report"
+)
+
+// GinkgoReporter is a ginkgo reporter that notifies the webhooks when the
+// suite ends. Failures outside of the lifecycle steps, like a failure to
+// set up the org, are reported by spec.
+type GinkgoReporter struct {
+	api      string
+	recorder *report.Recorder
+	notifier *Notifier
+
+	specFailures []Failure
+}
+
+func NewGinkgoReporter(api string, recorder *report.Recorder, notifier *Notifier) *GinkgoReporter {
+	return &GinkgoReporter{
+		api:      api,
+		recorder: recorder,
+		notifier: notifier,
+	}
+}
+
+func (r *GinkgoReporter) SpecSuiteWillBegin(config config.GinkgoConfigType, summary *types.SuiteSummary) {
+}
+
+func (r *GinkgoReporter) BeforeSuiteDidRun(setupSummary *types.SetupSummary) {
+	if setupSummary.State.IsFailure() {
+		r.specFailures = append(r.specFailures, Failure{Spec: "BeforeSuite", Error: excerpt(setupSummary.Failure.Message)})
+	}
+}
+
+func (r *GinkgoReporter) SpecWillRun(specSummary *types.SpecSummary) {
+}
+
+func (r *GinkgoReporter) SpecDidComplete(specSummary *types.SpecSummary) {
+	if specSummary.HasFailureState() {
+		r.specFailures = append(r.specFailures, Failure{
+			Spec:  strings.Join(specSummary.ComponentTexts[1:], " "),
+			Error: excerpt(specSummary.Failure.Message),
+		})
+	}
+}
+
+func (r *GinkgoReporter) AfterSuiteDidRun(setupSummary *types.SetupSummary) {
+	if setupSummary.State.IsFailure() {
+		r.specFailures = append(r.specFailures, Failure{Spec: "AfterSuite", Error: excerpt(setupSummary.Failure.Message)})
+	}
+}
+
+func (r *GinkgoReporter) SpecSuiteDidEnd(summary *types.SuiteSummary) {
+	if err := r.notifier.Notify(r.Run(summary.SuiteSucceeded, time.Now())); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to send the smoke tests notifications: %s\n", err.Error())
+	}
+}
+
+// Run describes the run, preferring failed steps over failed specs as they
+// say more precisely what broke.
+func (r *GinkgoReporter) Run(passed bool, finishedAt time.Time) Run {
+	failures := StepFailures(r.recorder)
+	if len(failures) == 0 && !passed {
+		failures = r.specFailures
+	}
+
+	return Run{
+		API:        r.api,
+		Passed:     passed,
+		StartedAt:  r.recorder.Start(),
+		FinishedAt: finishedAt,
+		Failures:   failures,
+	}
+}
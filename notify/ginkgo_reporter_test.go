@@ -0,0 +1,56 @@
+package notify_test
+
+import (
+	"time"
+
+	"github.com/onsi/ginkgo/types"
+
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/notify"
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/report"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("GinkgoReporter", func() {
+	var (
+		recorder *report.Recorder
+		reporter *notify.GinkgoReporter
+	)
+
+	BeforeEach(func() {
+		recorder = report.NewRecorder()
+		reporter = notify.NewGinkgoReporter("https://api.example.com", recorder, notify.NewNotifier(nil, time.Second))
+
+		reporter.SpecDidComplete(&types.SpecSummary{
+			ComponentTexts: []string{"[Top Level]", "RabbitMQ Service", "Can create the service instance"},
+			State:          types.SpecStateFailed,
+			Failure:        types.SpecFailure{Message: "Expected success"},
+		})
+	})
+
+	It("reports the failed steps", func() {
+		recorder.Record(report.Step{Plan: "standard", Protocol: "amqp", Name: "create", Outcome: report.Failed, Error: "plan is full"})
+
+		run := reporter.Run(false, time.Now())
+		Expect(run.API).To(Equal("https://api.example.com"))
+		Expect(run.Failures).To(Equal([]notify.Failure{{Plan: "standard", Protocol: "amqp", Step: "create", Error: "plan is full"}}))
+	})
+
+	It("falls back to the failed specs when no step failed", func() {
+		reporter.BeforeSuiteDidRun(&types.SetupSummary{
+			State:   types.SpecStateFailed,
+			Failure: types.SpecFailure{Message: "could not create the org"},
+		})
+
+		run := reporter.Run(false, time.Now())
+		Expect(run.Failures).To(Equal([]notify.Failure{
+			{Spec: "RabbitMQ Service Can create the service instance", Error: "Expected success"},
+			{Spec: "BeforeSuite", Error: "could not create the org"},
+		}))
+	})
+
+	It("reports no failures for a run that passed", func() {
+		Expect(reporter.Run(true, time.Now()).Failures).To(BeEmpty())
+	})
+})
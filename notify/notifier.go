@@ -0,0 +1,199 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/report"
+)
+
+// Formats of webhook payloads.
+const (
+	JSON  = "json"
+	Slack = "slack"
+)
+
+// errorExcerptLength keeps payloads small enough for chat services; the
+// artifacts have the full story.
+const errorExcerptLength = 1000
+
+// Webhook is an endpoint to tell about finished runs.
+type Webhook struct {
+	URL    string
+	Format string
+
+	// OnlyOnFailure skips runs that passed.
+	OnlyOnFailure bool
+}
+
+// Run is the outcome of a smoke test run.
+type Run struct {
+	API        string    `json:"api"`
+	Passed     bool      `json:"passed"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	Failures   []Failure `json:"failures"`
+}
+
+// Failure is a failed step, or a failed spec when no step failed.
+type Failure struct {
+	Plan      string   `json:"plan,omitempty"`
+	Protocol  string   `json:"protocol,omitempty"`
+	Step      string   `json:"step,omitempty"`
+	Spec      string   `json:"spec,omitempty"`
+	Error     string   `json:"error"`
+	Artifacts []string `json:"artifacts,omitempty"`
+}
+
+// StepFailures lists the failed steps in recorder.
+func StepFailures(recorder *report.Recorder) []Failure {
+	failures := []Failure{}
+	for _, step := range recorder.Steps() {
+		if step.Outcome != report.Failed {
+			continue
+		}
+		failures = append(failures, Failure{
+			Plan:      step.Plan,
+			Protocol:  step.Protocol,
+			Step:      step.Name,
+			Error:     excerpt(step.Error),
+			Artifacts: step.Artifacts,
+		})
+	}
+	return failures
+}
+
+func excerpt(message string) string {
+	if len(message) <= errorExcerptLength {
+		return message
+	}
+	end := errorExcerptLength
+	for end > 0 && !utf8.RuneStart(message[end]) {
+		end--
+	}
+	return message[:end] + "..."
+}
+
+// Notifier posts runs to webhooks, retrying each one a few times. It only
+// ever reports errors: a webhook outage must not fail the smoke tests.
+type Notifier struct {
+	Attempts      int
+	RetryInterval time.Duration
+
+	webhooks   []Webhook
+	httpClient *http.Client
+}
+
+func NewNotifier(webhooks []Webhook, timeout time.Duration) *Notifier {
+	return &Notifier{
+		Attempts:      3,
+		RetryInterval: 5 * time.Second,
+
+		webhooks:   webhooks,
+		httpClient: &http.Client{Timeout: timeout},
+	}
+}
+
+// Notify posts run to every webhook that wants it, and returns why any
+// could not be reached.
+func (n *Notifier) Notify(run Run) error {
+	failures := []string{}
+
+	for _, webhook := range n.webhooks {
+		if run.Passed && webhook.OnlyOnFailure {
+			continue
+		}
+
+		var payload interface{} = run
+		if webhook.Format == Slack {
+			payload = slackMessage(run)
+		}
+		body, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+
+		if err := n.post(webhook.URL, body); err != nil {
+			failures = append(failures, err.Error())
+		}
+	}
+
+	if len(failures) > 0 {
+		return fmt.Errorf("%s", strings.Join(failures, "\n"))
+	}
+	return nil
+}
+
+// post retries on connection failures, timeouts and server errors; a
+// client error will not go away by trying again.
+func (n *Notifier) post(address string, body []byte) error {
+	var err error
+	for attempt := 1; attempt <= n.Attempts; attempt++ {
+		if attempt > 1 {
+			time.Sleep(n.RetryInterval)
+		}
+
+		var response *http.Response
+		response, err = n.httpClient.Post(address, "application/json", bytes.NewReader(body))
+		if err != nil {
+			if urlErr, ok := err.(*url.Error); ok {
+				err = urlErr.Err
+			}
+			err = fmt.Errorf("Failed to notify %s: %s", redact(address), err.Error())
+			continue
+		}
+		io.Copy(ioutil.Discard, response.Body)
+		response.Body.Close()
+
+		if response.StatusCode < 300 {
+			return nil
+		}
+		err = fmt.Errorf("Failed to notify %s: %s", redact(address), response.Status)
+		if response.StatusCode < 500 && response.StatusCode != http.StatusTooManyRequests {
+			return err
+		}
+	}
+	return err
+}
+
+// redact keeps webhook URLs, which usually embed a secret token, out of
+// the logs.
+func redact(address string) string {
+	parts := strings.SplitN(address, "/", 4)
+	if len(parts) < 4 {
+		return address
+	}
+	return strings.Join(parts[:3], "/") + "/..."
+}
+
+type slackPayload struct {
+	Text string `json:"text"`
+}
+
+func slackMessage(run Run) slackPayload {
+	if run.Passed {
+		return slackPayload{Text: fmt.Sprintf(":white_check_mark: RabbitMQ smoke tests passed on %s", run.API)}
+	}
+
+	lines := []string{fmt.Sprintf(":red_circle: RabbitMQ smoke tests failed on %s", run.API)}
+	for _, failure := range run.Failures {
+		if failure.Step != "" {
+			lines = append(lines, fmt.Sprintf("*%s* over *%s*: the %s step failed", failure.Plan, failure.Protocol, failure.Step))
+		} else {
+			lines = append(lines, fmt.Sprintf("*%s* failed", failure.Spec))
+		}
+		lines = append(lines, "```"+strings.Replace(failure.Error, "```", "'''", -1)+"```")
+		if len(failure.Artifacts) > 0 {
+			lines = append(lines, "Artifacts: "+strings.Join(failure.Artifacts, ", "))
+		}
+	}
+	return slackPayload{Text: strings.Join(lines, "\n")}
+}
@@ -0,0 +1,156 @@
+package notify_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/notify"
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/report"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+)
+
+var _ = Describe("Notifier", func() {
+	var (
+		server   *ghttp.Server
+		recorder *report.Recorder
+		run      notify.Run
+	)
+
+	BeforeEach(func() {
+		server = ghttp.NewServer()
+
+		recorder = report.NewRecorder()
+		recorder.Record(report.Step{Plan: "standard", Protocol: "amqp", Name: "push", Outcome: report.Passed})
+		recorder.Record(report.Step{
+			Plan: "standard", Protocol: "mqtt", Name: "consume", Outcome: report.Failed,
+			Error:     "still failing after 25s: " + strings.Repeat("x", 2000),
+			Artifacts: []string{"/tmp/artifacts/app-logs.txt"},
+		})
+
+		run = notify.Run{
+			API:      "https://api.example.com",
+			Passed:   false,
+			Failures: notify.StepFailures(recorder),
+		}
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	notifier := func(webhooks ...notify.Webhook) *notify.Notifier {
+		n := notify.NewNotifier(webhooks, time.Second)
+		n.RetryInterval = time.Millisecond
+		return n
+	}
+
+	It("posts the failed steps as JSON", func() {
+		server.AppendHandlers(ghttp.CombineHandlers(
+			ghttp.VerifyRequest("POST", "/hook"),
+			ghttp.VerifyContentType("application/json"),
+			func(w http.ResponseWriter, r *http.Request) {
+				var payload map[string]interface{}
+				Expect(json.NewDecoder(r.Body).Decode(&payload)).To(Succeed())
+				Expect(payload).To(HaveKeyWithValue("api", "https://api.example.com"))
+				Expect(payload).To(HaveKeyWithValue("passed", false))
+
+				failures := payload["failures"].([]interface{})
+				Expect(failures).To(HaveLen(1))
+				failure := failures[0].(map[string]interface{})
+				Expect(failure).To(HaveKeyWithValue("plan", "standard"))
+				Expect(failure).To(HaveKeyWithValue("protocol", "mqtt"))
+				Expect(failure).To(HaveKeyWithValue("step", "consume"))
+				Expect(failure["error"]).To(HavePrefix("still failing after 25s"))
+				Expect(len(failure["error"].(string))).To(Equal(1003))
+				Expect(failure["artifacts"]).To(Equal([]interface{}{"/tmp/artifacts/app-logs.txt"}))
+			},
+		))
+
+		Expect(notifier(notify.Webhook{URL: server.URL() + "/hook"}).Notify(run)).To(Succeed())
+		Expect(server.ReceivedRequests()).To(HaveLen(1))
+	})
+
+	It("cuts long errors short without splitting a character", func() {
+		recorder = report.NewRecorder()
+		recorder.Record(report.Step{
+			Plan: "standard", Protocol: "amqp", Name: "consume", Outcome: report.Failed,
+			Error: "x" + strings.Repeat("é", 1000),
+		})
+
+		failures := notify.StepFailures(recorder)
+		Expect(failures).To(HaveLen(1))
+		Expect(utf8.ValidString(failures[0].Error)).To(BeTrue())
+		Expect(failures[0].Error).To(Equal("x" + strings.Repeat("é", 499) + "..."))
+	})
+
+	It("formats a Slack message", func() {
+		server.AppendHandlers(func(w http.ResponseWriter, r *http.Request) {
+			var payload map[string]string
+			Expect(json.NewDecoder(r.Body).Decode(&payload)).To(Succeed())
+			Expect(payload["text"]).To(HavePrefix(":red_circle: RabbitMQ smoke tests failed on https://api.example.com\n*standard* over *mqtt*: the consume step failed\n```still failing"))
+			Expect(payload["text"]).To(HaveSuffix("```\nArtifacts: /tmp/artifacts/app-logs.txt"))
+		})
+
+		Expect(notifier(notify.Webhook{URL: server.URL(), Format: notify.Slack}).Notify(run)).To(Succeed())
+	})
+
+	It("only notifies about passing runs when asked to", func() {
+		run.Passed = true
+		run.Failures = nil
+		server.AppendHandlers(ghttp.RespondWith(http.StatusOK, ""))
+
+		n := notifier(
+			notify.Webhook{URL: server.URL() + "/always"},
+			notify.Webhook{URL: server.URL() + "/failures", OnlyOnFailure: true},
+		)
+		Expect(n.Notify(run)).To(Succeed())
+
+		Expect(server.ReceivedRequests()).To(HaveLen(1))
+		Expect(server.ReceivedRequests()[0].URL.Path).To(Equal("/always"))
+	})
+
+	It("retries server errors", func() {
+		server.AppendHandlers(
+			ghttp.RespondWith(http.StatusBadGateway, ""),
+			ghttp.RespondWith(http.StatusTooManyRequests, ""),
+			ghttp.RespondWith(http.StatusOK, ""),
+		)
+
+		Expect(notifier(notify.Webhook{URL: server.URL()}).Notify(run)).To(Succeed())
+		Expect(server.ReceivedRequests()).To(HaveLen(3))
+	})
+
+	It("gives up without revealing the webhook's token", func() {
+		server.AllowUnhandledRequests = true
+		server.UnhandledRequestStatusCode = http.StatusServiceUnavailable
+
+		err := notifier(notify.Webhook{URL: server.URL() + "/services/secret-token"}).Notify(run)
+		Expect(err).To(MatchError("Failed to notify " + server.URL() + "/...: 503 Service Unavailable"))
+		Expect(server.ReceivedRequests()).To(HaveLen(3))
+	})
+
+	It("does not retry client errors", func() {
+		server.AppendHandlers(ghttp.RespondWith(http.StatusNotFound, ""))
+
+		Expect(notifier(notify.Webhook{URL: server.URL()}).Notify(run)).NotTo(Succeed())
+		Expect(server.ReceivedRequests()).To(HaveLen(1))
+	})
+
+	It("times out on webhooks that do not answer", func() {
+		server.AppendHandlers(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(200 * time.Millisecond)
+		})
+		n := notify.NewNotifier([]notify.Webhook{{URL: server.URL() + "/secret"}}, 50*time.Millisecond)
+		n.Attempts = 1
+
+		err := n.Notify(run)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).NotTo(ContainSubstring("secret"))
+	})
+})
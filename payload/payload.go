@@ -0,0 +1,166 @@
+package payload
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"math/rand"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Kinds of payload in the matrix.
+const (
+	Empty  = "empty"
+	UTF8   = "utf8"
+	Binary = "binary"
+	Sizes  = "sizes"
+)
+
+// Kinds lists every kind, in the order the matrix sends them.
+var Kinds = []string{Empty, UTF8, Binary, Sizes}
+
+// DefaultMaxSize bounds the sized payloads of plans without a maximum of
+// their own.
+const DefaultMaxSize = 128 * 1024
+
+// utf8Text has characters of one, two, three and four bytes, including a
+// combining mark and a right-to-left script.
+const utf8Text = "ASCII, Ünïcödé, Ελληνικά, Кириллица, עברית, العربية, 日本語, 中文, 한국어, é, 🐇📬✅"
+
+// Payload is a message body to send through the app. The app carries text,
+// so Binary payloads travel base64 encoded and are decoded before they are
+// compared.
+type Payload struct {
+	Name   string
+	Data   []byte
+	Binary bool
+}
+
+// Encoded is the message to publish.
+func (p Payload) Encoded() string {
+	if p.Binary {
+		return base64.StdEncoding.EncodeToString(p.Data)
+	}
+	return string(p.Data)
+}
+
+func (p Payload) Checksum() string {
+	return checksum(p.Data)
+}
+
+// Verify compares the message read back with the payload, and says how it
+// was mangled when it does not match.
+func (p Payload) Verify(received string) error {
+	data := []byte(received)
+	if p.Binary {
+		decoded, err := base64.StdEncoding.DecodeString(received)
+		if err != nil {
+			return fmt.Errorf("payload %s is corrupted: it is no longer valid base64: %s", p.Name, err.Error())
+		}
+		data = decoded
+	}
+
+	if bytes.Equal(data, p.Data) {
+		return nil
+	}
+
+	problem := "corrupted"
+	if len(data) < len(p.Data) && bytes.HasPrefix(p.Data, data) {
+		problem = "truncated"
+	}
+	return fmt.Errorf("payload %s is %s: sent %d bytes with sha256 %s, received %d bytes with sha256 %s",
+		p.Name, problem, len(p.Data), p.Checksum(), len(data), checksum(data))
+}
+
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Matrix builds the payloads of the given kinds. Sizes gives payloads of
+// 1 KiB, then four times bigger each time, up to and including maxSize.
+func Matrix(kinds []string, maxSize int) ([]Payload, error) {
+	payloads := []Payload{}
+	for _, kind := range kinds {
+		switch kind {
+		case Empty:
+			payloads = append(payloads, Payload{Name: Empty, Data: []byte{}})
+		case UTF8:
+			payloads = append(payloads, Payload{Name: UTF8, Data: []byte(utf8Text)})
+		case Binary:
+			data := make([]byte, 1024)
+			for i := range data {
+				data[i] = byte(i)
+			}
+			payloads = append(payloads, Payload{Name: Binary, Data: data, Binary: true})
+		case Sizes:
+			if maxSize <= 0 {
+				return nil, fmt.Errorf("the maximum payload size must be positive, got %d", maxSize)
+			}
+			for _, size := range sizes(maxSize) {
+				payloads = append(payloads, Payload{Name: "size-" + FormatSize(size), Data: text(size)})
+			}
+		default:
+			return nil, fmt.Errorf("unknown payload kind %q, expected one of %s", kind, strings.Join(Kinds, ", "))
+		}
+	}
+	return payloads, nil
+}
+
+func sizes(maxSize int) []int {
+	sizes := []int{}
+	for size := 1024; size < maxSize; size *= 4 {
+		sizes = append(sizes, size)
+	}
+	return append(sizes, maxSize)
+}
+
+// text is printable ASCII, so it has the same size however the app encodes
+// it. It is seeded by its size, so a run can be reproduced.
+func text(size int) []byte {
+	const alphabet = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+	random := rand.New(rand.NewSource(int64(size)))
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = alphabet[random.Intn(len(alphabet))]
+	}
+	return data
+}
+
+var sizePattern = regexp.MustCompile(`^\s*(\d+)\s*(B|KiB|MiB)?\s*$`)
+
+// ParseSize reads a size in bytes, like "4096", "128KiB" or "1MiB".
+func ParseSize(value string) (int, error) {
+	match := sizePattern.FindStringSubmatch(value)
+	if match == nil {
+		return 0, fmt.Errorf("invalid size %q, expected bytes, KiB or MiB, like 128KiB", value)
+	}
+
+	size, err := strconv.Atoi(match[1])
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q: %s", value, err.Error())
+	}
+	switch match[2] {
+	case "KiB":
+		size *= 1024
+	case "MiB":
+		size *= 1024 * 1024
+	}
+	return size, nil
+}
+
+// FormatSize writes size in the biggest unit that divides it.
+func FormatSize(size int) string {
+	switch {
+	case size >= 1024*1024 && size%(1024*1024) == 0:
+		return fmt.Sprintf("%dMiB", size/(1024*1024))
+	case size >= 1024 && size%1024 == 0:
+		return fmt.Sprintf("%dKiB", size/1024)
+	}
+	return fmt.Sprintf("%dB", size)
+}
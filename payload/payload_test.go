@@ -0,0 +1,90 @@
+package payload_test
+
+import (
+	"encoding/base64"
+	"unicode/utf8"
+
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/payload"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Payload", func() {
+	names := func(payloads []payload.Payload) []string {
+		result := []string{}
+		for _, p := range payloads {
+			result = append(result, p.Name)
+		}
+		return result
+	}
+
+	Describe("Matrix", func() {
+		It("builds every kind, with sizes up to the maximum", func() {
+			payloads, err := payload.Matrix(payload.Kinds, 128*1024)
+			Ω(err).ShouldNot(HaveOccurred())
+
+			Expect(names(payloads)).To(Equal([]string{"empty", "utf8", "binary", "size-1KiB", "size-4KiB", "size-16KiB", "size-64KiB", "size-128KiB"}))
+			Expect(payloads[0].Data).To(BeEmpty())
+			Expect(utf8.Valid(payloads[1].Data)).To(BeTrue())
+			Expect(len(payloads[1].Data)).To(BeNumerically(">", utf8.RuneCount(payloads[1].Data)))
+			Expect(payloads[2].Binary).To(BeTrue())
+			Expect(payloads[7].Data).To(HaveLen(128 * 1024))
+		})
+
+		It("builds only the kinds asked for", func() {
+			payloads, err := payload.Matrix([]string{payload.Sizes}, 1000)
+			Ω(err).ShouldNot(HaveOccurred())
+			Expect(names(payloads)).To(Equal([]string{"size-1000B"}))
+		})
+
+		It("rejects unknown kinds", func() {
+			_, err := payload.Matrix([]string{"huge"}, 1024)
+			Expect(err).To(MatchError(ContainSubstring(`unknown payload kind "huge"`)))
+		})
+	})
+
+	Describe("Verify", func() {
+		var p payload.Payload
+
+		BeforeEach(func() {
+			payloads, err := payload.Matrix([]string{payload.Sizes}, 1024)
+			Ω(err).ShouldNot(HaveOccurred())
+			p = payloads[0]
+		})
+
+		It("accepts the payload unchanged", func() {
+			Expect(p.Verify(p.Encoded())).To(Succeed())
+		})
+
+		It("reports truncation", func() {
+			err := p.Verify(p.Encoded()[:1000])
+			Expect(err).To(MatchError(ContainSubstring("payload size-1KiB is truncated: sent 1024 bytes with sha256 " + p.Checksum() + ", received 1000 bytes")))
+		})
+
+		It("reports corruption", func() {
+			err := p.Verify("x" + p.Encoded()[1:])
+			Expect(err).To(MatchError(ContainSubstring("payload size-1KiB is corrupted: sent 1024 bytes")))
+		})
+
+		It("decodes binary payloads before comparing them", func() {
+			binary := payload.Payload{Name: "binary", Data: []byte{0, 255, 10, 13}, Binary: true}
+			Expect(binary.Encoded()).To(Equal(base64.StdEncoding.EncodeToString(binary.Data)))
+			Expect(binary.Verify(binary.Encoded())).To(Succeed())
+			Expect(binary.Verify("not base64!")).To(MatchError(ContainSubstring("no longer valid base64")))
+		})
+	})
+
+	Describe("ParseSize", func() {
+		It("reads bytes, KiB and MiB", func() {
+			Expect(payload.ParseSize("4096")).To(Equal(4096))
+			Expect(payload.ParseSize("128KiB")).To(Equal(128 * 1024))
+			Expect(payload.ParseSize("1MiB")).To(Equal(1024 * 1024))
+		})
+
+		It("rejects other units", func() {
+			_, err := payload.ParseSize("1GB")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
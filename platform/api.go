@@ -0,0 +1,509 @@
+package platform
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/ccv3"
+)
+
+type api struct {
+	client *ccv3.Client
+
+	orgName    string
+	spaceName  string
+	appsDomain string
+
+	timeout      time.Duration
+	startTimeout time.Duration
+
+	mutex     sync.Mutex
+	spaceGUID string
+}
+
+// NewAPI returns a Platform backed by the Cloud Controller v3 API. It acts
+// on the given org and space, which must already exist.
+func NewAPI(client *ccv3.Client, orgName, spaceName, appsDomain string, timeout, startTimeout time.Duration) Platform {
+	return &api{
+		client:       client,
+		orgName:      orgName,
+		spaceName:    spaceName,
+		appsDomain:   appsDomain,
+		timeout:      timeout,
+		startTimeout: startTimeout,
+	}
+}
+
+func (p *api) PushApp(appName, appPath string) error {
+	spaceGUID, err := p.space()
+	if err != nil {
+		return err
+	}
+
+	app, err := p.client.CreateApp(appName, spaceGUID, appStack)
+	if err != nil {
+		return err
+	}
+
+	if err := p.client.ScaleProcess(app.GUID, "web", 0, 256); err != nil {
+		return err
+	}
+
+	domain, err := p.client.FindDomain(p.appsDomain)
+	if err != nil {
+		return err
+	}
+	route, err := p.client.CreateRoute(spaceGUID, domain.GUID, appName)
+	if err != nil {
+		return err
+	}
+	if err := p.client.MapRoute(route.GUID, app.GUID); err != nil {
+		return err
+	}
+
+	bits, err := zipApp(appPath)
+	if err != nil {
+		return err
+	}
+
+	pkg, err := p.client.CreatePackage(app.GUID)
+	if err != nil {
+		return err
+	}
+	if err := p.client.UploadPackage(pkg.GUID, bits); err != nil {
+		return err
+	}
+
+	return p.client.Poll(p.timeout, "package "+pkg.GUID+" to be processed", func() (bool, error) {
+		current, err := p.client.GetPackage(pkg.GUID)
+		if err != nil {
+			return false, err
+		}
+		switch current.State {
+		case "READY":
+			return true, nil
+		case "FAILED", "EXPIRED":
+			return false, fmt.Errorf("package %s for app %s is %s", pkg.GUID, appName, current.State)
+		}
+		return false, nil
+	})
+}
+
+// StartApp stages the app's latest package, like cf start does for an app
+// pushed with --no-start, and waits for an instance to be running.
+func (p *api) StartApp(appName string) error {
+	app, err := p.app(appName)
+	if err != nil {
+		return err
+	}
+
+	pkg, err := p.client.LatestPackage(app.GUID)
+	if err != nil {
+		return err
+	}
+
+	build, err := p.client.CreateBuild(pkg.GUID)
+	if err != nil {
+		return err
+	}
+
+	err = p.client.Poll(p.startTimeout, "app "+appName+" to stage", func() (bool, error) {
+		build, err = p.client.GetBuild(build.GUID)
+		if err != nil {
+			return false, err
+		}
+		switch build.State {
+		case "STAGED":
+			return true, nil
+		case "FAILED":
+			return false, fmt.Errorf("staging app %s failed: %s", appName, build.Error)
+		}
+		return false, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if build.Droplet == nil {
+		return fmt.Errorf("staging app %s produced no droplet", appName)
+	}
+	if err := p.client.SetCurrentDroplet(app.GUID, build.Droplet.GUID); err != nil {
+		return err
+	}
+
+	if err := p.client.StartApp(app.GUID); err != nil {
+		return err
+	}
+	return p.waitForRunning(app, appName)
+}
+
+// RestartApp stops the app and starts it again on its current droplet.
+func (p *api) RestartApp(appName string) error {
+	app, err := p.app(appName)
+	if err != nil {
+		return err
+	}
+
+	if err := p.client.StopApp(app.GUID); err != nil {
+		return err
+	}
+	if err := p.client.StartApp(app.GUID); err != nil {
+		return err
+	}
+	return p.waitForRunning(app, appName)
+}
+
+// ScaleApp changes the number of instances of the app's web process and
+// waits for all of them to be running.
+func (p *api) ScaleApp(appName string, instances int) error {
+	app, err := p.app(appName)
+	if err != nil {
+		return err
+	}
+
+	if err := p.client.ScaleProcess(app.GUID, "web", instances, 0); err != nil {
+		return err
+	}
+	return p.waitForInstances(app, appName, instances)
+}
+
+func (p *api) AppGUID(appName string) (string, error) {
+	app, err := p.app(appName)
+	if err != nil {
+		return "", err
+	}
+	return app.GUID, nil
+}
+
+// waitForRunning waits for an instance of the app to be running, and fails
+// as soon as one crashes.
+func (p *api) waitForRunning(app ccv3.App, appName string) error {
+	return p.waitForInstances(app, appName, 1)
+}
+
+// waitForInstances waits for count instances of the app to be running, and
+// fails as soon as one crashes.
+func (p *api) waitForInstances(app ccv3.App, appName string, count int) error {
+	description := "app " + appName + " to start"
+	if count > 1 {
+		description = fmt.Sprintf("%d instances of app %s to run", count, appName)
+	}
+
+	return p.client.Poll(p.startTimeout, description, func() (bool, error) {
+		instances, err := p.client.ProcessStats(app.GUID, "web")
+		if err != nil {
+			return false, err
+		}
+		running := 0
+		for _, instance := range instances {
+			if instance.State == "CRASHED" {
+				return false, fmt.Errorf("app %s instance %d crashed", appName, instance.Index)
+			}
+			if instance.State == "RUNNING" {
+				running++
+			}
+		}
+		return running >= count, nil
+	})
+}
+
+func (p *api) DeleteApp(appName string) error {
+	app, err := p.app(appName)
+	if err != nil {
+		return err
+	}
+
+	jobURL, err := p.client.DeleteApp(app.GUID)
+	if err != nil {
+		return err
+	}
+	return p.client.WaitForJob(jobURL, p.timeout)
+}
+
+func (p *api) SetEnv(appName, name, value string) error {
+	app, err := p.app(appName)
+	if err != nil {
+		return err
+	}
+
+	return p.client.UpdateEnvironmentVariables(app.GUID, map[string]string{name: value})
+}
+
+func (p *api) CreateService(serviceName, planName, instanceName string) error {
+	spaceGUID, err := p.space()
+	if err != nil {
+		return err
+	}
+
+	plan, err := p.client.FindServicePlan(serviceName, planName)
+	if err != nil {
+		return err
+	}
+
+	jobURL, err := p.client.CreateServiceInstance(instanceName, spaceGUID, plan.GUID)
+	if err != nil {
+		return err
+	}
+	return p.client.WaitForJob(jobURL, p.timeout)
+}
+
+func (p *api) DeleteService(instanceName string) error {
+	instance, err := p.serviceInstance(instanceName)
+	if err != nil {
+		return err
+	}
+
+	jobURL, err := p.client.DeleteServiceInstance(instance.GUID)
+	if err != nil {
+		return err
+	}
+	return p.client.WaitForJob(jobURL, p.timeout)
+}
+
+func (p *api) BindService(appName, instanceName string) error {
+	app, err := p.app(appName)
+	if err != nil {
+		return err
+	}
+	instance, err := p.serviceInstance(instanceName)
+	if err != nil {
+		return err
+	}
+
+	jobURL, err := p.client.CreateAppBinding(instance.GUID, app.GUID)
+	if err != nil {
+		return err
+	}
+	return p.client.WaitForJob(jobURL, p.timeout)
+}
+
+func (p *api) UnbindService(appName, instanceName string) error {
+	binding, err := p.appBinding(appName, instanceName)
+	if err != nil {
+		return err
+	}
+
+	jobURL, err := p.client.DeleteServiceCredentialBinding(binding.GUID)
+	if err != nil {
+		return err
+	}
+	return p.client.WaitForJob(jobURL, p.timeout)
+}
+
+func (p *api) BindingCredentials(appName, instanceName string) (map[string]interface{}, error) {
+	binding, err := p.appBinding(appName, instanceName)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.client.ServiceCredentialBindingCredentials(binding.GUID)
+}
+
+func (p *api) CreateServiceKey(instanceName, keyName string) error {
+	instance, err := p.serviceInstance(instanceName)
+	if err != nil {
+		return err
+	}
+
+	jobURL, err := p.client.CreateServiceKey(instance.GUID, keyName)
+	if err != nil {
+		return err
+	}
+	return p.client.WaitForJob(jobURL, p.timeout)
+}
+
+func (p *api) ServiceKey(instanceName, keyName string) (map[string]interface{}, error) {
+	key, err := p.serviceKey(instanceName, keyName)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.client.ServiceCredentialBindingCredentials(key.GUID)
+}
+
+func (p *api) DeleteServiceKey(instanceName, keyName string) error {
+	key, err := p.serviceKey(instanceName, keyName)
+	if err != nil {
+		return err
+	}
+
+	jobURL, err := p.client.DeleteServiceCredentialBinding(key.GUID)
+	if err != nil {
+		return err
+	}
+	return p.client.WaitForJob(jobURL, p.timeout)
+}
+
+func (p *api) ShareService(instanceName, spaceName string) error {
+	instance, err := p.serviceInstance(instanceName)
+	if err != nil {
+		return err
+	}
+	spaceGUID, err := p.client.FindSpaceGUID(p.orgName, spaceName)
+	if err != nil {
+		return err
+	}
+	return p.client.ShareServiceInstance(instance.GUID, spaceGUID)
+}
+
+func (p *api) UnshareService(instanceName, spaceName string) error {
+	instance, err := p.serviceInstance(instanceName)
+	if err != nil {
+		return err
+	}
+	spaceGUID, err := p.client.FindSpaceGUID(p.orgName, spaceName)
+	if err != nil {
+		return err
+	}
+	return p.client.UnshareServiceInstance(instance.GUID, spaceGUID)
+}
+
+func (p *api) InSpace(spaceName string) Platform {
+	return NewAPI(p.client, p.orgName, spaceName, p.appsDomain, p.timeout, p.startTimeout)
+}
+
+func (p *api) space() (string, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.spaceGUID == "" {
+		guid, err := p.client.FindSpaceGUID(p.orgName, p.spaceName)
+		if err != nil {
+			return "", err
+		}
+		p.spaceGUID = guid
+	}
+	return p.spaceGUID, nil
+}
+
+func (p *api) app(appName string) (ccv3.App, error) {
+	spaceGUID, err := p.space()
+	if err != nil {
+		return ccv3.App{}, err
+	}
+	return p.client.FindApp(spaceGUID, appName)
+}
+
+func (p *api) serviceInstance(instanceName string) (ccv3.ServiceInstance, error) {
+	spaceGUID, err := p.space()
+	if err != nil {
+		return ccv3.ServiceInstance{}, err
+	}
+	return p.client.FindServiceInstance(spaceGUID, instanceName)
+}
+
+func (p *api) appBinding(appName, instanceName string) (ccv3.ServiceCredentialBinding, error) {
+	app, err := p.app(appName)
+	if err != nil {
+		return ccv3.ServiceCredentialBinding{}, err
+	}
+
+	// by name, as an instance unshared from the app's space is no longer
+	// found there
+	bindings, err := p.client.FindServiceCredentialBindings(url.Values{
+		"type":                   {"app"},
+		"app_guids":              {app.GUID},
+		"service_instance_names": {instanceName},
+	})
+	if err != nil {
+		return ccv3.ServiceCredentialBinding{}, err
+	}
+	if len(bindings) == 0 {
+		return ccv3.ServiceCredentialBinding{}, &NotBoundError{AppName: appName, InstanceName: instanceName}
+	}
+	return bindings[0], nil
+}
+
+func (p *api) serviceKey(instanceName, keyName string) (ccv3.ServiceCredentialBinding, error) {
+	instance, err := p.serviceInstance(instanceName)
+	if err != nil {
+		return ccv3.ServiceCredentialBinding{}, err
+	}
+
+	keys, err := p.client.FindServiceCredentialBindings(url.Values{
+		"type":                   {"key"},
+		"names":                  {keyName},
+		"service_instance_guids": {instance.GUID},
+	})
+	if err != nil {
+		return ccv3.ServiceCredentialBinding{}, err
+	}
+	if len(keys) == 0 {
+		return ccv3.ServiceCredentialBinding{}, fmt.Errorf("service key %s of %s: %s", keyName, instanceName, ccv3.ErrNotFound)
+	}
+	return keys[0], nil
+}
+
+// zipApp returns the app bits to upload. Archives are sent as they are,
+// directories are zipped the way cf push does.
+func zipApp(appPath string) (io.Reader, error) {
+	info, err := os.Stat(appPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if !info.IsDir() {
+		contents, err := ioutil.ReadFile(appPath)
+		if err != nil {
+			return nil, err
+		}
+		return bytes.NewReader(contents), nil
+	}
+
+	buffer := &bytes.Buffer{}
+	archive := zip.NewWriter(buffer)
+
+	err = filepath.Walk(appPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relative, err := filepath.Rel(appPath, path)
+		if err != nil || relative == "." {
+			return err
+		}
+		if info.IsDir() && info.Name() == ".git" {
+			return filepath.SkipDir
+		}
+
+		header, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(relative)
+		if info.IsDir() {
+			header.Name += "/"
+			_, err = archive.CreateHeader(header)
+			return err
+		}
+		header.Method = zip.Deflate
+
+		writer, err := archive.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		_, err = io.Copy(writer, file)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := archive.Close(); err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(buffer.Bytes()), nil
+}
@@ -0,0 +1,282 @@
+package platform_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/ccv3"
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/platform"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+)
+
+var _ = Describe("API", func() {
+	var (
+		server *ghttp.Server
+		api    platform.Platform
+	)
+
+	BeforeEach(func() {
+		server = ghttp.NewServer()
+		server.RouteToHandler("GET", "/", ghttp.RespondWithJSONEncoded(http.StatusOK, map[string]interface{}{
+			"links": map[string]interface{}{
+				"login": map[string]string{"href": server.URL()},
+			},
+		}))
+		server.RouteToHandler("POST", "/oauth/token", ghttp.RespondWith(http.StatusOK, `{"access_token":"fake-token","expires_in":3600}`))
+		server.RouteToHandler("GET", "/v3/organizations", ghttp.CombineHandlers(
+			ghttp.VerifyRequest("GET", "/v3/organizations", "names=fake-org"),
+			ghttp.RespondWith(http.StatusOK, `{"resources":[{"guid":"org-guid"}]}`),
+		))
+		server.RouteToHandler("GET", "/v3/spaces", ghttp.CombineHandlers(
+			ghttp.VerifyRequest("GET", "/v3/spaces", "names=fake-space&organization_guids=org-guid"),
+			ghttp.RespondWith(http.StatusOK, `{"resources":[{"guid":"space-guid"}]}`),
+		))
+
+		client := ccv3.NewClient(server.URL(), "fake-user", "fake-password", false)
+		client.PollInterval = time.Millisecond
+		api = platform.NewAPI(client, "fake-org", "fake-space", "fake-domain", time.Second, time.Second)
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("creates a service instance in the space and waits for the job", func() {
+		server.AppendHandlers(
+			ghttp.CombineHandlers(
+				ghttp.VerifyRequest("GET", "/v3/service_plans", "names=standard&service_offering_names=p-rabbitmq"),
+				ghttp.RespondWith(http.StatusOK, `{"resources":[{"guid":"plan-guid","name":"standard"}]}`),
+			),
+			ghttp.CombineHandlers(
+				ghttp.VerifyRequest("POST", "/v3/service_instances"),
+				ghttp.VerifyJSON(`{
+					"type": "managed",
+					"name": "fake-instance",
+					"relationships": {
+						"space": {"data": {"guid": "space-guid"}},
+						"service_plan": {"data": {"guid": "plan-guid"}}
+					}
+				}`),
+				ghttp.RespondWith(http.StatusAccepted, "", http.Header{"Location": {server.URL() + "/v3/jobs/job-guid"}}),
+			),
+			ghttp.CombineHandlers(
+				ghttp.VerifyRequest("GET", "/v3/jobs/job-guid"),
+				ghttp.RespondWith(http.StatusOK, `{"guid":"job-guid","state":"COMPLETE"}`),
+			),
+		)
+
+		Expect(api.CreateService("p-rabbitmq", "standard", "fake-instance")).To(Succeed())
+	})
+
+	It("binds the app to the service instance", func() {
+		server.AppendHandlers(
+			ghttp.CombineHandlers(
+				ghttp.VerifyRequest("GET", "/v3/apps", "names=fake-app&space_guids=space-guid"),
+				ghttp.RespondWith(http.StatusOK, `{"resources":[{"guid":"app-guid"}]}`),
+			),
+			ghttp.CombineHandlers(
+				ghttp.VerifyRequest("GET", "/v3/service_instances", "names=fake-instance&space_guids=space-guid"),
+				ghttp.RespondWith(http.StatusOK, `{"resources":[{"guid":"instance-guid"}]}`),
+			),
+			ghttp.CombineHandlers(
+				ghttp.VerifyRequest("POST", "/v3/service_credential_bindings"),
+				ghttp.VerifyJSON(`{
+					"type": "app",
+					"relationships": {
+						"service_instance": {"data": {"guid": "instance-guid"}},
+						"app": {"data": {"guid": "app-guid"}}
+					}
+				}`),
+				ghttp.RespondWith(http.StatusCreated, `{"guid":"binding-guid"}`),
+			),
+		)
+
+		Expect(api.BindService("fake-app", "fake-instance")).To(Succeed())
+	})
+
+	It("shares the service instance into another space and takes it back", func() {
+		server.RouteToHandler("GET", "/v3/spaces", func(w http.ResponseWriter, request *http.Request) {
+			guid := map[string]string{"fake-space": "space-guid", "other-space": "other-space-guid"}[request.URL.Query().Get("names")]
+			w.Write([]byte(`{"resources":[{"guid":"` + guid + `"}]}`))
+		})
+		instanceHandler := ghttp.CombineHandlers(
+			ghttp.VerifyRequest("GET", "/v3/service_instances", "names=fake-instance&space_guids=space-guid"),
+			ghttp.RespondWith(http.StatusOK, `{"resources":[{"guid":"instance-guid"}]}`),
+		)
+		server.AppendHandlers(
+			instanceHandler,
+			ghttp.CombineHandlers(
+				ghttp.VerifyRequest("POST", "/v3/service_instances/instance-guid/relationships/shared_spaces"),
+				ghttp.VerifyJSON(`{"data": [{"guid": "other-space-guid"}]}`),
+				ghttp.RespondWith(http.StatusOK, `{"data":[{"guid":"other-space-guid"}]}`),
+			),
+			ghttp.CombineHandlers(
+				ghttp.VerifyRequest("GET", "/v3/apps", "names=fake-app&space_guids=other-space-guid"),
+				ghttp.RespondWith(http.StatusOK, `{"resources":[{"guid":"app-guid"}]}`),
+			),
+			instanceHandler,
+			ghttp.CombineHandlers(
+				ghttp.VerifyRequest("DELETE", "/v3/service_instances/instance-guid/relationships/shared_spaces/other-space-guid"),
+				ghttp.RespondWith(http.StatusNoContent, ""),
+			),
+		)
+
+		Expect(api.ShareService("fake-instance", "other-space")).To(Succeed())
+		Expect(api.InSpace("other-space").AppGUID("fake-app")).To(Equal("app-guid"))
+		Expect(api.UnshareService("fake-instance", "other-space")).To(Succeed())
+	})
+
+	It("reads the credentials of the app's binding", func() {
+		server.AppendHandlers(
+			ghttp.CombineHandlers(
+				ghttp.VerifyRequest("GET", "/v3/apps", "names=fake-app&space_guids=space-guid"),
+				ghttp.RespondWith(http.StatusOK, `{"resources":[{"guid":"app-guid"}]}`),
+			),
+			ghttp.CombineHandlers(
+				ghttp.VerifyRequest("GET", "/v3/service_credential_bindings", "app_guids=app-guid&service_instance_names=fake-instance&type=app"),
+				ghttp.RespondWith(http.StatusOK, `{"resources":[{"guid":"binding-guid"}]}`),
+			),
+			ghttp.CombineHandlers(
+				ghttp.VerifyRequest("GET", "/v3/service_credential_bindings/binding-guid/details"),
+				ghttp.RespondWith(http.StatusOK, `{"credentials":{"username":"fake-user"}}`),
+			),
+		)
+
+		credentials, err := api.BindingCredentials("fake-app", "fake-instance")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(credentials).To(HaveKeyWithValue("username", "fake-user"))
+	})
+
+	It("tells an app without a binding from a failed lookup", func() {
+		server.AppendHandlers(
+			ghttp.RespondWith(http.StatusOK, `{"resources":[{"guid":"app-guid"}]}`),
+			ghttp.CombineHandlers(
+				ghttp.VerifyRequest("GET", "/v3/service_credential_bindings", "app_guids=app-guid&service_instance_names=fake-instance&type=app"),
+				ghttp.RespondWith(http.StatusOK, `{"resources":[]}`),
+			),
+			ghttp.RespondWith(http.StatusOK, `{"resources":[{"guid":"app-guid"}]}`),
+			ghttp.RespondWith(http.StatusServiceUnavailable, `{"errors":[{"detail":"Service unavailable"}]}`),
+		)
+
+		_, err := api.BindingCredentials("fake-app", "fake-instance")
+		Expect(err).To(MatchError(&platform.NotBoundError{AppName: "fake-app", InstanceName: "fake-instance"}))
+
+		_, err = api.BindingCredentials("fake-app", "fake-instance")
+		Expect(err).To(HaveOccurred())
+		Expect(err).NotTo(BeAssignableToTypeOf(&platform.NotBoundError{}))
+	})
+
+	It("restarts the app and waits for an instance to run", func() {
+		server.AppendHandlers(
+			ghttp.CombineHandlers(
+				ghttp.VerifyRequest("GET", "/v3/apps", "names=fake-app&space_guids=space-guid"),
+				ghttp.RespondWith(http.StatusOK, `{"resources":[{"guid":"app-guid"}]}`),
+			),
+			ghttp.CombineHandlers(
+				ghttp.VerifyRequest("POST", "/v3/apps/app-guid/actions/stop"),
+				ghttp.RespondWith(http.StatusOK, `{}`),
+			),
+			ghttp.CombineHandlers(
+				ghttp.VerifyRequest("POST", "/v3/apps/app-guid/actions/start"),
+				ghttp.RespondWith(http.StatusOK, `{}`),
+			),
+			ghttp.CombineHandlers(
+				ghttp.VerifyRequest("GET", "/v3/apps/app-guid/processes/web/stats"),
+				ghttp.RespondWith(http.StatusOK, `{"resources":[{"index":0,"state":"STARTING"}]}`),
+			),
+			ghttp.CombineHandlers(
+				ghttp.VerifyRequest("GET", "/v3/apps/app-guid/processes/web/stats"),
+				ghttp.RespondWith(http.StatusOK, `{"resources":[{"index":0,"state":"RUNNING"}]}`),
+			),
+		)
+
+		Expect(api.RestartApp("fake-app")).To(Succeed())
+	})
+
+	It("scales the app and waits for every instance to run", func() {
+		server.AppendHandlers(
+			ghttp.CombineHandlers(
+				ghttp.VerifyRequest("GET", "/v3/apps", "names=fake-app&space_guids=space-guid"),
+				ghttp.RespondWith(http.StatusOK, `{"resources":[{"guid":"app-guid"}]}`),
+			),
+			ghttp.CombineHandlers(
+				ghttp.VerifyRequest("POST", "/v3/apps/app-guid/processes/web/actions/scale"),
+				ghttp.VerifyJSON(`{"instances":2}`),
+				ghttp.RespondWith(http.StatusAccepted, `{}`),
+			),
+			ghttp.CombineHandlers(
+				ghttp.VerifyRequest("GET", "/v3/apps/app-guid/processes/web/stats"),
+				ghttp.RespondWith(http.StatusOK, `{"resources":[{"index":0,"state":"RUNNING"},{"index":1,"state":"STARTING"}]}`),
+			),
+			ghttp.CombineHandlers(
+				ghttp.VerifyRequest("GET", "/v3/apps/app-guid/processes/web/stats"),
+				ghttp.RespondWith(http.StatusOK, `{"resources":[{"index":0,"state":"RUNNING"},{"index":1,"state":"RUNNING"}]}`),
+			),
+		)
+
+		Expect(api.ScaleApp("fake-app", 2)).To(Succeed())
+	})
+
+	It("reports a missing app", func() {
+		server.AppendHandlers(ghttp.RespondWith(http.StatusOK, `{"resources":[]}`))
+
+		Expect(api.StartApp("fake-app")).To(MatchError("app fake-app: resource not found"))
+	})
+
+	It("uploads the zipped app directory and waits for the package", func() {
+		appDir, err := ioutil.TempDir("", "fake-app")
+		Expect(err).NotTo(HaveOccurred())
+		defer os.RemoveAll(appDir)
+		Expect(ioutil.WriteFile(filepath.Join(appDir, "app.rb"), []byte("puts 'hi'"), 0644)).To(Succeed())
+
+		server.AppendHandlers(
+			ghttp.CombineHandlers(
+				ghttp.VerifyRequest("POST", "/v3/apps"),
+				ghttp.RespondWith(http.StatusCreated, `{"guid":"app-guid"}`),
+			),
+			ghttp.CombineHandlers(
+				ghttp.VerifyRequest("POST", "/v3/apps/app-guid/processes/web/actions/scale"),
+				ghttp.VerifyJSON(`{"memory_in_mb":256}`),
+				ghttp.RespondWith(http.StatusAccepted, `{}`),
+			),
+			ghttp.CombineHandlers(
+				ghttp.VerifyRequest("GET", "/v3/domains", "names=fake-domain"),
+				ghttp.RespondWith(http.StatusOK, `{"resources":[{"guid":"domain-guid"}]}`),
+			),
+			ghttp.CombineHandlers(
+				ghttp.VerifyRequest("POST", "/v3/routes"),
+				ghttp.RespondWith(http.StatusCreated, `{"guid":"route-guid"}`),
+			),
+			ghttp.CombineHandlers(
+				ghttp.VerifyRequest("POST", "/v3/routes/route-guid/destinations"),
+				ghttp.RespondWith(http.StatusOK, `{}`),
+			),
+			ghttp.CombineHandlers(
+				ghttp.VerifyRequest("POST", "/v3/packages"),
+				ghttp.RespondWith(http.StatusCreated, `{"guid":"package-guid","state":"AWAITING_UPLOAD"}`),
+			),
+			ghttp.CombineHandlers(
+				ghttp.VerifyRequest("POST", "/v3/packages/package-guid/upload"),
+				func(w http.ResponseWriter, req *http.Request) {
+					file, header, err := req.FormFile("bits")
+					Expect(err).NotTo(HaveOccurred())
+					defer file.Close()
+					Expect(header.Filename).To(Equal("package.zip"))
+				},
+				ghttp.RespondWith(http.StatusOK, `{"guid":"package-guid","state":"PROCESSING_UPLOAD"}`),
+			),
+			ghttp.CombineHandlers(
+				ghttp.VerifyRequest("GET", "/v3/packages/package-guid"),
+				ghttp.RespondWith(http.StatusOK, `{"guid":"package-guid","state":"READY"}`),
+			),
+		)
+
+		Expect(api.PushApp("fake-app", appDir)).To(Succeed())
+	})
+})
@@ -0,0 +1,243 @@
+package platform
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cloudfoundry-incubator/cf-test-helpers/cf"
+)
+
+type cli struct {
+	timeout      time.Duration
+	startTimeout time.Duration
+
+	// space, when set, is targeted for every command, and the space that
+	// was targeted before is targeted again after it.
+	space string
+}
+
+// NewCLI returns a Platform that shells out to the cf CLI through cf.Cf.
+// Staging and starting an app use startTimeout, everything else timeout.
+func NewCLI(timeout, startTimeout time.Duration) Platform {
+	return &cli{
+		timeout:      timeout,
+		startTimeout: startTimeout,
+	}
+}
+
+func (p *cli) PushApp(appName, appPath string) error {
+	_, err := p.cf(p.timeout, "push", appName, "-m", appMemory, "-p", appPath, "-s", appStack, "-no-start")
+	return err
+}
+
+func (p *cli) StartApp(appName string) error {
+	_, err := p.cf(p.startTimeout, "start", appName)
+	return err
+}
+
+func (p *cli) RestartApp(appName string) error {
+	_, err := p.cf(p.startTimeout, "restart", appName)
+	return err
+}
+
+// ScaleApp changes the number of instances; cf scale does not wait for the
+// new ones to start.
+func (p *cli) ScaleApp(appName string, instances int) error {
+	_, err := p.cf(p.startTimeout, "scale", appName, "-i", strconv.Itoa(instances))
+	return err
+}
+
+func (p *cli) AppGUID(appName string) (string, error) {
+	output, err := p.cf(p.timeout, "app", appName, "--guid")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(output), nil
+}
+
+func (p *cli) DeleteApp(appName string) error {
+	_, err := p.cf(p.timeout, "delete", appName, "-f")
+	return err
+}
+
+func (p *cli) SetEnv(appName, name, value string) error {
+	_, err := p.cf(p.startTimeout, "set-env", appName, name, value)
+	return err
+}
+
+func (p *cli) CreateService(serviceName, planName, instanceName string) error {
+	_, err := p.cf(p.timeout, "create-service", serviceName, planName, instanceName)
+	return err
+}
+
+func (p *cli) DeleteService(instanceName string) error {
+	_, err := p.cf(p.timeout, "delete-service", "-f", instanceName)
+	return err
+}
+
+func (p *cli) BindService(appName, instanceName string) error {
+	_, err := p.cf(p.timeout, "bind-service", appName, instanceName)
+	return err
+}
+
+func (p *cli) UnbindService(appName, instanceName string) error {
+	_, err := p.cf(p.timeout, "unbind-service", appName, instanceName)
+	return err
+}
+
+// BindingCredentials finds the instance's credentials in the
+// VCAP_SERVICES that cf env shows for the app. Older CLIs print it as a
+// JSON document of its own, newer ones as "VCAP_SERVICES: {...}"; either
+// way the services follow the name.
+func (p *cli) BindingCredentials(appName, instanceName string) (map[string]interface{}, error) {
+	output, err := p.cf(p.timeout, "env", appName)
+	if err != nil {
+		return nil, err
+	}
+
+	name := strings.Index(output, "VCAP_SERVICES")
+	start := strings.Index(output[name+1:], "{")
+	if name < 0 || start < 0 {
+		return nil, fmt.Errorf("cf env %s: no VCAP_SERVICES in output:\n%s", appName, output)
+	}
+
+	var services map[string][]struct {
+		Name        string                 `json:"name"`
+		Credentials map[string]interface{} `json:"credentials"`
+	}
+	if err := json.NewDecoder(strings.NewReader(output[name+1+start:])).Decode(&services); err != nil {
+		return nil, fmt.Errorf("cf env %s: decoding VCAP_SERVICES: %s", appName, err.Error())
+	}
+
+	for _, bindings := range services {
+		for _, binding := range bindings {
+			if binding.Name == instanceName {
+				return binding.Credentials, nil
+			}
+		}
+	}
+	return nil, &NotBoundError{AppName: appName, InstanceName: instanceName}
+}
+
+func (p *cli) CreateServiceKey(instanceName, keyName string) error {
+	_, err := p.cf(p.timeout, "create-service-key", instanceName, keyName)
+	return err
+}
+
+func (p *cli) ServiceKey(instanceName, keyName string) (map[string]interface{}, error) {
+	output, err := p.cf(p.timeout, "service-key", instanceName, keyName)
+	if err != nil {
+		return nil, err
+	}
+
+	// the JSON document follows a "Getting key ..." banner
+	start := strings.Index(output, "{")
+	if start < 0 {
+		return nil, fmt.Errorf("cf service-key %s %s: no credentials in output:\n%s", instanceName, keyName, output)
+	}
+
+	var credentials map[string]interface{}
+	if err := json.Unmarshal([]byte(output[start:]), &credentials); err != nil {
+		return nil, fmt.Errorf("cf service-key %s %s: decoding credentials: %s", instanceName, keyName, err.Error())
+	}
+
+	// newer CLIs nest the key under "credentials"
+	if nested, ok := credentials["credentials"].(map[string]interface{}); ok {
+		return nested, nil
+	}
+	return credentials, nil
+}
+
+func (p *cli) DeleteServiceKey(instanceName, keyName string) error {
+	_, err := p.cf(p.timeout, "delete-service-key", "-f", instanceName, keyName)
+	return err
+}
+
+// ShareService makes the instance available to spaceName, where apps can
+// then bind to it.
+func (p *cli) ShareService(instanceName, spaceName string) error {
+	_, err := p.cf(p.timeout, "share-service", instanceName, "-s", spaceName)
+	return err
+}
+
+func (p *cli) UnshareService(instanceName, spaceName string) error {
+	_, err := p.cf(p.timeout, "unshare-service", instanceName, "-s", spaceName, "-f")
+	return err
+}
+
+// InSpace returns a Platform that targets the space around each command,
+// as the cf CLI only acts on the targeted space.
+func (p *cli) InSpace(spaceName string) Platform {
+	return &cli{
+		timeout:      p.timeout,
+		startTimeout: p.startTimeout,
+		space:        spaceName,
+	}
+}
+
+// cf runs a cf command and waits for it to exit, returning its stdout. A
+// non-zero exit or a timeout is reported with the command's output. With a
+// space of its own, the Platform targets it for the command and then
+// targets the previous space again.
+func (p *cli) cf(timeout time.Duration, args ...string) (string, error) {
+	if p.space == "" {
+		return p.run(timeout, args...)
+	}
+
+	home, err := p.targetedSpace()
+	if err != nil {
+		return "", err
+	}
+	if _, err := p.run(p.timeout, "target", "-s", p.space); err != nil {
+		return "", err
+	}
+	output, err := p.run(timeout, args...)
+	if _, targetErr := p.run(p.timeout, "target", "-s", home); targetErr != nil && err == nil {
+		err = targetErr
+	}
+	return output, err
+}
+
+// targetedSpace reads the space from the "space:" line of cf target.
+func (p *cli) targetedSpace() (string, error) {
+	output, err := p.run(p.timeout, "target")
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == "space:" {
+			return fields[1], nil
+		}
+	}
+	return "", fmt.Errorf("cf target: no space is targeted:\n%s", output)
+}
+
+// run is cf in whichever space is targeted.
+func (p *cli) run(timeout time.Duration, args ...string) (string, error) {
+	session := cf.Cf(args...)
+	command := "cf " + strings.Join(args, " ")
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		session.Kill()
+		return "", fmt.Errorf(
+			"Timed out executing command (%v):\nCommand: %s\n\n[stdout]:\n%s\n\n[stderr]:\n%s",
+			timeout, command, string(session.Out.Contents()), string(session.Err.Contents()))
+	case <-session.Exited:
+	}
+
+	if session.ExitCode() != 0 {
+		return "", fmt.Errorf(
+			"Failed executing command (exit %d):\nCommand: %s\n\n[stdout]:\n%s\n\n[stderr]:\n%s",
+			session.ExitCode(), command, string(session.Out.Contents()), string(session.Err.Contents()))
+	}
+
+	return string(session.Out.Contents()), nil
+}
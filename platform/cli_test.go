@@ -0,0 +1,140 @@
+package platform_test
+
+import (
+	"os/exec"
+	"time"
+
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/platform"
+	"github.com/cloudfoundry-incubator/cf-test-helpers/cf"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gexec"
+)
+
+var _ = Describe("CLI", func() {
+	var (
+		cli         platform.Platform
+		fakeCfCalls [][]string
+		fakeCfCmd   func(args ...string) *exec.Cmd
+	)
+
+	BeforeEach(func() {
+		fakeCfCalls = [][]string{}
+		fakeCfCmd = func(args ...string) *exec.Cmd {
+			return exec.Command("echo", "OK")
+		}
+		cf.Cf = func(args ...string) *gexec.Session {
+			fakeCfCalls = append(fakeCfCalls, args)
+			session, err := gexec.Start(fakeCfCmd(args...), nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+			return session
+		}
+
+		cli = platform.NewCLI(time.Second, time.Second)
+	})
+
+	It("pushes the app without starting it", func() {
+		Expect(cli.PushApp("fake-app", "fake/path")).To(Succeed())
+		Expect(fakeCfCalls).To(Equal([][]string{
+			{"push", "fake-app", "-m", "256M", "-p", "fake/path", "-s", "cflinuxfs2", "-no-start"},
+		}))
+	})
+
+	It("restarts the app", func() {
+		Expect(cli.RestartApp("fake-app")).To(Succeed())
+		Expect(fakeCfCalls).To(Equal([][]string{{"restart", "fake-app"}}))
+	})
+
+	It("scales the app and looks up its guid", func() {
+		fakeCfCmd = func(args ...string) *exec.Cmd {
+			return exec.Command("echo", "app-guid")
+		}
+
+		Expect(cli.ScaleApp("fake-app", 3)).To(Succeed())
+		Expect(cli.AppGUID("fake-app")).To(Equal("app-guid"))
+		Expect(fakeCfCalls).To(Equal([][]string{
+			{"scale", "fake-app", "-i", "3"},
+			{"app", "fake-app", "--guid"},
+		}))
+	})
+
+	It("drives the service lifecycle with the matching cf commands", func() {
+		Expect(cli.CreateService("p-rabbitmq", "standard", "fake-instance")).To(Succeed())
+		Expect(cli.BindService("fake-app", "fake-instance")).To(Succeed())
+		Expect(cli.UnbindService("fake-app", "fake-instance")).To(Succeed())
+		Expect(cli.DeleteService("fake-instance")).To(Succeed())
+
+		Expect(fakeCfCalls).To(Equal([][]string{
+			{"create-service", "p-rabbitmq", "standard", "fake-instance"},
+			{"bind-service", "fake-app", "fake-instance"},
+			{"unbind-service", "fake-app", "fake-instance"},
+			{"delete-service", "-f", "fake-instance"},
+		}))
+	})
+
+	It("reports a failing command with its output", func() {
+		fakeCfCmd = func(args ...string) *exec.Cmd {
+			return exec.Command("bash", "-c", "echo out; echo err 1>&2; exit 1")
+		}
+
+		err := cli.StartApp("fake-app")
+		Expect(err).To(MatchError(ContainSubstring("Failed executing command (exit 1):\nCommand: cf start fake-app")))
+		Expect(err.Error()).To(ContainSubstring("[stdout]:\nout\n"))
+		Expect(err.Error()).To(ContainSubstring("[stderr]:\nerr\n"))
+	})
+
+	It("reports a command that times out", func() {
+		cli = platform.NewCLI(10*time.Millisecond, time.Second)
+		fakeCfCmd = func(args ...string) *exec.Cmd {
+			return exec.Command("sleep", "1")
+		}
+
+		Expect(cli.DeleteApp("fake-app")).To(MatchError(ContainSubstring("Timed out executing command (10ms)")))
+	})
+
+	It("parses service key credentials from the cf output", func() {
+		fakeCfCmd = func(args ...string) *exec.Cmd {
+			return exec.Command("bash", "-c", `echo "Getting key fake-key for service instance fake-instance as admin..."; echo; echo '{"username": "fake-user"}'`)
+		}
+
+		credentials, err := cli.ServiceKey("fake-instance", "fake-key")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(credentials).To(HaveKeyWithValue("username", "fake-user"))
+		Expect(fakeCfCalls).To(Equal([][]string{{"service-key", "fake-instance", "fake-key"}}))
+	})
+
+	It("finds the binding's credentials in the app's environment", func() {
+		fakeCfCmd = func(args ...string) *exec.Cmd {
+			return exec.Command("bash", "-c", `printf 'Getting env variables for app fake-app...\nOK\n\nSystem-Provided:\nVCAP_SERVICES: {\n "p-rabbitmq": [{"name": "other-instance", "credentials": {"username": "other-user"}}, {"name": "fake-instance", "credentials": {"username": "fake-user"}}]\n}\n\nVCAP_APPLICATION: {\n "name": "fake-app"\n}\n'`)
+		}
+
+		credentials, err := cli.BindingCredentials("fake-app", "fake-instance")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(credentials).To(HaveKeyWithValue("username", "fake-user"))
+		Expect(fakeCfCalls).To(Equal([][]string{{"env", "fake-app"}}))
+
+		_, err = cli.BindingCredentials("fake-app", "missing-instance")
+		Expect(err).To(MatchError(&platform.NotBoundError{AppName: "fake-app", InstanceName: "missing-instance"}))
+	})
+	It("shares the service instance and targets the other space around each command there", func() {
+		fakeCfCmd = func(args ...string) *exec.Cmd {
+			if len(args) == 1 && args[0] == "target" {
+				return exec.Command("printf", "api endpoint:   https://api.fake\nuser:           fake-user\norg:            fake-org\nspace:          fake-space\n")
+			}
+			return exec.Command("echo", "OK")
+		}
+
+		Expect(cli.ShareService("fake-instance", "other-space")).To(Succeed())
+		Expect(cli.InSpace("other-space").BindService("fake-app", "fake-instance")).To(Succeed())
+		Expect(cli.UnshareService("fake-instance", "other-space")).To(Succeed())
+		Expect(fakeCfCalls).To(Equal([][]string{
+			{"share-service", "fake-instance", "-s", "other-space"},
+			{"target"},
+			{"target", "-s", "other-space"},
+			{"bind-service", "fake-app", "fake-instance"},
+			{"target", "-s", "fake-space"},
+			{"unshare-service", "fake-instance", "-s", "other-space", "-f"},
+		}))
+	})
+})
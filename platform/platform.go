@@ -0,0 +1,57 @@
+package platform
+
+import "fmt"
+
+// Platform is the set of Cloud Foundry operations the smoke tests drive.
+// All operations act on the currently targeted org and space, unless the
+// Platform was returned by InSpace.
+type Platform interface {
+	PushApp(appName, appPath string) error
+	StartApp(appName string) error
+	RestartApp(appName string) error
+	ScaleApp(appName string, instances int) error
+	AppGUID(appName string) (string, error)
+	DeleteApp(appName string) error
+	SetEnv(appName, name, value string) error
+
+	CreateService(serviceName, planName, instanceName string) error
+	DeleteService(instanceName string) error
+
+	BindService(appName, instanceName string) error
+	UnbindService(appName, instanceName string) error
+	BindingCredentials(appName, instanceName string) (map[string]interface{}, error)
+
+	CreateServiceKey(instanceName, keyName string) error
+	ServiceKey(instanceName, keyName string) (map[string]interface{}, error)
+	DeleteServiceKey(instanceName, keyName string) error
+
+	// ShareService makes the instance visible in another space of the org;
+	// UnshareService takes it back, which removes its bindings there.
+	ShareService(instanceName, spaceName string) error
+	UnshareService(instanceName, spaceName string) error
+
+	// InSpace returns a Platform that acts on another space of the org.
+	InSpace(spaceName string) Platform
+}
+
+// NotBoundError is BindingCredentials finding no binding of the instance
+// to the app, as after unbinding it, or unsharing the instance from the
+// app's space.
+type NotBoundError struct {
+	AppName      string
+	InstanceName string
+}
+
+func (e *NotBoundError) Error() string {
+	return fmt.Sprintf("%s is not bound to the app %s", e.InstanceName, e.AppName)
+}
+
+const (
+	CLI = "cli"
+	API = "api"
+)
+
+const (
+	appMemory = "256M"
+	appStack  = "cflinuxfs2"
+)
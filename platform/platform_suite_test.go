@@ -0,0 +1,20 @@
+package platform_test
+
+import (
+	"testing"
+
+	"github.com/cloudfoundry-incubator/cf-test-helpers/cf"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var originalCf = cf.Cf
+
+var _ = AfterEach(func() {
+	cf.Cf = originalCf
+})
+
+func TestPlatform(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Platform Suite")
+}
@@ -0,0 +1,74 @@
+package report
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/onsi/ginkgo/config"
+	"github.com/onsi/ginkgo/types"
+)
+
+const (
+	JSONFileName  = "smoke-tests-report.json"
+	JUnitFileName = "smoke-tests-junit.xml"
+)
+
+// GinkgoReporter is a ginkgo reporter that writes the recorded steps as a
+// JSON artifact and a JUnit XML file into a directory when the suite ends.
+type GinkgoReporter struct {
+	recorder *Recorder
+	dir      string
+}
+
+func NewGinkgoReporter(recorder *Recorder, dir string) *GinkgoReporter {
+	return &GinkgoReporter{
+		recorder: recorder,
+		dir:      dir,
+	}
+}
+
+func (r *GinkgoReporter) SpecSuiteWillBegin(config config.GinkgoConfigType, summary *types.SuiteSummary) {
+}
+
+func (r *GinkgoReporter) BeforeSuiteDidRun(setupSummary *types.SetupSummary) {
+}
+
+func (r *GinkgoReporter) SpecWillRun(specSummary *types.SpecSummary) {
+}
+
+func (r *GinkgoReporter) SpecDidComplete(specSummary *types.SpecSummary) {
+}
+
+func (r *GinkgoReporter) AfterSuiteDidRun(setupSummary *types.SetupSummary) {
+}
+
+func (r *GinkgoReporter) SpecSuiteDidEnd(summary *types.SuiteSummary) {
+	if err := r.Write(time.Now()); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write the smoke tests report: %s\n", err.Error())
+	}
+}
+
+// Write writes both report files.
+func (r *GinkgoReporter) Write(finishedAt time.Time) error {
+	if err := os.MkdirAll(r.dir, 0755); err != nil {
+		return err
+	}
+
+	jsonFile, err := os.Create(filepath.Join(r.dir, JSONFileName))
+	if err != nil {
+		return err
+	}
+	defer jsonFile.Close()
+	if err := WriteJSON(jsonFile, r.recorder, finishedAt); err != nil {
+		return err
+	}
+
+	junitFile, err := os.Create(filepath.Join(r.dir, JUnitFileName))
+	if err != nil {
+		return err
+	}
+	defer junitFile.Close()
+	return WriteJUnit(junitFile, r.recorder)
+}
@@ -0,0 +1,147 @@
+package report
+
+import (
+	"sync"
+	"time"
+)
+
+// Outcomes of a step.
+const (
+	Passed  = "passed"
+	Failed  = "failed"
+	Skipped = "skipped"
+)
+
+// Step is one timed step of a plan's lifecycle over one protocol.
+type Step struct {
+	Plan     string    `json:"-"`
+	Protocol string    `json:"-"`
+	Name     string    `json:"step"`
+	Start    time.Time `json:"started_at"`
+	End      time.Time `json:"finished_at"`
+	Attempts int       `json:"attempts"`
+	Outcome  string    `json:"outcome"`
+	Error    string    `json:"error,omitempty"`
+	Output   string    `json:"output,omitempty"`
+
+	// Latency is how long after it was published a message was read
+	// back, for steps that read one.
+	Latency time.Duration `json:"-"`
+
+	// Artifacts are the diagnostics collected when the step failed.
+	Artifacts []string `json:"artifacts,omitempty"`
+
+	// Findings are the security problems the step found, for audits.
+	Findings []string `json:"findings,omitempty"`
+}
+
+func (s Step) Duration() time.Duration {
+	return s.End.Sub(s.Start)
+}
+
+// Broker is what the broker behind a plan says about itself.
+type Broker struct {
+	RabbitMQVersion string `json:"rabbitmq_version"`
+	ErlangVersion   string `json:"erlang_version"`
+	ClusterName     string `json:"cluster_name,omitempty"`
+}
+
+// Recorder collects steps from any number of lifecycles.
+type Recorder struct {
+	mutex   sync.Mutex
+	start   time.Time
+	steps   []Step
+	brokers map[string]Broker
+}
+
+func NewRecorder() *Recorder {
+	return &Recorder{start: time.Now(), brokers: map[string]Broker{}}
+}
+
+func (r *Recorder) Record(step Step) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.steps = append(r.steps, step)
+}
+
+// RecordBroker records the broker behind a plan; the last one recorded
+// wins.
+func (r *Recorder) RecordBroker(plan string, broker Broker) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.brokers[plan] = broker
+}
+
+func (r *Recorder) Steps() []Step {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]Step{}, r.steps...)
+}
+
+// StepsOf returns the steps of one plan over one protocol.
+func (r *Recorder) StepsOf(plan, protocol string) []Step {
+	steps := []Step{}
+	for _, step := range r.Steps() {
+		if step.Plan == plan && step.Protocol == protocol {
+			steps = append(steps, step)
+		}
+	}
+	return steps
+}
+
+func (r *Recorder) Start() time.Time {
+	return r.start
+}
+
+// Plan groups the steps of one plan by protocol.
+type Plan struct {
+	Name      string     `json:"plan"`
+	Broker    *Broker    `json:"broker,omitempty"`
+	Protocols []Protocol `json:"protocols"`
+}
+
+type Protocol struct {
+	Name  string `json:"protocol"`
+	Steps []Step `json:"steps"`
+}
+
+// Plans groups the recorded steps by plan and protocol, in the order they
+// were first seen.
+func (r *Recorder) Plans() []Plan {
+	plans := []Plan{}
+	for _, step := range r.Steps() {
+		p := -1
+		for i := range plans {
+			if plans[i].Name == step.Plan {
+				p = i
+			}
+		}
+		if p < 0 {
+			plans = append(plans, Plan{Name: step.Plan})
+			p = len(plans) - 1
+		}
+
+		protocols := plans[p].Protocols
+		q := -1
+		for i := range protocols {
+			if protocols[i].Name == step.Protocol {
+				q = i
+			}
+		}
+		if q < 0 {
+			protocols = append(protocols, Protocol{Name: step.Protocol})
+			q = len(protocols) - 1
+		}
+		protocols[q].Steps = append(protocols[q].Steps, step)
+		plans[p].Protocols = protocols
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for i := range plans {
+		if broker, ok := r.brokers[plans[i].Name]; ok {
+			plans[i].Broker = &broker
+		}
+	}
+	return plans
+}
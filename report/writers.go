@@ -0,0 +1,144 @@
+package report
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"time"
+)
+
+type jsonReport struct {
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	Plans      []Plan    `json:"plans"`
+}
+
+// MarshalJSON adds the duration and latency, which dashboards chart, to
+// the step.
+func (s Step) MarshalJSON() ([]byte, error) {
+	type plain Step
+	step := struct {
+		plain
+		DurationSeconds float64  `json:"duration_seconds"`
+		LatencySeconds  *float64 `json:"latency_seconds,omitempty"`
+	}{plain: plain(s), DurationSeconds: s.Duration().Seconds()}
+
+	if s.Latency > 0 {
+		latency := s.Latency.Seconds()
+		step.LatencySeconds = &latency
+	}
+	return json.Marshal(step)
+}
+
+// WriteJSON writes the steps grouped by plan and protocol.
+func WriteJSON(out io.Writer, recorder *Recorder, finishedAt time.Time) error {
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(jsonReport{
+		StartedAt:  recorder.Start(),
+		FinishedAt: finishedAt,
+		Plans:      recorder.Plans(),
+	})
+}
+
+type junitTestSuites struct {
+	XMLName    xml.Name         `xml:"testsuites"`
+	TestSuites []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name       string          `xml:"name,attr"`
+	Tests      int             `xml:"tests,attr"`
+	Failures   int             `xml:"failures,attr"`
+	Skipped    int             `xml:"skipped,attr"`
+	Time       float64         `xml:"time,attr"`
+	Timestamp  string          `xml:"timestamp,attr"`
+	Properties []junitProperty `xml:"properties>property,omitempty"`
+	TestCases  []junitTestCase `xml:"testcase"`
+}
+
+type junitProperty struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      float64       `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Skipped   *junitSkipped `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitFailure struct {
+	Message  string `xml:"message,attr"`
+	Contents string `xml:",chardata"`
+}
+
+type junitSkipped struct {
+	Message string `xml:"message,attr"`
+}
+
+// WriteJUnit writes one test suite per plan with a test case for each step,
+// classed by protocol.
+func WriteJUnit(out io.Writer, recorder *Recorder) error {
+	suites := junitTestSuites{}
+
+	for _, plan := range recorder.Plans() {
+		suite := junitTestSuite{Name: plan.Name}
+		if plan.Broker != nil {
+			suite.Properties = []junitProperty{
+				{Name: "rabbitmq_version", Value: plan.Broker.RabbitMQVersion},
+				{Name: "erlang_version", Value: plan.Broker.ErlangVersion},
+			}
+		}
+		var first, last time.Time
+
+		for _, protocol := range plan.Protocols {
+			for _, step := range protocol.Steps {
+				testCase := junitTestCase{
+					Name:      step.Name,
+					ClassName: fmt.Sprintf("%s.%s", plan.Name, protocol.Name),
+					Time:      step.Duration().Seconds(),
+					SystemOut: step.Output,
+				}
+
+				switch step.Outcome {
+				case Failed:
+					suite.Failures++
+					testCase.Failure = &junitFailure{Message: step.Error, Contents: step.Output}
+				case Skipped:
+					suite.Skipped++
+					testCase.Skipped = &junitSkipped{Message: step.Error}
+				}
+
+				if first.IsZero() || step.Start.Before(first) {
+					first = step.Start
+				}
+				if step.End.After(last) {
+					last = step.End
+				}
+
+				suite.Tests++
+				suite.TestCases = append(suite.TestCases, testCase)
+			}
+		}
+
+		suite.Time = last.Sub(first).Seconds()
+		suite.Timestamp = first.UTC().Format("2006-01-02T15:04:05")
+		suites.TestSuites = append(suites.TestSuites, suite)
+	}
+
+	if _, err := io.WriteString(out, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(out)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(suites); err != nil {
+		return err
+	}
+	_, err := io.WriteString(out, "\n")
+	return err
+}
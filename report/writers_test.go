@@ -0,0 +1,146 @@
+package report_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/report"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Writers", func() {
+	var (
+		recorder *report.Recorder
+		start    time.Time
+	)
+
+	BeforeEach(func() {
+		recorder = report.NewRecorder()
+		start = time.Date(2016, 3, 1, 12, 0, 0, 0, time.UTC)
+
+		recorder.Record(report.Step{
+			Plan: "standard", Protocol: "amqp", Name: "push",
+			Start: start, End: start.Add(30 * time.Second),
+			Attempts: 1, Outcome: report.Passed,
+		})
+		recorder.Record(report.Step{
+			Plan: "standard", Protocol: "amqp", Name: "consume",
+			Start: start.Add(30 * time.Second), End: start.Add(35 * time.Second),
+			Attempts: 3, Outcome: report.Failed, Latency: 1500 * time.Millisecond,
+			Error: "still failing after 5s", Output: "Reading from the (non-empty) queue: ",
+			Findings: []string{"the broker accepted a plaintext AMQP connection on rabbitmq.fake:5672"},
+		})
+		recorder.Record(report.Step{
+			Plan: "standard", Protocol: "stomp", Name: "bind",
+			Start: start.Add(40 * time.Second), End: start.Add(40 * time.Second),
+			Outcome: report.Skipped, Error: "the app was not pushed",
+		})
+		recorder.Record(report.Step{
+			Plan: "ha", Protocol: "amqp", Name: "push",
+			Start: start.Add(50 * time.Second), End: start.Add(60 * time.Second),
+			Attempts: 1, Outcome: report.Passed,
+		})
+		recorder.RecordBroker("standard", report.Broker{RabbitMQVersion: "3.8.9", ErlangVersion: "23.1.4"})
+	})
+
+	Describe("WriteJSON", func() {
+		It("groups the steps by plan and protocol", func() {
+			buffer := &bytes.Buffer{}
+			Expect(report.WriteJSON(buffer, recorder, start.Add(time.Minute))).To(Succeed())
+
+			var written struct {
+				FinishedAt time.Time `json:"finished_at"`
+				Plans      []struct {
+					Plan   string `json:"plan"`
+					Broker *struct {
+						RabbitMQVersion string `json:"rabbitmq_version"`
+						ErlangVersion   string `json:"erlang_version"`
+					} `json:"broker"`
+					Protocols []struct {
+						Protocol string `json:"protocol"`
+						Steps    []struct {
+							Step            string   `json:"step"`
+							Attempts        int      `json:"attempts"`
+							Outcome         string   `json:"outcome"`
+							Error           string   `json:"error"`
+							DurationSeconds float64  `json:"duration_seconds"`
+							LatencySeconds  float64  `json:"latency_seconds"`
+							Findings        []string `json:"findings"`
+						} `json:"steps"`
+					} `json:"protocols"`
+				} `json:"plans"`
+			}
+			Expect(json.Unmarshal(buffer.Bytes(), &written)).To(Succeed())
+
+			Expect(written.FinishedAt).To(BeTemporally("==", start.Add(time.Minute)))
+			Expect(written.Plans).To(HaveLen(2))
+			Expect(written.Plans[0].Plan).To(Equal("standard"))
+			Expect(written.Plans[0].Protocols).To(HaveLen(2))
+			Expect(written.Plans[0].Protocols[0].Protocol).To(Equal("amqp"))
+
+			consume := written.Plans[0].Protocols[0].Steps[1]
+			Expect(consume.Step).To(Equal("consume"))
+			Expect(consume.Attempts).To(Equal(3))
+			Expect(consume.Outcome).To(Equal("failed"))
+			Expect(consume.Error).To(Equal("still failing after 5s"))
+			Expect(consume.DurationSeconds).To(Equal(5.0))
+			Expect(consume.LatencySeconds).To(Equal(1.5))
+			Expect(consume.Findings).To(Equal([]string{"the broker accepted a plaintext AMQP connection on rabbitmq.fake:5672"}))
+			Expect(written.Plans[0].Protocols[0].Steps[0].Findings).To(BeEmpty())
+
+			Expect(written.Plans[0].Broker).NotTo(BeNil())
+			Expect(written.Plans[0].Broker.RabbitMQVersion).To(Equal("3.8.9"))
+			Expect(written.Plans[0].Broker.ErlangVersion).To(Equal("23.1.4"))
+
+			Expect(written.Plans[1].Plan).To(Equal("ha"))
+			Expect(written.Plans[1].Broker).To(BeNil())
+		})
+	})
+
+	Describe("WriteJUnit", func() {
+		It("writes a test suite per plan with a test case per step", func() {
+			buffer := &bytes.Buffer{}
+			Expect(report.WriteJUnit(buffer, recorder)).To(Succeed())
+
+			junit := buffer.String()
+			Expect(junit).To(ContainSubstring(`<testsuite name="standard" tests="3" failures="1" skipped="1" time="40" timestamp="2016-03-01T12:00:00">`))
+			Expect(junit).To(ContainSubstring(`<properties>
+      <property name="rabbitmq_version" value="3.8.9"></property>
+      <property name="erlang_version" value="23.1.4"></property>
+    </properties>`))
+			Expect(junit).To(ContainSubstring(`<testcase name="push" classname="standard.amqp" time="30">`))
+			Expect(junit).To(ContainSubstring(`<failure message="still failing after 5s">Reading from the (non-empty) queue: </failure>`))
+			Expect(junit).To(ContainSubstring(`<testcase name="bind" classname="standard.stomp" time="0">`))
+			Expect(junit).To(ContainSubstring(`<skipped message="the app was not pushed"></skipped>`))
+			Expect(junit).To(ContainSubstring(`<testsuite name="ha" tests="1" failures="0" skipped="0" time="10"`))
+		})
+	})
+
+	Describe("GinkgoReporter", func() {
+		var dir string
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "report")
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		It("writes both files into the report directory", func() {
+			reportDir := filepath.Join(dir, "reports")
+			Expect(report.NewGinkgoReporter(recorder, reportDir).Write(time.Now())).To(Succeed())
+
+			Expect(filepath.Join(reportDir, report.JSONFileName)).To(BeAnExistingFile())
+			Expect(filepath.Join(reportDir, report.JUnitFileName)).To(BeAnExistingFile())
+		})
+	})
+})
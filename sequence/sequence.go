@@ -0,0 +1,128 @@
+package sequence
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Message is the body of message number n of a batch. Numbers are zero
+// padded so that the messages sort in the order they were sent.
+func Message(batch string, n int) string {
+	return fmt.Sprintf("%s-%06d", batch, n)
+}
+
+// Parse reads the number of a message of the batch, if it is one.
+func Parse(batch, message string) (int, bool) {
+	if !strings.HasPrefix(message, batch+"-") {
+		return 0, false
+	}
+	n, err := strconv.Atoi(strings.TrimPrefix(message, batch+"-"))
+	if err != nil {
+		return 0, false
+	}
+	return n, true
+}
+
+// Result is what came back of a batch of messages numbered from 1 to
+// Count.
+type Result struct {
+	Count    int
+	Received int
+
+	// Missing were never received, Duplicates more than once.
+	Missing    []int
+	Duplicates []int
+
+	// OutOfOrder arrived after a message with a higher number.
+	OutOfOrder []int
+
+	// Unexpected are messages that are not part of the batch.
+	Unexpected []string
+}
+
+// Check compares the messages read back, in the order they were read, with
+// a batch of count messages.
+func Check(batch string, count int, messages []string) Result {
+	result := Result{Count: count, Received: len(messages)}
+
+	seen := map[int]int{}
+	highest := 0
+	for _, message := range messages {
+		n, ok := Parse(batch, message)
+		if !ok || n < 1 || n > count {
+			result.Unexpected = append(result.Unexpected, message)
+			continue
+		}
+
+		seen[n]++
+		if seen[n] == 2 {
+			result.Duplicates = append(result.Duplicates, n)
+		}
+		if seen[n] > 1 {
+			continue
+		}
+		if n < highest {
+			result.OutOfOrder = append(result.OutOfOrder, n)
+		} else {
+			highest = n
+		}
+	}
+
+	for n := 1; n <= count; n++ {
+		if seen[n] == 0 {
+			result.Missing = append(result.Missing, n)
+		}
+	}
+	sort.Ints(result.Duplicates)
+	sort.Ints(result.OutOfOrder)
+	return result
+}
+
+// Complete says whether every message of the batch was received.
+func (r Result) Complete() bool {
+	return len(r.Missing) == 0
+}
+
+// Err describes everything that went wrong with the batch, or is nil when
+// every message came back exactly once and in order.
+func (r Result) Err() error {
+	problems := []string{}
+	if len(r.Missing) > 0 {
+		problems = append(problems, fmt.Sprintf("%d missing: %s", len(r.Missing), Ranges(r.Missing)))
+	}
+	if len(r.Duplicates) > 0 {
+		problems = append(problems, fmt.Sprintf("%d duplicated: %s", len(r.Duplicates), Ranges(r.Duplicates)))
+	}
+	if len(r.OutOfOrder) > 0 {
+		problems = append(problems, fmt.Sprintf("%d out of order: %s", len(r.OutOfOrder), Ranges(r.OutOfOrder)))
+	}
+	if len(r.Unexpected) > 0 {
+		problems = append(problems, fmt.Sprintf("%d not part of the batch: %q", len(r.Unexpected), r.Unexpected))
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	return fmt.Errorf("sent %d messages, received %d; %s", r.Count, r.Received, strings.Join(problems, "; "))
+}
+
+// Ranges writes numbers, which must be sorted, with runs collapsed, like
+// "1-3, 7".
+func Ranges(numbers []int) string {
+	parts := []string{}
+	for i := 0; i < len(numbers); {
+		j := i
+		for j+1 < len(numbers) && numbers[j+1] == numbers[j]+1 {
+			j++
+		}
+		if i == j {
+			parts = append(parts, strconv.Itoa(numbers[i]))
+		} else {
+			parts = append(parts, fmt.Sprintf("%d-%d", numbers[i], numbers[j]))
+		}
+		i = j + 1
+	}
+	return strings.Join(parts, ", ")
+}
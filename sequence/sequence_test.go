@@ -0,0 +1,50 @@
+package sequence_test
+
+import (
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/sequence"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Sequence", func() {
+	messages := func(numbers ...int) []string {
+		result := []string{}
+		for _, n := range numbers {
+			result = append(result, sequence.Message("batch", n))
+		}
+		return result
+	}
+
+	It("numbers messages so they can be read back", func() {
+		Expect(sequence.Message("batch", 7)).To(Equal("batch-000007"))
+		n, ok := sequence.Parse("batch", "batch-000007")
+		Expect(ok).To(BeTrue())
+		Expect(n).To(Equal(7))
+
+		_, ok = sequence.Parse("batch", "other-000007")
+		Expect(ok).To(BeFalse())
+	})
+
+	It("accepts every message once and in order", func() {
+		result := sequence.Check("batch", 3, messages(1, 2, 3))
+		Expect(result.Complete()).To(BeTrue())
+		Expect(result.Err()).NotTo(HaveOccurred())
+	})
+
+	It("reports missing, duplicated and reordered messages", func() {
+		result := sequence.Check("batch", 8, append(messages(1, 3, 2, 3, 4, 8, 6, 8, 8), "stale"))
+
+		Expect(result.Complete()).To(BeFalse())
+		Expect(result.Missing).To(Equal([]int{5, 7}))
+		Expect(result.Duplicates).To(Equal([]int{3, 8}))
+		Expect(result.OutOfOrder).To(Equal([]int{2, 6}))
+		Expect(result.Unexpected).To(Equal([]string{"stale"}))
+		Expect(result.Err()).To(MatchError(`sent 8 messages, received 10; 2 missing: 5, 7; 2 duplicated: 3, 8; 2 out of order: 2, 6; 1 not part of the batch: ["stale"]`))
+	})
+
+	It("collapses runs of numbers", func() {
+		Expect(sequence.Ranges([]int{1, 2, 3, 5, 7, 8})).To(Equal("1-3, 5, 7-8"))
+		Expect(sequence.Ranges(nil)).To(Equal(""))
+	})
+})
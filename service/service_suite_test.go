@@ -2,6 +2,10 @@ package service_test
 
 import (
 	"testing"
+	"time"
+
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/notify"
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/report"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -9,5 +13,22 @@ import (
 
 func TestService(t *testing.T) {
 	RegisterFailHandler(Fail)
-	RunSpecs(t, "RabbitMQ Smoke Tests")
+
+	reporters := []Reporter{}
+	if config.ReportDir != "" {
+		reporters = append(reporters, report.NewGinkgoReporter(stepRecorder, config.ReportDir))
+	}
+	if len(config.Webhooks) > 0 && !config.DryRun {
+		webhooks := []notify.Webhook{}
+		for _, webhook := range config.Webhooks {
+			webhooks = append(webhooks, notify.Webhook{
+				URL:           webhook.URL,
+				Format:        webhook.Format,
+				OnlyOnFailure: webhook.OnlyOnFailure,
+			})
+		}
+		notifier := notify.NewNotifier(webhooks, 10*time.Second)
+		reporters = append(reporters, notify.NewGinkgoReporter(config.ApiEndpoint, stepRecorder, notifier))
+	}
+	RunSpecsWithDefaultAndCustomReporters(t, "RabbitMQ Smoke Tests", reporters)
 }
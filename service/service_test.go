@@ -1,21 +1,41 @@
 package service_test
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
 	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
-	"github.com/pborman/uuid"
-
 	"github.com/cloudfoundry-incubator/cf-test-helpers/cf"
 	"github.com/cloudfoundry-incubator/cf-test-helpers/runner"
 	"github.com/cloudfoundry-incubator/cf-test-helpers/services"
 
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/broker"
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/canary"
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/ccv3"
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/diagnostics"
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/dryrun"
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/exampleapp"
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/fakeplatform"
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/lifecycle"
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/metrics"
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/notify"
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/payload"
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/platform"
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/report"
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/statsd"
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/tlsprobe"
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/tracing"
+
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
-	. "github.com/onsi/gomega/gbytes"
-	. "github.com/onsi/gomega/gexec"
 )
 
 type rabbitmqTestConfig struct {
@@ -24,8 +44,342 @@ type rabbitmqTestConfig struct {
 	ServiceName     string   `json:"service_name"`
 	PlanNames       []string `json:"plan_names"`
 	RabbitMQSkipSSL bool     `json:"rabbitmq_skip_ssl"`
-  TestSTOMP       bool     `json:"test_stomp"`
-  TestMQTT        bool     `json:"test_mqtt"`
+	TestSTOMP       bool     `json:"test_stomp"`
+	TestMQTT        bool     `json:"test_mqtt"`
+
+	// Backend selects how the suite talks to Cloud Foundry: "cli" (the
+	// default) shells out to cf, "api" uses the Cloud Controller v3 API.
+	Backend string `json:"backend"`
+
+	// FakePlatform runs the suite against an in-memory foundation instead
+	// of the configured api, to check changes to the suite offline.
+	FakePlatform bool `json:"fake_platform"`
+
+	// DryRun prints the cf operations the suite would perform, with
+	// secrets masked, instead of performing them. DRY_RUN=true in the
+	// environment turns it on for any config.
+	DryRun bool `json:"dry_run"`
+
+	// ReportDir, when set, receives a JSON report and a JUnit XML file
+	// with the timing and outcome of every step of every plan.
+	ReportDir string `json:"report_dir"`
+
+	// Canary keeps checking the plans instead of running the smoke tests
+	// once. CANARY=true in the environment turns it on for any config. It
+	// cannot be combined with DryRun.
+	Canary canaryConfig `json:"canary"`
+
+	// StatsD, when it has a host, receives the outcome and duration of
+	// every step at the end of each plan's lifecycle.
+	StatsD statsdConfig `json:"statsd"`
+
+	// Webhooks are told about the run when it ends. A dry run tells no
+	// one.
+	Webhooks []webhookConfig `json:"webhooks"`
+
+	// Tracing, when it has an endpoint, exports a trace of the run over
+	// OTLP/HTTP.
+	Tracing tracingConfig `json:"tracing"`
+
+	// ArtifactsDir receives the app logs, events, environment and service
+	// state collected when a step fails. It defaults to a directory under
+	// the system's temporary directory.
+	ArtifactsDir string `json:"artifacts_dir"`
+
+	// Payloads, when it has kinds, sends a matrix of payloads through each
+	// plan over each protocol and checks that they come back intact.
+	Payloads payloadsConfig `json:"payloads"`
+
+	// OrderedMessages, when positive, is the size of a batch of numbered
+	// messages sent through each plan over each protocol, which must come
+	// back once each and in order.
+	OrderedMessages int `json:"ordered_messages"`
+
+	// Durability checks that messages survive the app restarting, for
+	// the plans that opt in; some plans are transient by design.
+	Durability durabilityConfig `json:"durability"`
+
+	// Scaling, when it asks for more than one instance, scales the app out
+	// and checks that the instances compete for the messages on the queue.
+	Scaling scalingConfig `json:"scaling"`
+
+	// CrossProtocol publishes over MQTT and over STOMP and consumes over
+	// AMQP, with the credentials of a service key, once for each plan.
+	CrossProtocol bool `json:"cross_protocol"`
+
+	// SharedInstance binds a second app to each plan's instance, consumes
+	// through it what the first app publishes, then checks that unbinding
+	// it leaves the first app working.
+	SharedInstance sharedInstanceConfig `json:"shared_instance"`
+
+	// SharedSpace creates a second space with the context, shares each
+	// plan's instance into it and binds an app there.
+	SharedSpace bool `json:"shared_space"`
+
+	// Management signs in to the management API as the app binding's
+	// user, once for each plan, and records the broker's versions.
+	Management managementConfig `json:"management"`
+
+	// Capabilities are what each plan offers and the limits it enforces.
+	// A check is generated for each capability given, run once per plan.
+	Capabilities map[string]capabilitiesConfig `json:"capabilities"`
+
+	// QuorumQueues and Streams send messages through a quorum queue and a
+	// stream of each plan, over AMQP. The plans whose capabilities list
+	// queue types without them skip the check.
+	QuorumQueues bool `json:"quorum_queues"`
+	Streams      bool `json:"streams"`
+
+	// DeliveryGuarantees checks, over AMQP, that the broker confirms
+	// publishes, redelivers a message nacked with requeue and drops one
+	// rejected without requeue.
+	DeliveryGuarantees bool `json:"delivery_guarantees"`
+
+	// DeadLettering declares queues with x-max-length, x-message-ttl and
+	// a dead-letter exchange over AMQP, and checks that the messages they
+	// overflow or expire land in the dead-letter queue.
+	DeadLettering bool `json:"dead_lettering"`
+
+	// TLS is how the broker's TLS endpoints are checked and verified.
+	TLS tlsConfig `json:"tls"`
+
+	// AppRoutes is how the example apps' routes are reached.
+	AppRoutes appRoutesConfig `json:"app_routes"`
+}
+
+type capabilitiesConfig struct {
+	// MaxMessageSize, like "128MiB", must get through and a byte more
+	// must be refused.
+	MaxMessageSize string `json:"max_message_size"`
+
+	// MaxConnections is how many connections the instance's vhost may
+	// have at once.
+	MaxConnections int `json:"max_connections"`
+
+	// QueueTypes are any of "classic", "quorum" and "stream".
+	QueueTypes []string `json:"queue_types"`
+
+	// TLSRequired means that bindings only get TLS endpoints, and that
+	// the broker refuses plaintext connections.
+	TLSRequired bool `json:"tls_required"`
+
+	// OperatorPolicy is what the plan's operator policy must set on every
+	// queue, as the management API reports it, e.g. {"max-length": 10000}.
+	OperatorPolicy map[string]interface{} `json:"operator_policy"`
+}
+
+// offersQueueType tells whether the plan offers the queue type, as far as
+// its capabilities say; plans that list no queue types are taken to.
+func offersQueueType(planName, queueType string) bool {
+	queueTypes := config.Capabilities[planName].QueueTypes
+	if len(queueTypes) == 0 {
+		return true
+	}
+	for _, offered := range queueTypes {
+		if offered == queueType {
+			return true
+		}
+	}
+	return false
+}
+
+// maxMessageSize is in bytes; loadConfig checks that it parses.
+func (c capabilitiesConfig) maxMessageSize() int {
+	size, _ := payload.ParseSize(c.MaxMessageSize)
+	return size
+}
+
+type tlsConfig struct {
+	// Check handshakes with every TLS endpoint of each plan's binding,
+	// the management API's included, failing on expired certificates,
+	// versions below MinVersion and certificates for another host.
+	Check bool `json:"check"`
+
+	// MinVersion, like "1.2", is the lowest version the endpoints may
+	// speak. It defaults to 1.2.
+	MinVersion string `json:"min_version"`
+
+	// CABundle is the CAs that sign the broker's certificates, as a PEM
+	// file or the PEM itself. They are trusted, both by the check and when
+	// connecting to the broker, instead of skipping verification with
+	// rabbitmq_skip_ssl.
+	CABundle string `json:"ca_bundle"`
+}
+
+// minVersion is 1.2 unless configured; loadConfig checks that it parses.
+func (c tlsConfig) minVersion() uint16 {
+	if c.MinVersion == "" {
+		return tls.VersionTLS12
+	}
+	version, _ := tlsprobe.ParseVersion(c.MinVersion)
+	return version
+}
+
+// caBundle is the pool of the bundle's CAs, or nil without one.
+func (c tlsConfig) caBundle() (*x509.CertPool, error) {
+	return loadCABundle(c.CABundle)
+}
+
+type appRoutesConfig struct {
+	// HTTP reaches the routes over plain http instead of https.
+	HTTP bool `json:"http"`
+
+	// CABundle is the CAs that sign the apps domain's certificates, as a
+	// PEM file or the PEM itself. Without one the system's CAs are
+	// trusted.
+	CABundle string `json:"ca_bundle"`
+
+	// SkipVerify accepts any certificate on the routes, like curl -k.
+	// Certificates are verified, hostname included, unless it is set.
+	SkipVerify bool `json:"skip_verify"`
+}
+
+// tlsConfig is how the routes' certificates are verified; loadConfig
+// checks that the bundle loads.
+func (c appRoutesConfig) tlsConfig() *tls.Config {
+	roots, _ := loadCABundle(c.CABundle)
+	return &tls.Config{RootCAs: roots, InsecureSkipVerify: c.SkipVerify}
+}
+
+// loadCABundle is the pool of the CAs in bundle, a PEM file or the PEM
+// itself, or nil for an empty bundle.
+func loadCABundle(bundle string) (*x509.CertPool, error) {
+	if bundle == "" {
+		return nil, nil
+	}
+	name, pem := "the inline bundle", []byte(bundle)
+	if !strings.Contains(bundle, "-----BEGIN") {
+		var err error
+		if pem, err = ioutil.ReadFile(bundle); err != nil {
+			return nil, err
+		}
+		name = bundle
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("%s holds no PEM certificates", name)
+	}
+	return pool, nil
+}
+
+type managementConfig struct {
+	Enabled bool `json:"enabled"`
+
+	// Plans are what each plan promises its users; for plans not listed,
+	// only their access to the vhost is checked.
+	Plans map[string]managementPlanConfig `json:"plans"`
+}
+
+type managementPlanConfig struct {
+	Tags []string `json:"tags"`
+
+	// Configure, Write and Read are permission patterns, e.g. ".*".
+	Configure string `json:"configure"`
+	Write     string `json:"write"`
+	Read      string `json:"read"`
+}
+
+func (c managementConfig) expectationsFor(planName string) lifecycle.ManagementExpectations {
+	plan := c.Plans[planName]
+	return lifecycle.ManagementExpectations{
+		Tags:      plan.Tags,
+		Configure: plan.Configure,
+		Write:     plan.Write,
+		Read:      plan.Read,
+	}
+}
+
+type sharedInstanceConfig struct {
+	Enabled bool `json:"enabled"`
+
+	// RequireDistinctCredentials fails the check when the two bindings
+	// share a broker user, rather than only reporting it.
+	RequireDistinctCredentials bool `json:"require_distinct_credentials"`
+}
+
+type durabilityConfig struct {
+	Plans []string `json:"plans"`
+
+	// Messages is how many to publish before the restart, 3 by default.
+	Messages int `json:"messages"`
+
+	// CheckDurableFlag also asks the management API, with the credentials
+	// of a service key, whether the AMQP queue is durable.
+	CheckDurableFlag bool `json:"check_durable_flag"`
+}
+
+func (c durabilityConfig) enabledFor(planName string) bool {
+	for _, plan := range c.Plans {
+		if plan == planName {
+			return true
+		}
+	}
+	return false
+}
+
+type scalingConfig struct {
+	Instances int `json:"instances"`
+
+	// Messages is how many to publish, 10 per instance by default.
+	Messages int `json:"messages"`
+}
+
+type payloadsConfig struct {
+	// Kinds are any of "empty", "utf8", "binary" and "sizes".
+	Kinds []string `json:"kinds"`
+
+	// MaxSize bounds the "sizes" payloads, like "128KiB" or "1MiB", and
+	// defaults to 128KiB. PlanMaxSizes overrides it for some plans.
+	MaxSize      string            `json:"max_size"`
+	PlanMaxSizes map[string]string `json:"plan_max_sizes"`
+}
+
+type canaryConfig struct {
+	Enabled bool `json:"enabled"`
+
+	// Interval is how often each plan gets a warm check and FullInterval
+	// how often a full one, as Go durations. They default to 1m and 1h.
+	Interval     string `json:"interval"`
+	FullInterval string `json:"full_interval"`
+
+	// MetricsAddress is where /metrics is served, ":9090" by default.
+	MetricsAddress string `json:"metrics_address"`
+
+	// Duration stops the canary after a while; it runs until killed when
+	// empty.
+	Duration string `json:"duration"`
+}
+
+type statsdConfig struct {
+	Host string `json:"host"`
+	Port int    `json:"port"`
+
+	// Prefix defaults to "rabbitmq_smoke_tests".
+	Prefix string `json:"prefix"`
+
+	// DogStatsD sends the plan, protocol and step, and Tags, as DogStatsD
+	// tags rather than in the metric names.
+	DogStatsD bool     `json:"dogstatsd"`
+	Tags      []string `json:"tags"`
+}
+
+type webhookConfig struct {
+	URL string `json:"url"`
+
+	// Format is "json" (the default) for a generic payload or "slack" for
+	// a Slack incoming webhook.
+	Format        string `json:"format"`
+	OnlyOnFailure bool   `json:"only_on_failure"`
+}
+
+type tracingConfig struct {
+	// Endpoint is the full URL of the collector's traces receiver, e.g.
+	// http://localhost:4318/v1/traces.
+	Endpoint string            `json:"endpoint"`
+	Headers  map[string]string `json:"headers"`
+
+	// ServiceName defaults to "cf-rabbitmq-smoke-tests".
+	ServiceName string `json:"service_name"`
 }
 
 func loadConfig() (testConfig rabbitmqTestConfig) {
@@ -41,11 +395,115 @@ func loadConfig() (testConfig rabbitmqTestConfig) {
 		panic(err)
 	}
 
+	if testConfig.ArtifactsDir == "" {
+		testConfig.ArtifactsDir = filepath.Join(os.TempDir(), "rabbitmq-smoke-tests-artifacts")
+	}
+
+	if dryRun := os.Getenv("DRY_RUN"); dryRun == "true" || dryRun == "1" {
+		testConfig.DryRun = true
+	}
+
+	for _, webhook := range testConfig.Webhooks {
+		if webhook.Format != "" && webhook.Format != notify.JSON && webhook.Format != notify.Slack {
+			panic("Unknown webhook format '" + webhook.Format + "', expected 'json' or 'slack'")
+		}
+	}
+
+	for planName, capabilities := range testConfig.Capabilities {
+		if capabilities.MaxMessageSize == "" {
+			continue
+		}
+		if _, err := payload.ParseSize(capabilities.MaxMessageSize); err != nil {
+			panic("Invalid max_message_size of plan '" + planName + "': " + err.Error())
+		}
+	}
+
+	if testConfig.TLS.MinVersion != "" {
+		if _, err := tlsprobe.ParseVersion(testConfig.TLS.MinVersion); err != nil {
+			panic("Invalid tls.min_version: " + err.Error())
+		}
+	}
+	if _, err := testConfig.TLS.caBundle(); err != nil {
+		panic("Invalid tls.ca_bundle: " + err.Error())
+	}
+	if _, err := loadCABundle(testConfig.AppRoutes.CABundle); err != nil {
+		panic("Invalid app_routes.ca_bundle: " + err.Error())
+	}
+	if testConfig.AppRoutes.HTTP && (testConfig.AppRoutes.CABundle != "" || testConfig.AppRoutes.SkipVerify) {
+		panic("app_routes.http leaves no certificates to verify: drop ca_bundle and skip_verify")
+	}
+
+	if testConfig.Durability.Messages == 0 {
+		testConfig.Durability.Messages = 3
+	}
+
+	if testConfig.Scaling.Messages == 0 {
+		testConfig.Scaling.Messages = 10 * testConfig.Scaling.Instances
+	}
+
+	if canary := os.Getenv("CANARY"); canary == "true" || canary == "1" {
+		testConfig.Canary.Enabled = true
+	}
+	if testConfig.Canary.Enabled && testConfig.DryRun {
+		panic("canary.enabled would check the plans forever against a dry run: turn off one of canary.enabled and dry_run")
+	}
+
 	return testConfig
 }
 
+// emitStepMetrics sends the steps of a finished lifecycle to StatsD. A
+// metrics pipeline that is down does not fail the smoke tests.
+func emitStepMetrics(planName string, protocol lifecycle.Protocol) {
+	if statsdEmitter == nil {
+		return
+	}
+	if err := statsdEmitter.Emit(stepRecorder.StepsOf(planName, string(protocol))); err != nil {
+		fmt.Fprintf(GinkgoWriter, "Failed to send metrics to StatsD: %s\n", err.Error())
+	}
+}
+
+// payloadMatrix builds the payloads to send through the plan, up to its
+// maximum size.
+func payloadMatrix(planName string) []payload.Payload {
+	maxSize := payload.DefaultMaxSize
+	value := config.Payloads.MaxSize
+	if planValue, ok := config.Payloads.PlanMaxSizes[planName]; ok {
+		value = planValue
+	}
+	if value != "" {
+		var err error
+		maxSize, err = payload.ParseSize(value)
+		Ω(err).ShouldNot(HaveOccurred())
+	}
+
+	payloads, err := payload.Matrix(config.Payloads.Kinds, maxSize)
+	Ω(err).ShouldNot(HaveOccurred())
+	return payloads
+}
+
+func parseDuration(value string, defaultValue time.Duration) time.Duration {
+	if value == "" {
+		return defaultValue
+	}
+	duration, err := time.ParseDuration(value)
+	Ω(err).ShouldNot(HaveOccurred())
+	return duration
+}
+
 var config = loadConfig()
 var context services.Context
+var sharedSpaceName string
+var cfPlatform platform.Platform
+var appHTTPClient *http.Client
+var managementHTTPClient *http.Client
+var brokerConnector broker.Connector
+var tlsProbe tlsprobe.Config
+var dryRunRecorder *dryrun.Recorder
+var stepRecorder = report.NewRecorder()
+var diagnosticsCollector *diagnostics.Collector
+var statsdEmitter *statsd.Emitter
+var tracer *tracing.Tracer
+var runSpan *tracing.Span
 
 var _ = Describe("RabbitMQ Service", func() {
 	var (
@@ -56,259 +514,468 @@ var _ = Describe("RabbitMQ Service", func() {
 		appSTOMPPath  = "../assets/cf-rabbitmq-example-stomp-app"
 	)
 
-	randomName := func() string {
-		return uuid.NewRandom().String()
-	}
-
-	appUri := func(appName string) string {
-		return "https://" + appName + "." + config.AppsDomain
-	}
-
-	assertAppIsRunning := func(appName string) {
-		pingUri := appUri(appName) + "/ping"
-		fmt.Println("Checking that the app is responding at url: ", pingUri)
-		Eventually(runner.Curl(pingUri, "-k"), config.ScaledTimeout(timeout), retryInterval).Should(Say("OK"))
-		fmt.Println("\n")
-	}
-
 	BeforeSuite(func() {
 		config.TimeoutScale = 30
+		appHTTPClient = exampleapp.NewHTTPClient(timeout, config.AppRoutes.tlsConfig())
+		brokerTLS := &tls.Config{InsecureSkipVerify: config.RabbitMQSkipSSL}
+		tlsProbe = tlsprobe.Config{MinVersion: config.TLS.minVersion(), SkipVerify: config.RabbitMQSkipSSL, Timeout: timeout}
+		if roots, _ := config.TLS.caBundle(); roots != nil {
+			brokerTLS = &tls.Config{RootCAs: roots}
+			tlsProbe.RootCAs, tlsProbe.SkipVerify = roots, false
+		}
+		brokerConnector = broker.NewConnector(timeout, brokerTLS)
+		managementHTTPClient = &http.Client{
+			Timeout: timeout,
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: brokerTLS,
+			},
+		}
+
+		if config.FakePlatform {
+			Ω(config.Backend).ShouldNot(Equal(platform.API), "the fake platform only stands in for the cf CLI")
+			fake := newFakePlatform()
+			cf.Cf = fake.Cf
+			appHTTPClient = fake.HTTPClient()
+			managementHTTPClient = fake.HTTPClient()
+			brokerConnector = fake.Connector()
+			roots, err := fake.CACertificates()
+			Ω(err).ShouldNot(HaveOccurred())
+			tlsProbe.RootCAs, tlsProbe.Dial, tlsProbe.SkipVerify = roots, fake.Dial, false
+		}
+
 		context = services.NewContext(config.Config, "rabbitmq-smoke-test")
+
+		if config.DryRun {
+			dryRunRecorder = dryrun.NewRecorder(os.Stdout, newFakePlatform())
+			dryRunRecorder.AddSecret(config.AdminPassword)
+			dryRunRecorder.AddSecret(context.RegularUserContext().Password)
+			cf.Cf = dryRunRecorder.Cf
+			cf.ApiRequest = dryRunRecorder.ApiRequest
+			appHTTPClient = dryRunRecorder.HTTPClient()
+			managementHTTPClient = dryRunRecorder.HTTPClient()
+			brokerConnector = dryRunRecorder.Connector()
+			roots, err := dryRunRecorder.CACertificates()
+			Ω(err).ShouldNot(HaveOccurred())
+			tlsProbe.RootCAs, tlsProbe.Dial, tlsProbe.SkipVerify = roots, dryRunRecorder.Dial, false
+			fmt.Println("Setting up the context:")
+		}
+
+		if config.Tracing.Endpoint != "" && !config.DryRun {
+			serviceName := config.Tracing.ServiceName
+			if serviceName == "" {
+				serviceName = "cf-rabbitmq-smoke-tests"
+			}
+			tracer = tracing.NewTracer(serviceName)
+			tracer.AddSecret(config.AdminPassword)
+			tracer.AddSecret(context.RegularUserContext().Password)
+
+			runSpan = tracer.StartSpan("rabbitmq smoke tests", tracing.KindInternal, nil)
+			runSpan.SetAttribute("cf.api", config.ApiEndpoint)
+			runSpan.SetAttribute("rabbitmq.service", config.ServiceName)
+			tracer.SetActive(runSpan)
+
+			cf.Cf = tracer.WrapCf(cf.Cf)
+			appHTTPClient = tracer.WrapHTTPClient(appHTTPClient)
+			managementHTTPClient = tracer.WrapHTTPClient(managementHTTPClient)
+		}
+
 		context.Setup()
-	})
 
-	AfterSuite(func() {
-		context.Teardown()
-	})
+		if config.SharedSpace {
+			createSharedSpace()
+		}
 
-	AssertLifeCycleMQTTBehavior := func(planName, appPath string) {
-		var serviceInstanceName string
-		appPushed := false
-		serviceCreated := false
-		serviceBound := false
-		appIsRunning := false
-		appName := randomName()
-
-		It("MQTT Protocol - Should be able to push the application", func() {
-			Eventually(cf.Cf("push", appName, "-m", "256M", "-p", appPath, "-s", "cflinuxfs2", "-no-start"), config.ScaledTimeout(timeout)).Should(Exit(0))
-			appPushed = true
-		})
+		if config.DryRun {
+			protocols := []string{string(lifecycle.AMQP)}
+			if config.TestSTOMP {
+				protocols = append(protocols, string(lifecycle.STOMP))
+			}
+			if config.TestMQTT {
+				protocols = append(protocols, string(lifecycle.MQTT))
+			}
 
-		It("MQTT Protocol - Can create the service instance", func() {
-			Ω(appPushed).Should(BeTrue())
-			serviceInstanceName = randomName()
-			Eventually(cf.Cf("create-service", config.ServiceName, planName, serviceInstanceName), config.ScaledTimeout(timeout)).Should(Exit(0))
-			serviceCreated = true
-		})
+			user := context.RegularUserContext()
+			plan := dryrun.Plan{
+				API:         config.ApiEndpoint,
+				Backend:     config.Backend,
+				ServiceName: config.ServiceName,
+				Plans:       config.PlanNames,
+				Protocols:   protocols,
+				Org:         user.Org,
+				ExistingOrg: config.OrgName != "",
+				Space:       user.Space,
+				User:        user.Username,
+			}
+			if plan.Backend == "" {
+				plan.Backend = platform.CLI
+			}
+			dryRunRecorder.ResolveContextNames(&plan)
+			plan.Write(os.Stdout)
+
+			// the API backend would make the same changes; show them as cf commands
+			config.Backend = platform.CLI
+		}
+
+		diagnosticsCollector = diagnostics.NewCollector(config.ArtifactsDir, config.ScaledTimeout(timeout), appHTTPClient)
 
-		It("MQTT Protocol - Can bind the service and start the application", func() {
-			Ω(appPushed && serviceCreated).Should(BeTrue())
-			Eventually(cf.Cf("bind-service", appName, serviceInstanceName), config.ScaledTimeout(timeout)).Should(Exit(0))
-			serviceBound = true
-			var RMQSkipSSLValue string = "0"
-			if config.RabbitMQSkipSSL {
-				RMQSkipSSLValue = "1"
+		if config.StatsD.Host != "" {
+			prefix := config.StatsD.Prefix
+			if prefix == "" {
+				prefix = "rabbitmq_smoke_tests"
 			}
-			Eventually(cf.Cf("set-env", appName, "RABBITMQ_SKIP_SSL", RMQSkipSSLValue), config.ScaledTimeout(5*time.Minute)).Should(Exit(0))
-			Eventually(cf.Cf("start", appName), config.ScaledTimeout(5*time.Minute)).Should(Exit(0))
-			assertAppIsRunning(appName)
-			appIsRunning = true
-		})
 
-		It("MQTT Protocol - can write to and read from a service instance using the "+planName+" plan", func() {
-			Ω(appPushed && serviceCreated && serviceBound && appIsRunning).Should(BeTrue())
-			/*
-			   subscribe          (should 204)
-			   publish            (should 201)
-			   subscribe          (should 200)
-			*/
+			var err error
+			statsdEmitter, err = statsd.NewEmitter(statsd.Config{
+				Host:      config.StatsD.Host,
+				Port:      config.StatsD.Port,
+				Prefix:    prefix,
+				DogStatsD: config.StatsD.DogStatsD,
+				Tags:      config.StatsD.Tags,
+			})
+			Ω(err).ShouldNot(HaveOccurred())
+		}
 
-			uri := appUri(appName) + "/queue/test-q"
+		switch config.Backend {
+		case "", platform.CLI:
+			cfPlatform = platform.NewCLI(config.ScaledTimeout(timeout), config.ScaledTimeout(5*time.Minute))
+		case platform.API:
+			user := context.RegularUserContext()
+			client := ccv3.NewClient(user.ApiUrl, user.Username, user.Password, user.SkipSSLValidation)
+			if tracer != nil {
+				client.WrapHTTPClient(tracer.WrapHTTPClient)
+			}
+			cfPlatform = platform.NewAPI(client, user.Org, user.Space, config.AppsDomain, config.ScaledTimeout(timeout), config.ScaledTimeout(5*time.Minute))
+		default:
+			Fail("Unknown backend '" + config.Backend + "', expected 'cli' or 'api'")
+		}
+	})
 
-			fmt.Println("Publishing to the queue: ", uri)
-			Eventually(runner.Curl("-d", "data=test-message-mqtt", "-X", "PUT", uri, "-k"), config.ScaledTimeout(timeout), retryInterval).Should(Say("SUCCESS"))
-			fmt.Println("\n")
+	AfterSuite(func() {
+		if config.DryRun {
+			fmt.Println("Tearing down the context:")
+		}
+		if sharedSpaceName != "" {
+			deleteSharedSpace()
+		}
+		context.Teardown()
 
-			fmt.Println("Reading from the (non-empty) queue: ", uri)
-			Eventually(runner.Curl(uri, "-k"), config.ScaledTimeout(timeout), retryInterval).Should(Say("test-message-mqtt"))
-			fmt.Println("\n")
+		if statsdEmitter != nil {
+			statsdEmitter.Close()
+		}
 
-			fmt.Println("Reading from the (empty) queue: ", uri)
-			Eventually(runner.Curl(uri, "-k"), config.ScaledTimeout(timeout), retryInterval).Should(Say(""))
-			fmt.Println("\n")
-		})
+		if tracer != nil {
+			runSpan.Finish(nil)
+			exporter := tracing.NewExporter(config.Tracing.Endpoint, config.Tracing.Headers, 10*time.Second)
+			if err := exporter.Export(tracer); err != nil {
+				fmt.Fprintf(GinkgoWriter, "Failed to export the trace: %s\n", err.Error())
+			}
+		}
+	})
+
+	lifecycleConfig := func(planName string, protocol lifecycle.Protocol, appPath string) lifecycle.Config {
+		return lifecycle.Config{
+			ServiceName:     config.ServiceName,
+			PlanName:        planName,
+			Protocol:        protocol,
+			AppPath:         appPath,
+			AppsDomain:      config.AppsDomain,
+			RabbitMQSkipSSL: config.RabbitMQSkipSSL,
+			AppRoutesHTTP:   config.AppRoutes.HTTP,
+			Timeout:         config.ScaledTimeout(timeout),
+			RetryInterval:   retryInterval,
+		}
+	}
 
-		It("MQTT Protocol - Should be able to clean up after itself", func() {
-			if serviceBound {
-				Eventually(cf.Cf("unbind-service", appName, serviceInstanceName), config.ScaledTimeout(timeout)).Should(Exit(0))
+	if config.Canary.Enabled {
+		It("keeps checking every plan until the canary's duration is up", func() {
+			configs := []lifecycle.Config{}
+			for _, planName := range config.PlanNames {
+				configs = append(configs, lifecycleConfig(planName, lifecycle.AMQP, appAMQPPath))
+				if config.TestSTOMP {
+					configs = append(configs, lifecycleConfig(planName, lifecycle.STOMP, appSTOMPPath))
+				}
+				if config.TestMQTT {
+					configs = append(configs, lifecycleConfig(planName, lifecycle.MQTT, appMQTTPath))
+				}
 			}
-			if serviceCreated {
-				Eventually(cf.Cf("delete-service", "-f", serviceInstanceName), config.ScaledTimeout(timeout)).Should(Exit(0))
+
+			registry := metrics.NewRegistry()
+			c := canary.New(configs, cfPlatform, appHTTPClient, managementHTTPClient, GinkgoWriter, registry)
+			c.Interval = parseDuration(config.Canary.Interval, c.Interval)
+			c.FullInterval = parseDuration(config.Canary.FullInterval, c.FullInterval)
+			c.Emitter = statsdEmitter
+
+			address := config.Canary.MetricsAddress
+			if address == "" {
+				address = ":9090"
 			}
-			if appPushed {
-				Eventually(cf.Cf("delete", appName, "-f"), config.ScaledTimeout(timeout)).Should(Exit(0))
+			listener, err := net.Listen("tcp", address)
+			Ω(err).ShouldNot(HaveOccurred())
+			defer listener.Close()
+
+			mux := http.NewServeMux()
+			mux.Handle("/metrics", registry)
+			go http.Serve(listener, mux)
+			fmt.Fprintf(GinkgoWriter, "Serving metrics at http://%s/metrics\n", listener.Addr())
+
+			stop := make(chan struct{})
+			if config.Canary.Duration != "" {
+				time.AfterFunc(parseDuration(config.Canary.Duration, 0), func() { close(stop) })
 			}
+			Ω(c.Run(stop)).Should(Succeed())
 		})
+		return
 	}
 
-	AssertLifeCycleSTOMPBehavior := func(planName, appPath string) {
-		var serviceInstanceName string
-		appPushed := false
-		serviceCreated := false
-		serviceBound := false
-		appIsRunning := false
-		appName := randomName()
-
-		It("STOMP Protocol - Should be able to push the application", func() {
-			Eventually(cf.Cf("push", appName, "-m", "256M", "-p", appPath, "-s", "cflinuxfs2", "-no-start"), config.ScaledTimeout(timeout)).Should(Exit(0))
-			appPushed = true
-		})
+	AssertLifeCycleBehavior := func(planName string, protocol lifecycle.Protocol, appPath string) {
+		var lc *lifecycle.Lifecycle
 
-		It("STOMP Protocol - Can create the service instance", func() {
-			Ω(appPushed).Should(BeTrue())
-			serviceInstanceName = randomName()
-			Eventually(cf.Cf("create-service", config.ServiceName, planName, serviceInstanceName), config.ScaledTimeout(timeout)).Should(Exit(0))
-			serviceCreated = true
-		})
+		specPrefix := ""
+		switch protocol {
+		case lifecycle.STOMP:
+			specPrefix = "STOMP Protocol - "
+		case lifecycle.MQTT:
+			specPrefix = "MQTT Protocol - "
+		}
 
-		It("STOMP Protocol - Can bind the service and start the application", func() {
-			Ω(appPushed && serviceCreated).Should(BeTrue())
-			Eventually(cf.Cf("bind-service", appName, serviceInstanceName), config.ScaledTimeout(timeout)).Should(Exit(0))
-			serviceBound = true
-			var RMQSkipSSLValue string = "0"
-			if config.RabbitMQSkipSSL {
-				RMQSkipSSLValue = "1"
+		It(specPrefix+"Should be able to push the application", func() {
+			if config.DryRun {
+				fmt.Printf("Plan %s over %s:\n", planName, protocol)
 			}
-			Eventually(cf.Cf("set-env", appName, "RABBITMQ_SKIP_SSL", RMQSkipSSLValue), config.ScaledTimeout(5*time.Minute)).Should(Exit(0))
-			Eventually(cf.Cf("start", appName), config.ScaledTimeout(5*time.Minute)).Should(Exit(0))
-			assertAppIsRunning(appName)
-			appIsRunning = true
-		})
 
-		It("STOMP Protocol - can write to and read from a service instance using the "+planName+" plan", func() {
-			Ω(appPushed && serviceCreated && serviceBound && appIsRunning).Should(BeTrue())
-			/*
-			   subscribe          (should 204)
-			   publish            (should 201)
-			   subscribe          (should 200)
-			*/
-			uri := appUri(appName) + "/queue/test-q"
-
-			fmt.Println("Publishing to the queue: ", uri)
-			Eventually(runner.Curl("-d", "data=test-message-stomp", "-X", "PUT", uri, "-k", "-vv"), config.ScaledTimeout(timeout), retryInterval).Should(Say("SUCCESS"))
-			fmt.Println("\n")
-
-			fmt.Println("Reading from the (non-empty) queue: ", uri)
-			Eventually(runner.Curl(uri, "-k", "-vv"), config.ScaledTimeout(timeout), retryInterval).Should(Say("test-message-stomp"))
-			fmt.Println("\n")
-
-			fmt.Println("Reading from the (empty) queue: ", uri)
-			Eventually(runner.Curl(uri, "-k"), config.ScaledTimeout(timeout), retryInterval).Should(Say(""))
-			fmt.Println("\n")
+			lc = lifecycle.New(lifecycleConfig(planName, protocol, appPath), cfPlatform, appHTTPClient, managementHTTPClient, GinkgoWriter).
+				WithRecorder(stepRecorder).
+				WithDiagnostics(diagnosticsCollector).
+				WithTracer(tracer).
+				WithConnector(brokerConnector)
+
+			Ω(lc.PushApp()).Should(Succeed())
 		})
 
-		It("STOMP Protocol - Should be able to clean up after itself", func() {
-			if serviceBound {
-				Eventually(cf.Cf("unbind-service", appName, serviceInstanceName), config.ScaledTimeout(timeout)).Should(Exit(0))
-			}
-			if serviceCreated {
-				Eventually(cf.Cf("delete-service", "-f", serviceInstanceName), config.ScaledTimeout(timeout)).Should(Exit(0))
-			}
-			if appPushed {
-				Eventually(cf.Cf("delete", appName, "-f"), config.ScaledTimeout(timeout)).Should(Exit(0))
-			}
+		It(specPrefix+"Can create the service instance", func() {
+			Ω(lc).ShouldNot(BeNil())
+			Ω(lc.CreateService()).Should(Succeed())
 		})
-	}
 
-	AssertLifeCycleAMQPBehavior := func(planName, appPath string) {
-		var serviceInstanceName string
-		appPushed := false
-		serviceCreated := false
-		serviceBound := false
-		appIsRunning := false
-		appName := randomName()
-
-		It("Should be able to push the application", func() {
-			Eventually(cf.Cf("push", appName, "-m", "256M", "-p", appPath, "-s", "cflinuxfs2", "-no-start"), config.ScaledTimeout(timeout)).Should(Exit(0))
-			appPushed = true
+		It(specPrefix+"Can bind the service and start the application", func() {
+			Ω(lc).ShouldNot(BeNil())
+			Ω(lc.BindAndStart()).Should(Succeed())
 		})
 
-		It("Can create the service instance", func() {
-			Ω(appPushed).Should(BeTrue())
-			serviceInstanceName = randomName()
-			Eventually(cf.Cf("create-service", config.ServiceName, planName, serviceInstanceName), config.ScaledTimeout(timeout)).Should(Exit(0))
-			serviceCreated = true
+		It(specPrefix+"can write to and read from a service instance using the "+planName+" plan", func() {
+			Ω(lc).ShouldNot(BeNil())
+			Ω(lc.WriteAndRead()).Should(Succeed())
 		})
 
-		It("Can bind the service and start the application", func() {
-			Ω(appPushed && serviceCreated).Should(BeTrue())
-			Eventually(cf.Cf("bind-service", appName, serviceInstanceName), config.ScaledTimeout(timeout)).Should(Exit(0))
-			serviceBound = true
-			var RMQSkipSSLValue string = "0"
-			if config.RabbitMQSkipSSL {
-				RMQSkipSSLValue = "1"
+		if len(config.Payloads.Kinds) > 0 {
+			It(specPrefix+"can send empty, unicode, binary and large payloads intact using the "+planName+" plan", func() {
+				Ω(lc).ShouldNot(BeNil())
+				Ω(lc.CheckPayloads(payloadMatrix(planName))).Should(Succeed())
+			})
+		}
+
+		if config.OrderedMessages > 0 {
+			It(specPrefix+fmt.Sprintf("can send a batch of %d messages in order using the %s plan", config.OrderedMessages, planName), func() {
+				Ω(lc).ShouldNot(BeNil())
+				Ω(lc.CheckOrdering(config.OrderedMessages)).Should(Succeed())
+			})
+		}
+
+		if config.Durability.enabledFor(planName) {
+			It(specPrefix+"keeps messages across an app restart using the "+planName+" plan", func() {
+				Ω(lc).ShouldNot(BeNil())
+				Ω(lc.CheckDurability(config.Durability.Messages, config.Durability.CheckDurableFlag)).Should(Succeed())
+			})
+		}
+
+		// MQTT subscribers each get their own copy, so only the queues of
+		// the other apps have competing consumers
+		if config.Scaling.Instances > 1 && protocol != lifecycle.MQTT {
+			It(specPrefix+fmt.Sprintf("consumes each message once across %d app instances using the %s plan", config.Scaling.Instances, planName), func() {
+				Ω(lc).ShouldNot(BeNil())
+				Ω(lc.CheckScaling(config.Scaling.Instances, config.Scaling.Messages)).Should(Succeed())
+			})
+		}
+
+		// an MQTT app only gets what is published after it subscribes,
+		// so the second app could not consume the first app's message
+		if config.SharedInstance.Enabled && protocol != lifecycle.MQTT {
+			It(specPrefix+"can consume through a second app bound to the instance using the "+planName+" plan", func() {
+				Ω(lc).ShouldNot(BeNil())
+				Ω(lc.CheckSharedInstance(config.SharedInstance.RequireDistinctCredentials)).Should(Succeed())
+			})
+		}
+
+		if config.SharedSpace && protocol != lifecycle.MQTT {
+			It(specPrefix+"can share the service instance into another space using the "+planName+" plan", func() {
+				Ω(lc).ShouldNot(BeNil())
+				Ω(lc.CheckSharedSpace(sharedSpaceName)).Should(Succeed())
+			})
+		}
+
+		if config.Management.Enabled && protocol == lifecycle.AMQP {
+			It("can use the management API with the binding's credentials using the "+planName+" plan", func() {
+				Ω(lc).ShouldNot(BeNil())
+				Ω(lc.CheckManagementAPI(config.Management.expectationsFor(planName))).Should(Succeed())
+			})
+		}
+
+		if capabilities, ok := config.Capabilities[planName]; ok && protocol == lifecycle.AMQP {
+			if maxSize := capabilities.maxMessageSize(); maxSize > 0 {
+				It(fmt.Sprintf("refuses messages over %s using the %s plan", capabilities.MaxMessageSize, planName), func() {
+					Ω(lc).ShouldNot(BeNil())
+					Ω(lc.CheckMaxMessageSize(maxSize)).Should(Succeed())
+				})
 			}
-			Eventually(cf.Cf("set-env", appName, "RABBITMQ_SKIP_SSL", RMQSkipSSLValue), config.ScaledTimeout(5*time.Minute)).Should(Exit(0))
-			Eventually(cf.Cf("start", appName), config.ScaledTimeout(5*time.Minute)).Should(Exit(0))
-			assertAppIsRunning(appName)
-			appIsRunning = true
-		})
 
-		It("can write to and read from a service instance using the "+planName+" plan", func() {
-			Ω(appPushed && serviceCreated && serviceBound && appIsRunning).Should(BeTrue())
-			/*
-			   create a queue     (should 201)
-			   list the queues    (should 200)
-			   subscribe          (should 204)
-			   publish            (should 201)
-			   subscribe          (should 200)
-			*/
-			uri := appUri(appName) + "/queues"
-			fmt.Println("Creating a new queue: ", uri)
-			Eventually(runner.Curl(uri, "-k", "-X", "POST", "-d", "name=test-q"), config.ScaledTimeout(timeout), retryInterval).Should(Say("SUCCESS"))
-			fmt.Println("\n")
-
-			fmt.Println("Listing the queues: ", uri)
-			Eventually(runner.Curl(uri, "-k"), config.ScaledTimeout(timeout), retryInterval).Should(Say("test-q\n"))
-			fmt.Println("\n")
-
-			uri = appUri(appName) + "/queue/test-q"
-
-			fmt.Println("Publishing to the queue: ", uri)
-			Eventually(runner.Curl("-d", "data=test-message-amqp", "-X", "PUT", uri, "-k"), config.ScaledTimeout(timeout), retryInterval).Should(Say("SUCCESS"))
-			fmt.Println("\n")
-
-			fmt.Println("Reading from the (non-empty) queue: ", uri)
-			Eventually(runner.Curl(uri, "-k"), config.ScaledTimeout(timeout), retryInterval).Should(Say("test-message-amqp"))
-			fmt.Println("\n")
-
-			fmt.Println("Reading from the (empty) queue: ", uri)
-			Eventually(runner.Curl(uri, "-k"), config.ScaledTimeout(timeout), retryInterval).Should(Say(""))
-			fmt.Println("\n")
-		})
+			if capabilities.MaxConnections > 0 {
+				It(fmt.Sprintf("refuses more than %d connections using the %s plan", capabilities.MaxConnections, planName), func() {
+					Ω(lc).ShouldNot(BeNil())
+					Ω(lc.CheckMaxConnections(capabilities.MaxConnections)).Should(Succeed())
+				})
+			}
+
+			if len(capabilities.QueueTypes) > 0 {
+				It(fmt.Sprintf("offers %s queues using the %s plan", strings.Join(capabilities.QueueTypes, ", "), planName), func() {
+					Ω(lc).ShouldNot(BeNil())
+					Ω(lc.CheckQueueTypes(capabilities.QueueTypes)).Should(Succeed())
+				})
+			}
 
-		It("Should be able to clean up after itself", func() {
-			if serviceBound {
-				Eventually(cf.Cf("unbind-service", appName, serviceInstanceName), config.ScaledTimeout(timeout)).Should(Exit(0))
+			if capabilities.TLSRequired {
+				It("only offers TLS endpoints using the "+planName+" plan", func() {
+					Ω(lc).ShouldNot(BeNil())
+					Ω(lc.CheckTLSRequired()).Should(Succeed())
+				})
+
+				It("refuses plaintext connections using the "+planName+" plan", func() {
+					Ω(lc).ShouldNot(BeNil())
+					Ω(lc.CheckPlaintextRefused()).Should(Succeed())
+				})
 			}
-			if serviceCreated {
-				Eventually(cf.Cf("delete-service", "-f", serviceInstanceName), config.ScaledTimeout(timeout)).Should(Exit(0))
+
+			if len(capabilities.OperatorPolicy) > 0 {
+				It("applies the operator policy to queues using the "+planName+" plan", func() {
+					Ω(lc).ShouldNot(BeNil())
+					Ω(lc.CheckOperatorPolicy(capabilities.OperatorPolicy)).Should(Succeed())
+				})
 			}
-			if appPushed {
-				Eventually(cf.Cf("delete", appName, "-f"), config.ScaledTimeout(timeout)).Should(Exit(0))
+		}
+
+		if config.QuorumQueues && protocol == lifecycle.AMQP {
+			It("can send messages through a quorum queue using the "+planName+" plan", func() {
+				if !offersQueueType(planName, "quorum") {
+					Skip("the " + planName + " plan does not offer quorum queues")
+				}
+				Ω(lc).ShouldNot(BeNil())
+				Ω(lc.CheckQuorumQueue()).Should(Succeed())
+			})
+		}
+
+		if config.Streams && protocol == lifecycle.AMQP {
+			It("can read a stream from an offset using the "+planName+" plan", func() {
+				if !offersQueueType(planName, "stream") {
+					Skip("the " + planName + " plan does not offer streams")
+				}
+				Ω(lc).ShouldNot(BeNil())
+				Ω(lc.CheckStreamQueue()).Should(Succeed())
+			})
+		}
+
+		if config.DeliveryGuarantees && protocol == lifecycle.AMQP {
+			It("can confirm, nack and reject messages using the "+planName+" plan", func() {
+				Ω(lc).ShouldNot(BeNil())
+				Ω(lc.CheckDeliveryGuarantees()).Should(Succeed())
+			})
+		}
+
+		if config.DeadLettering && protocol == lifecycle.AMQP {
+			It("dead-letters messages that overflow or expire using the "+planName+" plan", func() {
+				Ω(lc).ShouldNot(BeNil())
+				Ω(lc.CheckDeadLettering()).Should(Succeed())
+			})
+		}
+
+		if config.TLS.Check && protocol == lifecycle.AMQP {
+			It("offers sound TLS endpoints using the "+planName+" plan", func() {
+				Ω(lc).ShouldNot(BeNil())
+				Ω(lc.CheckTLSPosture(tlsProbe)).Should(Succeed())
+			})
+		}
+
+		if config.CrossProtocol && protocol == lifecycle.AMQP {
+			It("can publish over MQTT and STOMP and consume over AMQP using the "+planName+" plan", func() {
+				Ω(lc).ShouldNot(BeNil())
+				Ω(lc.CheckCrossProtocol()).Should(Succeed())
+			})
+		}
+
+		It(specPrefix+"Should be able to clean up after itself", func() {
+			if lc != nil {
+				defer emitStepMetrics(planName, protocol)
+				Ω(lc.Cleanup()).Should(Succeed())
 			}
 		})
 	}
 
 	Context("for each plan", func() {
 		for _, planName := range config.PlanNames {
-			AssertLifeCycleAMQPBehavior(planName, appAMQPPath)
-      if config.TestSTOMP {
-			  AssertLifeCycleSTOMPBehavior(planName, appSTOMPPath)
-      }
-      if config.TestMQTT {
-			  AssertLifeCycleMQTTBehavior(planName, appMQTTPath)
-      }
+			AssertLifeCycleBehavior(planName, lifecycle.AMQP, appAMQPPath)
+			if config.TestSTOMP {
+				AssertLifeCycleBehavior(planName, lifecycle.STOMP, appSTOMPPath)
+			}
+			if config.TestMQTT {
+				AssertLifeCycleBehavior(planName, lifecycle.MQTT, appMQTTPath)
+			}
 		}
 	})
 })
+
+// newFakePlatform returns a fake foundation whose plans have the
+// capabilities the config gives them, so that the checks generated from
+// them pass offline.
+func newFakePlatform() *fakeplatform.Platform {
+	fake := fakeplatform.New(config.AppsDomain)
+	for planName, capabilities := range config.Capabilities {
+		fake.LimitPlan(planName, fakeplatform.Limits{
+			MaxMessageSize: capabilities.maxMessageSize(),
+			MaxConnections: capabilities.MaxConnections,
+			QueueTypes:     capabilities.QueueTypes,
+			TLSRequired:    capabilities.TLSRequired,
+			OperatorPolicy: capabilities.OperatorPolicy,
+		})
+	}
+	return fake
+}
+
+// createSharedSpace creates the space the instances are shared into, next
+// to the context's, with the same user and security group.
+func createSharedSpace() {
+	user := context.RegularUserContext()
+	timeout := context.ShortTimeout()
+	sharedSpaceName = user.Space + "-SHARED"
+
+	cf.AsUser(context.AdminUserContext(), timeout, func() {
+		runner.NewCmdRunner(cf.Cf("create-space", "-o", user.Org, sharedSpaceName), timeout).Run()
+		runner.NewCmdRunner(cf.Cf("set-space-role", user.Username, user.Org, sharedSpaceName, "SpaceDeveloper"), timeout).Run()
+
+		// the context names its security group after its space
+		if config.CreatePermissiveSecurityGroup {
+			securityGroup := strings.Replace(user.Space, "-SPACE-", "-SECURITY_GROUP-", 1)
+			runner.NewCmdRunner(cf.Cf("bind-security-group", securityGroup, user.Org, sharedSpaceName), timeout).Run()
+		}
+	})
+}
+
+// deleteSharedSpace deletes the shared space before the context tears
+// down its own.
+func deleteSharedSpace() {
+	user := context.RegularUserContext()
+	timeout := context.LongTimeout()
+
+	cf.AsUser(context.AdminUserContext(), timeout, func() {
+		runner.NewCmdRunner(cf.Cf("target", "-o", user.Org), timeout).Run()
+		runner.NewCmdRunner(cf.Cf("delete-space", "-f", sharedSpaceName), timeout).Run()
+	})
+}
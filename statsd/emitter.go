@@ -0,0 +1,101 @@
+package statsd
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/report"
+)
+
+// Config says where to send metrics and how to name them.
+type Config struct {
+	Host   string
+	Port   int
+	Prefix string
+
+	// DogStatsD sends the plan, protocol and step as tags, along with
+	// Tags. Plain StatsD has no tags, so they go into the metric name
+	// instead and Tags are ignored.
+	DogStatsD bool
+	Tags      []string
+}
+
+// Emitter sends a counter for each step's outcome and a timer for its
+// duration over UDP, plus a timer for the message latency of steps that
+// read a message back.
+type Emitter struct {
+	config Config
+	conn   net.Conn
+}
+
+func NewEmitter(config Config) (*Emitter, error) {
+	conn, err := net.Dial("udp", net.JoinHostPort(config.Host, strconv.Itoa(config.Port)))
+	if err != nil {
+		return nil, err
+	}
+	return &Emitter{config: config, conn: conn}, nil
+}
+
+func (e *Emitter) Close() error {
+	return e.conn.Close()
+}
+
+// Emit sends the metrics for steps, one packet per metric.
+func (e *Emitter) Emit(steps []report.Step) error {
+	for _, step := range steps {
+		milliseconds := step.Duration().Nanoseconds() / 1000000
+		latencyMilliseconds := step.Latency.Nanoseconds() / 1000000
+
+		var counter, timer, latency string
+		if e.config.DogStatsD {
+			tags := append([]string{
+				"plan:" + tagValue(step.Plan),
+				"protocol:" + tagValue(step.Protocol),
+				"step:" + tagValue(step.Name),
+			}, e.config.Tags...)
+			suffix := "|#" + strings.Join(tags, ",")
+
+			counter = fmt.Sprintf("%s:1|c%s", e.name("step", step.Outcome), suffix)
+			timer = fmt.Sprintf("%s:%d|ms%s", e.name("step", "duration"), milliseconds, suffix)
+			latency = fmt.Sprintf("%s:%d|ms%s", e.name("message", "latency"), latencyMilliseconds, suffix)
+		} else {
+			counter = fmt.Sprintf("%s:1|c", e.name(step.Plan, step.Protocol, step.Name, step.Outcome))
+			timer = fmt.Sprintf("%s:%d|ms", e.name(step.Plan, step.Protocol, step.Name, "duration"), milliseconds)
+			latency = fmt.Sprintf("%s:%d|ms", e.name(step.Plan, step.Protocol, "latency"), latencyMilliseconds)
+		}
+
+		metrics := []string{counter, timer}
+		if step.Latency > 0 {
+			metrics = append(metrics, latency)
+		}
+		for _, metric := range metrics {
+			if _, err := e.conn.Write([]byte(metric)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+var (
+	unsafeInName     = regexp.MustCompile(`[^A-Za-z0-9_\-]`)
+	unsafeInTagValue = regexp.MustCompile(`[,|#\s]`)
+)
+
+func (e *Emitter) name(parts ...string) string {
+	name := []string{}
+	if e.config.Prefix != "" {
+		name = append(name, e.config.Prefix)
+	}
+	for _, part := range parts {
+		name = append(name, unsafeInName.ReplaceAllString(part, "_"))
+	}
+	return strings.Join(name, ".")
+}
+
+func tagValue(value string) string {
+	return unsafeInTagValue.ReplaceAllString(value, "_")
+}
@@ -0,0 +1,91 @@
+package statsd_test
+
+import (
+	"net"
+	"time"
+
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/report"
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/statsd"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Emitter", func() {
+	var (
+		listener net.PacketConn
+		port     int
+		steps    []report.Step
+	)
+
+	BeforeEach(func() {
+		var err error
+		listener, err = net.ListenPacket("udp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		port = listener.LocalAddr().(*net.UDPAddr).Port
+
+		start := time.Now()
+		steps = []report.Step{
+			{Plan: "standard", Protocol: "amqp", Name: "push", Start: start, End: start.Add(1500 * time.Millisecond), Outcome: report.Passed},
+			{Plan: "standard", Protocol: "amqp", Name: "consume", Start: start, End: start.Add(25 * time.Millisecond), Outcome: report.Passed, Latency: 12 * time.Millisecond},
+		}
+	})
+
+	AfterEach(func() {
+		listener.Close()
+	})
+
+	receive := func(count int) []string {
+		packets := []string{}
+		buffer := make([]byte, 1024)
+		for len(packets) < count {
+			listener.SetReadDeadline(time.Now().Add(time.Second))
+			n, _, err := listener.ReadFrom(buffer)
+			Expect(err).NotTo(HaveOccurred())
+			packets = append(packets, string(buffer[:n]))
+		}
+		return packets
+	}
+
+	emit := func(config statsd.Config) {
+		config.Host = "127.0.0.1"
+		config.Port = port
+
+		emitter, err := statsd.NewEmitter(config)
+		Expect(err).NotTo(HaveOccurred())
+		defer emitter.Close()
+
+		Expect(emitter.Emit(steps)).To(Succeed())
+	}
+
+	It("sends a counter per outcome and a timer per step, named after the plan, protocol and step", func() {
+		emit(statsd.Config{Prefix: "smoke"})
+
+		Expect(receive(5)).To(Equal([]string{
+			"smoke.standard.amqp.push.passed:1|c",
+			"smoke.standard.amqp.push.duration:1500|ms",
+			"smoke.standard.amqp.consume.passed:1|c",
+			"smoke.standard.amqp.consume.duration:25|ms",
+			"smoke.standard.amqp.latency:12|ms",
+		}))
+	})
+
+	It("sends the plan, protocol and step as DogStatsD tags", func() {
+		emit(statsd.Config{Prefix: "smoke", DogStatsD: true, Tags: []string{"foundation:lab"}})
+
+		Expect(receive(5)).To(Equal([]string{
+			"smoke.step.passed:1|c|#plan:standard,protocol:amqp,step:push,foundation:lab",
+			"smoke.step.duration:1500|ms|#plan:standard,protocol:amqp,step:push,foundation:lab",
+			"smoke.step.passed:1|c|#plan:standard,protocol:amqp,step:consume,foundation:lab",
+			"smoke.step.duration:25|ms|#plan:standard,protocol:amqp,step:consume,foundation:lab",
+			"smoke.message.latency:12|ms|#plan:standard,protocol:amqp,step:consume,foundation:lab",
+		}))
+	})
+
+	It("keeps plan names from breaking the metric format", func() {
+		steps = []report.Step{{Plan: "ha:3.node", Protocol: "amqp", Name: "push", Outcome: report.Passed}}
+		emit(statsd.Config{})
+
+		Expect(receive(1)).To(Equal([]string{"ha_3_node.amqp.push.passed:1|c"}))
+	})
+})
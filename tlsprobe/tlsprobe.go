@@ -0,0 +1,163 @@
+package tlsprobe
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// Config is how endpoints are probed and what they must live up to.
+type Config struct {
+	// MinVersion is the lowest acceptable version, e.g. tls.VersionTLS12;
+	// zero accepts any. Endpoints are probed down to TLS 1.0 regardless,
+	// so that the version they speak can be reported.
+	MinVersion uint16
+
+	// RootCAs are trusted to sign the endpoints' certificates; nil trusts
+	// the system's. With SkipVerify, a chain that is not trusted is
+	// reported, but is not a problem.
+	RootCAs    *x509.CertPool
+	SkipVerify bool
+
+	Timeout time.Duration
+
+	// Dial opens the connection to handshake over; nil dials TCP.
+	Dial func(network, address string) (net.Conn, error)
+}
+
+// Result is what a handshake showed of an endpoint.
+type Result struct {
+	Address     string
+	ServerName  string
+	Version     uint16
+	CipherSuite uint16
+
+	// Chain is the certificates the endpoint presented, its own first.
+	Chain []*x509.Certificate
+
+	// TrustError is why the chain is not trusted, if it is not.
+	TrustError error
+
+	// Problems are what fail the endpoint: an expired certificate, a
+	// version below the minimum, a certificate that does not cover the
+	// server name or, unless verification is skipped, an untrusted chain.
+	Problems []string
+}
+
+// Probe handshakes with the endpoint at address, as serverName, and
+// judges what it presents against config at the current time.
+func Probe(address, serverName string, config Config) (Result, error) {
+	dial := config.Dial
+	if dial == nil {
+		dial = (&net.Dialer{Timeout: config.Timeout}).Dial
+	}
+	conn, err := dial("tcp", address)
+	if err != nil {
+		return Result{}, err
+	}
+	defer conn.Close()
+	if config.Timeout > 0 {
+		conn.SetDeadline(time.Now().Add(config.Timeout))
+	}
+
+	// the chain is verified once the handshake is done, so that one
+	// that is not trusted can still be described
+	client := tls.Client(conn, &tls.Config{
+		ServerName:         serverName,
+		InsecureSkipVerify: true,
+		MinVersion:         tls.VersionTLS10,
+	})
+	if err := client.Handshake(); err != nil {
+		return Result{}, fmt.Errorf("TLS handshake with %s failed: %s", address, err.Error())
+	}
+
+	state := client.ConnectionState()
+	result := Result{
+		Address:     address,
+		ServerName:  serverName,
+		Version:     state.Version,
+		CipherSuite: state.CipherSuite,
+		Chain:       state.PeerCertificates,
+	}
+	result.judge(config, time.Now())
+	return result, nil
+}
+
+func (r *Result) judge(config Config, now time.Time) {
+	if config.MinVersion != 0 && r.Version < config.MinVersion {
+		r.Problems = append(r.Problems, fmt.Sprintf("%s is below the minimum of %s", VersionName(r.Version), VersionName(config.MinVersion)))
+	}
+	if len(r.Chain) == 0 {
+		r.Problems = append(r.Problems, "the endpoint presented no certificate")
+		return
+	}
+
+	expired := false
+	for _, certificate := range r.Chain {
+		switch {
+		case now.After(certificate.NotAfter):
+			expired = true
+			r.Problems = append(r.Problems, fmt.Sprintf("the certificate of %s expired on %s", certificate.Subject, certificate.NotAfter.UTC().Format(time.RFC3339)))
+		case now.Before(certificate.NotBefore):
+			expired = true
+			r.Problems = append(r.Problems, fmt.Sprintf("the certificate of %s is not valid until %s", certificate.Subject, certificate.NotBefore.UTC().Format(time.RFC3339)))
+		}
+	}
+
+	leaf := r.Chain[0]
+	if err := leaf.VerifyHostname(r.ServerName); err != nil {
+		r.Problems = append(r.Problems, fmt.Sprintf("the certificate does not cover %s, only: %s", r.ServerName, strings.Join(Names(leaf), ", ")))
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, certificate := range r.Chain[1:] {
+		intermediates.AddCert(certificate)
+	}
+	_, r.TrustError = leaf.Verify(x509.VerifyOptions{
+		Roots:         config.RootCAs,
+		Intermediates: intermediates,
+		CurrentTime:   now,
+	})
+	// an expired chain is not trusted either, which says nothing new
+	if r.TrustError != nil && !config.SkipVerify && !expired {
+		r.Problems = append(r.Problems, fmt.Sprintf("the certificate chain is not trusted: %s", r.TrustError.Error()))
+	}
+}
+
+// Names are the DNS names and IP addresses a certificate covers.
+func Names(certificate *x509.Certificate) []string {
+	names := append([]string{}, certificate.DNSNames...)
+	for _, ip := range certificate.IPAddresses {
+		names = append(names, ip.String())
+	}
+	return names
+}
+
+var versions = map[uint16]string{
+	tls.VersionTLS10: "TLS 1.0",
+	tls.VersionTLS11: "TLS 1.1",
+	tls.VersionTLS12: "TLS 1.2",
+	tls.VersionTLS13: "TLS 1.3",
+}
+
+// VersionName names a TLS version, e.g. "TLS 1.2".
+func VersionName(version uint16) string {
+	if name, ok := versions[version]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%04x", version)
+}
+
+// ParseVersion reads a TLS version named like "1.2" or "TLS 1.2".
+func ParseVersion(name string) (uint16, error) {
+	number := strings.TrimSpace(strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(name)), "TLS"))
+	for version, versionName := range versions {
+		if versionName == "TLS "+number {
+			return version, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown TLS version %q, expected one of 1.0, 1.1, 1.2 and 1.3", name)
+}
@@ -0,0 +1,13 @@
+package tlsprobe_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestTLSProbe(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "TLS Probe Suite")
+}
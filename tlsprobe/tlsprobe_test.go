@@ -0,0 +1,87 @@
+package tlsprobe_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/fakeplatform"
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/tlsprobe"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Probe", func() {
+	var (
+		fake   *fakeplatform.Platform
+		config tlsprobe.Config
+	)
+
+	BeforeEach(func() {
+		fake = fakeplatform.New("fake-domain")
+		roots, err := fake.CACertificates()
+		Ω(err).ShouldNot(HaveOccurred())
+		config = tlsprobe.Config{MinVersion: tls.VersionTLS12, RootCAs: roots, Dial: fake.Dial}
+	})
+
+	It("describes the version, cipher suite and chain of an endpoint that passes", func() {
+		result, err := tlsprobe.Probe("rabbitmq.fake:5671", "rabbitmq.fake", config)
+		Ω(err).ShouldNot(HaveOccurred())
+		Expect(result.Version).To(BeEquivalentTo(tls.VersionTLS13))
+		Expect(tls.CipherSuiteName(result.CipherSuite)).To(HavePrefix("TLS_"))
+		Expect(result.Chain).To(HaveLen(2))
+		Expect(result.Chain[0].Subject.CommonName).To(Equal("rabbitmq.fake"))
+		Expect(result.Chain[1].Subject.CommonName).To(Equal("Fake RabbitMQ CA"))
+		Expect(tlsprobe.Names(result.Chain[0])).To(Equal([]string{"rabbitmq.fake"}))
+		Expect(result.TrustError).NotTo(HaveOccurred())
+		Expect(result.Problems).To(BeEmpty())
+	})
+
+	It("fails expired certificates", func() {
+		fake.ServeTLS(fakeplatform.TLS{Expired: true})
+		result, err := tlsprobe.Probe("rabbitmq.fake:443", "rabbitmq.fake", config)
+		Ω(err).ShouldNot(HaveOccurred())
+		Expect(result.Problems).To(ConsistOf(MatchRegexp(`the certificate of CN=rabbitmq.fake expired on \d{4}-`)))
+	})
+
+	It("fails versions below the minimum", func() {
+		fake.ServeTLS(fakeplatform.TLS{MaxVersion: tls.VersionTLS11})
+		result, err := tlsprobe.Probe("rabbitmq.fake:8883", "rabbitmq.fake", config)
+		Ω(err).ShouldNot(HaveOccurred())
+		Expect(result.Version).To(BeEquivalentTo(tls.VersionTLS11))
+		Expect(result.Problems).To(ConsistOf("TLS 1.1 is below the minimum of TLS 1.2"))
+	})
+
+	It("fails certificates that do not cover the host", func() {
+		fake.ServeTLS(fakeplatform.TLS{Hosts: []string{"other.fake", "*.internal.fake"}})
+		result, err := tlsprobe.Probe("rabbitmq.fake:61614", "rabbitmq.fake", config)
+		Ω(err).ShouldNot(HaveOccurred())
+		Expect(result.Problems).To(ConsistOf("the certificate does not cover rabbitmq.fake, only: other.fake, *.internal.fake"))
+	})
+
+	It("fails chains that are not trusted, unless verification is skipped", func() {
+		config.RootCAs = x509.NewCertPool()
+		result, err := tlsprobe.Probe("rabbitmq.fake:5671", "rabbitmq.fake", config)
+		Ω(err).ShouldNot(HaveOccurred())
+		Expect(result.Problems).To(ConsistOf(HavePrefix("the certificate chain is not trusted: ")))
+
+		config.SkipVerify = true
+		result, err = tlsprobe.Probe("rabbitmq.fake:5671", "rabbitmq.fake", config)
+		Ω(err).ShouldNot(HaveOccurred())
+		Expect(result.TrustError).To(HaveOccurred())
+		Expect(result.Problems).To(BeEmpty())
+	})
+
+	It("reports endpoints it cannot reach", func() {
+		_, err := tlsprobe.Probe("rabbitmq.fake:5672", "rabbitmq.fake", config)
+		Expect(err).To(MatchError("dial tcp rabbitmq.fake:5672: connection refused"))
+	})
+
+	It("names and parses versions", func() {
+		Expect(tlsprobe.VersionName(tls.VersionTLS12)).To(Equal("TLS 1.2"))
+		Expect(tlsprobe.ParseVersion("1.3")).To(BeEquivalentTo(tls.VersionTLS13))
+		Expect(tlsprobe.ParseVersion("TLS 1.1")).To(BeEquivalentTo(tls.VersionTLS11))
+		_, err := tlsprobe.ParseVersion("SSL 3")
+		Expect(err).To(MatchError(`unknown TLS version "SSL 3", expected one of 1.0, 1.1, 1.2 and 1.3`))
+	})
+})
@@ -0,0 +1,35 @@
+package tracing
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/onsi/gomega/gexec"
+)
+
+// WrapCf traces each command run through cf, which can replace cf.Cf. The
+// span ends when the command exits.
+func (t *Tracer) WrapCf(cf func(args ...string) *gexec.Session) func(args ...string) *gexec.Session {
+	return func(args ...string) *gexec.Session {
+		name := "cf"
+		if len(args) > 0 {
+			name = "cf " + args[0]
+		}
+
+		span := t.StartSpan(name, KindClient, t.Active())
+		span.SetAttribute("cf.command", "cf "+strings.Join(args, " "))
+
+		session := cf(args...)
+		go func() {
+			<-session.Exited
+			span.SetAttribute("cf.exit_code", session.ExitCode())
+
+			var err error
+			if session.ExitCode() != 0 {
+				err = fmt.Errorf("exit status %d", session.ExitCode())
+			}
+			span.Finish(err)
+		}()
+		return session
+	}
+}
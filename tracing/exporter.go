@@ -0,0 +1,176 @@
+package tracing
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Exporter sends spans to an OpenTelemetry collector over OTLP/HTTP, in
+// the JSON encoding.
+type Exporter struct {
+	endpoint   string
+	headers    map[string]string
+	httpClient *http.Client
+}
+
+// NewExporter exports to endpoint, the full URL of the collector's traces
+// receiver, e.g. http://localhost:4318/v1/traces. Headers are added to
+// each request, for authentication.
+func NewExporter(endpoint string, headers map[string]string, timeout time.Duration) *Exporter {
+	return &Exporter{
+		endpoint:   endpoint,
+		headers:    headers,
+		httpClient: &http.Client{Timeout: timeout},
+	}
+}
+
+// Export sends the tracer's finished spans.
+func (e *Exporter) Export(tracer *Tracer) error {
+	spans := tracer.Finished()
+	body, err := json.Marshal(otlpRequest(tracer.ServiceName, spans))
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequest("POST", e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	for key, value := range e.headers {
+		request.Header.Set(key, value)
+	}
+
+	response, err := e.httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode >= 300 {
+		message, _ := ioutil.ReadAll(response.Body)
+		return fmt.Errorf("Failed to export %d spans to %s: %s %s", len(spans), e.endpoint, response.Status, message)
+	}
+	return nil
+}
+
+// The OTLP JSON encoding: IDs in hex, 64-bit integers as strings.
+type exportRequest struct {
+	ResourceSpans []resourceSpans `json:"resourceSpans"`
+}
+
+type resourceSpans struct {
+	Resource   resource     `json:"resource"`
+	ScopeSpans []scopeSpans `json:"scopeSpans"`
+}
+
+type resource struct {
+	Attributes []keyValue `json:"attributes"`
+}
+
+type scopeSpans struct {
+	Scope scope      `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type scope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string     `json:"traceId"`
+	SpanID            string     `json:"spanId"`
+	ParentSpanID      string     `json:"parentSpanId,omitempty"`
+	Name              string     `json:"name"`
+	Kind              int        `json:"kind"`
+	StartTimeUnixNano string     `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+	Attributes        []keyValue `json:"attributes"`
+	Status            status     `json:"status"`
+}
+
+type status struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type keyValue struct {
+	Key   string   `json:"key"`
+	Value anyValue `json:"value"`
+}
+
+type anyValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+}
+
+const (
+	statusOK    = 1
+	statusError = 2
+)
+
+func otlpRequest(serviceName string, spans []*Span) exportRequest {
+	converted := []otlpSpan{}
+	for _, span := range spans {
+		s := otlpSpan{
+			TraceID:           span.TraceID,
+			SpanID:            span.SpanID,
+			ParentSpanID:      span.ParentSpanID,
+			Name:              span.Name,
+			Kind:              span.Kind,
+			StartTimeUnixNano: strconv.FormatInt(span.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(span.End.UnixNano(), 10),
+			Attributes:        attributes(span.Attributes),
+			Status:            status{Code: statusOK},
+		}
+		if span.Error != "" {
+			s.Status = status{Code: statusError, Message: span.Error}
+		}
+		converted = append(converted, s)
+	}
+
+	return exportRequest{ResourceSpans: []resourceSpans{{
+		Resource: resource{Attributes: attributes(map[string]interface{}{"service.name": serviceName})},
+		ScopeSpans: []scopeSpans{{
+			Scope: scope{Name: "github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/tracing"},
+			Spans: converted,
+		}},
+	}}}
+}
+
+func attributes(values map[string]interface{}) []keyValue {
+	keys := []string{}
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	converted := []keyValue{}
+	for _, key := range keys {
+		var value anyValue
+		switch v := values[key].(type) {
+		case string:
+			value.StringValue = &v
+		case int:
+			text := strconv.Itoa(v)
+			value.IntValue = &text
+		case float64:
+			value.DoubleValue = &v
+		case bool:
+			value.BoolValue = &v
+		default:
+			text := fmt.Sprint(v)
+			value.StringValue = &text
+		}
+		converted = append(converted, keyValue{Key: key, Value: value})
+	}
+	return converted
+}
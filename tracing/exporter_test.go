@@ -0,0 +1,91 @@
+package tracing_test
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/tracing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+)
+
+var _ = Describe("Exporter", func() {
+	var (
+		server *ghttp.Server
+		tracer *tracing.Tracer
+	)
+
+	BeforeEach(func() {
+		server = ghttp.NewServer()
+		tracer = tracing.NewTracer("smoke-tests")
+
+		root := tracer.StartSpan("run", tracing.KindInternal, nil)
+		child := tracer.StartSpan("cf push", tracing.KindClient, root)
+		child.SetAttribute("cf.command", "cf push app")
+		child.SetAttribute("cf.exit_code", 1)
+		child.SetAttribute("smoke.retried", true)
+		child.Finish(errors.New("exit status 1"))
+		root.Finish(nil)
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("posts the spans in the OTLP JSON encoding", func() {
+		var request map[string]interface{}
+		server.AppendHandlers(ghttp.CombineHandlers(
+			ghttp.VerifyRequest("POST", "/v1/traces"),
+			ghttp.VerifyContentType("application/json"),
+			ghttp.VerifyHeaderKV("Authorization", "Bearer token"),
+			func(w http.ResponseWriter, r *http.Request) {
+				body, err := ioutil.ReadAll(r.Body)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(json.Unmarshal(body, &request)).To(Succeed())
+			},
+		))
+
+		exporter := tracing.NewExporter(server.URL()+"/v1/traces", map[string]string{"Authorization": "Bearer token"}, time.Second)
+		Expect(exporter.Export(tracer)).To(Succeed())
+
+		resourceSpans := request["resourceSpans"].([]interface{})[0].(map[string]interface{})
+		Expect(resourceSpans["resource"]).To(Equal(map[string]interface{}{
+			"attributes": []interface{}{
+				map[string]interface{}{"key": "service.name", "value": map[string]interface{}{"stringValue": "smoke-tests"}},
+			},
+		}))
+
+		spans := resourceSpans["scopeSpans"].([]interface{})[0].(map[string]interface{})["spans"].([]interface{})
+		Expect(spans).To(HaveLen(2))
+
+		child := spans[0].(map[string]interface{})
+		root := spans[1].(map[string]interface{})
+		Expect(child["name"]).To(Equal("cf push"))
+		Expect(child["kind"]).To(Equal(3.0))
+		Expect(child["parentSpanId"]).To(Equal(root["spanId"]))
+		Expect(child["traceId"]).To(Equal(root["traceId"]))
+		Expect(child["startTimeUnixNano"]).To(MatchRegexp(`^\d+$`))
+		Expect(child["status"]).To(Equal(map[string]interface{}{"code": 2.0, "message": "exit status 1"}))
+		Expect(child["attributes"]).To(Equal([]interface{}{
+			map[string]interface{}{"key": "cf.command", "value": map[string]interface{}{"stringValue": "cf push app"}},
+			map[string]interface{}{"key": "cf.exit_code", "value": map[string]interface{}{"intValue": "1"}},
+			map[string]interface{}{"key": "smoke.retried", "value": map[string]interface{}{"boolValue": true}},
+		}))
+
+		Expect(root).NotTo(HaveKey("parentSpanId"))
+		Expect(root["status"]).To(Equal(map[string]interface{}{"code": 1.0}))
+	})
+
+	It("reports a collector that rejects the spans", func() {
+		server.AppendHandlers(ghttp.RespondWith(http.StatusBadRequest, "unknown field"))
+
+		err := tracing.NewExporter(server.URL()+"/v1/traces", nil, time.Second).Export(tracer)
+		Expect(err).To(MatchError(ContainSubstring("Failed to export 2 spans")))
+		Expect(err).To(MatchError(ContainSubstring("400 Bad Request unknown field")))
+	})
+})
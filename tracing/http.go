@@ -0,0 +1,51 @@
+package tracing
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// WrapHTTPClient traces each request made through client, and passes the
+// trace on to the server in a traceparent header.
+func (t *Tracer) WrapHTTPClient(client *http.Client) *http.Client {
+	transport := client.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
+	wrapped := *client
+	wrapped.Transport = tracingTransport{tracer: t, transport: transport}
+	return &wrapped
+}
+
+type tracingTransport struct {
+	tracer    *Tracer
+	transport http.RoundTripper
+}
+
+func (t tracingTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	span := t.tracer.StartSpan("HTTP "+request.Method, KindClient, t.tracer.Active())
+	span.SetAttribute("http.method", request.Method)
+	span.SetAttribute("http.url", request.URL.String())
+
+	traced := new(http.Request)
+	*traced = *request
+	traced.Header = http.Header{}
+	for key, values := range request.Header {
+		traced.Header[key] = values
+	}
+	traced.Header.Set("traceparent", span.TraceParent())
+
+	response, err := t.transport.RoundTrip(traced)
+	if err != nil {
+		span.Finish(err)
+		return nil, err
+	}
+
+	span.SetAttribute("http.status_code", response.StatusCode)
+	if response.StatusCode >= 500 {
+		err = fmt.Errorf("%s", response.Status)
+	}
+	span.Finish(err)
+	return response, nil
+}
@@ -0,0 +1,148 @@
+package tracing
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Span kinds, as numbered by OTLP.
+const (
+	KindInternal = 1
+	KindClient   = 3
+)
+
+// Masked replaces secrets in span names and attributes.
+const Masked = "[PRIVATE DATA HIDDEN]"
+
+// Tracer collects the spans of one run. Spans for cf invocations and app
+// requests are children of the active span, which the lifecycle sets to
+// the step it is running.
+type Tracer struct {
+	ServiceName string
+
+	mutex    sync.Mutex
+	active   *Span
+	finished []*Span
+	secrets  []string
+}
+
+func NewTracer(serviceName string) *Tracer {
+	return &Tracer{ServiceName: serviceName}
+}
+
+// AddSecret masks value wherever it appears in a span.
+func (t *Tracer) AddSecret(value string) {
+	if value == "" {
+		return
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.secrets = append(t.secrets, value)
+}
+
+// Active returns the span new spans are children of by default.
+func (t *Tracer) Active() *Span {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.active
+}
+
+func (t *Tracer) SetActive(span *Span) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.active = span
+}
+
+// Finished returns the ended spans, in the order they ended.
+func (t *Tracer) Finished() []*Span {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return append([]*Span{}, t.finished...)
+}
+
+// StartSpan starts a span under parent, or a new trace when parent is nil.
+func (t *Tracer) StartSpan(name string, kind int, parent *Span) *Span {
+	span := &Span{
+		tracer:     t,
+		Name:       t.mask(name),
+		Kind:       kind,
+		SpanID:     randomID(8),
+		Start:      time.Now(),
+		Attributes: map[string]interface{}{},
+	}
+	if parent != nil {
+		span.TraceID = parent.TraceID
+		span.ParentSpanID = parent.SpanID
+	} else {
+		span.TraceID = randomID(16)
+	}
+	return span
+}
+
+func (t *Tracer) finish(span *Span) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.finished = append(t.finished, span)
+}
+
+func (t *Tracer) mask(value string) string {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	for _, secret := range t.secrets {
+		value = strings.Replace(value, secret, Masked, -1)
+	}
+	return value
+}
+
+// Span is a timed operation. Attributes hold strings, ints, floats and
+// bools.
+type Span struct {
+	tracer *Tracer
+
+	TraceID      string
+	SpanID       string
+	ParentSpanID string
+	Name         string
+	Kind         int
+	Start        time.Time
+	End          time.Time
+	Attributes   map[string]interface{}
+
+	// Error is set when the operation failed.
+	Error string
+}
+
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if text, ok := value.(string); ok {
+		value = s.tracer.mask(text)
+	}
+	s.Attributes[key] = value
+}
+
+// Finish ends the span, failed when err is not nil.
+func (s *Span) Finish(err error) {
+	s.End = time.Now()
+	if err != nil {
+		s.Error = s.tracer.mask(err.Error())
+	}
+	s.tracer.finish(s)
+}
+
+// TraceParent is the W3C traceparent header that continues the trace from
+// this span.
+func (s *Span) TraceParent() string {
+	return "00-" + s.TraceID + "-" + s.SpanID + "-01"
+}
+
+func randomID(bytes int) string {
+	id := make([]byte, bytes)
+	if _, err := rand.Read(id); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(id)
+}
@@ -0,0 +1,114 @@
+package tracing_test
+
+import (
+	"errors"
+	"net/http"
+	"os/exec"
+
+	"github.com/cloudfoundry-community/cf-rabbitmq-smoke-tests/tracing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gexec"
+	"github.com/onsi/gomega/ghttp"
+)
+
+var _ = Describe("Tracer", func() {
+	var (
+		tracer *tracing.Tracer
+		root   *tracing.Span
+	)
+
+	BeforeEach(func() {
+		tracer = tracing.NewTracer("smoke-tests")
+		tracer.AddSecret("s3cr3t")
+		root = tracer.StartSpan("run", tracing.KindInternal, nil)
+		tracer.SetActive(root)
+	})
+
+	It("starts new traces and continues them in child spans", func() {
+		child := tracer.StartSpan("push", tracing.KindInternal, root)
+
+		Expect(root.TraceID).To(HaveLen(32))
+		Expect(root.SpanID).To(HaveLen(16))
+		Expect(root.ParentSpanID).To(BeEmpty())
+		Expect(child.TraceID).To(Equal(root.TraceID))
+		Expect(child.ParentSpanID).To(Equal(root.SpanID))
+		Expect(child.SpanID).NotTo(Equal(root.SpanID))
+		Expect(child.TraceParent()).To(Equal("00-" + root.TraceID + "-" + child.SpanID + "-01"))
+	})
+
+	It("keeps spans in the order they finish, with masked errors", func() {
+		child := tracer.StartSpan("auth", tracing.KindInternal, root)
+		child.Finish(errors.New("bad password s3cr3t"))
+		root.Finish(nil)
+
+		finished := tracer.Finished()
+		Expect(finished).To(Equal([]*tracing.Span{child, root}))
+		Expect(child.Error).To(Equal("bad password [PRIVATE DATA HIDDEN]"))
+		Expect(root.Error).To(BeEmpty())
+		Expect(root.End).NotTo(BeTemporally("<", root.Start))
+	})
+
+	Describe("WrapCf", func() {
+		cf := func(args ...string) *gexec.Session {
+			session, err := gexec.Start(exec.Command("sh", "-c", "exit $1", "sh", args[len(args)-1]), nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+			return session
+		}
+
+		It("traces each command with its masked arguments and exit code", func() {
+			tracer.WrapCf(cf)("auth", "admin", "s3cr3t", "0").Wait()
+			tracer.WrapCf(cf)("create-service", "p-rabbitmq", "standard", "3").Wait()
+
+			Eventually(tracer.Finished).Should(HaveLen(2))
+			auth, create := tracer.Finished()[0], tracer.Finished()[1]
+			if auth.Name != "cf auth" {
+				auth, create = create, auth
+			}
+
+			Expect(auth.Name).To(Equal("cf auth"))
+			Expect(auth.Kind).To(Equal(tracing.KindClient))
+			Expect(auth.ParentSpanID).To(Equal(root.SpanID))
+			Expect(auth.Attributes).To(HaveKeyWithValue("cf.command", "cf auth admin [PRIVATE DATA HIDDEN] 0"))
+			Expect(auth.Attributes).To(HaveKeyWithValue("cf.exit_code", 0))
+			Expect(auth.Error).To(BeEmpty())
+
+			Expect(create.Attributes).To(HaveKeyWithValue("cf.exit_code", 3))
+			Expect(create.Error).To(Equal("exit status 3"))
+		})
+	})
+
+	Describe("WrapHTTPClient", func() {
+		var server *ghttp.Server
+
+		BeforeEach(func() {
+			server = ghttp.NewServer()
+		})
+
+		AfterEach(func() {
+			server.Close()
+		})
+
+		It("traces each request and passes the trace on", func() {
+			var traceParent string
+			server.AppendHandlers(func(w http.ResponseWriter, r *http.Request) {
+				traceParent = r.Header.Get("traceparent")
+				w.WriteHeader(http.StatusBadGateway)
+			})
+
+			response, err := tracer.WrapHTTPClient(&http.Client{}).Get(server.URL() + "/ping")
+			Expect(err).NotTo(HaveOccurred())
+			response.Body.Close()
+
+			Expect(tracer.Finished()).To(HaveLen(1))
+			span := tracer.Finished()[0]
+			Expect(span.Name).To(Equal("HTTP GET"))
+			Expect(span.ParentSpanID).To(Equal(root.SpanID))
+			Expect(span.Attributes).To(HaveKeyWithValue("http.url", server.URL()+"/ping"))
+			Expect(span.Attributes).To(HaveKeyWithValue("http.status_code", 502))
+			Expect(span.Error).To(Equal("502 Bad Gateway"))
+			Expect(traceParent).To(Equal(span.TraceParent()))
+		})
+	})
+})